@@ -321,6 +321,7 @@ func (a *sparseArrayObject) expand(idx uint32) bool {
 			return false
 		}
 	}
+	a.val.runtime.allocMem(memSparseItemSize)
 	return true
 }
 
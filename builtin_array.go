@@ -23,6 +23,7 @@ func (r *Runtime) newArrayObject() *arrayObject {
 }
 
 func setArrayValues(a *arrayObject, values []Value) *arrayObject {
+	a.val.runtime.allocMem(int64(len(values)) * memValueSize)
 	a.values = values
 	a.length = uint32(len(values))
 	a.objCount = len(values)
@@ -187,8 +188,9 @@ func (r *Runtime) arrayproto_join(call FunctionCall) Value {
 	if l == 0 {
 		return stringEmpty
 	}
+	r.vm.charge(int64(l))
 
-	var buf valueStringBuilder
+	buf := valueStringBuilder{runtime: r}
 
 	element0 := o.self.getIdx(valueInt(0), nil)
 	if element0 != nil && element0 != _undefined && element0 != _null {
@@ -196,6 +198,7 @@ func (r *Runtime) arrayproto_join(call FunctionCall) Value {
 	}
 
 	for i := 1; i < l; i++ {
+		r.vm.checkInterrupt()
 		buf.WriteString(sep)
 		element := o.self.getIdx(valueInt(int64(i)), nil)
 		if element != nil && element != _undefined && element != _null {
@@ -238,7 +241,7 @@ func (r *Runtime) writeItemLocaleString(item Value, buf *valueStringBuilder) {
 
 func (r *Runtime) arrayproto_toLocaleString(call FunctionCall) Value {
 	array := call.This.ToObject(r)
-	var buf valueStringBuilder
+	buf := valueStringBuilder{runtime: r}
 	if a := r.checkStdArrayObj(array); a != nil {
 		for i, item := range a.values {
 			if i > 0 {
@@ -249,6 +252,7 @@ func (r *Runtime) arrayproto_toLocaleString(call FunctionCall) Value {
 	} else {
 		length := toLength(array.self.getStr("length", nil))
 		for i := int64(0); i < length; i++ {
+			r.vm.checkInterrupt()
 			if i > 0 {
 				buf.WriteRune(',')
 			}
@@ -276,6 +280,7 @@ func (r *Runtime) arrayproto_concat_append(a *Object, item Value) {
 			panic(r.NewTypeError("Invalid array length"))
 		}
 		for i := int64(0); i < length; i++ {
+			r.vm.checkInterrupt()
 			v := obj.self.getIdx(valueInt(i), nil)
 			if v != nil {
 				createDataPropertyOrThrow(a, intToValue(aLength), v)
@@ -361,15 +366,19 @@ func (r *Runtime) arrayproto_sort(call FunctionCall) Value {
 
 	if s != nil {
 		ctx := arraySortCtx{
+			r:       r,
 			obj:     s,
 			compare: compareFn,
 		}
 
+		r.chargeSort(ctx.Len())
 		sort.Stable(&ctx)
 	} else {
 		length := toLength(o.self.getStr("length", nil))
+		r.vm.charge(length)
 		a := make([]Value, 0, length)
 		for i := int64(0); i < length; i++ {
+			r.vm.checkInterrupt()
 			idx := valueInt(i)
 			if o.self.hasPropertyIdx(idx) {
 				a = append(a, nilSafe(o.self.getIdx(idx, nil)))
@@ -377,15 +386,18 @@ func (r *Runtime) arrayproto_sort(call FunctionCall) Value {
 		}
 		ar := r.newArrayValues(a)
 		ctx := arraySortCtx{
+			r:       r,
 			obj:     ar.self,
 			compare: compareFn,
 		}
 
+		r.chargeSort(ctx.Len())
 		sort.Stable(&ctx)
 		for i := 0; i < len(a); i++ {
 			o.self.setOwnIdx(valueInt(i), a[i], true)
 		}
 		for i := int64(len(a)); i < length; i++ {
+			r.vm.checkInterrupt()
 			o.self.deleteIdx(valueInt(i), true)
 		}
 	}
@@ -409,6 +421,12 @@ func (r *Runtime) arrayproto_splice(call FunctionCall) Value {
 	if newLength >= maxInt {
 		panic(r.NewTypeError("Invalid array length"))
 	}
+	work := actualDeleteCount + itemCount
+	if itemCount != actualDeleteCount {
+		// the elements after the deleted ones are moved
+		work += length - actualStart - actualDeleteCount
+	}
+	r.vm.charge(work)
 	a := arraySpeciesCreate(o, actualDeleteCount)
 	if src := r.checkStdArrayObj(o); src != nil {
 		if dst := r.checkStdArrayObjWithProto(a); dst != nil {
@@ -457,6 +475,7 @@ func (r *Runtime) arrayproto_splice(call FunctionCall) Value {
 
 		if itemCount < actualDeleteCount {
 			for k := actualStart; k < length-actualDeleteCount; k++ {
+				r.vm.checkInterrupt()
 				from := valueInt(k + actualDeleteCount)
 				to := valueInt(k + itemCount)
 				if o.self.hasPropertyIdx(from) {
@@ -516,6 +535,7 @@ func (r *Runtime) arrayproto_unshift(call FunctionCall) Value {
 			arr.objCount = int(arr.length)
 		} else {
 			for k := length - 1; k >= 0; k-- {
+				r.vm.checkInterrupt()
 				from := valueInt(k)
 				to := valueInt(k + argCount)
 				if o.self.hasPropertyIdx(from) {
@@ -573,13 +593,17 @@ func (r *Runtime) arrayproto_indexOf(call FunctionCall) Value {
 	if arr := r.checkStdArrayObj(o); arr != nil {
 		for i, val := range arr.values[n:] {
 			if searchElement.StrictEquals(val) {
+				r.vm.charge(int64(i))
 				return intToValue(n + int64(i))
 			}
 		}
+		r.vm.charge(int64(len(arr.values)) - n)
 		return intToValue(-1)
 	}
 
 	for ; n < length; n++ {
+		r.vm.checkInterrupt()
+		r.vm.charge(1)
 		idx := valueInt(n)
 		if o.self.hasPropertyIdx(idx) {
 			if val := o.self.getIdx(idx, nil); val != nil {
@@ -615,15 +639,19 @@ func (r *Runtime) arrayproto_includes(call FunctionCall) Value {
 	}
 
 	if arr := r.checkStdArrayObj(o); arr != nil {
-		for _, val := range arr.values[n:] {
+		for i, val := range arr.values[n:] {
 			if searchElement.SameAs(val) {
+				r.vm.charge(int64(i))
 				return valueTrue
 			}
 		}
+		r.vm.charge(int64(len(arr.values)) - n)
 		return valueFalse
 	}
 
 	for ; n < length; n++ {
+		r.vm.checkInterrupt()
+		r.vm.charge(1)
 		idx := valueInt(n)
 		val := nilSafe(o.self.getIdx(idx, nil))
 		if searchElement.SameAs(val) {
@@ -660,13 +688,17 @@ func (r *Runtime) arrayproto_lastIndexOf(call FunctionCall) Value {
 		vals := arr.values
 		for k := fromIndex; k >= 0; k-- {
 			if v := vals[k]; v != nil && searchElement.StrictEquals(v) {
+				r.vm.charge(fromIndex - k)
 				return intToValue(k)
 			}
 		}
+		r.vm.charge(fromIndex + 1)
 		return intToValue(-1)
 	}
 
 	for k := fromIndex; k >= 0; k-- {
+		r.vm.checkInterrupt()
+		r.vm.charge(1)
 		idx := valueInt(k)
 		if o.self.hasPropertyIdx(idx) {
 			if val := o.self.getIdx(idx, nil); val != nil {
@@ -689,6 +721,7 @@ func (r *Runtime) arrayproto_every(call FunctionCall) Value {
 		Arguments: []Value{nil, nil, o},
 	}
 	for k := int64(0); k < length; k++ {
+		r.vm.checkInterrupt()
 		idx := valueInt(k)
 		if val := o.self.getIdx(idx, nil); val != nil {
 			fc.Arguments[0] = val
@@ -710,6 +743,7 @@ func (r *Runtime) arrayproto_some(call FunctionCall) Value {
 		Arguments: []Value{nil, nil, o},
 	}
 	for k := int64(0); k < length; k++ {
+		r.vm.checkInterrupt()
 		idx := valueInt(k)
 		if val := o.self.getIdx(idx, nil); val != nil {
 			fc.Arguments[0] = val
@@ -731,6 +765,7 @@ func (r *Runtime) arrayproto_forEach(call FunctionCall) Value {
 		Arguments: []Value{nil, nil, o},
 	}
 	for k := int64(0); k < length; k++ {
+		r.vm.checkInterrupt()
 		idx := valueInt(k)
 		if val := o.self.getIdx(idx, nil); val != nil {
 			fc.Arguments[0] = val
@@ -766,6 +801,7 @@ func (r *Runtime) arrayproto_map(call FunctionCall) Value {
 		}
 	}
 	for k := int64(0); k < length; k++ {
+		r.vm.checkInterrupt()
 		idx := valueInt(k)
 		if val := o.self.getIdx(idx, nil); val != nil {
 			fc.Arguments[0] = val
@@ -806,6 +842,7 @@ func (r *Runtime) arrayproto_filter(call FunctionCall) Value {
 
 		to := int64(0)
 		for k := int64(0); k < length; k++ {
+			r.vm.checkInterrupt()
 			idx := valueInt(k)
 			if val := o.self.getIdx(idx, nil); val != nil {
 				fc.Arguments[0] = val
@@ -839,6 +876,7 @@ func (r *Runtime) arrayproto_reduce(call FunctionCall) Value {
 			fc.Arguments[0] = call.Argument(1)
 		} else {
 			for ; k < length; k++ {
+				r.vm.checkInterrupt()
 				idx := valueInt(k)
 				if val := o.self.getIdx(idx, nil); val != nil {
 					fc.Arguments[0] = val
@@ -853,6 +891,7 @@ func (r *Runtime) arrayproto_reduce(call FunctionCall) Value {
 		}
 
 		for ; k < length; k++ {
+			r.vm.checkInterrupt()
 			idx := valueInt(k)
 			if val := o.self.getIdx(idx, nil); val != nil {
 				fc.Arguments[1] = val
@@ -883,6 +922,7 @@ func (r *Runtime) arrayproto_reduceRight(call FunctionCall) Value {
 			fc.Arguments[0] = call.Argument(1)
 		} else {
 			for ; k >= 0; k-- {
+				r.vm.checkInterrupt()
 				idx := valueInt(k)
 				if val := o.self.getIdx(idx, nil); val != nil {
 					fc.Arguments[0] = val
@@ -897,6 +937,7 @@ func (r *Runtime) arrayproto_reduceRight(call FunctionCall) Value {
 		}
 
 		for ; k >= 0; k-- {
+			r.vm.checkInterrupt()
 			idx := valueInt(k)
 			if val := o.self.getIdx(idx, nil); val != nil {
 				fc.Arguments[1] = val
@@ -938,7 +979,9 @@ func arrayproto_reverse_generic_step(o *Object, lower, upper int64) {
 func (r *Runtime) arrayproto_reverse_generic(o *Object, start int64) {
 	l := toLength(o.self.getStr("length", nil))
 	middle := l / 2
+	r.vm.charge(middle - start)
 	for lower := start; lower != middle; lower++ {
+		r.vm.checkInterrupt()
 		arrayproto_reverse_generic_step(o, lower, l-lower-1)
 	}
 }
@@ -948,6 +991,7 @@ func (r *Runtime) arrayproto_reverse(call FunctionCall) Value {
 	if a := r.checkStdArrayObj(o); a != nil {
 		l := len(a.values)
 		middle := l / 2
+		r.vm.charge(int64(middle))
 		for lower := 0; lower != middle; lower++ {
 			upper := l - lower - 1
 			a.values[lower], a.values[upper] = a.values[upper], a.values[lower]
@@ -982,6 +1026,7 @@ func (r *Runtime) arrayproto_shift(call FunctionCall) Value {
 	}
 	first := o.self.getIdx(valueInt(0), nil)
 	for i := int64(1); i < length; i++ {
+		r.vm.checkInterrupt()
 		idxFrom := valueInt(i)
 		idxTo := valueInt(i - 1)
 		if o.self.hasPropertyIdx(idxFrom) {
@@ -1019,6 +1064,7 @@ func (r *Runtime) arrayproto_copyWithin(call FunctionCall) Value {
 	}
 	final := relToIdx(relEnd, l)
 	count := min(final-from, l-to)
+	r.vm.charge(count)
 	if arr := r.checkStdArrayObj(o); arr != nil {
 		if count > 0 {
 			copy(arr.values[to:to+count], arr.values[from:from+count])
@@ -1033,6 +1079,7 @@ func (r *Runtime) arrayproto_copyWithin(call FunctionCall) Value {
 		dir = 1
 	}
 	for count > 0 {
+		r.vm.checkInterrupt()
 		if o.self.hasPropertyIdx(valueInt(from)) {
 			o.self.setOwnIdx(valueInt(to), nilSafe(o.self.getIdx(valueInt(from), nil)), true)
 		} else {
@@ -1062,12 +1109,14 @@ func (r *Runtime) arrayproto_fill(call FunctionCall) Value {
 	}
 	final := relToIdx(relEnd, l)
 	value := call.Argument(0)
+	r.vm.charge(final - k)
 	if arr := r.checkStdArrayObj(o); arr != nil {
 		for ; k < final; k++ {
 			arr.values[k] = value
 		}
 	} else {
 		for ; k < final; k++ {
+			r.vm.checkInterrupt()
 			o.self.setOwnIdx(valueInt(k), value, true)
 		}
 	}
@@ -1083,6 +1132,7 @@ func (r *Runtime) arrayproto_find(call FunctionCall) Value {
 		Arguments: []Value{nil, nil, o},
 	}
 	for k := int64(0); k < l; k++ {
+		r.vm.checkInterrupt()
 		idx := valueInt(k)
 		kValue := o.self.getIdx(idx, nil)
 		fc.Arguments[0], fc.Arguments[1] = kValue, idx
@@ -1103,6 +1153,7 @@ func (r *Runtime) arrayproto_findIndex(call FunctionCall) Value {
 		Arguments: []Value{nil, nil, o},
 	}
 	for k := int64(0); k < l; k++ {
+		r.vm.checkInterrupt()
 		idx := valueInt(k)
 		kValue := o.self.getIdx(idx, nil)
 		fc.Arguments[0], fc.Arguments[1] = kValue, idx
@@ -1293,6 +1344,7 @@ func (r *Runtime) array_from(call FunctionCall) Value {
 			}
 		}
 		for k := int64(0); k < l; k++ {
+			r.vm.checkInterrupt()
 			idx := valueInt(k)
 			item := arrayLike.self.getIdx(idx, nil)
 			if mapFn != nil {
@@ -1308,6 +1360,174 @@ func (r *Runtime) array_from(call FunctionCall) Value {
 	return arr
 }
 
+// arrayFromAsync holds the state of an Array.fromAsync() call between promise jobs.
+type arrayFromAsync struct {
+	r       *Runtime
+	pcap    *promiseCapability
+	arr     *Object
+	k       int64
+	mapFn   func(FunctionCall) Value
+	thisArg Value
+
+	iter *iteratorRecord // nil when iterating over an array-like
+
+	arrayLike *Object
+	length    int64
+}
+
+func (a *arrayFromAsync) reject(reason Value) {
+	a.pcap.reject(reason)
+}
+
+// closeAndReject closes the iterator (if any) and rejects the resulting promise. Errors from the iterator's
+// return() are ignored in favour of the original reason.
+func (a *arrayFromAsync) closeAndReject(reason Value) {
+	if a.iter != nil {
+		_ = a.r.vm.try(func() {
+			if retMethod := toMethod(a.iter.iterator.self.getStr("return", nil)); retMethod != nil {
+				retMethod(FunctionCall{This: a.iter.iterator})
+			}
+		})
+	}
+	a.reject(reason)
+}
+
+func (a *arrayFromAsync) finish() {
+	if ex := a.r.vm.try(func() {
+		a.arr.self.setOwnStr("length", intToValue(a.k), true)
+	}); ex != nil {
+		a.reject(ex.val)
+		return
+	}
+	a.pcap.resolve(a.arr)
+}
+
+func (a *arrayFromAsync) store(v Value) {
+	if ex := a.r.vm.try(func() {
+		createDataPropertyOrThrow(a.arr, intToValue(a.k), v)
+	}); ex != nil {
+		a.closeAndReject(ex.val)
+		return
+	}
+	a.k++
+	a.step()
+}
+
+func (a *arrayFromAsync) addValue(v Value) {
+	if a.mapFn == nil {
+		a.store(v)
+		return
+	}
+	if ex := a.r.vm.try(func() {
+		mapped := a.mapFn(FunctionCall{This: a.thisArg, Arguments: []Value{v, intToValue(a.k)}})
+		a.r.await(mapped, a.store, a.closeAndReject)
+	}); ex != nil {
+		a.closeAndReject(ex.val)
+	}
+}
+
+func (a *arrayFromAsync) iterResult(res Value) {
+	r := a.r
+	var value Value
+	done := false
+	if ex := r.vm.try(func() {
+		resObj, ok := res.(*Object)
+		if !ok {
+			panic(r.NewTypeError("Iterator result %s is not an object", res.String()))
+		}
+		if nilSafe(resObj.self.getStr("done", nil)).ToBoolean() {
+			done = true
+			return
+		}
+		value = nilSafe(resObj.self.getStr("value", nil))
+	}); ex != nil {
+		a.reject(ex.val)
+		return
+	}
+	if done {
+		a.finish()
+		return
+	}
+	if ex := r.vm.try(func() {
+		r.await(value, a.addValue, a.closeAndReject)
+	}); ex != nil {
+		a.closeAndReject(ex.val)
+	}
+}
+
+func (a *arrayFromAsync) step() {
+	r := a.r
+	if a.iter == nil {
+		if a.k >= a.length {
+			a.finish()
+			return
+		}
+		if ex := r.vm.try(func() {
+			r.await(nilSafe(a.arrayLike.self.getIdx(valueInt(a.k), nil)), a.addValue, a.reject)
+		}); ex != nil {
+			a.reject(ex.val)
+		}
+		return
+	}
+	if a.k >= maxInt-1 {
+		a.closeAndReject(r.NewTypeError("Invalid array length"))
+		return
+	}
+	var res Value
+	if ex := r.vm.try(func() {
+		res = a.iter.next(FunctionCall{This: a.iter.iterator})
+	}); ex != nil {
+		a.reject(ex.val)
+		return
+	}
+	a.iterResult(res)
+}
+
+func (r *Runtime) array_fromAsync(call FunctionCall) Value {
+	pcap := r.newPromiseCapability(r.global.Promise)
+	a := &arrayFromAsync{
+		r:       r,
+		pcap:    pcap,
+		thisArg: call.Argument(2),
+	}
+	if pcap.try(func() {
+		if mapFnArg := call.Argument(1); mapFnArg != _undefined {
+			if mapFnObj, ok := mapFnArg.(*Object); ok {
+				a.mapFn, _ = mapFnObj.self.assertCallable()
+			}
+			if a.mapFn == nil {
+				panic(r.NewTypeError("%s is not a function", mapFnArg))
+			}
+		}
+		var ctor func(args []Value, newTarget *Object) *Object
+		if o, ok := call.This.(*Object); ok {
+			ctor = o.self.assertConstructor()
+		}
+		items := call.Argument(0)
+		// async iteration is not supported, so only the synchronous iterators are used (their values are
+		// awaited as if wrapped with CreateAsyncFromSyncIterator)
+		if usingIterator := toMethod(r.getV(items, SymIterator)); usingIterator != nil {
+			if ctor != nil {
+				a.arr = ctor([]Value{}, nil)
+			} else {
+				a.arr = r.newArrayValues(nil)
+			}
+			a.iter = r.getIterator(items, usingIterator)
+		} else {
+			a.arrayLike = items.ToObject(r)
+			a.length = toLength(a.arrayLike.self.getStr("length", nil))
+			if ctor != nil {
+				a.arr = ctor([]Value{intToValue(a.length)}, nil)
+			} else {
+				a.arr = r.newArrayLength(a.length)
+			}
+		}
+	}) {
+		a.step()
+	}
+	return pcap.promise
+}
+
 func (r *Runtime) array_isArray(call FunctionCall) Value {
 	if o, ok := call.Argument(0).(*Object); ok {
 		if isArray(o) {
@@ -1416,6 +1636,7 @@ func (r *Runtime) createArrayProto(val *Object) objectImpl {
 func (r *Runtime) createArray(val *Object) objectImpl {
 	o := r.newNativeFuncConstructObj(val, r.builtin_newArray, "Array", r.global.ArrayPrototype, 1)
 	o._putProp("from", r.newNativeFunc(r.array_from, nil, "from", nil, 1), true, false, true)
+	o._putProp("fromAsync", r.newNativeFunc(r.array_fromAsync, nil, "fromAsync", nil, 1), true, false, true)
 	o._putProp("isArray", r.newNativeFunc(r.array_isArray, nil, "isArray", nil, 1), true, false, true)
 	o._putProp("of", r.newNativeFunc(r.array_of, nil, "of", nil, 0), true, false, true)
 	r.putSpeciesReturnThis(o)
@@ -1456,6 +1677,7 @@ type sortable interface {
 }
 
 type arraySortCtx struct {
+	r       *Runtime
 	obj     sortable
 	compare func(FunctionCall) Value
 }
@@ -1511,6 +1733,7 @@ func (a *arraySortCtx) Len() int {
 }
 
 func (a *arraySortCtx) Less(j, k int) bool {
+	a.r.vm.checkInterrupt()
 	return a.sortCompare(a.obj.sortGet(j), a.obj.sortGet(k)) < 0
 }
 
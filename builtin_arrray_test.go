@@ -323,3 +323,48 @@ func TestArrayFlatMap(t *testing.T) {
 	`
 	testScriptWithTestLibX(SCRIPT, _undefined, t)
 }
+
+func TestArrayFromAsync(t *testing.T) {
+	const SCRIPT = `
+	const thenable = {
+		constructor: Promise,
+		then(resolve) {
+			resolve(2);
+		}
+	};
+
+	let closed = 0;
+	const closable = {
+		[Symbol.iterator]() {
+			return {
+				next() {
+					return {value: 1, done: false};
+				},
+				return() {
+					closed++;
+					return {};
+				}
+			};
+		}
+	};
+
+	class MyArray extends Array {}
+
+	const p = Array.fromAsync([1, Promise.resolve(2), 3]);
+	assert(p instanceof Promise, "returns a Promise");
+
+	Promise.all([
+		p.then(a => assert(compareArray(a, [1, 2, 3]), "sync iterable with promises")),
+		Array.fromAsync([1, thenable, 3], x => Promise.resolve(x * 2)).then(a => assert(compareArray(a, [2, 4, 6]), "thenable")),
+		Array.fromAsync({length: 2, 0: "a", 1: Promise.resolve("b")}).then(a => assert(compareArray(a, ["a", "b"]), "array-like")),
+		MyArray.fromAsync([1]).then(a => assert(a instanceof MyArray, "constructor")),
+		Array.fromAsync([1], 1).then(() => { throw new Error("should reject") }, e => assert(e instanceof TypeError, "mapfn")),
+		Array.fromAsync(closable, () => { throw new RangeError() }).then(() => { throw new Error("should reject") }, e => {
+			assert(e instanceof RangeError, "mapfn error");
+			assert.sameValue(closed, 1, "iterator closed");
+		}),
+		Array.fromAsync([Promise.reject(new SyntaxError())]).then(() => { throw new Error("should reject") }, e => assert(e instanceof SyntaxError, "rejected value")),
+	]);
+	`
+	testAsyncScriptWithTestLib(SCRIPT, t)
+}
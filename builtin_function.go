@@ -5,7 +5,8 @@ import (
 	"math"
 )
 
-func (r *Runtime) builtin_Function(args []Value, proto *Object) *Object {
+// functionSource returns the source of the function expression created by the Function constructor.
+func functionSource(args []Value) valueString {
 	var sb valueStringBuilder
 	sb.WriteString(asciiString("(function anonymous("))
 	if len(args) > 1 {
@@ -22,8 +23,13 @@ func (r *Runtime) builtin_Function(args []Value, proto *Object) *Object {
 		sb.WriteString(args[len(args)-1].toString())
 	}
 	sb.WriteString(asciiString("\n})"))
+	return sb.String()
+}
 
-	ret := r.toObject(r.eval(sb.String(), false, false))
+func (r *Runtime) builtin_Function(args []Value, proto *Object) *Object {
+	src := functionSource(args)
+	r.checkDynamicCode(DynamicCodeFunction, src)
+	ret := r.toObject(r.eval(src, false, false))
 	ret.self.setProto(proto, true)
 	return ret
 }
@@ -193,6 +199,7 @@ lenNotInt:
 	bf := &boundFuncObject{
 		nativeFuncObject: *ff,
 		wrapped:          obj,
+		boundArgs:        append([]Value(nil), call.Arguments...),
 	}
 	bf.prototype = obj.self.proto()
 	v.self = bf
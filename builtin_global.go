@@ -90,6 +90,7 @@ func (r *Runtime) _encode(uriString valueString, unescaped *[256]bool) valueStri
 		return uriString
 	}
 
+	r.allocMem(int64(l))
 	buf := make([]byte, l)
 	i := 0
 	reader = uriString.reader(0)
@@ -257,7 +258,7 @@ func (r *Runtime) builtin_escape(call FunctionCall) Value {
 			sb.WriteByte(hexUpper[r&0xf])
 		}
 	}
-	return asciiString(sb.String())
+	return r.allocString(asciiString(sb.String()))
 }
 
 func (r *Runtime) builtin_unescape(call FunctionCall) Value {
@@ -343,6 +344,7 @@ func (r *Runtime) initGlobalObject() {
 	o._putProp("encodeURIComponent", r.newNativeFunc(r.builtin_encodeURIComponent, nil, "encodeURIComponent", nil, 1), true, false, true)
 	o._putProp("escape", r.newNativeFunc(r.builtin_escape, nil, "escape", nil, 1), true, false, true)
 	o._putProp("unescape", r.newNativeFunc(r.builtin_unescape, nil, "unescape", nil, 1), true, false, true)
+	o._putProp("structuredClone", r.newNativeFunc(r.builtin_structuredClone, nil, "structuredClone", nil, 1), true, false, true)
 
 	o._putSym(SymToStringTag, valueProp(asciiString(classGlobal), false, false, true))
 
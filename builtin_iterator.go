@@ -0,0 +1,573 @@
+package goja
+
+import (
+	"math"
+)
+
+type iteratorHelperObject struct {
+	baseObject
+	nextFn  func() (Value, bool)
+	closeFn func()
+	running bool
+	done    bool
+}
+
+type wrapForValidIteratorObject struct {
+	baseObject
+	iterated *iteratorRecord
+}
+
+func (o *iteratorHelperObject) next() Value {
+	r := o.val.runtime
+	if o.running {
+		panic(r.NewTypeError("Iterator helper is already running"))
+	}
+	if o.done {
+		return r.createIterResultObject(_undefined, true)
+	}
+	o.running = true
+	ok := false
+	defer func() {
+		o.running = false
+		if !ok {
+			o.done = true
+		}
+	}()
+	value, hasValue := o.nextFn()
+	ok = true
+	if !hasValue {
+		o.done = true
+		return r.createIterResultObject(_undefined, true)
+	}
+	return r.createIterResultObject(value, false)
+}
+
+func (o *iteratorHelperObject) _return() Value {
+	r := o.val.runtime
+	if o.running {
+		panic(r.NewTypeError("Iterator helper is already running"))
+	}
+	if !o.done {
+		o.done = true
+		o.closeFn()
+	}
+	return r.createIterResultObject(_undefined, true)
+}
+
+// getIteratorDirect implements the GetIteratorDirect abstract operation. Unlike getIterator the 'next'
+// method is not required to be callable until it is actually called.
+func (r *Runtime) getIteratorDirect(obj *Object) *iteratorRecord {
+	nextMethod := nilSafe(obj.self.getStr("next", nil))
+	var next func(FunctionCall) Value
+	if o, ok := nextMethod.(*Object); ok {
+		next, _ = o.self.assertCallable()
+	}
+	if next == nil {
+		next = func(FunctionCall) Value {
+			panic(r.NewTypeError("%s is not a function", nextMethod.String()))
+		}
+	}
+	return &iteratorRecord{
+		iterator: obj,
+		next:     next,
+	}
+}
+
+// getIteratorFlattenable implements the GetIteratorFlattenable abstract operation.
+func (r *Runtime) getIteratorFlattenable(obj Value, iterateStrings bool) *iteratorRecord {
+	if _, ok := obj.(*Object); !ok {
+		if _, ok := obj.(valueString); !ok || !iterateStrings {
+			panic(r.NewTypeError("%s is not an object", obj.String()))
+		}
+	}
+	var iter Value
+	if method := toMethod(r.getV(obj, SymIterator)); method != nil {
+		iter = method(FunctionCall{This: obj})
+	} else {
+		iter = obj
+	}
+	iterObj, ok := iter.(*Object)
+	if !ok {
+		panic(r.NewTypeError("%s is not an object", iter.String()))
+	}
+	return r.getIteratorDirect(iterObj)
+}
+
+// nextValue implements the IteratorStepValue abstract operation.
+func (ir *iteratorRecord) nextValue() (Value, bool) {
+	if ir.iterator == nil {
+		return nil, false
+	}
+	r := ir.iterator.runtime
+	res := r.toObject(ir.next(FunctionCall{This: ir.iterator}))
+	if nilSafe(res.self.getStr("done", nil)).ToBoolean() {
+		ir.close()
+		return nil, false
+	}
+	return nilSafe(res.self.getStr("value", nil)), true
+}
+
+// guard calls f and closes the iterator if f panics, then re-panics.
+func (ir *iteratorRecord) guard(f func()) {
+	if ret := tryFunc(f); ret != nil {
+		_ = tryFunc(ir.returnIter)
+		panic(ret)
+	}
+}
+
+func (r *Runtime) iteratorProtoThis(call FunctionCall, name string) *Object {
+	if obj, ok := call.This.(*Object); ok {
+		return obj
+	}
+	panic(r.NewTypeError("Method Iterator.prototype.%s called on incompatible receiver %s", name, call.This.String()))
+}
+
+// iteratorProtoCallback returns the callable argument of an iterator helper method. If the argument is not
+// callable the iterator is closed before the TypeError is thrown.
+func (r *Runtime) iteratorProtoCallback(obj *Object, arg Value) func(FunctionCall) Value {
+	if o, ok := arg.(*Object); ok {
+		if fn, ok := o.self.assertCallable(); ok {
+			return fn
+		}
+	}
+	_ = tryFunc((&iteratorRecord{iterator: obj}).returnIter)
+	panic(r.NewTypeError("%s is not a function", arg.String()))
+}
+
+func (r *Runtime) iteratorProtoLimit(obj *Object, arg Value) (limit int64) {
+	ir := &iteratorRecord{iterator: obj}
+	var num Value
+	ir.guard(func() {
+		num = arg.ToNumber()
+	})
+	if f, ok := num.(valueFloat); ok && math.IsNaN(float64(f)) {
+		_ = tryFunc(ir.returnIter)
+		panic(r.newError(r.global.RangeError, "%s must be positive", arg.String()))
+	}
+	limit = num.ToInteger()
+	if limit < 0 {
+		_ = tryFunc(ir.returnIter)
+		panic(r.newError(r.global.RangeError, "%s must be positive", arg.String()))
+	}
+	return
+}
+
+func (r *Runtime) newIteratorHelper(iterated *iteratorRecord, nextFn func() (Value, bool), closeFn func()) *Object {
+	o := &Object{runtime: r}
+
+	h := &iteratorHelperObject{
+		nextFn:  nextFn,
+		closeFn: closeFn,
+	}
+	if closeFn == nil {
+		h.closeFn = iterated.returnIter
+	}
+	h.class = classObject
+	h.val = o
+	h.extensible = true
+	o.self = h
+	h.prototype = r.global.IteratorHelperPrototype
+	h.init()
+
+	return o
+}
+
+func (r *Runtime) iteratorProto_drop(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "drop")
+	remaining := r.iteratorProtoLimit(obj, call.Argument(0))
+	iterated := r.getIteratorDirect(obj)
+	return r.newIteratorHelper(iterated, func() (Value, bool) {
+		for ; remaining > 0; remaining-- {
+			if _, ok := iterated.nextValue(); !ok {
+				return nil, false
+			}
+		}
+		return iterated.nextValue()
+	}, nil)
+}
+
+func (r *Runtime) iteratorProto_every(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "every")
+	predicate := r.iteratorProtoCallback(obj, call.Argument(0))
+	iterated := r.getIteratorDirect(obj)
+	var counter int64
+	for {
+		value, ok := iterated.nextValue()
+		if !ok {
+			return valueTrue
+		}
+		var result bool
+		iterated.guard(func() {
+			result = predicate(FunctionCall{This: _undefined, Arguments: []Value{value, intToValue(counter)}}).ToBoolean()
+		})
+		if !result {
+			iterated.returnIter()
+			return valueFalse
+		}
+		counter++
+	}
+}
+
+func (r *Runtime) iteratorProto_filter(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "filter")
+	predicate := r.iteratorProtoCallback(obj, call.Argument(0))
+	iterated := r.getIteratorDirect(obj)
+	var counter int64
+	return r.newIteratorHelper(iterated, func() (Value, bool) {
+		for {
+			value, ok := iterated.nextValue()
+			if !ok {
+				return nil, false
+			}
+			var selected bool
+			iterated.guard(func() {
+				selected = predicate(FunctionCall{This: _undefined, Arguments: []Value{value, intToValue(counter)}}).ToBoolean()
+			})
+			counter++
+			if selected {
+				return value, true
+			}
+		}
+	}, nil)
+}
+
+func (r *Runtime) iteratorProto_find(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "find")
+	predicate := r.iteratorProtoCallback(obj, call.Argument(0))
+	iterated := r.getIteratorDirect(obj)
+	var counter int64
+	for {
+		value, ok := iterated.nextValue()
+		if !ok {
+			return _undefined
+		}
+		var result bool
+		iterated.guard(func() {
+			result = predicate(FunctionCall{This: _undefined, Arguments: []Value{value, intToValue(counter)}}).ToBoolean()
+		})
+		if result {
+			iterated.returnIter()
+			return value
+		}
+		counter++
+	}
+}
+
+func (r *Runtime) iteratorProto_flatMap(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "flatMap")
+	mapper := r.iteratorProtoCallback(obj, call.Argument(0))
+	iterated := r.getIteratorDirect(obj)
+	var counter int64
+	var inner *iteratorRecord
+	return r.newIteratorHelper(iterated, func() (Value, bool) {
+		for {
+			if inner != nil {
+				var value Value
+				var ok bool
+				iterated.guard(func() {
+					value, ok = inner.nextValue()
+				})
+				if ok {
+					return value, true
+				}
+				inner = nil
+			}
+			value, ok := iterated.nextValue()
+			if !ok {
+				return nil, false
+			}
+			iterated.guard(func() {
+				mapped := mapper(FunctionCall{This: _undefined, Arguments: []Value{value, intToValue(counter)}})
+				inner = r.getIteratorFlattenable(mapped, false)
+			})
+			counter++
+		}
+	}, func() {
+		if inner != nil {
+			iterated.guard(inner.returnIter)
+		}
+		iterated.returnIter()
+	})
+}
+
+func (r *Runtime) iteratorProto_forEach(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "forEach")
+	fn := r.iteratorProtoCallback(obj, call.Argument(0))
+	iterated := r.getIteratorDirect(obj)
+	var counter int64
+	for {
+		value, ok := iterated.nextValue()
+		if !ok {
+			return _undefined
+		}
+		iterated.guard(func() {
+			fn(FunctionCall{This: _undefined, Arguments: []Value{value, intToValue(counter)}})
+		})
+		counter++
+	}
+}
+
+func (r *Runtime) iteratorProto_map(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "map")
+	mapper := r.iteratorProtoCallback(obj, call.Argument(0))
+	iterated := r.getIteratorDirect(obj)
+	var counter int64
+	return r.newIteratorHelper(iterated, func() (Value, bool) {
+		value, ok := iterated.nextValue()
+		if !ok {
+			return nil, false
+		}
+		iterated.guard(func() {
+			value = mapper(FunctionCall{This: _undefined, Arguments: []Value{value, intToValue(counter)}})
+		})
+		counter++
+		return value, true
+	}, nil)
+}
+
+func (r *Runtime) iteratorProto_reduce(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "reduce")
+	reducer := r.iteratorProtoCallback(obj, call.Argument(0))
+	iterated := r.getIteratorDirect(obj)
+	var accumulator Value
+	var counter int64
+	if len(call.Arguments) < 2 {
+		value, ok := iterated.nextValue()
+		if !ok {
+			panic(r.NewTypeError("Reduce of empty iterator with no initial value"))
+		}
+		accumulator = value
+		counter = 1
+	} else {
+		accumulator = call.Arguments[1]
+	}
+	for {
+		value, ok := iterated.nextValue()
+		if !ok {
+			return accumulator
+		}
+		iterated.guard(func() {
+			accumulator = reducer(FunctionCall{This: _undefined, Arguments: []Value{accumulator, value, intToValue(counter)}})
+		})
+		counter++
+	}
+}
+
+func (r *Runtime) iteratorProto_some(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "some")
+	predicate := r.iteratorProtoCallback(obj, call.Argument(0))
+	iterated := r.getIteratorDirect(obj)
+	var counter int64
+	for {
+		value, ok := iterated.nextValue()
+		if !ok {
+			return valueFalse
+		}
+		var result bool
+		iterated.guard(func() {
+			result = predicate(FunctionCall{This: _undefined, Arguments: []Value{value, intToValue(counter)}}).ToBoolean()
+		})
+		if result {
+			iterated.returnIter()
+			return valueTrue
+		}
+		counter++
+	}
+}
+
+func (r *Runtime) iteratorProto_take(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "take")
+	remaining := r.iteratorProtoLimit(obj, call.Argument(0))
+	iterated := r.getIteratorDirect(obj)
+	return r.newIteratorHelper(iterated, func() (Value, bool) {
+		if remaining == 0 {
+			iterated.returnIter()
+			return nil, false
+		}
+		if remaining != math.MaxInt64 {
+			remaining--
+		}
+		return iterated.nextValue()
+	}, nil)
+}
+
+func (r *Runtime) iteratorProto_toArray(call FunctionCall) Value {
+	obj := r.iteratorProtoThis(call, "toArray")
+	iterated := r.getIteratorDirect(obj)
+	var values []Value
+	for {
+		value, ok := iterated.nextValue()
+		if !ok {
+			return r.newArrayValues(values)
+		}
+		values = append(values, value)
+	}
+}
+
+func (r *Runtime) iterator_from(call FunctionCall) Value {
+	iterated := r.getIteratorFlattenable(call.Argument(0), true)
+	iterProto := r.global.IteratorPrototype
+	for p := iterated.iterator.self.proto(); p != nil; p = p.self.proto() {
+		if p == iterProto {
+			return iterated.iterator
+		}
+	}
+	o := &Object{runtime: r}
+
+	w := &wrapForValidIteratorObject{
+		iterated: iterated,
+	}
+	w.class = classObject
+	w.val = o
+	w.extensible = true
+	o.self = w
+	w.prototype = r.global.WrapForValidIteratorPrototype
+	w.init()
+
+	return o
+}
+
+func (r *Runtime) builtin_newIterator(args []Value, newTarget *Object) *Object {
+	if newTarget == nil || newTarget == r.global.Iterator {
+		panic(r.NewTypeError("Abstract class Iterator not directly constructable"))
+	}
+	proto := r.getPrototypeFromCtor(newTarget, r.global.Iterator, r.global.IteratorPrototype)
+	return r.newBaseObject(proto, classObject).val
+}
+
+func (r *Runtime) iteratorHelperProto_next(call FunctionCall) Value {
+	thisObj := r.toObject(call.This)
+	if iter, ok := thisObj.self.(*iteratorHelperObject); ok {
+		return iter.next()
+	}
+	panic(r.NewTypeError("Method Iterator Helper.prototype.next called on incompatible receiver %s", r.objectproto_toString(FunctionCall{This: thisObj})))
+}
+
+func (r *Runtime) iteratorHelperProto_return(call FunctionCall) Value {
+	thisObj := r.toObject(call.This)
+	if iter, ok := thisObj.self.(*iteratorHelperObject); ok {
+		return iter._return()
+	}
+	panic(r.NewTypeError("Method Iterator Helper.prototype.return called on incompatible receiver %s", r.objectproto_toString(FunctionCall{This: thisObj})))
+}
+
+func (r *Runtime) wrapForValidIteratorProto_next(call FunctionCall) Value {
+	thisObj := r.toObject(call.This)
+	if iter, ok := thisObj.self.(*wrapForValidIteratorObject); ok {
+		return iter.iterated.next(FunctionCall{This: iter.iterated.iterator})
+	}
+	panic(r.NewTypeError("Method next called on incompatible receiver %s", r.objectproto_toString(FunctionCall{This: thisObj})))
+}
+
+func (r *Runtime) wrapForValidIteratorProto_return(call FunctionCall) Value {
+	thisObj := r.toObject(call.This)
+	if iter, ok := thisObj.self.(*wrapForValidIteratorObject); ok {
+		iterator := iter.iterated.iterator
+		retMethod := toMethod(iterator.self.getStr("return", nil))
+		if retMethod == nil {
+			return r.createIterResultObject(_undefined, true)
+		}
+		return retMethod(FunctionCall{This: iterator})
+	}
+	panic(r.NewTypeError("Method return called on incompatible receiver %s", r.objectproto_toString(FunctionCall{This: thisObj})))
+}
+
+// setterThatIgnoresPrototypeProperties implements https://tc39.es/ecma262/#sec-SetterThatIgnoresPrototypeProperties
+func (r *Runtime) setterThatIgnoresPrototypeProperties(this Value, home *Object, p, v Value) {
+	o, ok := this.(*Object)
+	if !ok {
+		panic(r.NewTypeError("Cannot set property %s on a non-object", p.String()))
+	}
+	if o == home {
+		panic(r.NewTypeError("Cannot assign to read only property '%s'", p.String()))
+	}
+	if o.getOwnProp(p) == nil {
+		createDataPropertyOrThrow(o, p, v)
+	} else {
+		o.set(p, v, o, true)
+	}
+}
+
+func (r *Runtime) iteratorProto_getConstructor(FunctionCall) Value {
+	return r.global.Iterator
+}
+
+func (r *Runtime) iteratorProto_setConstructor(call FunctionCall) Value {
+	r.setterThatIgnoresPrototypeProperties(call.This, r.global.IteratorPrototype, asciiString("constructor"), call.Argument(0))
+	return _undefined
+}
+
+func (r *Runtime) iteratorProto_getToStringTag(FunctionCall) Value {
+	return asciiString(classIterator)
+}
+
+func (r *Runtime) iteratorProto_setToStringTag(call FunctionCall) Value {
+	r.setterThatIgnoresPrototypeProperties(call.This, r.global.IteratorPrototype, SymToStringTag, call.Argument(0))
+	return _undefined
+}
+
+func (r *Runtime) createIterProto(val *Object) objectImpl {
+	o := newBaseObjectObj(val, r.global.ObjectPrototype, classObject)
+
+	o._put("constructor", &valueProperty{
+		getterFunc:   r.newNativeFunc(r.iteratorProto_getConstructor, nil, "get constructor", nil, 0),
+		setterFunc:   r.newNativeFunc(r.iteratorProto_setConstructor, nil, "set constructor", nil, 1),
+		accessor:     true,
+		configurable: true,
+	})
+	o._putProp("drop", r.newNativeFunc(r.iteratorProto_drop, nil, "drop", nil, 1), true, false, true)
+	o._putProp("every", r.newNativeFunc(r.iteratorProto_every, nil, "every", nil, 1), true, false, true)
+	o._putProp("filter", r.newNativeFunc(r.iteratorProto_filter, nil, "filter", nil, 1), true, false, true)
+	o._putProp("find", r.newNativeFunc(r.iteratorProto_find, nil, "find", nil, 1), true, false, true)
+	o._putProp("flatMap", r.newNativeFunc(r.iteratorProto_flatMap, nil, "flatMap", nil, 1), true, false, true)
+	o._putProp("forEach", r.newNativeFunc(r.iteratorProto_forEach, nil, "forEach", nil, 1), true, false, true)
+	o._putProp("map", r.newNativeFunc(r.iteratorProto_map, nil, "map", nil, 1), true, false, true)
+	o._putProp("reduce", r.newNativeFunc(r.iteratorProto_reduce, nil, "reduce", nil, 1), true, false, true)
+	o._putProp("some", r.newNativeFunc(r.iteratorProto_some, nil, "some", nil, 1), true, false, true)
+	o._putProp("take", r.newNativeFunc(r.iteratorProto_take, nil, "take", nil, 1), true, false, true)
+	o._putProp("toArray", r.newNativeFunc(r.iteratorProto_toArray, nil, "toArray", nil, 0), true, false, true)
+
+	o._putSym(SymIterator, valueProp(r.newNativeFunc(r.returnThis, nil, "[Symbol.iterator]", nil, 0), true, false, true))
+	o._putSym(SymToStringTag, &valueProperty{
+		getterFunc:   r.newNativeFunc(r.iteratorProto_getToStringTag, nil, "get [Symbol.toStringTag]", nil, 0),
+		setterFunc:   r.newNativeFunc(r.iteratorProto_setToStringTag, nil, "set [Symbol.toStringTag]", nil, 1),
+		accessor:     true,
+		configurable: true,
+	})
+	return o
+}
+
+func (r *Runtime) createIterator(val *Object) objectImpl {
+	o := r.newNativeConstructOnly(val, r.builtin_newIterator, r.global.IteratorPrototype, "Iterator", 0)
+	o._putProp("from", r.newNativeFunc(r.iterator_from, nil, "from", nil, 1), true, false, true)
+
+	return o
+}
+
+func (r *Runtime) createIteratorHelperProto(val *Object) objectImpl {
+	o := newBaseObjectObj(val, r.global.IteratorPrototype, classObject)
+
+	o._putProp("next", r.newNativeFunc(r.iteratorHelperProto_next, nil, "next", nil, 0), true, false, true)
+	o._putProp("return", r.newNativeFunc(r.iteratorHelperProto_return, nil, "return", nil, 0), true, false, true)
+	o._putSym(SymToStringTag, valueProp(asciiString(classIteratorHelper), false, false, true))
+
+	return o
+}
+
+func (r *Runtime) createWrapForValidIteratorProto(val *Object) objectImpl {
+	o := newBaseObjectObj(val, r.global.IteratorPrototype, classObject)
+
+	o._putProp("next", r.newNativeFunc(r.wrapForValidIteratorProto_next, nil, "next", nil, 0), true, false, true)
+	o._putProp("return", r.newNativeFunc(r.wrapForValidIteratorProto_return, nil, "return", nil, 0), true, false, true)
+
+	return o
+}
+
+func (r *Runtime) initIterator() {
+	r.global.IteratorPrototype = r.newLazyObject(r.createIterProto)
+	r.global.IteratorHelperPrototype = r.newLazyObject(r.createIteratorHelperProto)
+	r.global.WrapForValidIteratorPrototype = r.newLazyObject(r.createWrapForValidIteratorProto)
+	r.global.Iterator = r.newLazyObject(r.createIterator)
+
+	r.addToGlobal("Iterator", r.global.Iterator)
+}
@@ -0,0 +1,120 @@
+package goja
+
+import "testing"
+
+func TestIteratorHelpers(t *testing.T) {
+	const SCRIPT = `
+	const m = new Map([[1, "a"], [2, "b"], [3, "c"], [4, "d"]]);
+	const res = m.keys().filter(k => k % 2 === 0).map(k => k * 10).toArray();
+	assert(compareArray(res, [20, 40]), "filter/map");
+
+	assert(compareArray(new Set([1, 2, 3, 4, 5]).values().drop(1).take(3).toArray(), [2, 3, 4]), "drop/take");
+	assert(compareArray([1, 2].values().flatMap(x => [x, x]).toArray(), [1, 1, 2, 2]), "flatMap");
+	assert.sameValue([1, 2, 3].values().reduce((a, b) => a + b), 6, "reduce");
+	assert.sameValue([1, 2, 3].values().reduce((a, b) => a + b, 10), 16, "reduce with initial");
+	assert.throws(TypeError, () => [].values().reduce((a, b) => a + b));
+	assert.sameValue([1, 2, 3].values().some(x => x > 2), true, "some");
+	assert.sameValue([1, 2, 3].values().every(x => x > 2), false, "every");
+	assert.sameValue([1, 2, 3].values().find(x => x > 1), 2, "find");
+
+	let sum = 0;
+	[1, 2, 3].values().forEach((x, i) => { sum += x * i });
+	assert.sameValue(sum, 8, "forEach");
+
+	assert.throws(RangeError, () => [].values().take(-1));
+	assert.throws(RangeError, () => [].values().drop(NaN));
+	assert.throws(TypeError, () => Iterator.prototype.map.call({}, 1));
+	assert.throws(TypeError, () => new Iterator());
+	assert.throws(TypeError, () => Iterator());
+	assert.sameValue(Object.prototype.toString.call([].values().map(x => x)), "[object Iterator Helper]");
+	`
+	testScriptWithTestLib(SCRIPT, _undefined, t)
+}
+
+func TestIteratorHelpersLazy(t *testing.T) {
+	const SCRIPT = `
+	let calls = 0;
+	let closed = 0;
+	const it = {
+		__proto__: Iterator.prototype,
+		next() {
+			calls++;
+			return {value: calls, done: false};
+		},
+		return() {
+			closed++;
+			return {};
+		}
+	};
+	const res = it.map(x => x * 2).take(3).toArray();
+	assert(compareArray(res, [2, 4, 6]), "result");
+	assert.sameValue(calls, 3, "calls");
+	assert.sameValue(closed, 1, "closed");
+
+	const h = it.filter(() => true);
+	h.next();
+	h.return();
+	assert.sameValue(closed, 2, "closed after return");
+	assert.sameValue(h.next().done, true, "done after return");
+
+	assert.throws(Error, () => it.map(() => { throw new Error() }).next());
+	assert.sameValue(closed, 3, "closed after throw");
+	`
+	testScriptWithTestLib(SCRIPT, _undefined, t)
+}
+
+func TestIteratorFrom(t *testing.T) {
+	const SCRIPT = `
+	let i = 0;
+	const plain = {
+		next() {
+			return {value: i++, done: i > 3};
+		}
+	};
+	const wrapped = Iterator.from(plain);
+	assert.sameValue(Object.getPrototypeOf(Object.getPrototypeOf(wrapped)), Iterator.prototype, "wrapped proto");
+	assert(compareArray(wrapped.toArray(), [0, 1, 2]), "wrapped values");
+	assert.sameValue(wrapped.return().done, true, "return without underlying return");
+
+	const arrIter = [1].values();
+	assert.sameValue(Iterator.from(arrIter), arrIter, "iterator is returned as is");
+	assert(compareArray(Iterator.from("ab").toArray(), ["a", "b"]), "string");
+	assert.throws(TypeError, () => Iterator.from(1));
+
+	class MyIter extends Iterator {
+		next() {
+			return {done: true};
+		}
+	}
+	assert(new MyIter() instanceof Iterator, "subclass");
+	`
+	testScriptWithTestLib(SCRIPT, _undefined, t)
+}
+
+func TestIteratorProtoAccessors(t *testing.T) {
+	const SCRIPT = `
+	for (const [key, value] of [["constructor", Iterator], [Symbol.toStringTag, "Iterator"]]) {
+		const desc = Object.getOwnPropertyDescriptor(Iterator.prototype, key);
+		assert.sameValue(typeof desc.get, "function", "getter");
+		assert.sameValue(typeof desc.set, "function", "setter");
+		assert.sameValue(desc.enumerable, false, "enumerable");
+		assert.sameValue(desc.configurable, true, "configurable");
+		assert.sameValue(Iterator.prototype[key], value, "value");
+
+		assert.throws(TypeError, () => { Iterator.prototype[key] = 1 }, "set on the prototype");
+		assert.sameValue(Iterator.prototype[key], value, "value after set on the prototype");
+		assert.throws(TypeError, () => desc.set.call(1, 1), "set on a primitive");
+
+		const o = Object.create(Iterator.prototype);
+		o[key] = 1;
+		assert.sameValue(o[key], 1, "own property created");
+		assert(Object.getOwnPropertyDescriptor(o, key).enumerable, "created property is enumerable");
+		o[key] = 2;
+		assert.sameValue(o[key], 2, "own property updated");
+		Object.defineProperty(o, key, {writable: false});
+		assert.throws(TypeError, () => desc.set.call(o, 3), "non-writable own property");
+	}
+	assert.sameValue(Object.prototype.toString.call(Iterator.from({next() {}})), "[object Iterator]", "toString");
+	`
+	testScriptWithTestLib(SCRIPT, _undefined, t)
+}
@@ -43,6 +43,7 @@ func (r *Runtime) builtinJSON_parse(call FunctionCall) Value {
 }
 
 func (r *Runtime) builtinJSON_decodeToken(d *json.Decoder, tok json.Token) (Value, error) {
+	r.vm.checkInterrupt()
 	switch tok := tok.(type) {
 	case json.Delim:
 		switch tok {
@@ -133,6 +134,7 @@ func (r *Runtime) builtinJSON_decodeArray(d *json.Decoder) (*Object, error) {
 }
 
 func (r *Runtime) builtinJSON_reviveWalk(reviver func(FunctionCall) Value, holder *Object, name Value) Value {
+	r.vm.checkInterrupt()
 	value := nilSafe(holder.get(name, nil))
 
 	if object, ok := value.(*Object); ok {
@@ -171,6 +173,7 @@ type _builtinJSON_stringifyContext struct {
 	replacerFunction func(FunctionCall) Value
 	gap, indent      string
 	buf              bytes.Buffer
+	accounted        int64
 }
 
 func (r *Runtime) builtinJSON_stringify(call FunctionCall) Value {
@@ -254,7 +257,7 @@ func (r *Runtime) builtinJSON_stringify(call FunctionCall) Value {
 	}
 
 	if ctx.do(call.Argument(0)) {
-		return newStringValue(ctx.buf.String())
+		return r.allocString(newStringValue(ctx.buf.String()))
 	}
 	return _undefined
 }
@@ -265,7 +268,19 @@ func (ctx *_builtinJSON_stringifyContext) do(v Value) bool {
 	return ctx.str(stringEmpty, holder)
 }
 
+// accountMem adds the growth of the buffer (including extraLen bytes which are about to be written) to the memory
+// usage of the Runtime.
+func (ctx *_builtinJSON_stringifyContext) accountMem(extraLen int) {
+	if size := int64(ctx.buf.Len()) + int64(extraLen); size > ctx.accounted {
+		ctx.r.allocMem(size - ctx.accounted)
+		ctx.accounted = size
+	}
+}
+
 func (ctx *_builtinJSON_stringifyContext) str(key Value, holder *Object) bool {
+	ctx.r.vm.checkInterrupt()
+	ctx.r.vm.charge(1)
+	ctx.accountMem(0)
 	value := nilSafe(holder.get(key, nil))
 
 	if object, ok := value.(*Object); ok {
@@ -463,6 +478,7 @@ func (ctx *_builtinJSON_stringifyContext) jo(object *Object) {
 }
 
 func (ctx *_builtinJSON_stringifyContext) quote(str valueString) {
+	ctx.accountMem(str.length() + 2)
 	ctx.buf.WriteByte('"')
 	reader := &lenientUtf16Decoder{utf16Reader: str.utf16Reader(0)}
 	for {
@@ -44,6 +44,7 @@ func (o *mapIterObject) next() Value {
 func (mo *mapObject) init() {
 	mo.baseObject.init()
 	mo.m = newOrderedMap(mo.val.runtime.getHash())
+	mo.m.runtime = mo.val.runtime
 }
 
 func (mo *mapObject) exportType() reflect.Type {
@@ -199,15 +200,8 @@ func (r *Runtime) builtin_newMap(args []Value, newTarget *Object) *Object {
 		panic(r.needNew("Map"))
 	}
 	proto := r.getPrototypeFromCtor(newTarget, r.global.Map, r.global.MapPrototype)
-	o := &Object{runtime: r}
-
-	mo := &mapObject{}
-	mo.class = classMap
-	mo.val = o
-	mo.extensible = true
-	o.self = mo
-	mo.prototype = proto
-	mo.init()
+	mo := r.newMapObject(proto)
+	o := mo.val
 	if len(args) > 0 {
 		if arg := args[0]; arg != nil && arg != _undefined && arg != _null {
 			adder := mo.getStr("set", nil)
@@ -238,6 +232,19 @@ func (r *Runtime) builtin_newMap(args []Value, newTarget *Object) *Object {
 	return o
 }
 
+func (r *Runtime) newMapObject(proto *Object) *mapObject {
+	o := &Object{runtime: r}
+
+	mo := &mapObject{}
+	mo.class = classMap
+	mo.val = o
+	mo.extensible = true
+	o.self = mo
+	mo.prototype = proto
+	mo.init()
+	return mo
+}
+
 func (r *Runtime) createMapIterator(mapValue Value, kind iterationKind) Value {
 	obj := r.toObject(mapValue)
 	mapObj, ok := obj.self.(*mapObject)
@@ -277,30 +277,34 @@ func (r *Runtime) object_seal(call FunctionCall) Value {
 	return arg
 }
 
-func (r *Runtime) object_freeze(call FunctionCall) Value {
-	arg := call.Argument(0)
-	if obj, ok := arg.(*Object); ok {
-		obj.self.preventExtensions(true)
-
-		for item, next := obj.self.iterateKeys()(); next != nil; item, next = next() {
-			if prop, ok := item.value.(*valueProperty); ok {
-				prop.configurable = false
-				if !prop.accessor {
-					prop.writable = false
-				}
+func freezeObject(obj *Object) {
+	obj.self.preventExtensions(true)
+
+	for item, next := obj.self.iterateKeys()(); next != nil; item, next = next() {
+		if prop, ok := item.value.(*valueProperty); ok {
+			prop.configurable = false
+			if !prop.accessor {
+				prop.writable = false
+			}
+		} else {
+			prop := obj.getOwnProp(item.name)
+			descr := PropertyDescriptor{
+				Configurable: FLAG_FALSE,
+			}
+			if prop, ok := prop.(*valueProperty); ok && prop.accessor {
+				// no-op
 			} else {
-				prop := obj.getOwnProp(item.name)
-				descr := PropertyDescriptor{
-					Configurable: FLAG_FALSE,
-				}
-				if prop, ok := prop.(*valueProperty); ok && prop.accessor {
-					// no-op
-				} else {
-					descr.Writable = FLAG_FALSE
-				}
-				obj.defineOwnProperty(item.name, descr, true)
+				descr.Writable = FLAG_FALSE
 			}
+			obj.defineOwnProperty(item.name, descr, true)
 		}
+	}
+}
+
+func (r *Runtime) object_freeze(call FunctionCall) Value {
+	arg := call.Argument(0)
+	if obj, ok := arg.(*Object); ok {
+		freezeObject(obj)
 		return obj
 	} else {
 		// ES6 behavior
@@ -1,6 +1,8 @@
 package goja
 
 import (
+	gocontext "context"
+	"errors"
 	"github.com/dop251/goja/unistring"
 	"reflect"
 )
@@ -28,8 +30,18 @@ const (
 
 type PromiseRejectionTracker func(p *Promise, operation PromiseRejectionOperation)
 
+// HostEnqueuePromiseJob is called to schedule a Promise job (such as a reaction to a resolved Promise or a callback
+// passed to queueMicrotask()) instead of adding it to the Runtime's own queue.
+// See https://tc39.es/ecma262/#sec-hostenqueuepromisejob for more details.
+//
+// The job must eventually be called on the vm goroutine when no JavaScript code is running, in the same order as
+// the jobs were enqueued. It returns an error if the job was interrupted or stopped by a limit (see
+// Runtime.Interrupt(), Runtime.SetInstructionLimit()). In this case the host should discard the remaining jobs.
+type HostEnqueuePromiseJob func(job func() error)
+
 type jobCallback struct {
 	callback func(FunctionCall) Value
+	realm    *Realm
 }
 
 type promiseCapability struct {
@@ -68,6 +80,26 @@ func (p *Promise) Result() Value {
 	return p.result
 }
 
+// Then registers Go callbacks that are called once the Promise is fulfilled or rejected, in the same way as
+// the functions passed to Promise.prototype.then(). Either callback may be nil in which case the corresponding
+// outcome is ignored. The callbacks are called from a Promise job, i.e. not before the currently running script
+// returns, or if no script is running, when RunPendingJobs() (or AwaitPromise()) is called.
+//
+// Calling this method marks the Promise as handled (see SetPromiseRejectionTracker()).
+// It must be called from the vm goroutine.
+func (p *Promise) Then(onFulfilled, onRejected func(Value)) {
+	r := p.val.runtime
+	wrap := func(f func(Value)) *jobCallback {
+		return r.makeJobCallback(nil, func(call FunctionCall) Value {
+			if f != nil {
+				f(call.Argument(0))
+			}
+			return _undefined
+		})
+	}
+	r.addPromiseReactions(p, wrap(onFulfilled), wrap(onRejected), nil)
+}
+
 func (p *Promise) toValue(r *Runtime) Value {
 	if p == nil || p.val == nil {
 		return _null
@@ -100,7 +132,7 @@ func (p *Promise) createResolvingFunctions() (resolve, reject *Object) {
 					return p.reject(ex.val)
 				}
 				if call, ok := assertCallable(thenAction); ok {
-					job := r.newPromiseResolveThenableJob(p, resolution, &jobCallback{callback: call})
+					job := r.newPromiseResolveThenableJob(p, resolution, r.makeJobCallback(thenAction, call))
 					r.enqueuePromiseJob(job)
 					return _undefined
 				}
@@ -162,6 +194,12 @@ func (r *Runtime) newPromiseResolveThenableJob(p *Promise, thenable Value, then
 }
 
 func (r *Runtime) enqueuePromiseJob(job func()) {
+	if r.hostEnqueuePromiseJob != nil {
+		r.hostEnqueuePromiseJob(func() error {
+			return r.runJobs(job)
+		})
+		return
+	}
 	r.jobQueue = append(r.jobQueue, job)
 }
 
@@ -282,11 +320,19 @@ func (r *Runtime) newPromiseCapability(c *Object) *promiseCapability {
 func (r *Runtime) performPromiseThen(p *Promise, onFulfilled, onRejected Value, resultCapability *promiseCapability) Value {
 	var onFulfilledJobCallback, onRejectedJobCallback *jobCallback
 	if f, ok := assertCallable(onFulfilled); ok {
-		onFulfilledJobCallback = &jobCallback{callback: f}
+		onFulfilledJobCallback = r.makeJobCallback(onFulfilled, f)
 	}
 	if f, ok := assertCallable(onRejected); ok {
-		onRejectedJobCallback = &jobCallback{callback: f}
+		onRejectedJobCallback = r.makeJobCallback(onRejected, f)
+	}
+	r.addPromiseReactions(p, onFulfilledJobCallback, onRejectedJobCallback, resultCapability)
+	if resultCapability == nil {
+		return _undefined
 	}
+	return resultCapability.promise
+}
+
+func (r *Runtime) addPromiseReactions(p *Promise, onFulfilledJobCallback, onRejectedJobCallback *jobCallback, resultCapability *promiseCapability) {
 	fulfillReaction := &promiseReaction{
 		capability: resultCapability,
 		typ:        promiseReactionFulfill,
@@ -311,10 +357,27 @@ func (r *Runtime) performPromiseThen(p *Promise, onFulfilled, onRejected Value,
 		r.enqueuePromiseJob(r.newPromiseReactionJob(rejectReaction, reason))
 	}
 	p.handled = true
-	if resultCapability == nil {
-		return _undefined
+}
+
+// await resolves v into a Promise and arranges for onFulfilled or onRejected to be called from a promise job
+// once it has settled, which is how an Await() step of an async built-in is implemented. Any panics in the
+// callbacks are swallowed, so they must handle errors themselves.
+func (r *Runtime) await(v Value, onFulfilled, onRejected func(Value)) {
+	po := r.promiseResolve(r.global.Promise, v)
+	p, ok := po.self.(*Promise)
+	if !ok {
+		// a thenable whose constructor is %Promise%
+		pcap := r.newPromiseCapability(r.global.Promise)
+		pcap.resolve(po)
+		p = pcap.promise.self.(*Promise)
 	}
-	return resultCapability.promise
+	r.addPromiseReactions(p, r.makeJobCallback(nil, func(call FunctionCall) Value {
+		onFulfilled(call.Argument(0))
+		return _undefined
+	}), r.makeJobCallback(nil, func(call FunctionCall) Value {
+		onRejected(call.Argument(0))
+		return _undefined
+	}), nil)
 }
 
 func (r *Runtime) promiseProto_catch(call FunctionCall) Value {
@@ -529,6 +592,32 @@ func (r *Runtime) promise_resolve(call FunctionCall) Value {
 	return r.promiseResolve(r.toObject(call.This), call.Argument(0))
 }
 
+func (r *Runtime) promise_try(call FunctionCall) Value {
+	c := r.toObject(call.This)
+	pcap := r.newPromiseCapability(c)
+	fn := call.Argument(0)
+	var args []Value
+	if len(call.Arguments) > 1 {
+		args = call.Arguments[1:]
+	}
+	var res Value
+	if pcap.try(func() {
+		res = r.toCallable(fn)(FunctionCall{This: _undefined, Arguments: args})
+	}) {
+		pcap.resolve(res)
+	}
+	return pcap.promise
+}
+
+func (r *Runtime) promise_withResolvers(call FunctionCall) Value {
+	pcap := r.newPromiseCapability(r.toObject(call.This))
+	o := r.NewObject()
+	o.self.setOwnStr("promise", pcap.promise, false)
+	o.self.setOwnStr("resolve", pcap.resolveObj, false)
+	o.self.setOwnStr("reject", pcap.rejectObj, false)
+	return o
+}
+
 func (r *Runtime) createPromiseProto(val *Object) objectImpl {
 	o := newBaseObjectObj(val, r.global.ObjectPrototype, classObject)
 	o._putProp("constructor", r.global.Promise, true, false, true)
@@ -551,6 +640,8 @@ func (r *Runtime) createPromise(val *Object) objectImpl {
 	o._putProp("race", r.newNativeFunc(r.promise_race, nil, "race", nil, 1), true, false, true)
 	o._putProp("reject", r.newNativeFunc(r.promise_reject, nil, "reject", nil, 1), true, false, true)
 	o._putProp("resolve", r.newNativeFunc(r.promise_resolve, nil, "resolve", nil, 1), true, false, true)
+	o._putProp("try", r.newNativeFunc(r.promise_try, nil, "try", nil, 1), true, false, true)
+	o._putProp("withResolvers", r.newNativeFunc(r.promise_withResolvers, nil, "withResolvers", nil, 0), true, false, true)
 
 	r.putSpeciesReturnThis(o)
 
@@ -606,3 +697,87 @@ func (r *Runtime) NewPromise() (promise *Promise, resolve func(result interface{
 func (r *Runtime) SetPromiseRejectionTracker(tracker PromiseRejectionTracker) {
 	r.promiseRejectionTracker = tracker
 }
+
+// SetHostEnqueuePromiseJob registers a function that will be called to schedule Promise jobs instead of adding them
+// to the Runtime's own queue. This allows a host to interleave the jobs with other tasks, see HostEnqueuePromiseJob.
+// The jobs that are already queued are not affected, use RunPendingJobs() to run them.
+//
+// Setting it to nil restores the default behaviour.
+func (r *Runtime) SetHostEnqueuePromiseJob(f HostEnqueuePromiseJob) {
+	r.hostEnqueuePromiseJob = f
+}
+
+// ErrPromisePending is returned by AwaitPromise() if the Promise is still pending after all queued jobs have run.
+var ErrPromisePending = errors.New("promise is still pending")
+
+// AwaitPromise runs the queued Promise jobs (see RunPendingJobs()) and returns the Promise's result once it has
+// settled. If the Promise is rejected, the returned error is an *Exception containing the rejection reason.
+// If the Promise is still pending when there are no more jobs to run (i.e. it depends on something else, such as a
+// timer or I/O completion), ErrPromisePending is returned. To wait for such a Promise from another goroutine,
+// use EventLoop.AwaitPromise().
+//
+// The jobs are interrupted when ctx is done, see RunProgramContext(). If it is called while JavaScript code is
+// running (e.g. from a Go function called by JavaScript), the jobs are not run as this would break the ordering
+// guarantees.
+//
+// If the Promise jobs are scheduled by the host (see SetHostEnqueuePromiseJob()), they are not in the Runtime's queue
+// so AwaitPromise() does not run anything: it returns the result if the Promise has already settled and
+// ErrPromisePending otherwise. In this case the host must run its jobs before calling it.
+func (r *Runtime) AwaitPromise(ctx gocontext.Context, p *Promise) (Value, error) {
+	if p.state == PromiseStatePending && len(r.vm.callStack) == 0 {
+		if err := r.runContext(ctx, r.RunPendingJobs); err != nil {
+			return nil, err
+		}
+	}
+	switch p.state {
+	case PromiseStateFulfilled:
+		return p.result, nil
+	case PromiseStateRejected:
+		if !p.handled {
+			r.trackPromiseRejection(p, PromiseRejectionHandle)
+			p.handled = true
+		}
+		return nil, &Exception{
+			val: p.result,
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrPromisePending
+}
+
+// RunPendingJobs runs the queued Promise jobs, including the ones enqueued while running, until the queue is empty.
+// Normally this happens automatically when the control returns from JavaScript to Go, so this is only needed
+// when Promises are resolved outside a running script in a way that bypasses it.
+// If a job is interrupted or stops because of a limit, the remaining jobs are discarded and the error is returned.
+// It must not be called while JavaScript code is running (e.g. from a Go function called by JavaScript).
+func (r *Runtime) RunPendingJobs() error {
+	return r.runJobs(nil)
+}
+
+// HasPendingJobs returns true if there are Promise jobs waiting to be run, see RunPendingJobs().
+func (r *Runtime) HasPendingJobs() bool {
+	return len(r.jobQueue) > 0
+}
+
+// runJobs runs job (if not nil) and then drains the job queue.
+func (r *Runtime) runJobs(job func()) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			if ex, ok := x.(*uncatchableException); ok {
+				err = ex.err
+				if len(r.vm.callStack) == 0 {
+					r.leaveAbrupt()
+				}
+			} else {
+				panic(x)
+			}
+		}
+	}()
+	if job != nil {
+		job()
+	}
+	r.leave()
+	return
+}
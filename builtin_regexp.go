@@ -723,7 +723,7 @@ func (r *Runtime) regexpproto_stdMatcher(call FunctionCall) Value {
 		return r.regexpproto_stdMatcherGeneric(thisObj, s)
 	}
 	if rx.pattern.global {
-		res := rx.pattern.findAllSubmatchIndex(s, 0, -1, rx.pattern.sticky)
+		res := rx.pattern.findAllSubmatchIndex(r.vm, s, 0, -1, rx.pattern.sticky)
 		if len(res) == 0 {
 			rx.setOwnStr("lastIndex", intToValue(0), true)
 			return _null
@@ -991,7 +991,7 @@ func (r *Runtime) regexpproto_stdSplitter(call FunctionCall) Value {
 	lastIndex := 0
 	found := 0
 
-	result := pattern.findAllSubmatchIndex(s, 0, -1, false)
+	result := pattern.findAllSubmatchIndex(r.vm, s, 0, -1, false)
 	if targetLength == 0 {
 		if result == nil {
 			valueArray = append(valueArray, s)
@@ -1064,7 +1064,7 @@ func (r *Runtime) regexpproto_stdReplacerGeneric(rxObj *Object, s, replaceStr va
 	}
 	lengthS := s.length()
 	nextSourcePosition := 0
-	var resultBuf valueStringBuilder
+	resultBuf := valueStringBuilder{runtime: r}
 	for _, result := range results {
 		obj := r.toObject(result)
 		nCaptures := max(toLength(obj.self.getStr("length", nil))-1, 0)
@@ -1188,7 +1188,7 @@ func (r *Runtime) regexpproto_stdReplacer(call FunctionCall) Value {
 	} else {
 		index = rx.getLastIndex()
 	}
-	found := rx.pattern.findAllSubmatchIndex(s, toIntStrict(index), find, rx.pattern.sticky)
+	found := rx.pattern.findAllSubmatchIndex(r.vm, s, toIntStrict(index), find, rx.pattern.sticky)
 	if len(found) > 0 {
 		if !rx.updateLastIndex(index, found[0], found[len(found)-1]) {
 			found = nil
@@ -1197,7 +1197,7 @@ func (r *Runtime) regexpproto_stdReplacer(call FunctionCall) Value {
 		rx.updateLastIndex(index, nil, nil)
 	}
 
-	return stringReplace(s, found, replaceStr, rcall)
+	return r.stringReplace(s, found, replaceStr, rcall)
 }
 
 func (r *Runtime) regExpStringIteratorProto_next(call FunctionCall) Value {
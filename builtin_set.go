@@ -2,6 +2,7 @@ package goja
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 )
 
@@ -43,6 +44,7 @@ func (o *setIterObject) next() Value {
 func (so *setObject) init() {
 	so.baseObject.init()
 	so.m = newOrderedMap(so.val.runtime.getHash())
+	so.m.runtime = so.val.runtime
 }
 
 func (so *setObject) exportType() reflect.Type {
@@ -191,20 +193,233 @@ func (r *Runtime) setProto_values(call FunctionCall) Value {
 	return r.createSetIterator(call.This, iterationKindValue)
 }
 
+type setRecord struct {
+	obj  *Object
+	size int64
+	has  func(FunctionCall) Value
+	keys func(FunctionCall) Value
+
+	// set if obj is a Set with unmodified has and keys, in which case its data can be accessed directly
+	native *setObject
+}
+
+// getSetRecord implements the GetSetRecord abstract operation.
+func (r *Runtime) getSetRecord(v Value) *setRecord {
+	obj, ok := v.(*Object)
+	if !ok {
+		panic(r.NewTypeError("%s is not an object", v.String()))
+	}
+	num := nilSafe(obj.self.getStr("size", nil)).ToNumber()
+	if f, ok := num.(valueFloat); ok && math.IsNaN(float64(f)) {
+		panic(r.NewTypeError("The 'size' property must be a number"))
+	}
+	size := num.ToInteger()
+	if size < 0 {
+		panic(r.newError(r.global.RangeError, "The 'size' property must not be negative"))
+	}
+	hasVal := obj.self.getStr("has", nil)
+	has := toMethod(hasVal)
+	if has == nil {
+		panic(r.NewTypeError("The 'has' property must be a function"))
+	}
+	keysVal := obj.self.getStr("keys", nil)
+	keys := toMethod(keysVal)
+	if keys == nil {
+		panic(r.NewTypeError("The 'keys' property must be a function"))
+	}
+	rec := &setRecord{
+		obj:  obj,
+		size: size,
+		has:  has,
+		keys: keys,
+	}
+	if so, ok := obj.self.(*setObject); ok && hasVal == r.global.setHas && keysVal == r.global.setValues &&
+		r.global.SetIteratorPrototype.self.getStr("next", nil) == r.global.setIterNext {
+		rec.native = so
+	}
+	return rec
+}
+
+func (sr *setRecord) contains(v Value) bool {
+	if sr.native != nil {
+		return sr.native.m.has(v)
+	}
+	return sr.has(FunctionCall{This: sr.obj, Arguments: []Value{v}}).ToBoolean()
+}
+
+// iterateKeys calls step for every key returned by the keys() method. If step returns false the iteration
+// stops and the iterator is closed.
+func (sr *setRecord) iterateKeys(step func(Value) bool) {
+	if sr.native != nil {
+		iter := sr.native.m.newIter()
+		for entry := iter.next(); entry != nil; entry = iter.next() {
+			if !step(entry.key) {
+				return
+			}
+		}
+		return
+	}
+	r := sr.obj.runtime
+	iter := r.toObject(sr.keys(FunctionCall{This: sr.obj}))
+	ir := &iteratorRecord{
+		iterator: iter,
+		next:     r.toCallable(nilSafe(iter.self.getStr("next", nil))),
+	}
+	for {
+		value, ok := ir.nextValue()
+		if !ok {
+			return
+		}
+		if !step(value) {
+			ir.returnIter()
+			return
+		}
+	}
+}
+
+func (r *Runtime) setProtoThis(call FunctionCall, name string) *setObject {
+	thisObj := r.toObject(call.This)
+	so, ok := thisObj.self.(*setObject)
+	if !ok {
+		panic(r.NewTypeError("Method Set.prototype.%s called on incompatible receiver %s", name, r.objectproto_toString(FunctionCall{This: thisObj})))
+	}
+	return so
+}
+
+func (r *Runtime) newSetFromMap(m *orderedMap) *Object {
+	so := r.newSetObject(r.global.SetPrototype)
+	so.m = m
+	return so.val
+}
+
+func (r *Runtime) setProto_difference(call FunctionCall) Value {
+	so := r.setProtoThis(call, "difference")
+	other := r.getSetRecord(call.Argument(0))
+	result := so.m.copy()
+	if int64(so.m.size) <= other.size {
+		iter := so.m.newIter()
+		for entry := iter.next(); entry != nil; entry = iter.next() {
+			if other.contains(entry.key) {
+				result.remove(entry.key)
+			}
+		}
+	} else {
+		other.iterateKeys(func(key Value) bool {
+			result.remove(key)
+			return true
+		})
+	}
+	return r.newSetFromMap(result)
+}
+
+func (r *Runtime) setProto_intersection(call FunctionCall) Value {
+	so := r.setProtoThis(call, "intersection")
+	other := r.getSetRecord(call.Argument(0))
+	result := newOrderedMap(r.getHash())
+	result.runtime = r
+	if int64(so.m.size) <= other.size {
+		iter := so.m.newIter()
+		for entry := iter.next(); entry != nil; entry = iter.next() {
+			key := entry.key
+			if other.contains(key) {
+				result.set(key, nil)
+			}
+		}
+	} else {
+		other.iterateKeys(func(key Value) bool {
+			if so.m.has(key) {
+				result.set(key, nil)
+			}
+			return true
+		})
+	}
+	return r.newSetFromMap(result)
+}
+
+func (r *Runtime) setProto_isDisjointFrom(call FunctionCall) Value {
+	so := r.setProtoThis(call, "isDisjointFrom")
+	other := r.getSetRecord(call.Argument(0))
+	if int64(so.m.size) <= other.size {
+		iter := so.m.newIter()
+		for entry := iter.next(); entry != nil; entry = iter.next() {
+			if other.contains(entry.key) {
+				return valueFalse
+			}
+		}
+		return valueTrue
+	}
+	disjoint := true
+	other.iterateKeys(func(key Value) bool {
+		if so.m.has(key) {
+			disjoint = false
+		}
+		return disjoint
+	})
+	return r.toBoolean(disjoint)
+}
+
+func (r *Runtime) setProto_isSubsetOf(call FunctionCall) Value {
+	so := r.setProtoThis(call, "isSubsetOf")
+	other := r.getSetRecord(call.Argument(0))
+	if int64(so.m.size) > other.size {
+		return valueFalse
+	}
+	iter := so.m.newIter()
+	for entry := iter.next(); entry != nil; entry = iter.next() {
+		if !other.contains(entry.key) {
+			return valueFalse
+		}
+	}
+	return valueTrue
+}
+
+func (r *Runtime) setProto_isSupersetOf(call FunctionCall) Value {
+	so := r.setProtoThis(call, "isSupersetOf")
+	other := r.getSetRecord(call.Argument(0))
+	if int64(so.m.size) < other.size {
+		return valueFalse
+	}
+	superset := true
+	other.iterateKeys(func(key Value) bool {
+		superset = so.m.has(key)
+		return superset
+	})
+	return r.toBoolean(superset)
+}
+
+func (r *Runtime) setProto_symmetricDifference(call FunctionCall) Value {
+	so := r.setProtoThis(call, "symmetricDifference")
+	other := r.getSetRecord(call.Argument(0))
+	result := so.m.copy()
+	other.iterateKeys(func(key Value) bool {
+		if so.m.has(key) {
+			result.remove(key)
+		} else {
+			result.set(key, nil)
+		}
+		return true
+	})
+	return r.newSetFromMap(result)
+}
+
+func (r *Runtime) setProto_union(call FunctionCall) Value {
+	so := r.setProtoThis(call, "union")
+	other := r.getSetRecord(call.Argument(0))
+	result := so.m.copy()
+	other.iterateKeys(func(key Value) bool {
+		result.set(key, nil)
+		return true
+	})
+	return r.newSetFromMap(result)
+}
+
 func (r *Runtime) builtin_newSet(args []Value, newTarget *Object) *Object {
 	if newTarget == nil {
 		panic(r.needNew("Set"))
 	}
 	proto := r.getPrototypeFromCtor(newTarget, r.global.Set, r.global.SetPrototype)
-	o := &Object{runtime: r}
-
-	so := &setObject{}
-	so.class = classSet
-	so.val = o
-	so.extensible = true
-	o.self = so
-	so.prototype = proto
-	so.init()
+	so := r.newSetObject(proto)
+	o := so.val
 	if len(args) > 0 {
 		if arg := args[0]; arg != nil && arg != _undefined && arg != _null {
 			adder := so.getStr("add", nil)
@@ -227,6 +442,19 @@ func (r *Runtime) builtin_newSet(args []Value, newTarget *Object) *Object {
 	return o
 }
 
+func (r *Runtime) newSetObject(proto *Object) *setObject {
+	o := &Object{runtime: r}
+
+	so := &setObject{}
+	so.class = classSet
+	so.val = o
+	so.extensible = true
+	o.self = so
+	so.prototype = proto
+	so.init()
+	return so
+}
+
 func (r *Runtime) createSetIterator(setValue Value, kind iterationKind) Value {
 	obj := r.toObject(setValue)
 	setObj, ok := obj.self.(*setObject)
@@ -267,8 +495,14 @@ func (r *Runtime) createSetProto(val *Object) objectImpl {
 
 	o._putProp("clear", r.newNativeFunc(r.setProto_clear, nil, "clear", nil, 0), true, false, true)
 	o._putProp("delete", r.newNativeFunc(r.setProto_delete, nil, "delete", nil, 1), true, false, true)
+	o._putProp("difference", r.newNativeFunc(r.setProto_difference, nil, "difference", nil, 1), true, false, true)
 	o._putProp("forEach", r.newNativeFunc(r.setProto_forEach, nil, "forEach", nil, 1), true, false, true)
-	o._putProp("has", r.newNativeFunc(r.setProto_has, nil, "has", nil, 1), true, false, true)
+	r.global.setHas = r.newNativeFunc(r.setProto_has, nil, "has", nil, 1)
+	o._putProp("has", r.global.setHas, true, false, true)
+	o._putProp("intersection", r.newNativeFunc(r.setProto_intersection, nil, "intersection", nil, 1), true, false, true)
+	o._putProp("isDisjointFrom", r.newNativeFunc(r.setProto_isDisjointFrom, nil, "isDisjointFrom", nil, 1), true, false, true)
+	o._putProp("isSubsetOf", r.newNativeFunc(r.setProto_isSubsetOf, nil, "isSubsetOf", nil, 1), true, false, true)
+	o._putProp("isSupersetOf", r.newNativeFunc(r.setProto_isSupersetOf, nil, "isSupersetOf", nil, 1), true, false, true)
 	o.setOwnStr("size", &valueProperty{
 		getterFunc:   r.newNativeFunc(r.setProto_getSize, nil, "get size", nil, 0),
 		accessor:     true,
@@ -276,7 +510,11 @@ func (r *Runtime) createSetProto(val *Object) objectImpl {
 		configurable: true,
 	}, true)
 
+	o._putProp("symmetricDifference", r.newNativeFunc(r.setProto_symmetricDifference, nil, "symmetricDifference", nil, 1), true, false, true)
+	o._putProp("union", r.newNativeFunc(r.setProto_union, nil, "union", nil, 1), true, false, true)
+
 	valuesFunc := r.newNativeFunc(r.setProto_values, nil, "values", nil, 0)
+	r.global.setValues = valuesFunc
 	o._putProp("values", valuesFunc, true, false, true)
 	o._putProp("keys", valuesFunc, true, false, true)
 	o._putProp("entries", r.newNativeFunc(r.setProto_entries, nil, "entries", nil, 0), true, false, true)
@@ -296,7 +534,8 @@ func (r *Runtime) createSet(val *Object) objectImpl {
 func (r *Runtime) createSetIterProto(val *Object) objectImpl {
 	o := newBaseObjectObj(val, r.global.IteratorPrototype, classObject)
 
-	o._putProp("next", r.newNativeFunc(r.setIterProto_next, nil, "next", nil, 0), true, false, true)
+	r.global.setIterNext = r.newNativeFunc(r.setIterProto_next, nil, "next", nil, 0)
+	o._putProp("next", r.global.setIterNext, true, false, true)
 	o._putSym(SymToStringTag, valueProp(asciiString(classSetIterator), false, false, true))
 
 	return o
@@ -100,3 +100,76 @@ func TestSetExportToArrayMismatchedLengths(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestSetAlgebra(t *testing.T) {
+	const SCRIPT = `
+	function values(s) {
+		return Array.from(s);
+	}
+	const a = new Set([1, 2, 3, 4]);
+	const b = new Set([3, 4, 5]);
+	assert(compareArray(values(a.union(b)), [1, 2, 3, 4, 5]), "union");
+	assert(compareArray(values(a.intersection(b)), [3, 4]), "intersection");
+	assert(compareArray(values(b.intersection(a)), [3, 4]), "intersection (reverse)");
+	assert(compareArray(values(a.difference(b)), [1, 2]), "difference");
+	assert(compareArray(values(b.difference(a)), [5]), "difference (reverse)");
+	assert(compareArray(values(a.symmetricDifference(b)), [1, 2, 5]), "symmetricDifference");
+	assert.sameValue(new Set([3, 4]).isSubsetOf(a), true, "isSubsetOf");
+	assert.sameValue(b.isSubsetOf(a), false, "isSubsetOf (false)");
+	assert.sameValue(a.isSupersetOf(new Set([1, 4])), true, "isSupersetOf");
+	assert.sameValue(a.isSupersetOf(b), false, "isSupersetOf (false)");
+	assert.sameValue(a.isDisjointFrom(new Set([7, 8])), true, "isDisjointFrom");
+	assert.sameValue(a.isDisjointFrom(b), false, "isDisjointFrom (false)");
+	assert.sameValue(Object.getPrototypeOf(a.union(b)), Set.prototype, "result proto");
+	`
+	testScriptWithTestLib(SCRIPT, _undefined, t)
+}
+
+func TestSetAlgebraSetLike(t *testing.T) {
+	const SCRIPT = `
+	function values(s) {
+		return Array.from(s);
+	}
+	let hasCalls = 0;
+	let closed = false;
+	const setLike = {
+		size: 2,
+		has(v) {
+			hasCalls++;
+			return v === 1 || v === 2;
+		},
+		keys() {
+			let i = 0;
+			return {
+				next() {
+					i++;
+					return i > 2 ? {done: true} : {value: i, done: false};
+				},
+				return() {
+					closed = true;
+					return {};
+				}
+			};
+		}
+	};
+	const a = new Set([1, 2, 3]);
+	assert(compareArray(values(a.union(setLike)), [1, 2, 3]), "union");
+	assert(compareArray(values(a.intersection(setLike)), [1, 2]), "intersection");
+	assert.sameValue(hasCalls, 0, "has is not called when the set is larger");
+	assert(compareArray(values(new Set([2, 5]).difference(setLike)), [5]), "difference");
+	assert.sameValue(hasCalls, 2, "has is called when the set is smaller");
+	assert.sameValue(new Set([1]).isDisjointFrom(setLike), false, "isDisjointFrom");
+	assert.sameValue(a.isDisjointFrom(setLike), false, "isDisjointFrom via keys");
+	assert.sameValue(closed, true, "keys iterator is closed");
+
+	assert.throws(TypeError, () => a.union([1, 2]));
+	assert.throws(TypeError, () => a.union({size: 1, has: 1, keys() {}}));
+	assert.throws(RangeError, () => a.union({size: -1, has() {}, keys() {}}));
+	assert.throws(TypeError, () => Set.prototype.union.call({}, a));
+
+	const s = new Set([1]);
+	s.has = () => true;
+	assert.sameValue(new Set([7]).isSubsetOf(s), true, "overridden has");
+	`
+	testScriptWithTestLib(SCRIPT, _undefined, t)
+}
@@ -0,0 +1,221 @@
+package goja
+
+import (
+	"github.com/dop251/goja/unistring"
+)
+
+// ShadowRealmImporter is called by ShadowRealm.prototype.importValue() to load a module inside the given realm.
+// It should return the module namespace object (or any object which own properties are treated as exports).
+// If an error is returned, the promise returned by importValue() is rejected with a TypeError.
+type ShadowRealmImporter func(realm *Realm, specifier string) (*Object, error)
+
+type shadowRealmObject struct {
+	baseObject
+	realm *Realm
+}
+
+func (r *Runtime) shadowRealmProtoThis(call FunctionCall, name string) *shadowRealmObject {
+	thisObj := r.toObject(call.This)
+	if sr, ok := thisObj.self.(*shadowRealmObject); ok {
+		return sr
+	}
+	panic(r.NewTypeError("Method ShadowRealm.prototype.%s called on incompatible receiver %s", name, r.objectproto_toString(FunctionCall{This: thisObj})))
+}
+
+// shadowRealmCall runs f in the specified realm. Any exception thrown by f is replaced with a TypeError
+// created in the current realm, so that no objects can leak across the boundary.
+func (r *Runtime) shadowRealmCall(realm *Realm, f func()) {
+	ex := r.vm.try(func() {
+		r.runInRealm(realm, f)
+	})
+	if ex != nil {
+		var msg string
+		r.runInRealm(realm, func() {
+			_ = r.vm.try(func() {
+				msg = ex.Value().String()
+			})
+		})
+		panic(r.NewTypeError("Error in ShadowRealm: %s", msg))
+	}
+}
+
+// getWrappedValue implements GetWrappedValue. The value must belong to the current realm,
+// the result belongs to the target realm.
+func (r *Runtime) getWrappedValue(target *Realm, v Value) Value {
+	if obj, ok := v.(*Object); ok {
+		if _, ok := obj.self.assertCallable(); !ok {
+			panic(r.NewTypeError("Cannot pass a non-callable object across a ShadowRealm boundary"))
+		}
+		return r.wrappedFunctionCreate(target, obj)
+	}
+	return v
+}
+
+// wrappedFunctionCreate implements WrappedFunctionCreate. The wrapped function is created in the target
+// realm and calls the supplied function in the current realm.
+func (r *Runtime) wrappedFunctionCreate(target *Realm, fn *Object) *Object {
+	fnRealm := r.realm
+	call, _ := fn.self.assertCallable()
+
+	var length Value = _positiveZero
+	var name unistring.String
+	ex := r.vm.try(func() {
+		if fn.self.hasOwnPropertyStr("length") {
+			switch l := fn.self.getStr("length", nil).(type) {
+			case valueInt:
+				if l > 0 {
+					length = l
+				}
+			case valueFloat:
+				if l == _positiveInf {
+					length = l
+				} else if li := l.ToInteger(); li > 0 {
+					length = intToValue(li)
+				}
+			}
+		}
+		if s, ok := fn.self.getStr("name", nil).(valueString); ok {
+			name = s.string()
+		}
+	})
+	if ex != nil {
+		panic(r.NewTypeError("Cannot copy name and length of a wrapped function"))
+	}
+
+	v := &Object{runtime: r}
+	r.runInRealm(target, func() {
+		r.newNativeFuncObj(v, func(c FunctionCall) Value {
+			callerRealm := r.realm
+			this := r.getWrappedValue(fnRealm, c.This)
+			args := make([]Value, len(c.Arguments))
+			for i, arg := range c.Arguments {
+				args[i] = r.getWrappedValue(fnRealm, arg)
+			}
+			var res Value
+			r.shadowRealmCall(fnRealm, func() {
+				res = r.getWrappedValue(callerRealm, call(FunctionCall{This: this, Arguments: args}))
+			})
+			return res
+		}, nil, name, nil, length)
+	})
+	return v
+}
+
+func (r *Runtime) shadowRealmEval(src valueString, realm *Realm) Value {
+	p, err := r.compile("<eval>", escapeInvalidUtf16(src), false, true, r.vm)
+	if err != nil {
+		panic(err)
+	}
+	callerRealm := r.realm
+	var res Value
+	r.shadowRealmCall(realm, func() {
+		vm := r.vm
+		vm.pushCtx()
+		vm.stash = &r.global.stash
+		vm.privEnv = nil
+		vm.prg = p
+		vm.pc = 0
+		vm.args = 0
+		vm.result = _undefined
+		vm.push(_undefined)
+		vm.sb = vm.sp
+		vm.push(nil) // this
+		vm.run()
+		retval := vm.result
+		vm.popCtx()
+		vm.halt = false
+		vm.sp -= 2
+		res = r.getWrappedValue(callerRealm, retval)
+	})
+	return res
+}
+
+func (r *Runtime) shadowRealmProto_evaluate(call FunctionCall) Value {
+	sr := r.shadowRealmProtoThis(call, "evaluate")
+	src, ok := call.Argument(0).(valueString)
+	if !ok {
+		panic(r.NewTypeError("ShadowRealm.prototype.evaluate: source must be a string"))
+	}
+	r.checkDynamicCode(DynamicCodeShadowRealm, src)
+	return r.shadowRealmEval(src, sr.realm)
+}
+
+func (r *Runtime) shadowRealmProto_importValue(call FunctionCall) Value {
+	sr := r.shadowRealmProtoThis(call, "importValue")
+	specifier := call.Argument(0).String()
+	exportName, ok := call.Argument(1).(valueString)
+	if !ok {
+		panic(r.NewTypeError("ShadowRealm.prototype.importValue: export name must be a string"))
+	}
+	pcap := r.newPromiseCapability(r.global.Promise)
+	importer := r.shadowRealmImporter
+	if importer == nil {
+		pcap.reject(r.NewTypeError("Cannot import %q: modules are not supported", specifier))
+		return pcap.promise
+	}
+	callerRealm := r.realm
+	pcap.try(func() {
+		var res Value
+		r.shadowRealmCall(sr.realm, func() {
+			ns, err := importer(sr.realm, specifier)
+			if err != nil {
+				panic(r.NewGoError(err))
+			}
+			name := exportName.string()
+			if !ns.self.hasOwnPropertyStr(name) {
+				panic(r.NewTypeError("Module %q does not export %s", specifier, name))
+			}
+			res = r.getWrappedValue(callerRealm, ns.self.getStr(name, nil))
+		})
+		pcap.resolve(res)
+	})
+	return pcap.promise
+}
+
+func (r *Runtime) builtin_newShadowRealm(args []Value, newTarget *Object) *Object {
+	if newTarget == nil {
+		panic(r.needNew("ShadowRealm"))
+	}
+	proto := r.getPrototypeFromCtor(newTarget, r.global.ShadowRealm, r.global.ShadowRealmPrototype)
+	o := &Object{runtime: r}
+	sr := &shadowRealmObject{}
+	sr.class = classObject
+	sr.val = o
+	sr.extensible = true
+	o.self = sr
+	sr.prototype = proto
+	sr.init()
+	sr.realm = r.newRealm()
+	return o
+}
+
+// SetShadowRealmImporter sets the function that is used by ShadowRealm.prototype.importValue() to load modules.
+// If not set, importValue() returns a rejected promise.
+func (r *Runtime) SetShadowRealmImporter(importer ShadowRealmImporter) {
+	r.shadowRealmImporter = importer
+}
+
+func (r *Runtime) createShadowRealmProto(val *Object) objectImpl {
+	o := newBaseObjectObj(val, r.global.ObjectPrototype, classObject)
+
+	o._putProp("constructor", r.global.ShadowRealm, true, false, true)
+	o._putProp("evaluate", r.newNativeFunc(r.shadowRealmProto_evaluate, nil, "evaluate", nil, 1), true, false, true)
+	o._putProp("importValue", r.newNativeFunc(r.shadowRealmProto_importValue, nil, "importValue", nil, 2), true, false, true)
+
+	o._putSym(SymToStringTag, valueProp(asciiString(classShadowRealm), false, false, true))
+
+	return o
+}
+
+func (r *Runtime) createShadowRealm(val *Object) objectImpl {
+	o := r.newNativeConstructOnly(val, r.builtin_newShadowRealm, r.global.ShadowRealmPrototype, "ShadowRealm", 0)
+
+	return o
+}
+
+func (r *Runtime) initShadowRealm() {
+	r.global.ShadowRealmPrototype = r.newLazyObject(r.createShadowRealmProto)
+	r.global.ShadowRealm = r.newLazyObject(r.createShadowRealm)
+
+	r.addToGlobal("ShadowRealm", r.global.ShadowRealm)
+}
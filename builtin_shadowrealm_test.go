@@ -0,0 +1,232 @@
+package goja
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestShadowRealm(t *testing.T) {
+	const SCRIPT = `
+	const sr = new ShadowRealm();
+	assert.sameValue(sr.evaluate("1 + 1"), 2, "primitive");
+	assert.sameValue(sr.evaluate("Array") === Array, false, "intrinsics");
+	assert.sameValue(sr.evaluate("typeof Array"), "function");
+
+	globalThis.x = 1;
+	sr.evaluate("var x = 42; let y = 'y'");
+	assert.sameValue(x, 1, "globals are separate");
+	assert.sameValue(sr.evaluate("x"), 42, "global var");
+	assert.throws(TypeError, () => sr.evaluate("y"), "lexical declarations are scoped to evaluate()");
+	assert.sameValue(sr.evaluate("[] instanceof Array"), true, "instanceof in shadow realm");
+	assert.sameValue(sr.evaluate("Object.getPrototypeOf([]) === Array.prototype"), true);
+
+	Array.prototype.polluted = true;
+	assert.sameValue(sr.evaluate("[].polluted"), undefined, "prototypes are separate");
+
+	const add = sr.evaluate("(function add(a, b) { return a + b + x })");
+	assert.sameValue(typeof add, "function");
+	assert.sameValue(add.name, "add");
+	assert.sameValue(add.length, 2);
+	assert.sameValue(Object.getPrototypeOf(add), Function.prototype, "wrapped function proto");
+	assert.sameValue(add(1, 2), 45, "wrapped call uses the shadow realm globals");
+	assert.throws(TypeError, () => new add(), "wrapped functions are not constructors");
+
+	const callMe = sr.evaluate("(cb) => cb(10) * 2");
+	assert.sameValue(callMe(v => v + x), 22, "callback is wrapped back");
+
+	assert.throws(TypeError, () => sr.evaluate("({})"), "objects cannot cross");
+	assert.throws(TypeError, () => sr.evaluate("() => ({})")(), "returned objects cannot cross");
+	assert.throws(TypeError, () => callMe({}), "object arguments cannot cross");
+	assert.throws(TypeError, () => sr.evaluate("throw new RangeError('x')"), "errors are converted");
+	assert.throws(SyntaxError, () => sr.evaluate("let let"), "syntax errors are thrown in the caller realm");
+	assert.throws(TypeError, () => sr.evaluate(1));
+	assert.throws(TypeError, () => ShadowRealm.prototype.evaluate.call({}, "1"));
+	assert.throws(TypeError, () => ShadowRealm());
+	assert.sameValue(Object.prototype.toString.call(sr), "[object ShadowRealm]");
+
+	const nested = sr.evaluate("const sr2 = new ShadowRealm(); sr2.evaluate('(a) => a * 2')");
+	assert.sameValue(nested(21), 42, "nested realms");
+	`
+	testScriptWithTestLib(SCRIPT, _undefined, t)
+}
+
+func TestShadowRealmPromiseJobs(t *testing.T) {
+	r := New()
+	_, err := r.RunString(`
+	const sr = new ShadowRealm();
+	sr.evaluate("var result; Promise.resolve(1).then(v => { result = [v] instanceof Array }); undefined");
+	var checked = false;
+	Promise.resolve().then(() => {
+		checked = sr.evaluate("result");
+	});
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Get("checked").ToBoolean() {
+		t.Fatal("promise job did not run in the shadow realm")
+	}
+}
+
+func TestShadowRealmImportValue(t *testing.T) {
+	r := New()
+	r.SetShadowRealmImporter(func(realm *Realm, specifier string) (*Object, error) {
+		if specifier != "mod" {
+			return nil, errors.New("not found")
+		}
+		ns := realm.Runtime().NewObject()
+		f, err := realm.RunString("(function double(a) { return a * 2 })")
+		if err != nil {
+			return nil, err
+		}
+		_ = ns.Set("double", f)
+		_ = ns.Set("answer", 42)
+		return ns, nil
+	})
+	_, err := r.RunString(`
+	var res = [];
+	const sr = new ShadowRealm();
+	sr.importValue("mod", "double").then(f => res.push(f(21)));
+	sr.importValue("mod", "answer").then(v => res.push(v));
+	sr.importValue("mod", "missing").catch(e => res.push(e instanceof TypeError));
+	sr.importValue("nope", "x").catch(e => res.push(e instanceof TypeError));
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := r.RunString(`res.join()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.String(); s != "42,42,true,true" {
+		t.Fatal(s)
+	}
+}
+
+func TestRuntimeNewRealm(t *testing.T) {
+	r := New()
+	realm := r.NewRealm()
+	if realm.GlobalObject() == r.GlobalObject() {
+		t.Fatal("global objects are the same")
+	}
+	if err := realm.Set("v", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := realm.RunString(`let l = v + 1; Object.prototype.x = "realm";`); err != nil {
+		t.Fatal(err)
+	}
+	if v := realm.Get("l"); v == nil || v.ToInteger() != 2 {
+		t.Fatalf("unexpected l: %v", v)
+	}
+	if v := r.Get("v"); v != nil {
+		t.Fatalf("unexpected v: %v", v)
+	}
+	res, err := r.RunString(`({}).x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != _undefined {
+		t.Fatalf("intrinsics are shared: %v", res)
+	}
+	if r.Realm() == realm {
+		t.Fatal("realm was not restored")
+	}
+
+	p := MustCompile("", "typeof l", false)
+	res, err = realm.RunProgram(p)
+	if err != nil || res.String() != "number" {
+		t.Fatal(res, err)
+	}
+	res, err = r.RunProgram(p)
+	if err != nil || res.String() != "undefined" {
+		t.Fatal(res, err)
+	}
+
+	if _, err := realm.RunString(`throw new TypeError("x")`); err == nil {
+		t.Fatal("expected error")
+	}
+	if r.Realm() == realm {
+		t.Fatal("realm was not restored after an exception")
+	}
+}
+
+func TestRealmFunctionRealm(t *testing.T) {
+	r := New()
+	realm := r.NewRealm()
+	if _, err := r.RunString(`var x = "main"`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := realm.RunString(`
+	var x = "tenant";
+	function getX() {
+		return x;
+	}
+	function makeArray() {
+		return [];
+	}
+	var boundGetX = getX.bind(null);
+	var keys = Object.keys;
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	getX, ok := AssertFunction(realm.Get("getX"))
+	if !ok {
+		t.Fatal("getX is not a function")
+	}
+	res, err := getX(_undefined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.String(); s != "tenant" {
+		t.Fatalf("unexpected x: %s", s)
+	}
+	if r.Realm() == realm {
+		t.Fatal("realm was not restored")
+	}
+
+	keys, ok := AssertFunction(realm.Get("keys"))
+	if !ok {
+		t.Fatal("keys is not a function")
+	}
+	res, err = keys(_undefined, r.NewObject())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantArrayProto := realm.Get("Array").ToObject(r).Get("prototype")
+	if proto := res.ToObject(r).Prototype(); proto != tenantArrayProto {
+		t.Fatal("the array was not created in the function's realm")
+	}
+
+	// called from the main realm's code
+	if err := r.Set("getX", realm.Get("getX")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set("boundGetX", realm.Get("boundGetX")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set("makeArray", realm.Get("makeArray")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set("keys", realm.Get("keys")); err != nil {
+		t.Fatal(err)
+	}
+	res, err = r.RunString(`
+	const res = [getX(), boundGetX(), makeArray() instanceof Array, keys({}) instanceof Array, x];
+	Promise.resolve().then(getX).then(v => res.push(v));
+	res;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = r.RunString(`res.join()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.String(); s != "tenant,tenant,false,false,main,tenant" {
+		t.Fatal(s)
+	}
+	if r.Realm() == realm {
+		t.Fatal("realm was not restored")
+	}
+}
@@ -117,7 +117,7 @@ func (r *Runtime) string_fromcharcode(call FunctionCall) Value {
 }
 
 func (r *Runtime) string_fromcodepoint(call FunctionCall) Value {
-	var sb valueStringBuilder
+	sb := valueStringBuilder{runtime: r}
 	for _, arg := range call.Arguments {
 		num := arg.ToNumber()
 		var c rune
@@ -141,7 +141,7 @@ func (r *Runtime) string_raw(call FunctionCall) Value {
 	if literalSegments <= 0 {
 		return stringEmpty
 	}
-	var stringElements valueStringBuilder
+	stringElements := valueStringBuilder{runtime: r}
 	nextIndex := int64(0)
 	numberOfSubstitutions := int64(len(call.Arguments) - 1)
 	for {
@@ -227,6 +227,7 @@ func (r *Runtime) stringproto_concat(call FunctionCall) Value {
 		strs[i+1] = s
 		totalLen += s.length()
 	}
+	r.allocStringMem(int64(totalLen), allAscii)
 
 	if allAscii {
 		var buf strings.Builder
@@ -443,7 +444,7 @@ func (r *Runtime) stringproto_normalize(call FunctionCall) Value {
 
 	if s, ok := s.(unicodeString); ok {
 		ss := s.String()
-		return newStringValue(f.String(ss))
+		return r.allocString(newStringValue(f.String(ss)))
 	}
 
 	return s
@@ -457,6 +458,7 @@ func (r *Runtime) stringproto_padEnd(call FunctionCall) Value {
 	if maxLength <= stringLength {
 		return s
 	}
+	r.vm.charge(maxLength - stringLength)
 	var filler valueString
 	var fillerASCII bool
 	if fillString := call.Argument(1); fillString != _undefined {
@@ -469,8 +471,9 @@ func (r *Runtime) stringproto_padEnd(call FunctionCall) Value {
 		filler = asciiString(" ")
 		fillerASCII = true
 	}
-	remaining := toIntStrict(maxLength - stringLength)
 	_, stringASCII := s.(asciiString)
+	r.allocStringMem(maxLength, fillerASCII && stringASCII)
+	remaining := toIntStrict(maxLength - stringLength)
 	if fillerASCII && stringASCII {
 		fl := filler.length()
 		var sb strings.Builder
@@ -478,6 +481,7 @@ func (r *Runtime) stringproto_padEnd(call FunctionCall) Value {
 		sb.WriteString(s.String())
 		fs := filler.String()
 		for remaining >= fl {
+			r.vm.checkInterrupt()
 			sb.WriteString(fs)
 			remaining -= fl
 		}
@@ -491,6 +495,7 @@ func (r *Runtime) stringproto_padEnd(call FunctionCall) Value {
 	sb.WriteString(s)
 	fl := filler.length()
 	for remaining >= fl {
+		r.vm.checkInterrupt()
 		sb.WriteString(filler)
 		remaining -= fl
 	}
@@ -509,6 +514,7 @@ func (r *Runtime) stringproto_padStart(call FunctionCall) Value {
 	if maxLength <= stringLength {
 		return s
 	}
+	r.vm.charge(maxLength - stringLength)
 	var filler valueString
 	var fillerASCII bool
 	if fillString := call.Argument(1); fillString != _undefined {
@@ -521,14 +527,16 @@ func (r *Runtime) stringproto_padStart(call FunctionCall) Value {
 		filler = asciiString(" ")
 		fillerASCII = true
 	}
-	remaining := toIntStrict(maxLength - stringLength)
 	_, stringASCII := s.(asciiString)
+	r.allocStringMem(maxLength, fillerASCII && stringASCII)
+	remaining := toIntStrict(maxLength - stringLength)
 	if fillerASCII && stringASCII {
 		fl := filler.length()
 		var sb strings.Builder
 		sb.Grow(toIntStrict(maxLength))
 		fs := filler.String()
 		for remaining >= fl {
+			r.vm.checkInterrupt()
 			sb.WriteString(fs)
 			remaining -= fl
 		}
@@ -542,6 +550,7 @@ func (r *Runtime) stringproto_padStart(call FunctionCall) Value {
 	sb.Grow(toIntStrict(maxLength))
 	fl := filler.length()
 	for remaining >= fl {
+		r.vm.checkInterrupt()
 		sb.WriteString(filler)
 		remaining -= fl
 	}
@@ -567,11 +576,20 @@ func (r *Runtime) stringproto_repeat(call FunctionCall) Value {
 	if numInt == 0 || s.length() == 0 {
 		return stringEmpty
 	}
+	_, isASCII := s.(asciiString)
+	if l := int64(s.length()); numInt > math.MaxInt64/l {
+		r.vm.charge(math.MaxInt64)
+		r.allocStringMem(math.MaxInt64, isASCII)
+	} else {
+		r.vm.charge(numInt * l)
+		r.allocStringMem(numInt*l, isASCII)
+	}
 	num := toIntStrict(numInt)
 	if s, ok := s.(asciiString); ok {
 		var sb strings.Builder
 		sb.Grow(len(s) * num)
 		for i := 0; i < num; i++ {
+			r.vm.checkInterrupt()
 			sb.WriteString(string(s))
 		}
 		return asciiString(sb.String())
@@ -580,6 +598,7 @@ func (r *Runtime) stringproto_repeat(call FunctionCall) Value {
 	var sb unicodeStringBuilder
 	sb.Grow(s.length() * num)
 	for i := 0; i < num; i++ {
+		r.vm.checkInterrupt()
 		sb.WriteString(s)
 	}
 	return sb.String()
@@ -596,7 +615,7 @@ func getReplaceValue(replaceValue Value) (str valueString, rcall func(FunctionCa
 	return
 }
 
-func stringReplace(s valueString, found [][]int, newstring valueString, rcall func(FunctionCall) Value) Value {
+func (r *Runtime) stringReplace(s valueString, found [][]int, newstring valueString, rcall func(FunctionCall) Value) Value {
 	if len(found) == 0 {
 		return s
 	}
@@ -608,7 +627,7 @@ func stringReplace(s valueString, found [][]int, newstring valueString, rcall fu
 		isASCII = true
 	}
 
-	var buf valueStringBuilder
+	buf := valueStringBuilder{runtime: r}
 
 	lastIndex := 0
 	lengthS := s.length()
@@ -688,7 +707,7 @@ func (r *Runtime) stringproto_replace(call FunctionCall) Value {
 	}
 
 	str, rcall := getReplaceValue(replaceValue)
-	return stringReplace(s, found, str, rcall)
+	return r.stringReplace(s, found, str, rcall)
 }
 
 func (r *Runtime) stringproto_search(call FunctionCall) Value {
@@ -881,14 +900,14 @@ func (r *Runtime) stringproto_toLowerCase(call FunctionCall) Value {
 	r.checkObjectCoercible(call.This)
 	s := call.This.toString()
 
-	return s.toLower()
+	return r.allocString(s.toLower())
 }
 
 func (r *Runtime) stringproto_toUpperCase(call FunctionCall) Value {
 	r.checkObjectCoercible(call.This)
 	s := call.This.toString()
 
-	return s.toUpper()
+	return r.allocString(s.toUpper())
 }
 
 func (r *Runtime) stringproto_trim(call FunctionCall) Value {
@@ -896,7 +915,7 @@ func (r *Runtime) stringproto_trim(call FunctionCall) Value {
 	s := call.This.toString()
 
 	// TODO handle invalid UTF-16
-	return newStringValue(strings.Trim(s.String(), parser.WhitespaceChars))
+	return r.allocString(newStringValue(strings.Trim(s.String(), parser.WhitespaceChars)))
 }
 
 func (r *Runtime) stringproto_trimEnd(call FunctionCall) Value {
@@ -904,7 +923,7 @@ func (r *Runtime) stringproto_trimEnd(call FunctionCall) Value {
 	s := call.This.toString()
 
 	// TODO handle invalid UTF-16
-	return newStringValue(strings.TrimRight(s.String(), parser.WhitespaceChars))
+	return r.allocString(newStringValue(strings.TrimRight(s.String(), parser.WhitespaceChars)))
 }
 
 func (r *Runtime) stringproto_trimStart(call FunctionCall) Value {
@@ -912,7 +931,7 @@ func (r *Runtime) stringproto_trimStart(call FunctionCall) Value {
 	s := call.This.toString()
 
 	// TODO handle invalid UTF-16
-	return newStringValue(strings.TrimLeft(s.String(), parser.WhitespaceChars))
+	return r.allocString(newStringValue(strings.TrimLeft(s.String(), parser.WhitespaceChars)))
 }
 
 func (r *Runtime) stringproto_substr(call FunctionCall) Value {
@@ -20,6 +20,7 @@ func (ctx *typedArraySortCtx) Len() int {
 }
 
 func (ctx *typedArraySortCtx) Less(i, j int) bool {
+	ctx.ta.val.runtime.vm.checkInterrupt()
 	if ctx.needValidate {
 		ctx.ta.viewedArrayBuf.ensureNotDetached(true)
 		ctx.needValidate = false
@@ -74,13 +75,18 @@ func allocByteSlice(size int) (b []byte) {
 	return
 }
 
+func (r *Runtime) allocArrayBufferData(size int) []byte {
+	r.allocMem(int64(size))
+	return allocByteSlice(size)
+}
+
 func (r *Runtime) builtin_newArrayBuffer(args []Value, newTarget *Object) *Object {
 	if newTarget == nil {
 		panic(r.needNew("ArrayBuffer"))
 	}
 	b := r._newArrayBuffer(r.getPrototypeFromCtor(newTarget, r.global.ArrayBuffer, r.global.ArrayBufferPrototype), nil)
 	if len(args) > 0 {
-		b.data = allocByteSlice(r.toIndex(args[0]))
+		b.data = r.allocArrayBufferData(r.toIndex(args[0]))
 	}
 	return b.val
 }
@@ -706,8 +712,9 @@ func (r *Runtime) typedArrayProto_join(call FunctionCall) Value {
 		if l == 0 {
 			return stringEmpty
 		}
+		r.vm.charge(int64(l))
 
-		var buf valueStringBuilder
+		buf := valueStringBuilder{runtime: r}
 
 		var element0 Value
 		if ta.isValidIntegerIndex(0) {
@@ -718,6 +725,7 @@ func (r *Runtime) typedArrayProto_join(call FunctionCall) Value {
 		}
 
 		for i := 1; i < l; i++ {
+			r.vm.checkInterrupt()
 			buf.WriteString(sep)
 			if ta.isValidIntegerIndex(i) {
 				element := ta.typedArray.get(ta.offset + i)
@@ -1043,6 +1051,7 @@ func (r *Runtime) typedArrayProto_sort(call FunctionCall) Value {
 			compare: compareFn,
 		}
 
+		r.chargeSort(ctx.Len())
 		sort.Stable(&ctx)
 		return call.This
 	}
@@ -1072,7 +1081,7 @@ func (r *Runtime) typedArrayProto_subarray(call FunctionCall) Value {
 func (r *Runtime) typedArrayProto_toLocaleString(call FunctionCall) Value {
 	if ta, ok := r.toObject(call.This).self.(*typedArrayObject); ok {
 		length := ta.length
-		var buf valueStringBuilder
+		buf := valueStringBuilder{runtime: r}
 		for i := 0; i < length; i++ {
 			ta.viewedArrayBuf.ensureNotDetached(true)
 			if i > 0 {
@@ -1169,7 +1178,7 @@ func (r *Runtime) allocateTypedArray(newTarget *Object, length int, taCtor typed
 	buf := r._newArrayBuffer(r.global.ArrayBufferPrototype, nil)
 	ta := taCtor(buf, 0, length, r.getPrototypeFromCtor(newTarget, nil, proto))
 	if length > 0 {
-		buf.data = allocByteSlice(length * ta.elemSize)
+		buf.data = r.allocArrayBufferData(length * ta.elemSize)
 	}
 	return ta
 }
@@ -1280,7 +1289,7 @@ func (r *Runtime) _newTypedArrayFromTypedArray(src *typedArrayObject, newTarget
 	l := src.length
 
 	dst.viewedArrayBuf.prototype = r.getPrototypeFromCtor(r.speciesConstructorObj(src.viewedArrayBuf.val, r.global.ArrayBuffer), r.global.ArrayBuffer, r.global.ArrayBufferPrototype)
-	dst.viewedArrayBuf.data = allocByteSlice(toIntStrict(int64(l) * int64(dst.elemSize)))
+	dst.viewedArrayBuf.data = r.allocArrayBufferData(toIntStrict(int64(l) * int64(dst.elemSize)))
 	src.viewedArrayBuf.ensureNotDetached(true)
 	if src.defaultCtor == dst.defaultCtor {
 		copy(dst.viewedArrayBuf.data, src.viewedArrayBuf.data[src.offset*src.elemSize:])
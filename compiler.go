@@ -84,6 +84,10 @@ type compiler struct {
 	evalVM *vm // VM used to evaluate constant expressions
 	ctxVM  *vm // VM in which an eval() code is compiled
 
+	debug bool // compile as if every statement contained a direct eval() call, see markDirectEval()
+
+	coverage *fileCoverage // not nil when compiling with coverage counters, see coverage.go
+
 	codeScratchpad []instruction
 }
 
@@ -377,6 +381,21 @@ func (c *compiler) newBlockScope() {
 	c.scope.base = len(c.p.code)
 }
 
+// markDirectEval marks the current scope and all the outer ones as dynamically accessed (which is what a direct
+// eval() call does), so that all their bindings are kept in named stashes.
+func (c *compiler) markDirectEval() {
+	foundVar := false
+	for sc := c.scope; sc != nil; sc = sc.outer {
+		if !foundVar && (sc.variable || sc.isFunction()) {
+			foundVar = true
+			if !sc.strict {
+				sc.dynamic = true
+			}
+		}
+		sc.dynLookup = true
+	}
+}
+
 func (c *compiler) popScope() {
 	c.scope = c.scope.outer
 }
@@ -137,6 +137,7 @@ type compiledFunctionLiteral struct {
 	homeObjOffset   uint32
 	typ             funcType
 	isExpr          bool
+	cover           *coverFunction
 }
 
 type compiledBracketExpr struct {
@@ -171,21 +172,25 @@ type compiledUnaryExpr struct {
 type compiledConditionalExpr struct {
 	baseCompiledExpr
 	test, consequent, alternate compiledExpr
+	counters                    []*coverCounter
 }
 
 type compiledLogicalOr struct {
 	baseCompiledExpr
 	left, right compiledExpr
+	counters    []*coverCounter
 }
 
 type compiledCoalesce struct {
 	baseCompiledExpr
 	left, right compiledExpr
+	counters    []*coverCounter
 }
 
 type compiledLogicalAnd struct {
 	baseCompiledExpr
 	left, right compiledExpr
+	counters    []*coverCounter
 }
 
 type compiledBinaryExpr struct {
@@ -1552,6 +1557,10 @@ func (e *compiledFunctionLiteral) compile() (prg *Program, name unistring.String
 		}
 	}
 
+	if e.cover != nil {
+		e.c.coverage.setName(e.cover, name.String())
+		e.c.emitCoverCounter(&e.cover.coverCounter)
+	}
 	e.c.compileFunctions(funcs)
 	e.c.compileStatements(body, false)
 
@@ -1581,7 +1590,7 @@ func (e *compiledFunctionLiteral) compile() (prg *Program, name unistring.String
 			e.c.throwSyntaxError(e.offset, "'arguments' is not allowed in class field initializer or static initialization block")
 		}
 		b, created := s.bindNameLexical("arguments", false, 0)
-		if created || b.isVar {
+		if created || b.isVar && !b.isArg {
 			if !s.argsInStash {
 				s.moveArgsToStash()
 			}
@@ -1656,7 +1665,7 @@ func (e *compiledFunctionLiteral) compile() (prg *Program, name unistring.String
 			enter = &enter1
 			if enterFunc2Mark != -1 {
 				ef2 := &enterFuncBody{
-					extensible: e.c.scope.dynamic,
+					extensible: e.c.scope.isDynamic(),
 					funcType:   e.typ,
 				}
 				e.c.updateEnterBlock(&ef2.enterBlock)
@@ -1677,7 +1686,7 @@ func (e *compiledFunctionLiteral) compile() (prg *Program, name unistring.String
 			if enterFunc2Mark != -1 {
 				ef2 := &enterFuncBody{
 					adjustStack: true,
-					extensible:  e.c.scope.dynamic,
+					extensible:  e.c.scope.isDynamic(),
 					funcType:    e.typ,
 				}
 				e.c.updateEnterBlock(&ef2.enterBlock)
@@ -1694,7 +1703,7 @@ func (e *compiledFunctionLiteral) compile() (prg *Program, name unistring.String
 		}
 		if enterFunc2Mark != -1 {
 			ef2 := &enterFuncBody{
-				extensible: e.c.scope.dynamic,
+				extensible: e.c.scope.isDynamic(),
 				funcType:   e.typ,
 			}
 			e.c.updateEnterBlock(&ef2.enterBlock)
@@ -1747,6 +1756,7 @@ func (c *compiler) compileFunctionLiteral(v *ast.FunctionLiteral, isExpr bool) *
 		isExpr:          isExpr,
 		typ:             funcRegular,
 		strict:          strictBody,
+		cover:           c.coverFunction(v, v.Name),
 	}
 	r.init(c, v.Idx0())
 	return r
@@ -2261,6 +2271,7 @@ func (c *compiler) compileArrowFunctionLiteral(v *ast.ArrowFunctionLiteral) *com
 		isExpr:          true,
 		typ:             funcArrow,
 		strict:          strictBody,
+		cover:           c.coverFunction(v, nil),
 	}
 	r.init(c, v.Idx0())
 	return r
@@ -2533,10 +2544,12 @@ func (e *compiledConditionalExpr) emitGetter(putOnStack bool) {
 	e.test.emitGetter(true)
 	j := len(e.c.p.code)
 	e.c.emit(nil)
+	e.c.emitBranchCounter(e.counters, 0)
 	e.consequent.emitGetter(putOnStack)
 	j1 := len(e.c.p.code)
 	e.c.emit(nil)
 	e.c.p.code[j] = jne(len(e.c.p.code) - j)
+	e.c.emitBranchCounter(e.counters, 1)
 	e.alternate.emitGetter(putOnStack)
 	e.c.p.code[j1] = jump(len(e.c.p.code) - j1)
 }
@@ -2546,6 +2559,7 @@ func (c *compiler) compileConditionalExpression(v *ast.ConditionalExpression) co
 		test:       c.compileExpression(v.Test),
 		consequent: c.compileExpression(v.Consequent),
 		alternate:  c.compileExpression(v.Alternate),
+		counters:   c.coverBranch("cond-expr", v, v.Consequent, v.Alternate),
 	}
 	r.init(c, v.Idx0())
 	return r
@@ -2567,9 +2581,11 @@ func (e *compiledLogicalOr) constant() bool {
 }
 
 func (e *compiledLogicalOr) emitGetter(putOnStack bool) {
+	e.c.emitBranchCounter(e.counters, 0)
 	if e.left.constant() {
 		if v, ex := e.c.evalConst(e.left); ex == nil {
 			if !v.ToBoolean() {
+				e.c.emitBranchCounter(e.counters, 1)
 				e.c.emitExpr(e.right, putOnStack)
 			} else {
 				if putOnStack {
@@ -2585,6 +2601,7 @@ func (e *compiledLogicalOr) emitGetter(putOnStack bool) {
 	j := len(e.c.p.code)
 	e.addSrcMap()
 	e.c.emit(nil)
+	e.c.emitBranchCounter(e.counters, 1)
 	e.c.emitExpr(e.right, true)
 	e.c.p.code[j] = jeq1(len(e.c.p.code) - j)
 	if !putOnStack {
@@ -2608,9 +2625,11 @@ func (e *compiledCoalesce) constant() bool {
 }
 
 func (e *compiledCoalesce) emitGetter(putOnStack bool) {
+	e.c.emitBranchCounter(e.counters, 0)
 	if e.left.constant() {
 		if v, ex := e.c.evalConst(e.left); ex == nil {
 			if v == _undefined || v == _null {
+				e.c.emitBranchCounter(e.counters, 1)
 				e.c.emitExpr(e.right, putOnStack)
 			} else {
 				if putOnStack {
@@ -2626,6 +2645,7 @@ func (e *compiledCoalesce) emitGetter(putOnStack bool) {
 	j := len(e.c.p.code)
 	e.addSrcMap()
 	e.c.emit(nil)
+	e.c.emitBranchCounter(e.counters, 1)
 	e.c.emitExpr(e.right, true)
 	e.c.p.code[j] = jcoalesc(len(e.c.p.code) - j)
 	if !putOnStack {
@@ -2651,11 +2671,13 @@ func (e *compiledLogicalAnd) constant() bool {
 
 func (e *compiledLogicalAnd) emitGetter(putOnStack bool) {
 	var j int
+	e.c.emitBranchCounter(e.counters, 0)
 	if e.left.constant() {
 		if v, ex := e.c.evalConst(e.left); ex == nil {
 			if !v.ToBoolean() {
 				e.c.emit(loadVal(e.c.p.defineLiteralValue(v)))
 			} else {
+				e.c.emitBranchCounter(e.counters, 1)
 				e.c.emitExpr(e.right, putOnStack)
 			}
 		} else {
@@ -2667,6 +2689,7 @@ func (e *compiledLogicalAnd) emitGetter(putOnStack bool) {
 	j = len(e.c.p.code)
 	e.addSrcMap()
 	e.c.emit(nil)
+	e.c.emitBranchCounter(e.counters, 1)
 	e.c.emitExpr(e.right, true)
 	e.c.p.code[j] = jneq1(len(e.c.p.code) - j)
 	if !putOnStack {
@@ -2742,11 +2765,11 @@ func (c *compiler) compileBinaryExpression(v *ast.BinaryExpression) compiledExpr
 
 	switch v.Operator {
 	case token.LOGICAL_OR:
-		return c.compileLogicalOr(v.Left, v.Right, v.Idx0())
+		return c.compileLogicalOr(v.Left, v.Right, v.Idx0(), c.coverBranch("binary-expr", v, v.Left, v.Right))
 	case token.COALESCE:
-		return c.compileCoalesce(v.Left, v.Right, v.Idx0())
+		return c.compileCoalesce(v.Left, v.Right, v.Idx0(), c.coverBranch("binary-expr", v, v.Left, v.Right))
 	case token.LOGICAL_AND:
-		return c.compileLogicalAnd(v.Left, v.Right, v.Idx0())
+		return c.compileLogicalAnd(v.Left, v.Right, v.Idx0(), c.coverBranch("binary-expr", v, v.Left, v.Right))
 	}
 
 	if id, ok := v.Left.(*ast.PrivateIdentifier); ok {
@@ -2790,28 +2813,31 @@ func (c *compiler) compilePrivateIn(id *ast.PrivateIdentifier, right ast.Express
 	return r
 }
 
-func (c *compiler) compileLogicalOr(left, right ast.Expression, idx file.Idx) compiledExpr {
+func (c *compiler) compileLogicalOr(left, right ast.Expression, idx file.Idx, counters []*coverCounter) compiledExpr {
 	r := &compiledLogicalOr{
-		left:  c.compileExpression(left),
-		right: c.compileExpression(right),
+		left:     c.compileExpression(left),
+		right:    c.compileExpression(right),
+		counters: counters,
 	}
 	r.init(c, idx)
 	return r
 }
 
-func (c *compiler) compileCoalesce(left, right ast.Expression, idx file.Idx) compiledExpr {
+func (c *compiler) compileCoalesce(left, right ast.Expression, idx file.Idx, counters []*coverCounter) compiledExpr {
 	r := &compiledCoalesce{
-		left:  c.compileExpression(left),
-		right: c.compileExpression(right),
+		left:     c.compileExpression(left),
+		right:    c.compileExpression(right),
+		counters: counters,
 	}
 	r.init(c, idx)
 	return r
 }
 
-func (c *compiler) compileLogicalAnd(left, right ast.Expression, idx file.Idx) compiledExpr {
+func (c *compiler) compileLogicalAnd(left, right ast.Expression, idx file.Idx, counters []*coverCounter) compiledExpr {
 	r := &compiledLogicalAnd{
-		left:  c.compileExpression(left),
-		right: c.compileExpression(right),
+		left:     c.compileExpression(left),
+		right:    c.compileExpression(right),
+		counters: counters,
 	}
 	r.init(c, idx)
 	return r
@@ -3054,16 +3080,7 @@ func (e *compiledCallExpr) emitGetter(putOnStack bool) {
 			e.c.emit(superCall(len(e.args)))
 		}
 	} else if calleeName == "eval" {
-		foundVar := false
-		for sc := e.c.scope; sc != nil; sc = sc.outer {
-			if !foundVar && (sc.variable || sc.isFunction()) {
-				foundVar = true
-				if !sc.strict {
-					sc.dynamic = true
-				}
-			}
-			sc.dynLookup = true
-		}
+		e.c.markDirectEval()
 
 		if e.c.scope.strict {
 			if e.isVariadic {
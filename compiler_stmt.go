@@ -8,6 +8,11 @@ import (
 )
 
 func (c *compiler) compileStatement(v ast.Statement, needResult bool) {
+	if c.debug {
+		c.markDirectEval()
+		c.p.addSrcMap(int(v.Idx0()) - 1)
+	}
+	c.coverStatement(v)
 
 	switch v := v.(type) {
 	case *ast.BlockStatement:
@@ -52,6 +57,8 @@ func (c *compiler) compileStatement(v ast.Statement, needResult bool) {
 	case *ast.WithStatement:
 		c.compileWithStatement(v, needResult)
 	case *ast.DebuggerStatement:
+		c.p.addSrcMap(int(v.Idx0()) - 1)
+		c.emit(debuggerStatement)
 	default:
 		c.assert(false, int(v.Idx0())-1, "Unknown statement type: %T", v)
 		panic("unreachable")
@@ -678,6 +685,11 @@ func (c *compiler) compileIfBodyDummy(s ast.Statement) {
 }
 
 func (c *compiler) compileIfStatement(v *ast.IfStatement, needResult bool) {
+	var alternate ast.Node
+	if v.Alternate != nil {
+		alternate = v.Alternate
+	}
+	counters := c.coverBranch("if", v, v.Consequent, alternate)
 	test := c.compileExpression(v.Test)
 	if needResult {
 		c.emit(clearResult)
@@ -690,12 +702,14 @@ func (c *compiler) compileIfStatement(v *ast.IfStatement, needResult bool) {
 			return
 		}
 		if r.ToBoolean() {
+			c.emitBranchCounter(counters, 0)
 			c.compileIfBody(v.Consequent, needResult)
 			if v.Alternate != nil {
 				c.compileIfBodyDummy(v.Alternate)
 			}
 		} else {
 			c.compileIfBodyDummy(v.Consequent)
+			c.emitBranchCounter(counters, 1)
 			if v.Alternate != nil {
 				c.compileIfBody(v.Alternate, needResult)
 			} else {
@@ -709,18 +723,25 @@ func (c *compiler) compileIfStatement(v *ast.IfStatement, needResult bool) {
 	test.emitGetter(true)
 	jmp := len(c.p.code)
 	c.emit(nil)
+	c.emitBranchCounter(counters, 0)
 	c.compileIfBody(v.Consequent, needResult)
 	if v.Alternate != nil {
 		jmp1 := len(c.p.code)
 		c.emit(nil)
 		c.p.code[jmp] = jne(len(c.p.code) - jmp)
+		c.emitBranchCounter(counters, 1)
 		c.compileIfBody(v.Alternate, needResult)
 		c.p.code[jmp1] = jump(len(c.p.code) - jmp1)
 	} else {
-		if needResult {
-			c.emit(jump(2))
+		if needResult || counters != nil {
+			jmp1 := len(c.p.code)
+			c.emit(nil)
 			c.p.code[jmp] = jne(len(c.p.code) - jmp)
-			c.emit(clearResult)
+			c.emitBranchCounter(counters, 1)
+			if needResult {
+				c.emit(clearResult)
+			}
+			c.p.code[jmp1] = jump(len(c.p.code) - jmp1)
 		} else {
 			c.p.code[jmp] = jne(len(c.p.code) - jmp)
 		}
@@ -1019,6 +1040,15 @@ func (c *compiler) compileSwitchStatement(v *ast.SwitchStatement, needResult boo
 
 	c.compileExpression(v.Discriminant).emitGetter(true)
 
+	var counters []*coverCounter
+	if c.coverage != nil {
+		cases := make([]ast.Node, len(v.Body))
+		for i, s := range v.Body {
+			cases[i] = s
+		}
+		counters = c.coverBranch("switch", v, cases...)
+	}
+
 	var funcs []*ast.FunctionDeclaration
 	for _, s := range v.Body {
 		f := c.extractFunctions(s.Consequent)
@@ -1037,6 +1067,7 @@ func (c *compiler) compileSwitchStatement(v *ast.SwitchStatement, needResult boo
 
 	var enter *enterBlock
 	var db *binding
+	enterPos := -1
 	if scopeDeclared {
 		c.block = &block{
 			typ:        blockScope,
@@ -1044,6 +1075,7 @@ func (c *compiler) compileSwitchStatement(v *ast.SwitchStatement, needResult boo
 			needResult: needResult,
 		}
 		enter = &enterBlock{}
+		enterPos = len(c.p.code)
 		c.emit(enter)
 		// create anonymous variable for the discriminant
 		bindings := c.scope.bindings
@@ -1108,6 +1140,7 @@ func (c *compiler) compileSwitchStatement(v *ast.SwitchStatement, needResult boo
 		if s.Test != nil || i != 0 {
 			c.p.code[jumps[i]] = jump(len(c.p.code) - jumps[i])
 		}
+		c.emitBranchCounter(counters, i)
 		c.compileStatements(s.Consequent, needResult)
 	}
 
@@ -1116,7 +1149,16 @@ func (c *compiler) compileSwitchStatement(v *ast.SwitchStatement, needResult boo
 	}
 	if enter != nil {
 		c.leaveScopeBlock(enter)
-		enter.stackSize--
+		if c.scope.dynLookup || db.inStash {
+			// The discriminant is in the stash, so move its value there on entry.
+			c.p.code[enterPos] = &enterCatchBlock{
+				names:     enter.names,
+				stashSize: enter.stashSize,
+				stackSize: enter.stackSize,
+			}
+		} else {
+			enter.stackSize--
+		}
 		c.popScope()
 	}
 	c.leaveBlock()
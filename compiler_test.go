@@ -5580,3 +5580,43 @@ func BenchmarkCompile(b *testing.B) {
 		}
 	}
 }
+
+func TestArgumentsParamWithEval(t *testing.T) {
+	const SCRIPT = `
+	function F(x, arguments) {
+		eval("");
+		return arguments;
+	}
+	F(1, 42);
+	`
+	testScript(SCRIPT, valueInt(42), t)
+}
+
+func TestSwitchLexicalWithEval(t *testing.T) {
+	const SCRIPT = `
+	function f(x) {
+		switch (x) {
+		case 1:
+			let y = 1;
+			return eval("y + x");
+		}
+	}
+	f(1);
+	`
+	testScript(SCRIPT, valueInt(2), t)
+}
+
+func TestDerivedCtorParamInitWithEval(t *testing.T) {
+	const SCRIPT = `
+	class C extends Error {
+		a = true;
+		constructor(message = "My Error") {
+			eval("");
+			super(message);
+		}
+	}
+	const c = new C();
+	c.a && c.message === "My Error";
+	`
+	testScript(SCRIPT, valueTrue, t)
+}
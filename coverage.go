@@ -0,0 +1,454 @@
+package goja
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/file"
+)
+
+// Coverage collects the statement, branch and function coverage of the code compiled with it (see
+// Runtime.SetCoverage() and Coverage.Compile()). The counters are kept per source file name, so the coverage of a
+// script that is compiled several times (or run in several Runtimes) is accumulated, provided the source is the
+// same. If a different source is compiled under the same name, the previously collected data for that name is
+// discarded.
+//
+// The code of eval() and the Function constructor is not covered, and neither are the sources without a name (such
+// as the ones run with RunString()), because they could not be told apart in the reports.
+//
+// A Coverage is safe for concurrent use, however the reports are only consistent when the covered code is not
+// running.
+type Coverage struct {
+	mu    sync.Mutex
+	files map[string]*fileCoverage
+	names []string
+}
+
+type coverRange struct {
+	start, end int // byte offsets in the source, end is exclusive
+}
+
+// coverCounter is an instruction that increments itself.
+type coverCounter struct {
+	count uint64
+}
+
+func (c *coverCounter) exec(vm *vm) {
+	atomic.AddUint64(&c.count, 1)
+	vm.pc++
+}
+
+func (c *coverCounter) get() uint64 {
+	return atomic.LoadUint64(&c.count)
+}
+
+type coverStatement struct {
+	coverCounter
+	loc coverRange
+}
+
+type coverFunction struct {
+	coverCounter
+	name      string
+	decl, loc coverRange
+}
+
+type coverBranchKey struct {
+	typ string
+	loc coverRange
+}
+
+type coverBranch struct {
+	typ       string
+	loc       coverRange
+	locations []coverRange
+	counters  []*coverCounter
+}
+
+type fileCoverage struct {
+	mu        sync.Mutex
+	name, src string
+	file      *file.File // for the line positions
+
+	statements    []*coverStatement
+	statementsIdx map[coverRange]*coverStatement
+	functions     []*coverFunction
+	functionsIdx  map[coverRange]*coverFunction
+	branches      []*coverBranch
+	branchesIdx   map[coverBranchKey]*coverBranch
+}
+
+// NewCoverage creates an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		files: make(map[string]*fileCoverage),
+	}
+}
+
+// SetCoverage enables the coverage collection for the named scripts subsequently compiled by the Runtime (e.g. by
+// RunScript()). Programs compiled separately are only covered if they were compiled with Coverage.Compile().
+// Passing nil disables the collection for newly compiled scripts.
+func (r *Runtime) SetCoverage(c *Coverage) {
+	r.coverage = c
+}
+
+// Compile is like the package-level Compile(), but the Program collects coverage into c.
+func (c *Coverage) Compile(name, src string, strict bool) (*Program, error) {
+	return compile(name, src, strict, true, nil, compilerMode{coverage: c})
+}
+
+func (c *Coverage) file(name, src string) *fileCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := c.files[name]
+	if f != nil && f.src == src {
+		return f
+	}
+	f = &fileCoverage{
+		name:          name,
+		src:           src,
+		statementsIdx: make(map[coverRange]*coverStatement),
+		functionsIdx:  make(map[coverRange]*coverFunction),
+		branchesIdx:   make(map[coverBranchKey]*coverBranch),
+	}
+	if _, exists := c.files[name]; !exists {
+		c.names = append(c.names, name)
+	}
+	c.files[name] = f
+	return f
+}
+
+func (c *Coverage) fileList() []*fileCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files := make([]*fileCoverage, len(c.names))
+	for i, name := range c.names {
+		files[i] = c.files[name]
+	}
+	return files
+}
+
+func (f *fileCoverage) nodeRange(n ast.Node) (coverRange, bool) {
+	r := coverRange{start: int(n.Idx0()) - 1, end: int(n.Idx1()) - 1}
+	if r.start < 0 || r.end < r.start || r.end > len(f.src) {
+		// synthesized nodes
+		return r, false
+	}
+	return r, true
+}
+
+func (f *fileCoverage) statement(n ast.Node) *coverCounter {
+	loc, ok := f.nodeRange(n)
+	if !ok {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.statementsIdx[loc]
+	if s == nil {
+		s = &coverStatement{loc: loc}
+		f.statements = append(f.statements, s)
+		f.statementsIdx[loc] = s
+	}
+	return &s.coverCounter
+}
+
+func (f *fileCoverage) function(n ast.Node, name *ast.Identifier) *coverFunction {
+	loc, ok := f.nodeRange(n)
+	if !ok {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn := f.functionsIdx[loc]
+	if fn == nil {
+		fn = &coverFunction{loc: loc, decl: loc}
+		if name != nil {
+			fn.name = name.Name.String()
+			if decl, ok := f.nodeRange(name); ok {
+				fn.decl = decl
+			}
+		}
+		f.functions = append(f.functions, fn)
+		f.functionsIdx[loc] = fn
+	}
+	return fn
+}
+
+// setName sets the name of a function that does not have its own (e.g. 'f' in 'var f = function() {}').
+func (f *fileCoverage) setName(fn *coverFunction, name string) {
+	f.mu.Lock()
+	if fn.name == "" {
+		fn.name = name
+	}
+	f.mu.Unlock()
+}
+
+func (f *fileCoverage) branch(typ string, n ast.Node, locations ...ast.Node) []*coverCounter {
+	loc, ok := f.nodeRange(n)
+	if !ok {
+		return nil
+	}
+	key := coverBranchKey{typ: typ, loc: loc}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.branchesIdx[key]
+	if b == nil {
+		b = &coverBranch{
+			typ:       typ,
+			loc:       loc,
+			locations: make([]coverRange, len(locations)),
+			counters:  make([]*coverCounter, len(locations)),
+		}
+		for i, l := range locations {
+			if l == nil {
+				// implicit 'else'
+				b.locations[i] = loc
+			} else if r, ok := f.nodeRange(l); ok {
+				b.locations[i] = r
+			} else {
+				b.locations[i] = loc
+			}
+			b.counters[i] = &coverCounter{}
+		}
+		f.branches = append(f.branches, b)
+		f.branchesIdx[key] = b
+	}
+	return b.counters
+}
+
+// position returns the 1-based line and the 0-based column (in UTF-16 code units) of the offset.
+func (f *fileCoverage) position(offset int) (line, column int) {
+	if f.file == nil {
+		f.file = file.NewFile(f.name, f.src, 1)
+	}
+	pos := f.file.Position(offset)
+	for _, r := range f.src[offset-pos.Column+1 : offset] {
+		if r >= 0x10000 && r != utf8.RuneError {
+			column += 2
+		} else {
+			column++
+		}
+	}
+	return pos.Line, column
+}
+
+func (f *fileCoverage) functionName(i int, fn *coverFunction) string {
+	if fn.name != "" {
+		return fn.name
+	}
+	return fmt.Sprintf("(anonymous_%d)", i)
+}
+
+// WriteLCOV writes the coverage in the LCOV tracefile format (as used by genhtml and most CI services).
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range c.fileList() {
+		f.writeLCOV(bw)
+	}
+	return bw.Flush()
+}
+
+func (f *fileCoverage) writeLCOV(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "TN:\nSF:%s\n", f.name)
+
+	hit := 0
+	for i, fn := range f.functions {
+		line, _ := f.position(fn.decl.start)
+		fmt.Fprintf(w, "FN:%d,%s\n", line, f.functionName(i, fn))
+	}
+	for i, fn := range f.functions {
+		count := fn.get()
+		if count > 0 {
+			hit++
+		}
+		fmt.Fprintf(w, "FNDA:%d,%s\n", count, f.functionName(i, fn))
+	}
+	fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(f.functions), hit)
+
+	found, hit := 0, 0
+	for i, b := range f.branches {
+		line, _ := f.position(b.loc.start)
+		for j, cnt := range b.counters {
+			count := cnt.get()
+			if count > 0 {
+				hit++
+			}
+			found++
+			fmt.Fprintf(w, "BRDA:%d,%d,%d,%d\n", line, i, j, count)
+		}
+	}
+	fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", found, hit)
+
+	lines := make(map[int]uint64)
+	var lineList []int
+	for _, s := range f.statements {
+		line, _ := f.position(s.loc.start)
+		count := s.get()
+		if prev, exists := lines[line]; !exists {
+			lineList = append(lineList, line)
+			lines[line] = count
+		} else if count > prev {
+			lines[line] = count
+		}
+	}
+	sort.Ints(lineList)
+	hit = 0
+	for _, line := range lineList {
+		count := lines[line]
+		if count > 0 {
+			hit++
+		}
+		fmt.Fprintf(w, "DA:%d,%d\n", line, count)
+	}
+	fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(lineList), hit)
+}
+
+type istanbulPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type istanbulRange struct {
+	Start istanbulPosition `json:"start"`
+	End   istanbulPosition `json:"end"`
+}
+
+type istanbulFunction struct {
+	Name string        `json:"name"`
+	Decl istanbulRange `json:"decl"`
+	Loc  istanbulRange `json:"loc"`
+	Line int           `json:"line"`
+}
+
+type istanbulBranch struct {
+	Loc       istanbulRange   `json:"loc"`
+	Type      string          `json:"type"`
+	Locations []istanbulRange `json:"locations"`
+	Line      int             `json:"line"`
+}
+
+type istanbulFile struct {
+	Path         string                      `json:"path"`
+	StatementMap map[string]istanbulRange    `json:"statementMap"`
+	FnMap        map[string]istanbulFunction `json:"fnMap"`
+	BranchMap    map[string]istanbulBranch   `json:"branchMap"`
+	S            map[string]uint64           `json:"s"`
+	F            map[string]uint64           `json:"f"`
+	B            map[string][]uint64         `json:"b"`
+}
+
+func (f *fileCoverage) istanbulRange(r coverRange) istanbulRange {
+	var res istanbulRange
+	res.Start.Line, res.Start.Column = f.position(r.start)
+	res.End.Line, res.End.Column = f.position(r.end)
+	return res
+}
+
+func (f *fileCoverage) istanbul() *istanbulFile {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := &istanbulFile{
+		Path:         f.name,
+		StatementMap: make(map[string]istanbulRange, len(f.statements)),
+		FnMap:        make(map[string]istanbulFunction, len(f.functions)),
+		BranchMap:    make(map[string]istanbulBranch, len(f.branches)),
+		S:            make(map[string]uint64, len(f.statements)),
+		F:            make(map[string]uint64, len(f.functions)),
+		B:            make(map[string][]uint64, len(f.branches)),
+	}
+	for i, s := range f.statements {
+		key := fmt.Sprint(i)
+		res.StatementMap[key] = f.istanbulRange(s.loc)
+		res.S[key] = s.get()
+	}
+	for i, fn := range f.functions {
+		key := fmt.Sprint(i)
+		decl := f.istanbulRange(fn.decl)
+		res.FnMap[key] = istanbulFunction{
+			Name: f.functionName(i, fn),
+			Decl: decl,
+			Loc:  f.istanbulRange(fn.loc),
+			Line: decl.Start.Line,
+		}
+		res.F[key] = fn.get()
+	}
+	for i, b := range f.branches {
+		key := fmt.Sprint(i)
+		loc := f.istanbulRange(b.loc)
+		br := istanbulBranch{
+			Loc:       loc,
+			Type:      b.typ,
+			Locations: make([]istanbulRange, len(b.locations)),
+			Line:      loc.Start.Line,
+		}
+		counts := make([]uint64, len(b.counters))
+		for j, l := range b.locations {
+			br.Locations[j] = f.istanbulRange(l)
+			counts[j] = b.counters[j].get()
+		}
+		res.BranchMap[key] = br
+		res.B[key] = counts
+	}
+	return res
+}
+
+// WriteIstanbulJSON writes the coverage in the Istanbul (nyc) coverage JSON format, keyed by the source name, so
+// it can be merged and reported using the Istanbul tools (e.g. 'nyc report').
+func (c *Coverage) WriteIstanbulJSON(w io.Writer) error {
+	res := make(map[string]*istanbulFile)
+	for _, f := range c.fileList() {
+		res[f.name] = f.istanbul()
+	}
+	return json.NewEncoder(w).Encode(res)
+}
+
+// The compiler part
+
+func (c *compiler) coverStatement(s ast.Statement) {
+	if c.coverage == nil {
+		return
+	}
+	switch s.(type) {
+	case *ast.BlockStatement, *ast.EmptyStatement, *ast.FunctionDeclaration:
+		return
+	}
+	c.emitCoverCounter(c.coverage.statement(s))
+}
+
+// coverBranch registers a branch with the given locations (nil means an implicit 'else'), the returned counters
+// must be emitted at the beginning of each location. Returns nil if coverage is not enabled.
+func (c *compiler) coverBranch(typ string, n ast.Node, locations ...ast.Node) []*coverCounter {
+	if c.coverage == nil {
+		return nil
+	}
+	return c.coverage.branch(typ, n, locations...)
+}
+
+func (c *compiler) emitBranchCounter(counters []*coverCounter, i int) {
+	if counters != nil {
+		c.emitCoverCounter(counters[i])
+	}
+}
+
+func (c *compiler) coverFunction(n ast.Node, name *ast.Identifier) *coverFunction {
+	if c.coverage == nil {
+		return nil
+	}
+	return c.coverage.function(n, name)
+}
+
+func (c *compiler) emitCoverCounter(cnt *coverCounter) {
+	if cnt != nil {
+		c.emit(cnt)
+	}
+}
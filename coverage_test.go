@@ -0,0 +1,126 @@
+package goja
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	const SCRIPT = `function f(x) {
+	if (x > 0) {
+		return "pos";
+	}
+	return x < 0 ? "neg" : "zero";
+}
+var g = function() {
+	return 1;
+};
+var h = x => x || 42;
+switch (f(1)) {
+case "pos":
+	f(-1);
+case "neg":
+	break;
+default:
+	g();
+}
+h(0);
+`
+	cov := NewCoverage()
+	vm := New()
+	vm.SetCoverage(cov)
+	if _, err := vm.RunScript("test.js", SCRIPT); err != nil {
+		t.Fatal(err)
+	}
+	// a second run of the same source is accumulated
+	prg, err := cov.Compile("test.js", SCRIPT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New().RunProgram(prg); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cov.WriteIstanbulJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var res map[string]*istanbulFile
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	fc := res["test.js"]
+	if fc == nil {
+		t.Fatalf("No coverage for test.js: %s", buf.String())
+	}
+
+	functions := make(map[string]uint64)
+	for key, fn := range fc.FnMap {
+		functions[fn.Name] = fc.F[key]
+	}
+	if functions["f"] != 4 || functions["g"] != 0 || functions["h"] != 2 {
+		t.Fatalf("Unexpected function counts: %v", functions)
+	}
+
+	branches := make(map[string][]uint64)
+	for key, b := range fc.BranchMap {
+		branches[b.Type] = fc.B[key]
+	}
+	expected := map[string][]uint64{
+		"if":          {2, 2},
+		"cond-expr":   {2, 0},
+		"binary-expr": {2, 2},
+		"switch":      {2, 2, 0},
+	}
+	for typ, counts := range expected {
+		if got := branches[typ]; len(got) != len(counts) || got[0] != counts[0] || got[1] != counts[1] {
+			t.Fatalf("Unexpected counts for %s: %v", typ, got)
+		}
+	}
+
+	for key, s := range fc.StatementMap {
+		if s.Start.Line == 8 && fc.S[key] != 0 {
+			t.Fatalf("Line 8 is not executed, but its count is %d", fc.S[key])
+		}
+		if s.Start.Line == 3 && (s.Start.Column != 2 || fc.S[key] != 2) {
+			t.Fatalf("Unexpected statement on line 3: %v, %d", s, fc.S[key])
+		}
+	}
+
+	buf.Reset()
+	if err := cov.WriteLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lcov := buf.String()
+	for _, line := range []string{"SF:test.js\n", "FNDA:4,f\n", "FNDA:0,g\n", "DA:3,2\n", "DA:8,0\n", "FNF:3\nFNH:2\n", "end_of_record\n"} {
+		if !strings.Contains(lcov, line) {
+			t.Fatalf("%q is missing in:\n%s", line, lcov)
+		}
+	}
+}
+
+func TestCoverageSourceChange(t *testing.T) {
+	cov := NewCoverage()
+	vm := New()
+	vm.SetCoverage(cov)
+	if _, err := vm.RunScript("test.js", "var x = 1;"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.RunScript("test.js", "var y = 1;\nvar z = 2;"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.RunString("eval('var e = 1')"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := cov.WriteLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// the unnamed source of RunString() is not covered
+	const expected = "TN:\nSF:test.js\nFNF:0\nFNH:0\nBRF:0\nBRH:0\nDA:1,1\nDA:2,1\nLF:2\nLH:2\nend_of_record\n"
+	if lcov := buf.String(); lcov != expected {
+		t.Fatalf("Unexpected LCOV:\n%s", lcov)
+	}
+}
@@ -68,7 +68,7 @@ var (
 	}
 )
 
-func dateParse(date string) (time.Time, bool) {
+func dateParse(date string, loc *time.Location) (time.Time, bool) {
 	var t time.Time
 	var err error
 	var layouts []dateLayoutDesc
@@ -87,7 +87,7 @@ func dateParse(date string) (time.Time, bool) {
 		if desc.dateOnly {
 			defLoc = time.UTC
 		} else {
-			defLoc = time.Local
+			defLoc = loc
 		}
 		t, err = parseDate(desc.layout, date, defLoc)
 		if err == nil {
@@ -118,8 +118,8 @@ func (r *Runtime) newDateObject(t time.Time, isSet bool, proto *Object) *Object
 	return v
 }
 
-func dateFormat(t time.Time) string {
-	return t.Local().Format(dateTimeLayout)
+func dateFormat(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(dateTimeLayout)
 }
 
 func timeFromMsec(msec int64) time.Time {
@@ -166,7 +166,7 @@ func (d *dateObject) unset() {
 }
 
 func (d *dateObject) time() time.Time {
-	return timeFromMsec(d.msec)
+	return timeFromMsec(d.msec).In(d.val.runtime.timeZone())
 }
 
 func (d *dateObject) timeUTC() time.Time {
@@ -0,0 +1,676 @@
+package goja
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dop251/goja/file"
+	"github.com/dop251/goja/unistring"
+)
+
+// PauseReason describes why the execution has been paused.
+type PauseReason int
+
+const (
+	// PauseBreakpoint means a breakpoint has been hit.
+	PauseBreakpoint PauseReason = iota
+	// PauseDebuggerStatement means a 'debugger' statement has been executed.
+	PauseDebuggerStatement
+	// PauseStep means a step requested by the previous DebugAction has been completed.
+	PauseStep
+	// PauseRequested means the execution has been paused by Debugger.Pause().
+	PauseRequested
+)
+
+func (r PauseReason) String() string {
+	switch r {
+	case PauseBreakpoint:
+		return "breakpoint"
+	case PauseDebuggerStatement:
+		return "debugger statement"
+	case PauseStep:
+		return "step"
+	case PauseRequested:
+		return "pause"
+	}
+	return "unknown"
+}
+
+// DebugAction is returned by a DebugHandler and tells how the execution should proceed.
+type DebugAction int
+
+const (
+	// DebugContinue resumes the execution until the next breakpoint, 'debugger' statement or Debugger.Pause().
+	DebugContinue DebugAction = iota
+	// DebugStepIn pauses on the next line, including the lines of the functions called from the current one.
+	DebugStepIn
+	// DebugStepOver pauses on the next line of the current function or when it returns.
+	DebugStepOver
+	// DebugStepOut pauses when the current function returns.
+	DebugStepOut
+)
+
+// DebugHandler is called when the execution is paused. It is called synchronously on the goroutine
+// running the code, which stays blocked until the handler returns. The handler may inspect the frames and
+// evaluate expressions in them (see DebugFrame) but the DebugPause and the frames must not be used after
+// it returns.
+type DebugHandler func(p *DebugPause) DebugAction
+
+// DebugPause describes the state of a paused execution.
+type DebugPause struct {
+	// Reason is the reason why the execution has been paused.
+	Reason PauseReason
+	// Breakpoint is the breakpoint that has been hit if Reason is PauseBreakpoint, nil otherwise.
+	Breakpoint *Breakpoint
+	// Frames is the call stack, the innermost frame first.
+	Frames []*DebugFrame
+
+	active bool
+}
+
+// Breakpoint is a source position on which the execution is paused. See Debugger.SetBreakpoint().
+type Breakpoint struct {
+	id       int
+	filename string
+	line     int
+	column   int
+}
+
+// ID returns a number that uniquely identifies the breakpoint within its Debugger.
+func (b *Breakpoint) ID() int {
+	return b.id
+}
+
+// Filename returns the name of the source the breakpoint is set in.
+func (b *Breakpoint) Filename() string {
+	return b.filename
+}
+
+// Line returns the (1-based) line number of the breakpoint.
+func (b *Breakpoint) Line() int {
+	return b.line
+}
+
+// Column returns the (1-based) column number of the breakpoint or 0 if the breakpoint applies to the whole line.
+func (b *Breakpoint) Column() int {
+	return b.column
+}
+
+// DebugScopeType is the type of DebugScope.
+type DebugScopeType int
+
+const (
+	// DebugScopeLocal is a block or a function scope of the frame's function.
+	DebugScopeLocal DebugScopeType = iota
+	// DebugScopeClosure is a scope of an enclosing function.
+	DebugScopeClosure
+	// DebugScopeWith is an object environment created by a 'with' statement.
+	DebugScopeWith
+	// DebugScopeGlobal is the global scope.
+	DebugScopeGlobal
+)
+
+func (t DebugScopeType) String() string {
+	switch t {
+	case DebugScopeLocal:
+		return "local"
+	case DebugScopeClosure:
+		return "closure"
+	case DebugScopeWith:
+		return "with"
+	case DebugScopeGlobal:
+		return "global"
+	}
+	return "unknown"
+}
+
+// DebugVariable is a variable visible in a DebugScope.
+type DebugVariable struct {
+	Name string
+	// Value is nil if the variable is a 'let', 'const' or 'class' binding that has not been initialised yet.
+	Value Value
+}
+
+// DebugScope is an element of a frame's scope chain.
+type DebugScope struct {
+	Type DebugScopeType
+	// Object is the binding object for DebugScopeWith and DebugScopeGlobal, nil otherwise.
+	Object    *Object
+	Variables []DebugVariable
+}
+
+// DebugFrame is a call stack frame of a paused execution.
+type DebugFrame struct {
+	StackFrame
+
+	pause   *DebugPause
+	r       *Runtime
+	stash   *stash
+	privEnv *privateEnv
+	sb      int
+}
+
+type debugProgram struct {
+	lines     map[int]int // pc -> line for every position change
+	bps       map[int]*Breakpoint
+	bpVersion uint32
+}
+
+// maxDebugPrograms limits the size of the per-Program cache (it may grow indefinitely when eval() is used).
+const maxDebugPrograms = 1024
+
+// Debugger provides breakpoints, stepping and call frame inspection. It is attached to a Runtime using
+// Runtime.AttachDebugger().
+//
+// While a Debugger is attached, the code compiled by the Runtime (i.e. by RunString, RunScript, eval() and
+// the Function constructor) keeps all its variables in scopes that can be inspected. Such code runs slower
+// than normal. Programs created with Compile() before or while a Debugger is attached can still be debugged,
+// but the variables that the compiler optimised away (i.e. the ones that are not captured by a closure) are
+// not visible in the frame scopes and cannot be accessed by DebugFrame.Eval().
+//
+// SetBreakpoint, RemoveBreakpoint, ClearBreakpoints and Pause are safe for concurrent use, all other methods
+// may only be called from the goroutine running the code (e.g. from the DebugHandler) or when the code
+// is not running.
+type Debugger struct {
+	r       *Runtime
+	handler DebugHandler
+
+	mu          sync.Mutex
+	breakpoints []*Breakpoint
+	bpId        int
+	bpVersion   uint32
+
+	pauseRequested uint32
+
+	programs map[*Program]*debugProgram
+	curPrg   *Program
+	cur      *debugProgram
+
+	sourceHandler func(src *file.File)
+	sources       map[*file.File]struct{}
+
+	paused bool
+
+	step      DebugAction
+	stepDepth int
+	stepPrg   *Program
+	stepLine  int
+}
+
+var errDebugPauseInactive = errors.New("goja: the debugger is no longer paused in this frame")
+
+// AttachDebugger attaches a new Debugger to the Runtime, replacing the current one (if any).
+// The handler is called every time the execution is paused.
+// This method is not safe for concurrent use and may only be called from the vm goroutine or when the vm
+// is not running.
+func (r *Runtime) AttachDebugger(handler DebugHandler) *Debugger {
+	d := &Debugger{
+		r:        r,
+		handler:  handler,
+		programs: make(map[*Program]*debugProgram),
+	}
+	r.debugger = d
+	atomicSetFlag(&r.vm.interrupted, vmFlagDebugger)
+	return d
+}
+
+// DetachDebugger detaches the current Debugger (if any).
+func (r *Runtime) DetachDebugger() {
+	r.debugger = nil
+	atomicClearFlag(&r.vm.interrupted, vmFlagDebugger)
+}
+
+// Debugger returns the currently attached Debugger or nil.
+func (r *Runtime) Debugger() *Debugger {
+	return r.debugger
+}
+
+// SetBreakpoint sets a breakpoint in the source with the given name on the given (1-based) line. If column is
+// greater than 0, the breakpoint is set on the first position on that line at or after the column. If the source
+// has a source map, the line and the column refer to the original source.
+// The execution pauses before the first instruction at the breakpoint position is executed, which includes
+// every loop iteration.
+func (d *Debugger) SetBreakpoint(filename string, line, column int) *Breakpoint {
+	d.mu.Lock()
+	d.bpId++
+	bp := &Breakpoint{
+		id:       d.bpId,
+		filename: filename,
+		line:     line,
+		column:   column,
+	}
+	d.breakpoints = append(d.breakpoints, bp)
+	atomic.AddUint32(&d.bpVersion, 1)
+	d.mu.Unlock()
+	return bp
+}
+
+// RemoveBreakpoint removes the breakpoint. Returns false if it has already been removed.
+func (d *Debugger) RemoveBreakpoint(bp *Breakpoint) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, b := range d.breakpoints {
+		if b == bp {
+			copy(d.breakpoints[i:], d.breakpoints[i+1:])
+			d.breakpoints[len(d.breakpoints)-1] = nil
+			d.breakpoints = d.breakpoints[:len(d.breakpoints)-1]
+			atomic.AddUint32(&d.bpVersion, 1)
+			return true
+		}
+	}
+	return false
+}
+
+// ClearBreakpoints removes all breakpoints.
+func (d *Debugger) ClearBreakpoints() {
+	d.mu.Lock()
+	d.breakpoints = nil
+	atomic.AddUint32(&d.bpVersion, 1)
+	d.mu.Unlock()
+}
+
+// Breakpoints returns the breakpoints currently set.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*Breakpoint(nil), d.breakpoints...)
+}
+
+// Pause makes the execution pause before the next instruction. If the code is not running it will pause
+// as soon as it starts.
+func (d *Debugger) Pause() {
+	atomic.StoreUint32(&d.pauseRequested, 1)
+}
+
+// SetSourceHandler sets a function that is called before the code from a source file is executed for the first
+// time, which allows to list the sources that are being debugged. The function is called on the goroutine
+// running the code. In some cases (e.g. when a lot of code is generated by eval()) it may be called more than
+// once for the same source.
+func (d *Debugger) SetSourceHandler(f func(src *file.File)) {
+	d.sourceHandler = f
+	d.sources = nil
+}
+
+func (d *Debugger) program(prg *Program) *debugProgram {
+	if prg == d.curPrg {
+		return d.cur
+	}
+	dp := d.programs[prg]
+	if dp == nil {
+		if len(d.programs) >= maxDebugPrograms {
+			d.programs = make(map[*Program]*debugProgram)
+			d.sources = nil
+		}
+		dp = &debugProgram{
+			lines:     make(map[int]int, len(prg.srcMap)),
+			bpVersion: math.MaxUint32,
+		}
+		if prg.src != nil {
+			for _, item := range prg.srcMap {
+				dp.lines[item.pc] = prg.src.Position(item.srcPos).Line
+			}
+		}
+		d.programs[prg] = dp
+		if src := prg.src; src != nil && d.sourceHandler != nil {
+			if _, exists := d.sources[src]; !exists {
+				if d.sources == nil {
+					d.sources = make(map[*file.File]struct{})
+				}
+				d.sources[src] = struct{}{}
+				d.sourceHandler(src)
+			}
+		}
+	}
+	d.curPrg, d.cur = prg, dp
+	return dp
+}
+
+func (d *Debugger) resolveBreakpoints(prg *Program, dp *debugProgram, version uint32) {
+	dp.bps = nil
+	dp.bpVersion = version
+	if prg.src == nil {
+		return
+	}
+	d.mu.Lock()
+	breakpoints := d.breakpoints
+	d.mu.Unlock()
+	if len(breakpoints) == 0 {
+		return
+	}
+	for _, bp := range breakpoints {
+		found := false
+		var bestPc, bestPos int
+		for _, item := range prg.srcMap {
+			if _, exists := dp.bps[item.pc]; exists {
+				continue
+			}
+			pos := prg.src.Position(item.srcPos)
+			if pos.Filename != bp.filename || pos.Line != bp.line || pos.Column < bp.column {
+				continue
+			}
+			if !found || item.srcPos < bestPos {
+				found, bestPc, bestPos = true, item.pc, item.srcPos
+			}
+		}
+		if found {
+			if dp.bps == nil {
+				dp.bps = make(map[int]*Breakpoint)
+			}
+			dp.bps[bestPc] = bp
+		}
+	}
+}
+
+// beforeExec is called before every instruction while the debugger is attached.
+func (d *Debugger) beforeExec(vm *vm) {
+	if d.paused {
+		return
+	}
+	prg := vm.prg
+	var dp *debugProgram
+	if prg != nil {
+		dp = d.program(prg)
+	}
+	if atomic.LoadUint32(&d.pauseRequested) != 0 {
+		atomic.StoreUint32(&d.pauseRequested, 0)
+		d.pause(vm, PauseRequested, nil)
+		return
+	}
+	if dp == nil {
+		return
+	}
+	line, ok := dp.lines[vm.pc]
+	if !ok {
+		return
+	}
+	if v := atomic.LoadUint32(&d.bpVersion); v != dp.bpVersion {
+		d.resolveBreakpoints(prg, dp, v)
+	}
+	if bp := dp.bps[vm.pc]; bp != nil {
+		d.pause(vm, PauseBreakpoint, bp)
+		return
+	}
+	if d.step != DebugContinue {
+		depth := len(vm.callStack)
+		var stop bool
+		switch d.step {
+		case DebugStepIn:
+			stop = depth != d.stepDepth || prg != d.stepPrg || line != d.stepLine
+		case DebugStepOver:
+			stop = depth < d.stepDepth || depth == d.stepDepth && (prg != d.stepPrg || line != d.stepLine)
+		case DebugStepOut:
+			stop = depth < d.stepDepth
+		}
+		if stop {
+			d.pause(vm, PauseStep, nil)
+		}
+	}
+}
+
+func (d *Debugger) pause(vm *vm, reason PauseReason, bp *Breakpoint) {
+	p := &DebugPause{
+		Reason:     reason,
+		Breakpoint: bp,
+		active:     true,
+	}
+	p.Frames = d.captureFrames(vm, p)
+	d.paused = true
+	action := func() DebugAction {
+		defer func() {
+			d.paused = false
+			p.active = false
+		}()
+		return d.handler(p)
+	}()
+	d.step = action
+	if action != DebugContinue {
+		d.stepDepth = len(vm.callStack)
+		d.stepPrg = vm.prg
+		d.stepLine = 0
+		if vm.prg != nil {
+			d.stepLine = d.program(vm.prg).lineAt(vm.prg, vm.pc)
+		}
+	}
+}
+
+func (dp *debugProgram) lineAt(prg *Program, pc int) int {
+	i := sort.Search(len(prg.srcMap), func(idx int) bool {
+		return prg.srcMap[idx].pc > pc
+	}) - 1
+	if i >= 0 {
+		return dp.lines[prg.srcMap[i].pc]
+	}
+	return 0
+}
+
+func (d *Debugger) captureFrames(vm *vm, p *DebugPause) []*DebugFrame {
+	var frames []*DebugFrame
+	if vm.pc != -1 {
+		funcName := vm.funcName
+		if vm.prg != nil {
+			funcName = vm.prg.funcName
+		}
+		frames = append(frames, &DebugFrame{
+			StackFrame: StackFrame{prg: vm.prg, pc: vm.pc, funcName: funcName},
+			pause:      p,
+			r:          d.r,
+			stash:      vm.stash,
+			privEnv:    vm.privEnv,
+			sb:         vm.sb,
+		})
+	}
+	for i := len(vm.callStack) - 1; i >= 0; i-- {
+		ctx := &vm.callStack[i]
+		if ctx.pc == -1 {
+			continue
+		}
+		funcName := ctx.funcName
+		if ctx.prg != nil {
+			funcName = ctx.prg.funcName
+		}
+		frames = append(frames, &DebugFrame{
+			StackFrame: StackFrame{prg: ctx.prg, pc: ctx.pc - 1, funcName: funcName},
+			pause:      p,
+			r:          d.r,
+			stash:      ctx.stash,
+			privEnv:    ctx.privEnv,
+			sb:         ctx.sb,
+		})
+	}
+	return frames
+}
+
+// This returns the value of 'this' in the frame, or nil if it is not available (e.g. in a native
+// function or in a derived class constructor before super() is called).
+func (f *DebugFrame) This() Value {
+	if !f.pause.active || f.prg == nil {
+		return nil
+	}
+	if v, exists := f.lookup(thisBindingName); exists {
+		return v
+	}
+	if f.sb > 0 {
+		return f.r.vm.stack[f.sb]
+	}
+	return f.r.globalObject
+}
+
+func (f *DebugFrame) lookup(name unistring.String) (Value, bool) {
+	for s := f.stash; s != nil; s = s.outer {
+		if s.obj != nil {
+			continue
+		}
+		if idx, exists := s.names[name]; exists {
+			return s.values[idx&^maskTyp], true
+		}
+	}
+	return nil, false
+}
+
+// Scopes returns the frame's scope chain, the innermost scope first. The last element is always the global
+// scope. Returns nil for native frames or if the execution is no longer paused.
+func (f *DebugFrame) Scopes() []*DebugScope {
+	if !f.pause.active || f.prg == nil {
+		return nil
+	}
+	r := f.r
+	var scopes []*DebugScope
+	typ := DebugScopeLocal
+	for s := f.stash; s != nil; s = s.outer {
+		if s == &r.global.stash {
+			break
+		}
+		if s.obj != nil {
+			scopes = append(scopes, &DebugScope{
+				Type:      DebugScopeWith,
+				Object:    s.obj,
+				Variables: r.objectDebugVariables(s.obj, nil),
+			})
+			continue
+		}
+		if vars := stashDebugVariables(s, nil); len(vars) > 0 {
+			scopes = append(scopes, &DebugScope{
+				Type:      typ,
+				Variables: vars,
+			})
+		}
+		if s.funcType != funcNone {
+			typ = DebugScopeClosure
+		}
+	}
+	vars := stashDebugVariables(&r.global.stash, nil)
+	return append(scopes, &DebugScope{
+		Type:      DebugScopeGlobal,
+		Object:    r.globalObject,
+		Variables: r.objectDebugVariables(r.globalObject, vars),
+	})
+}
+
+func stashDebugVariables(s *stash, vars []DebugVariable) []DebugVariable {
+	type item struct {
+		name unistring.String
+		idx  uint32
+	}
+	items := make([]item, 0, len(s.names))
+	for name, idx := range s.names {
+		if name == "" || name == thisBindingName {
+			continue
+		}
+		items = append(items, item{name: name, idx: idx})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].idx&^maskTyp < items[j].idx&^maskTyp
+	})
+	for _, it := range items {
+		v := s.values[it.idx&^maskTyp]
+		if v == nil && it.idx&maskVar != 0 {
+			v = _undefined
+		}
+		vars = append(vars, DebugVariable{Name: it.name.String(), Value: v})
+	}
+	return vars
+}
+
+// objectDebugVariables appends the object's own enumerable string-keyed properties to vars. If a getter
+// (or a proxy trap) throws, the exception becomes the value of the property, so it does not affect the
+// paused code.
+func (r *Runtime) objectDebugVariables(o *Object, vars []DebugVariable) []DebugVariable {
+	var keys []string
+	if ex := r.vm.try(func() {
+		keys = o.Keys()
+	}); ex != nil {
+		return vars
+	}
+	for _, name := range keys {
+		var v Value
+		if ex := r.vm.try(func() {
+			v = nilSafe(o.Get(name))
+		}); ex != nil {
+			v = ex.Value()
+		}
+		vars = append(vars, DebugVariable{Name: name, Value: v})
+	}
+	return vars
+}
+
+// Properties returns the own enumerable string-keyed properties of the object. It is meant for inspecting
+// the values found in the frame scopes and may only be called from the DebugHandler.
+func (p *DebugPause) Properties(o *Object) []DebugVariable {
+	if !p.active {
+		return nil
+	}
+	return o.runtime.objectDebugVariables(o, nil)
+}
+
+// Eval evaluates the source code in the scope of the frame as if it were a direct eval() call in strict mode
+// placed at the current position. A thrown JavaScript exception is returned as *Exception.
+// The variables that are not visible in Scopes() cannot be accessed.
+// Eval may only be called from the DebugHandler; breakpoints and steps are not triggered by the evaluated code.
+func (f *DebugFrame) Eval(src string) (res Value, err error) {
+	if !f.pause.active {
+		return nil, errDebugPauseInactive
+	}
+	if f.prg == nil {
+		return nil, errors.New("goja: cannot evaluate in a native frame")
+	}
+	r := f.r
+	vm := r.vm
+	ex := vm.try(func() {
+		inGlobal := true
+		for s := f.stash; s != nil; s = s.outer {
+			if s.isVariable() {
+				inGlobal = false
+				break
+			}
+		}
+		funcObj := _undefined
+		if f.sb > 0 {
+			funcObj = vm.stack[f.sb-1]
+		}
+		vm.pushCtx()
+		vm.stash = f.stash
+		vm.privEnv = f.privEnv
+		p, err := r.compile("<eval>", src, true, inGlobal, vm)
+		if err != nil {
+			panic(err)
+		}
+		vm.prg = p
+		vm.pc = 0
+		vm.args = 0
+		vm.result = _undefined
+		vm.push(funcObj)
+		vm.sb = vm.sp
+		vm.push(nil) // this
+		vm.run()
+		res = vm.result
+		vm.popCtx()
+		vm.halt = false
+		vm.sp -= 2
+	})
+	if ex != nil {
+		err = ex
+	}
+	return
+}
+
+type _debuggerStatement struct{}
+
+var debuggerStatement _debuggerStatement
+
+func (_debuggerStatement) exec(vm *vm) {
+	if d := vm.r.debugger; d != nil && !d.paused {
+		d.pause(vm, PauseDebuggerStatement, nil)
+	}
+	vm.pc++
+}
+
+// leaveDebug is called when the control is passed outside the Runtime, a step that has not been completed
+// does not carry over to the next execution.
+func (r *Runtime) leaveDebug() {
+	if d := r.debugger; d != nil {
+		d.step = DebugContinue
+	}
+}
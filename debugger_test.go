@@ -0,0 +1,239 @@
+package goja
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dop251/goja/file"
+)
+
+func TestDebuggerStatement(t *testing.T) {
+	const SCRIPT = `
+	function outer() {
+		var c = 10;
+		return function f(a) {
+			let b = a + 1;
+			debugger;
+			return b + c;
+		}
+	}
+	outer()(1);
+	`
+	r := New()
+	paused := 0
+	r.AttachDebugger(func(p *DebugPause) DebugAction {
+		paused++
+		if p.Reason != PauseDebuggerStatement {
+			t.Fatalf("Unexpected reason: %v", p.Reason)
+		}
+		if len(p.Frames) != 2 {
+			t.Fatalf("Unexpected frames: %d", len(p.Frames))
+		}
+		f := p.Frames[0]
+		if f.FuncName() != "f" {
+			t.Fatalf("Unexpected func name: %q", f.FuncName())
+		}
+		if pos := f.Position(); pos.Filename != "test.js" || pos.Line != 6 {
+			t.Fatalf("Unexpected position: %v", pos)
+		}
+		scopes := f.Scopes()
+		if len(scopes) < 3 {
+			t.Fatalf("Unexpected scopes: %d", len(scopes))
+		}
+		vars := make(map[string]Value)
+		for _, v := range scopes[0].Variables {
+			vars[v.Name] = v.Value
+		}
+		if scopes[0].Type != DebugScopeLocal || !vars["a"].SameAs(valueInt(1)) || !vars["b"].SameAs(valueInt(2)) {
+			t.Fatalf("Unexpected local scope: %v", scopes[0].Variables)
+		}
+		var found bool
+		for _, s := range scopes[1:] {
+			for _, v := range s.Variables {
+				if v.Name == "c" {
+					if s.Type != DebugScopeClosure || !v.Value.SameAs(valueInt(10)) {
+						t.Fatalf("Unexpected closure variable: %v in %v", v.Value, s.Type)
+					}
+					found = true
+				}
+			}
+		}
+		if !found {
+			t.Fatal("closure variable not found")
+		}
+		if global := scopes[len(scopes)-1]; global.Type != DebugScopeGlobal || global.Object != r.GlobalObject() {
+			t.Fatalf("Unexpected global scope: %v", global.Type)
+		}
+		res, err := f.Eval("a + b + c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.SameAs(valueInt(13)) {
+			t.Fatalf("Unexpected eval result: %v", res)
+		}
+		if _, err := f.Eval("b = 5"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Eval("throw new Error('boom')"); err == nil {
+			t.Fatal("Expected an error")
+		} else if _, ok := err.(*Exception); !ok {
+			t.Fatalf("Unexpected error type: %T", err)
+		}
+		if name := p.Frames[1].FuncName(); name != "<anonymous>" {
+			t.Fatalf("Unexpected outer frame name: %q", name)
+		}
+		return DebugContinue
+	})
+	v, err := r.RunScript("test.js", SCRIPT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paused != 1 {
+		t.Fatalf("paused: %d", paused)
+	}
+	if !v.SameAs(valueInt(15)) {
+		t.Fatalf("Unexpected result: %v", v)
+	}
+}
+
+func TestDebuggerStatementNoDebugger(t *testing.T) {
+	testScript(`debugger; 1`, valueInt(1), t)
+}
+
+func TestDebuggerBreakpoint(t *testing.T) {
+	const SCRIPT = `
+	var sum = 0;
+	for (var i = 0; i < 3; i++) {
+		sum += i;
+	}
+	sum;
+	`
+	r := New()
+	var hits []int64
+	d := r.AttachDebugger(func(p *DebugPause) DebugAction {
+		if p.Reason != PauseBreakpoint {
+			t.Fatalf("Unexpected reason: %v", p.Reason)
+		}
+		if p.Breakpoint.Line() != 4 {
+			t.Fatalf("Unexpected breakpoint: %d", p.Breakpoint.Line())
+		}
+		v, err := p.Frames[0].Eval("i")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hits = append(hits, v.ToInteger())
+		return DebugContinue
+	})
+	bp := d.SetBreakpoint("test.js", 4, 0)
+	d.SetBreakpoint("other.js", 4, 0)
+	if _, err := r.RunScript("test.js", SCRIPT); err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 3 || hits[0] != 0 || hits[1] != 1 || hits[2] != 2 {
+		t.Fatalf("Unexpected hits: %v", hits)
+	}
+	if !d.RemoveBreakpoint(bp) || d.RemoveBreakpoint(bp) {
+		t.Fatal("RemoveBreakpoint")
+	}
+	hits = nil
+	if _, err := r.RunScript("test.js", SCRIPT); err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 0 {
+		t.Fatalf("Unexpected hits: %v", hits)
+	}
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	const SCRIPT = `
+	function f(x) {
+		let y = x * 2;
+		return y;
+	}
+	debugger;
+	let a = f(1);
+	let b = f(a);
+	let c = a + b;
+	`
+	r := New()
+	actions := []DebugAction{DebugStepOver, DebugStepIn, DebugStepOver, DebugStepOut, DebugStepOver, DebugStepOver, DebugContinue}
+	var lines []int
+	r.AttachDebugger(func(p *DebugPause) DebugAction {
+		lines = append(lines, p.Frames[0].Position().Line)
+		action := actions[0]
+		actions = actions[1:]
+		return action
+	})
+	if _, err := r.RunScript("test.js", SCRIPT); err != nil {
+		t.Fatal(err)
+	}
+	expected := []int{6, 7, 3, 4, 7, 8, 9}
+	if len(lines) != len(expected) {
+		t.Fatalf("Unexpected lines: %v", lines)
+	}
+	for i, l := range expected {
+		if lines[i] != l {
+			t.Fatalf("Unexpected lines: %v", lines)
+		}
+	}
+}
+
+func TestDebuggerPause(t *testing.T) {
+	r := New()
+	paused := make(chan struct{})
+	d := r.AttachDebugger(func(p *DebugPause) DebugAction {
+		if p.Reason != PauseRequested {
+			t.Errorf("Unexpected reason: %v", p.Reason)
+		}
+		r.Interrupt("stop")
+		close(paused)
+		return DebugContinue
+	})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		d.Pause()
+	}()
+	_, err := r.RunString("for (;;) {}")
+	if _, ok := err.(*InterruptedError); !ok {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	<-paused
+}
+
+func TestDebuggerFrameInactive(t *testing.T) {
+	r := New()
+	var frame *DebugFrame
+	r.AttachDebugger(func(p *DebugPause) DebugAction {
+		frame = p.Frames[0]
+		return DebugContinue
+	})
+	if _, err := r.RunString("debugger"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := frame.Eval("1"); err != errDebugPauseInactive {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if frame.Scopes() != nil {
+		t.Fatal("Expected nil scopes")
+	}
+}
+
+func TestDebuggerSourceHandler(t *testing.T) {
+	r := New()
+	d := r.AttachDebugger(func(p *DebugPause) DebugAction {
+		return DebugContinue
+	})
+	var sources []string
+	d.SetSourceHandler(func(src *file.File) {
+		sources = append(sources, src.Name())
+	})
+	if _, err := r.RunScript("a.js", "function f() { return 1 }; f(); f();"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RunScript("b.js", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 || sources[0] != "a.js" || sources[1] != "b.js" {
+		t.Fatalf("Unexpected sources: %v", sources)
+	}
+}
@@ -0,0 +1,73 @@
+package goja
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DeterministicOptions configures SetDeterministic().
+type DeterministicOptions struct {
+	// RandSeed is the seed of the pseudo-random number generator used by Math.random().
+	RandSeed int64
+
+	// Time is returned by Date.now() and used by new Date(). It does not advance. The zero value means the
+	// Unix epoch. Use SetTimeSource() afterwards for a clock which advances deterministically.
+	Time time.Time
+
+	// TimeZone is the location used for the local time. If nil, time.UTC is used. Note that the locations loaded
+	// with time.LoadLocation() depend on the time zone database of the machine (unless time/tzdata is imported),
+	// so time.UTC or time.FixedZone() should be preferred.
+	TimeZone *time.Location
+}
+
+// SetDeterministic replaces all sources of nondeterminism in the Runtime with fixed ones, so that the same script
+// produces identical results on any machine:
+//
+//   - Math.random() returns the sequence of a math/rand generator seeded with RandSeed;
+//   - Date.now() and new Date() return the fixed Time;
+//   - the local time of the Date built-ins is in TimeZone rather than in the time zone of the machine;
+//   - the object identifiers used internally by Map, Set, WeakMap and WeakSet are allocated from a fixed start.
+//
+// The remaining sources that could be considered environment dependent are already fixed:
+//
+//   - the locale-sensitive methods do not depend on the locale of the machine: toLocaleString(),
+//     toLocaleDateString() and toLocaleTimeString() of Date use the en-GB formats, toLocaleString() of Number and
+//     toLocaleUpperCase()/toLocaleLowerCase() of String are the same as their non-locale counterparts, and
+//     String.prototype.localeCompare() uses the root collation of golang.org/x/text (so the results only depend
+//     on its version);
+//   - the iteration order of Map and Set is the insertion order, so it's not affected by the (random) maphash seed,
+//     which only determines the layout of the hash tables.
+//
+// Floating point: the conversions between numbers and strings (String(), toFixed(), toPrecision(), parseFloat()
+// and so on) are exact and implemented in pure Go, and so are the arithmetic operators, so their results are the
+// same on all platforms. However, the Math functions other than the exactly rounded ones (Math.sqrt(), Math.abs(),
+// Math.floor() etc.) are computed with the math package of Go, which uses assembly implementations on some
+// architectures and allows the compiler to fuse multiplications and additions (e.g. on arm64, ppc64 and s390x).
+// As a result, functions such as Math.sin(), Math.exp() or Math.pow() may differ in the last bit between
+// architectures. Code which requires bit-identical results across architectures should avoid them.
+//
+// Note that the Go values provided by the host are not covered: for example the properties of a Go map wrapped
+// with ToValue() are enumerated in the random order of the Go map iteration.
+//
+// SetDeterministic should be called right after New(), before any code is run. When combined with Lockdown(), the
+// sources of Math.random() and the current time set by the method which is called last are used.
+func (r *Runtime) SetDeterministic(opts DeterministicOptions) {
+	r.SetRandSource(rand.New(rand.NewSource(opts.RandSeed)).Float64)
+	t := opts.Time
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	r.SetTimeSource(func() time.Time {
+		return t
+	})
+	loc := opts.TimeZone
+	if loc == nil {
+		loc = time.UTC
+	}
+	r.SetTimeZone(loc)
+	if r.hash == nil {
+		// no identifiers have been allocated yet
+		r.getHash()
+		r.idSeq = 1
+	}
+}
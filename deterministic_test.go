@@ -0,0 +1,50 @@
+package goja
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeterministic(t *testing.T) {
+	const SCRIPT = `
+	const m = new Map([[{}, 1], ["a", 2]]);
+	[
+		Math.random(), Math.random(),
+		Date.now(), new Date().toString(), new Date(2020, 0, 1, 12).getTime(),
+		new Date(0).toLocaleString(), Date.parse("2020-01-01T00:00:00"),
+		0.1 + 0.2, (1e21).toString(), (123.456).toFixed(2), Math.sqrt(2),
+		Array.from(m.values()).join(),
+	].join("|")
+	`
+	run := func(opts DeterministicOptions) string {
+		r := New()
+		r.SetDeterministic(opts)
+		res, err := r.RunString(SCRIPT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.String()
+	}
+	res := run(DeterministicOptions{RandSeed: 1})
+	if res1 := run(DeterministicOptions{RandSeed: 1}); res1 != res {
+		t.Fatalf("%q != %q", res1, res)
+	}
+	const expected = "|0|Thu Jan 01 1970 00:00:00 GMT+0000 (UTC)|1577880000000|01/01/1970, 00:00:00|1577836800000|" +
+		"0.30000000000000004|1e+21|123.46|1.4142135623730951|1,2"
+	if res[len(res)-len(expected):] != expected {
+		t.Fatal(res)
+	}
+	if res2 := run(DeterministicOptions{RandSeed: 2}); res2 == res {
+		t.Fatal("The seed is not used")
+	}
+
+	res = run(DeterministicOptions{
+		Time:     time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		TimeZone: time.FixedZone("UTC+3", 3*60*60),
+	})
+	const expected1 = "|1577836800000|Wed Jan 01 2020 03:00:00 GMT+0300 (UTC+3)|1577869200000|01/01/1970, 03:00:00|1577826000000|"
+	if !strings.Contains(res, expected1) {
+		t.Fatal(res)
+	}
+}
@@ -0,0 +1,557 @@
+package goja
+
+import (
+	gocontext "context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errEventLoopTerminated = errors.New("event loop terminated")
+
+// Timer is a handle returned by EventLoop.SetTimeout() and EventLoop.SetInterval() (and their
+// JavaScript counterparts).
+type Timer struct {
+	loop      *EventLoop
+	timer     *time.Timer
+	delay     time.Duration
+	interval  bool
+	cancelled bool
+	fn        func()
+}
+
+// Immediate is a handle returned by setImmediate().
+type Immediate struct {
+	loop      *EventLoop
+	cancelled bool
+	fn        func()
+}
+
+// EventLoop owns a Runtime and runs tasks for it on a single goroutine: timers, immediates, callbacks of
+// pending host operations and arbitrary functions submitted with RunOnLoop(). After each task the Promise
+// job queue (microtasks) is drained.
+//
+// The following globals are defined in the Runtime: setTimeout, clearTimeout, setInterval, clearInterval,
+// setImmediate, clearImmediate and queueMicrotask.
+//
+// An uncaught exception in a JavaScript callback stops the loop. The exception is returned by Run() or Stop().
+//
+// The Runtime must only be accessed from the loop, i.e. from the functions passed to Run(), RunOnLoop(),
+// SetTimeout(), SetInterval() or the ones returned by RegisterCallback().
+type EventLoop struct {
+	vm       *Runtime
+	jobCount int32
+	canRun   int32
+
+	auxJobsLock sync.Mutex
+	wakeupChan  chan struct{}
+
+	auxJobs, auxJobsSpare []func()
+
+	immediates []*Immediate
+	timers     map[*Timer]struct{}
+	err        error
+
+	stopLock   sync.Mutex
+	stopCond   *sync.Cond
+	running    bool
+	terminated bool
+}
+
+// NewEventLoop creates a new EventLoop with a new Runtime.
+func NewEventLoop() *EventLoop {
+	loop := &EventLoop{
+		vm:         New(),
+		wakeupChan: make(chan struct{}, 1),
+		timers:     make(map[*Timer]struct{}),
+	}
+	loop.stopCond = sync.NewCond(&loop.stopLock)
+
+	r := loop.vm
+	r.Set("setTimeout", loop.builtin_setTimeout)
+	r.Set("setInterval", loop.builtin_setInterval)
+	r.Set("setImmediate", loop.builtin_setImmediate)
+	r.Set("clearTimeout", loop.builtin_clearTimeout)
+	r.Set("clearInterval", loop.builtin_clearTimeout)
+	r.Set("clearImmediate", loop.builtin_clearImmediate)
+	r.Set("queueMicrotask", loop.builtin_queueMicrotask)
+
+	return loop
+}
+
+func (loop *EventLoop) jsCallback(call FunctionCall, argsFrom int) func() {
+	r := loop.vm
+	fn, ok := AssertFunction(call.Argument(0))
+	if !ok {
+		panic(r.NewTypeError("The callback must be a function"))
+	}
+	var args []Value
+	if len(call.Arguments) > argsFrom {
+		args = append(args, call.Arguments[argsFrom:]...)
+	}
+	return func() {
+		if _, err := fn(nil, args...); err != nil {
+			loop.fail(err)
+		}
+	}
+}
+
+func (loop *EventLoop) jsDelay(v Value) time.Duration {
+	ms := v.ToFloat()
+	if !(ms > 0) {
+		return 0
+	}
+	if ms > math.MaxInt32 {
+		ms = math.MaxInt32
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+func (loop *EventLoop) builtin_setTimeout(call FunctionCall) Value {
+	t := loop.newTimer(loop.jsCallback(call, 2), loop.jsDelay(call.Argument(1)), false)
+	loop.addTimer(t)
+	return loop.vm.ToValue(t)
+}
+
+func (loop *EventLoop) builtin_setInterval(call FunctionCall) Value {
+	delay := loop.jsDelay(call.Argument(1))
+	if delay < time.Millisecond {
+		delay = time.Millisecond
+	}
+	t := loop.newTimer(loop.jsCallback(call, 2), delay, true)
+	loop.addTimer(t)
+	return loop.vm.ToValue(t)
+}
+
+func (loop *EventLoop) builtin_setImmediate(call FunctionCall) Value {
+	im := &Immediate{
+		loop: loop,
+		fn:   loop.jsCallback(call, 1),
+	}
+	if loop.isTerminated() {
+		im.cancelled = true
+	} else {
+		loop.immediates = append(loop.immediates, im)
+		loop.jobCount++
+	}
+	return loop.vm.ToValue(im)
+}
+
+func (loop *EventLoop) builtin_clearTimeout(call FunctionCall) Value {
+	if t, ok := call.Argument(0).Export().(*Timer); ok && t.loop == loop {
+		loop.clearTimer(t)
+	}
+	return _undefined
+}
+
+func (loop *EventLoop) builtin_clearImmediate(call FunctionCall) Value {
+	if im, ok := call.Argument(0).Export().(*Immediate); ok && im.loop == loop && !im.cancelled {
+		im.cancelled = true
+		loop.jobCount--
+	}
+	return _undefined
+}
+
+func (loop *EventLoop) builtin_queueMicrotask(call FunctionCall) Value {
+	r := loop.vm
+	fn := r.toCallable(call.Argument(0))
+	r.enqueuePromiseJob(func() {
+		if ex := r.vm.try(func() {
+			fn(FunctionCall{This: _undefined})
+		}); ex != nil {
+			loop.fail(ex)
+		}
+	})
+	return _undefined
+}
+
+func (loop *EventLoop) newTimer(fn func(), delay time.Duration, interval bool) *Timer {
+	return &Timer{
+		loop:     loop,
+		delay:    delay,
+		interval: interval,
+		fn:       fn,
+	}
+}
+
+// addTimer arms the timer unless the loop has been terminated. Must be called on the loop.
+func (loop *EventLoop) addTimer(t *Timer) {
+	if loop.isTerminated() {
+		t.cancelled = true
+		return
+	}
+	loop.timers[t] = struct{}{}
+	loop.jobCount++
+	t.timer = time.AfterFunc(t.delay, func() {
+		loop.addAuxJob(func() {
+			loop.fireTimer(t)
+		})
+	})
+}
+
+func (loop *EventLoop) fireTimer(t *Timer) {
+	if t.cancelled {
+		return
+	}
+	if !t.interval {
+		t.cancelled = true
+		delete(loop.timers, t)
+		loop.jobCount--
+	}
+	t.fn()
+	if t.interval && !t.cancelled {
+		t.timer.Reset(t.delay)
+	}
+}
+
+// clearTimer cancels the timer. Must be called on the loop.
+func (loop *EventLoop) clearTimer(t *Timer) {
+	if !t.cancelled {
+		t.cancelled = true
+		t.timer.Stop()
+		delete(loop.timers, t)
+		loop.jobCount--
+	}
+}
+
+func (loop *EventLoop) fail(err error) {
+	if loop.err == nil {
+		loop.err = err
+	}
+	atomic.StoreInt32(&loop.canRun, 0)
+}
+
+// drainJobs runs the Promise jobs that were queued by a Go task.
+func (loop *EventLoop) drainJobs() {
+	if err := loop.vm.RunPendingJobs(); err != nil {
+		loop.fail(err)
+	}
+}
+
+func (loop *EventLoop) runTask(task func()) {
+	task()
+	loop.drainJobs()
+}
+
+func (loop *EventLoop) isTerminated() bool {
+	loop.auxJobsLock.Lock()
+	defer loop.auxJobsLock.Unlock()
+	return loop.terminated
+}
+
+func (loop *EventLoop) addAuxJob(fn func()) bool {
+	loop.auxJobsLock.Lock()
+	if loop.terminated {
+		loop.auxJobsLock.Unlock()
+		return false
+	}
+	loop.auxJobs = append(loop.auxJobs, fn)
+	loop.auxJobsLock.Unlock()
+	select {
+	case loop.wakeupChan <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (loop *EventLoop) runAux() {
+	loop.auxJobsLock.Lock()
+	jobs := loop.auxJobs
+	loop.auxJobs = loop.auxJobsSpare
+	loop.auxJobsLock.Unlock()
+	for i, job := range jobs {
+		if atomic.LoadInt32(&loop.canRun) == 0 {
+			// keep the remaining jobs for the next run
+			loop.auxJobsLock.Lock()
+			loop.auxJobs = append(append([]func(){}, jobs[i:]...), loop.auxJobs...)
+			loop.auxJobsLock.Unlock()
+			jobs = jobs[:i]
+			break
+		}
+		loop.runTask(job)
+		jobs[i] = nil
+	}
+	loop.auxJobsSpare = jobs[:0]
+}
+
+func (loop *EventLoop) runImmediates() {
+	immediates := loop.immediates
+	loop.immediates = nil
+	for i, im := range immediates {
+		if atomic.LoadInt32(&loop.canRun) == 0 {
+			loop.immediates = append(immediates[i:], loop.immediates...)
+			break
+		}
+		if !im.cancelled {
+			im.cancelled = true
+			loop.jobCount--
+			loop.runTask(im.fn)
+		}
+	}
+}
+
+func (loop *EventLoop) run(inBackground bool) {
+	if inBackground {
+		loop.jobCount++
+	}
+	loop.runAux()
+	for loop.jobCount > 0 && atomic.LoadInt32(&loop.canRun) != 0 {
+		if len(loop.immediates) > 0 {
+			loop.runImmediates()
+		} else {
+			<-loop.wakeupChan
+		}
+		loop.runAux()
+	}
+	if inBackground {
+		loop.jobCount--
+	}
+}
+
+func (loop *EventLoop) setRunning() error {
+	if loop.isTerminated() {
+		return errEventLoopTerminated
+	}
+	loop.stopLock.Lock()
+	defer loop.stopLock.Unlock()
+	if loop.running {
+		panic("Loop is already started")
+	}
+	loop.running = true
+	loop.err = nil
+	atomic.StoreInt32(&loop.canRun, 1)
+	return nil
+}
+
+func (loop *EventLoop) setStopped() {
+	loop.stopLock.Lock()
+	loop.running = false
+	loop.stopLock.Unlock()
+	loop.stopCond.Broadcast()
+}
+
+// Run calls the specified function, runs the event loop and waits until there are no more pending timers,
+// immediates or registered callbacks, after which it stops the loop and returns. It returns the uncaught
+// exception that stopped the loop, if any.
+//
+// Do NOT use this function while the loop is already running, use RunOnLoop() instead.
+// If the loop is already started it will panic. If the loop has been terminated an error is returned.
+func (loop *EventLoop) Run(fn func(*Runtime)) error {
+	if err := loop.setRunning(); err != nil {
+		return err
+	}
+	defer loop.setStopped()
+	loop.runTask(func() {
+		fn(loop.vm)
+	})
+	loop.run(false)
+	return loop.err
+}
+
+// Start the event loop in the background. The loop continues to run until Stop() is called.
+// If the loop is already started it will panic. If the loop has been terminated an error is returned.
+func (loop *EventLoop) Start() error {
+	if err := loop.setRunning(); err != nil {
+		return err
+	}
+	go func() {
+		defer loop.setStopped()
+		loop.run(true)
+	}()
+	return nil
+}
+
+// Stop the loop that was started with Start(). After this function returns there will be no more jobs
+// executed by the loop. Pending timers are preserved and the loop can be started again.
+// Returns the uncaught exception that stopped the loop earlier, if any.
+//
+// It is fine to call Stop() when the loop is not running. Must not be called from the loop.
+func (loop *EventLoop) Stop() error {
+	loop.StopNoWait()
+	loop.stopLock.Lock()
+	for loop.running {
+		loop.stopCond.Wait()
+	}
+	err := loop.err
+	loop.stopLock.Unlock()
+	return err
+}
+
+// StopNoWait signals the loop to stop and returns immediately. The loop finishes the task it is currently
+// running (if any) before stopping.
+func (loop *EventLoop) StopNoWait() {
+	atomic.StoreInt32(&loop.canRun, 0)
+	select {
+	case loop.wakeupChan <- struct{}{}:
+	default:
+	}
+}
+
+// Terminate stops the loop, interrupts the currently running script (see Runtime.Interrupt()) and cancels
+// all pending timers, immediates and tasks. After it returns there are no goroutines associated with the loop,
+// RunOnLoop() returns false and the loop cannot be started again.
+//
+// Must not be called from the loop.
+func (loop *EventLoop) Terminate() {
+	loop.auxJobsLock.Lock()
+	loop.terminated = true
+	loop.auxJobs = nil
+	loop.auxJobsLock.Unlock()
+
+	loop.StopNoWait()
+	loop.stopLock.Lock()
+	if loop.running {
+		loop.vm.Interrupt(errEventLoopTerminated)
+	}
+	for loop.running {
+		loop.stopCond.Wait()
+	}
+	loop.stopLock.Unlock()
+	loop.vm.ClearInterrupt()
+
+	for t := range loop.timers {
+		t.cancelled = true
+		t.timer.Stop()
+	}
+	loop.timers = nil
+	for _, im := range loop.immediates {
+		im.cancelled = true
+	}
+	loop.immediates = nil
+	loop.jobCount = 0
+}
+
+// RunOnLoop schedules to run the specified function in the context of the loop as soon as possible.
+// The order of the runs is preserved (i.e. the functions will be called in the same order as calls to RunOnLoop()).
+// The instance of Runtime that is passed to the function and any Values derived from it must not be used
+// outside the function.
+//
+// It is safe to call RunOnLoop() from any goroutine. Returns false if the loop has been terminated.
+func (loop *EventLoop) RunOnLoop(fn func(*Runtime)) bool {
+	return loop.addAuxJob(func() {
+		fn(loop.vm)
+	})
+}
+
+// PromiseRejectedError is returned by EventLoop.AwaitPromise() if the Promise has been rejected.
+type PromiseRejectedError struct {
+	reason  interface{}
+	message string
+}
+
+// Reason returns the exported (see Value.Export()) rejection reason.
+func (e *PromiseRejectedError) Reason() interface{} {
+	return e.reason
+}
+
+func (e *PromiseRejectedError) Error() string {
+	return "promise rejected: " + e.message
+}
+
+// AwaitPromise waits until p has settled and returns its exported (see Value.Export()) result. If p is rejected
+// the error is a *PromiseRejectedError. The loop is kept running while waiting (as if a host operation was
+// pending, see RegisterCallback()), so the Promise may depend on timers or other tasks. The loop must be
+// running (see Start()) for the Promise to make progress.
+//
+// If ctx is done first, ctx.Err() is returned. p must belong to the loop's Runtime. It is safe to call
+// AwaitPromise() from any goroutine except the loop itself, where it would deadlock.
+func (loop *EventLoop) AwaitPromise(ctx gocontext.Context, p *Promise) (interface{}, error) {
+	type result struct {
+		value interface{}
+		err   error
+	}
+	ch := make(chan result, 1)
+	// the following are only accessed on the loop
+	var done func(func(*Runtime))
+	release := func() {
+		if done != nil {
+			done(func(*Runtime) {})
+			done = nil
+		}
+	}
+	if !loop.RunOnLoop(func(r *Runtime) {
+		done = loop.RegisterCallback()
+		p.Then(func(v Value) {
+			release()
+			ch <- result{value: v.Export()}
+		}, func(reason Value) {
+			release()
+			ch <- result{err: &PromiseRejectedError{
+				reason:  reason.Export(),
+				message: reason.String(),
+			}}
+		})
+	}) {
+		return nil, errEventLoopTerminated
+	}
+	select {
+	case res := <-ch:
+		return res.value, res.err
+	case <-ctx.Done():
+		loop.RunOnLoop(func(*Runtime) {
+			release()
+		})
+		return nil, ctx.Err()
+	}
+}
+
+// RegisterCallback signals that a host operation has been started, so that the loop keeps running until
+// it is complete even if there are no other pending tasks. It returns a function which must be called exactly
+// once when the operation is complete. That function is safe to call from any goroutine, it schedules the
+// supplied callback to run on the loop.
+//
+// RegisterCallback must be called from the loop. If the loop has been terminated, the returned function does nothing.
+func (loop *EventLoop) RegisterCallback() func(func(*Runtime)) {
+	if loop.isTerminated() {
+		return func(func(*Runtime)) {}
+	}
+	loop.jobCount++
+	return func(fn func(*Runtime)) {
+		loop.addAuxJob(func() {
+			loop.jobCount--
+			fn(loop.vm)
+		})
+	}
+}
+
+// SetTimeout schedules to run the specified function in the context of the loop as soon as possible
+// after the specified timeout period. It returns a Timer which can be passed to ClearTimeout().
+// It is safe to call SetTimeout() from any goroutine.
+func (loop *EventLoop) SetTimeout(fn func(*Runtime), timeout time.Duration) *Timer {
+	t := loop.newTimer(func() {
+		fn(loop.vm)
+	}, timeout, false)
+	loop.addAuxJob(func() {
+		loop.addTimer(t)
+	})
+	return t
+}
+
+// ClearTimeout cancels a Timer returned by SetTimeout() if it has not run yet.
+// It is safe to call ClearTimeout() from any goroutine.
+func (loop *EventLoop) ClearTimeout(t *Timer) {
+	loop.addAuxJob(func() {
+		loop.clearTimer(t)
+	})
+}
+
+// SetInterval schedules to repeatedly run the specified function in the context of the loop with the
+// specified interval. It returns a Timer which can be passed to ClearInterval().
+// It is safe to call SetInterval() from any goroutine.
+func (loop *EventLoop) SetInterval(fn func(*Runtime), interval time.Duration) *Timer {
+	t := loop.newTimer(func() {
+		fn(loop.vm)
+	}, interval, true)
+	loop.addAuxJob(func() {
+		loop.addTimer(t)
+	})
+	return t
+}
+
+// ClearInterval cancels a Timer returned by SetInterval().
+// It is safe to call ClearInterval() from any goroutine.
+func (loop *EventLoop) ClearInterval(t *Timer) {
+	loop.ClearTimeout(t)
+}
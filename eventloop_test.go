@@ -0,0 +1,220 @@
+package goja
+
+import (
+	gocontext "context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEventLoopTimers(t *testing.T) {
+	const SCRIPT = `
+	var log = [];
+	setTimeout((a, b) => log.push("timeout " + a + b), 5, 1, 2);
+	const cancelled = setTimeout(() => log.push("cancelled"), 1);
+	clearTimeout(cancelled);
+	setImmediate(() => log.push("immediate"));
+	clearImmediate(setImmediate(() => log.push("cancelled immediate")));
+	queueMicrotask(() => log.push("microtask"));
+	Promise.resolve().then(() => log.push("promise"));
+	let count = 0;
+	const i = setInterval(() => {
+		if (++count === 3) {
+			clearInterval(i);
+			log.push("interval");
+		}
+	}, 1);
+	log.push("sync");
+	`
+	loop := NewEventLoop()
+	var log string
+	err := loop.Run(func(r *Runtime) {
+		if _, err := r.RunString(SCRIPT); err != nil {
+			panic(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.Run(func(r *Runtime) {
+		log = r.Get("log").String()
+	})
+	if log != "sync,microtask,promise,immediate,interval,timeout 12" && log != "sync,microtask,promise,immediate,timeout 12,interval" {
+		t.Fatal(log)
+	}
+}
+
+func TestEventLoopUncaughtException(t *testing.T) {
+	loop := NewEventLoop()
+	err := loop.Run(func(r *Runtime) {
+		r.RunString(`
+		setTimeout(() => { throw new Error("boom") }, 0);
+		setTimeout(() => { throw new Error("not reached") }, 100);
+		`)
+	})
+	if ex, ok := err.(*Exception); !ok || ex.Value().String() != "Error: boom" {
+		t.Fatal(err)
+	}
+
+	err = loop.Run(func(r *Runtime) {
+		r.RunString(`queueMicrotask(() => { throw new TypeError("micro") })`)
+	})
+	if ex, ok := err.(*Exception); !ok || ex.Value().String() != "TypeError: micro" {
+		t.Fatal(err)
+	}
+}
+
+func TestEventLoopRunOnLoop(t *testing.T) {
+	loop := NewEventLoop()
+	loop.Start()
+	defer loop.Stop()
+
+	res := make(chan string, 1)
+	loop.RunOnLoop(func(r *Runtime) {
+		done := loop.RegisterCallback()
+		p, resolve, _ := r.NewPromise()
+		r.Set("p", p)
+		r.Set("report", func(s string) {
+			res <- s
+		})
+		r.RunString(`p.then(v => report("resolved " + v))`)
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			done(func(*Runtime) {
+				resolve(42)
+			})
+		}()
+	})
+	select {
+	case s := <-res:
+		if s != "resolved 42" {
+			t.Fatal(s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	var fired int32
+	tm := loop.SetTimeout(func(*Runtime) {
+		atomic.StoreInt32(&fired, 1)
+	}, time.Hour)
+	loop.ClearTimeout(tm)
+	if err := loop.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&fired) != 0 {
+		t.Fatal("cleared timer fired")
+	}
+	loop.Start()
+}
+
+func TestEventLoopRegisterCallbackKeepsRunning(t *testing.T) {
+	loop := NewEventLoop()
+	var result Value
+	err := loop.Run(func(r *Runtime) {
+		done := loop.RegisterCallback()
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			done(func(r *Runtime) {
+				result, _ = r.RunString(`"done"`)
+			})
+		}()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || result.String() != "done" {
+		t.Fatal(result)
+	}
+}
+
+func TestEventLoopTerminate(t *testing.T) {
+	loop := NewEventLoop()
+	errCh := make(chan error, 1)
+	started := make(chan struct{})
+	go func() {
+		errCh <- loop.Run(func(r *Runtime) {
+			r.Set("started", func() {
+				close(started)
+			})
+			r.RunString(`setTimeout(() => { started(); for (;;) {} }, 0); setInterval(() => {}, 1)`)
+		})
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	loop.Terminate()
+	err := <-errCh
+	var ie *InterruptedError
+	if !errors.As(err, &ie) || ie.Value() != errEventLoopTerminated {
+		t.Fatal(err)
+	}
+	if loop.RunOnLoop(func(*Runtime) {}) {
+		t.Fatal("RunOnLoop succeeded after Terminate")
+	}
+	if err := loop.Run(func(r *Runtime) {
+		t.Fatal("Run after Terminate")
+	}); err != errEventLoopTerminated {
+		t.Fatal(err)
+	}
+	if err := loop.Start(); err != errEventLoopTerminated {
+		t.Fatal(err)
+	}
+	// the schedulers do nothing once the loop has been terminated
+	if _, err := loop.vm.RunString(`clearTimeout(setTimeout(() => {}, 0)); clearImmediate(setImmediate(() => {}))`); err != nil {
+		t.Fatal(err)
+	}
+	loop.RegisterCallback()(func(*Runtime) {})
+	if loop.jobCount != 0 {
+		t.Fatal(loop.jobCount)
+	}
+}
+
+func TestEventLoopAwaitPromise(t *testing.T) {
+	loop := NewEventLoop()
+	loop.Start()
+	defer loop.Stop()
+
+	pch := make(chan *Promise, 2)
+	loop.RunOnLoop(func(r *Runtime) {
+		v, err := r.RunString(`
+		new Promise(resolve => setTimeout(() => resolve({answer: 42}), 5))
+		`)
+		if err != nil {
+			panic(err)
+		}
+		pch <- v.Export().(*Promise)
+		v, err = r.RunString(`
+		new Promise((_, reject) => setTimeout(() => reject(new Error("later")), 5))
+		`)
+		if err != nil {
+			panic(err)
+		}
+		pch <- v.Export().(*Promise)
+	})
+	res, err := loop.AwaitPromise(gocontext.Background(), <-pch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := res.(map[string]interface{}); !ok || m["answer"] != int64(42) {
+		t.Fatal(res)
+	}
+	_, err = loop.AwaitPromise(gocontext.Background(), <-pch)
+	var re *PromiseRejectedError
+	if !errors.As(err, &re) || re.Error() != "promise rejected: Error: later" {
+		t.Fatal(err)
+	}
+
+	loop.RunOnLoop(func(r *Runtime) {
+		p, _, _ := r.NewPromise()
+		pch <- p
+	})
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = loop.AwaitPromise(ctx, <-pch); err != gocontext.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
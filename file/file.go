@@ -108,6 +108,8 @@ type File struct {
 	src               string
 	base              int // This will always be 1 or greater
 	sourceMap         *sourcemap.Consumer
+	sourceMapData     []byte
+	sourceNames       map[string]string // resolved source URL -> source name in the source map
 	lineOffsets       []int
 	lastScannedOffset int
 }
@@ -134,6 +136,43 @@ func (fl *File) Base() int {
 
 func (fl *File) SetSourceMap(m *sourcemap.Consumer) {
 	fl.sourceMap = m
+	fl.sourceMapData = nil
+}
+
+// SetSourceMapData is like SetSourceMap, but it also retains the JSON the source map was parsed from,
+// see SourceMapData().
+func (fl *File) SetSourceMapData(m *sourcemap.Consumer, data []byte) {
+	fl.sourceMap = m
+	fl.sourceMapData = data
+}
+
+// SourceMapData returns the source map JSON set by SetSourceMapData() or nil.
+func (fl *File) SourceMapData() []byte {
+	return fl.sourceMapData
+}
+
+// SourceMap returns the source map set by SetSourceMap() or nil.
+func (fl *File) SourceMap() *sourcemap.Consumer {
+	return fl.sourceMap
+}
+
+// OriginalSource returns the content of an original source referenced by the source map. The filename
+// must be as returned in Position.Filename by a previous call to Position(). Returns false if the source
+// is unknown or if the source map does not include its content.
+func (fl *File) OriginalSource(filename string) (string, bool) {
+	if fl.sourceMap == nil {
+		return "", false
+	}
+	fl.mu.Lock()
+	source, exists := fl.sourceNames[filename]
+	fl.mu.Unlock()
+	if !exists {
+		return "", false
+	}
+	if content := fl.sourceMap.SourceContent(source); content != "" {
+		return content, true
+	}
+	return "", false
 }
 
 func (fl *File) Position(offset int) Position {
@@ -160,8 +199,17 @@ func (fl *File) Position(offset int) Position {
 
 	if fl.sourceMap != nil {
 		if source, _, row, col, ok := fl.sourceMap.Source(row, col); ok {
+			filename := ResolveSourcemapURL(fl.Name(), source).String()
+			fl.mu.Lock()
+			if _, exists := fl.sourceNames[filename]; !exists {
+				if fl.sourceNames == nil {
+					fl.sourceNames = make(map[string]string)
+				}
+				fl.sourceNames[filename] = source
+			}
+			fl.mu.Unlock()
 			return Position{
-				Filename: ResolveSourcemapURL(fl.Name(), source).String(),
+				Filename: filename,
 				Line:     row,
 				Column:   col,
 			}
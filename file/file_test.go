@@ -2,6 +2,8 @@ package file
 
 import (
 	"testing"
+
+	"github.com/go-sourcemap/sourcemap"
 )
 
 func TestPosition(t *testing.T) {
@@ -71,3 +73,29 @@ func TestGetSourceFilename(t *testing.T) {
 		}
 	}
 }
+
+func TestOriginalSource(t *testing.T) {
+	const MAP = `{"version":3,"sources":["orig.ts"],"sourcesContent":["let a: number = 1;"],"mappings":"AAAA,IAAI;AACA"}`
+	m, err := sourcemap.Parse("", []byte(MAP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFile("/dir/gen.js", "let a = 1;\n", 0)
+	if _, ok := f.OriginalSource("/dir/orig.ts"); ok {
+		t.Fatal("no source map")
+	}
+	f.SetSourceMap(m)
+	if f.SourceMap() != m {
+		t.Fatal("SourceMap")
+	}
+	if _, ok := f.OriginalSource("/dir/orig.ts"); ok {
+		t.Fatal("not resolved yet")
+	}
+	p := f.Position(4)
+	if p.Filename != "/dir/orig.ts" {
+		t.Fatalf("Filename: %q", p.Filename)
+	}
+	if src, ok := f.OriginalSource(p.Filename); !ok || src != "let a: number = 1;" {
+		t.Fatalf("OriginalSource: %q, %v", src, ok)
+	}
+}
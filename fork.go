@@ -0,0 +1,585 @@
+package goja
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/dop251/goja/unistring"
+)
+
+var (
+	errForkTemplateModified = errors.New("goja: the forked Runtime has been modified")
+	errForkTemplateRun      = errors.New("goja: cannot run code in a Runtime that has been forked")
+)
+
+// forkTemplate is the state of a Runtime that has been forked. It is computed by the first Fork() and shared by all
+// the forks.
+type forkTemplate struct {
+	r     *Runtime
+	table []snapshotIntrinsic
+
+	intrinsics []*Object // the built-in objects of r in the order of the table, nil for the replaced ones
+	ids        map[*Object]int
+	hosts      map[*Object]string
+
+	typedArrayCtors []*Object
+}
+
+// forker populates a fork. The ordinary objects and the built-in objects that have not yet been initialised in the
+// fork are copied when they are first used, using the same lazyObject mechanism that initialises the built-ins of a
+// new Runtime. The other objects (arrays, functions, Maps and so on) are copied as soon as they are referenced by an
+// object being copied. The forker is kept by the lazy objects until all of them have been copied.
+type forker struct {
+	t *forkTemplate
+	r *Runtime
+
+	objs    map[*Object]*Object
+	stashes map[*stash]*stash
+	weak    map[weakMap]weakMap
+
+	// the built-in objects of the fork, their implementations before they were replaced and the state of the
+	// pristine ones, used to locate the built-ins within them
+	intrinsics []*Object
+	orig       []objectImpl
+	impls      []objectImpl
+	pristine   []*baseObject
+
+	queue      []forkItem
+	fixups     map[*Object]func()
+	fixupOrder []*Object
+}
+
+type forkItem struct {
+	impl, src objectImpl
+}
+
+// Fork creates a new Runtime with the same heap as r, the same way RestoreSnapshot() would, but without encoding it.
+// The compiled code and the immutable values are shared. The ordinary objects and the built-in objects are copied
+// when they are first used in the fork. The other objects created by the code (arrays, functions, Maps etc.) are
+// copied when an object that references them is copied (the ones referenced by the global variables are copied when
+// the fork is created). This allows to prepare a template Runtime once (for example by running a library bundle) and
+// to hand a pristine fork of it to every request.
+//
+// The first call makes r a template: its heap is checked to contain only the objects that Snapshot() supports, with
+// the host objects registered in reg (which is not used by the subsequent calls). After that r must not be used to
+// run code or modified in any other way, because the forks keep reading its state: RunProgram() and the functions
+// returned by AssertFunction() return an error. Fork itself may be called concurrently from multiple goroutines and
+// the forks are fully independent of each other.
+//
+// If setup is not nil, it is called with the new Runtime before the state is copied into it. It must create the
+// host objects of the fork and register them under the same names as in reg. Like with snapshots, only the
+// references to the host objects are copied, and the settings that are not a part of the heap (such as
+// SetRandSource() or SetMemoryLimit()) can be configured by setup. setup must not modify the built-in objects.
+func (r *Runtime) Fork(reg *SnapshotRegistry, setup func(fork *Runtime, reg *SnapshotRegistry)) (*Runtime, error) {
+	t, err := r.getForkTemplate(reg)
+	if err != nil {
+		return nil, err
+	}
+	fork := New()
+	forkReg := NewSnapshotRegistry()
+	if setup != nil {
+		setup(fork, forkReg)
+	}
+	n := len(t.table)
+	f := &forker{
+		t:          t,
+		r:          fork,
+		objs:       make(map[*Object]*Object),
+		stashes:    make(map[*stash]*stash),
+		weak:       make(map[weakMap]weakMap),
+		intrinsics: make([]*Object, n),
+		orig:       make([]objectImpl, n),
+		impls:      make([]objectImpl, n),
+		pristine:   make([]*baseObject, n),
+		fixups:     make(map[*Object]func()),
+	}
+	for o, name := range t.hosts {
+		h := forkReg.byName[name]
+		if h == nil {
+			return nil, fmt.Errorf("goja: host object %q is not registered", name)
+		}
+		if h.runtime != fork {
+			return nil, fmt.Errorf("goja: host object %q belongs to another Runtime", name)
+		}
+		f.objs[o] = h
+	}
+	f.populate()
+	return fork, nil
+}
+
+func (r *Runtime) getForkTemplate(reg *SnapshotRegistry) (*forkTemplate, error) {
+	r.forkLock.Lock()
+	defer r.forkLock.Unlock()
+	if r.forkTmpl != nil {
+		return r.forkTmpl, nil
+	}
+	if len(r.vm.callStack) > 0 {
+		return nil, errors.New("goja: cannot fork a running Runtime")
+	}
+	if len(r.jobQueue) > 0 {
+		return nil, errors.New("goja: cannot fork a Runtime with pending jobs")
+	}
+
+	// Encoding the heap verifies that everything can be copied, locates the built-in objects and forces the lazy
+	// ones, so that the template is not modified by the forks.
+	e := r.newSnapshotEncoder(reg, "fork Runtime")
+	if err := e.encode(); err != nil {
+		return nil, err
+	}
+	t := &forkTemplate{
+		r:               r,
+		table:           getSnapshotIntrinsics(),
+		intrinsics:      e.objs[:e.numIntrinsics:e.numIntrinsics],
+		ids:             make(map[*Object]int, e.numIntrinsics),
+		hosts:           make(map[*Object]string),
+		typedArrayCtors: r.typedArrayCtors(),
+	}
+	for i, o := range t.intrinsics {
+		if o != nil {
+			t.ids[o] = i
+		}
+	}
+	for id := e.numIntrinsics; id < len(e.objs); id++ {
+		if !e.fill[id] {
+			o := e.objs[id]
+			t.hosts[o] = reg.names[o]
+		}
+	}
+	r.forkTmpl = t
+	atomic.StoreUint32(&r.forked, 1)
+	return t, nil
+}
+
+func (f *forker) populate() {
+	t, r := f.t, f.r
+	for i := range t.table {
+		// the roots that are not set yet are located when they are first referenced
+		if e := &t.table[i]; e.parent == -1 && t.intrinsics[i] != nil && f.root(e) != nil {
+			f.intrinsic(i)
+		}
+	}
+	src := t.r.global
+	f.copyStash(&r.global.stash, &src.stash)
+	r.global.varNames = make(map[unistring.String]struct{}, len(src.varNames))
+	for name := range src.varNames {
+		r.global.varNames[name] = struct{}{}
+	}
+	if len(t.r.symbolRegistry) > 0 && r.symbolRegistry == nil {
+		r.symbolRegistry = make(map[unistring.String]*Symbol, len(t.r.symbolRegistry))
+	}
+	for name, sym := range t.r.symbolRegistry {
+		r.symbolRegistry[name] = sym
+	}
+	f.flush()
+}
+
+// flush copies the state of the objects allocated so far and then completes the ones that depend on the state of
+// other objects (such as a bound function which needs the target to be callable). It may be re-entered when
+// completing an object initialises a built-in.
+func (f *forker) flush() {
+	for {
+		if n := len(f.queue); n > 0 {
+			item := f.queue[n-1]
+			f.queue = f.queue[:n-1]
+			f.fill(item.impl, item.src)
+			continue
+		}
+		if len(f.fixupOrder) > 0 {
+			o := f.fixupOrder[0]
+			f.fixupOrder = f.fixupOrder[1:]
+			f.runFixup(o)
+			continue
+		}
+		break
+	}
+}
+
+func (f *forker) addFixup(o *Object, fn func()) {
+	f.fixups[o] = fn
+	f.fixupOrder = append(f.fixupOrder, o)
+}
+
+func (f *forker) runFixup(o *Object) {
+	if fn := f.fixups[o]; fn != nil {
+		delete(f.fixups, o)
+		fn()
+	}
+}
+
+// intrinsic returns the fork's counterpart of the template's built-in object with the specified index. It is located
+// the same way as in the template, by its path in the pristine objects.
+func (f *forker) intrinsic(i int) *Object {
+	if o := f.intrinsics[i]; o != nil {
+		return o
+	}
+	e := &f.t.table[i]
+	var o *Object
+	if e.parent == -1 {
+		o = f.root(e)
+		// some of the fields of the global struct are only set when other built-ins are initialised
+		for j := 0; o == nil && j < i && f.t.table[j].parent == -1; j++ {
+			if f.intrinsics[j] != nil {
+				f.pristineImpl(j)
+				o = f.root(e)
+			}
+		}
+	} else {
+		f.intrinsic(e.parent)
+		f.pristineImpl(e.parent)
+		o = e.lookup(f.pristine[e.parent])
+	}
+	if o == nil || o.runtime != f.r {
+		panic(&uncatchableException{err: errSnapshotMismatch})
+	}
+	src := f.t.intrinsics[i]
+	f.intrinsics[i] = o
+	f.objs[src] = o
+	f.orig[i] = o.self
+	o.weakRefs = f.weakRefs(src.weakRefs)
+	if _, lazy := o.self.(*lazyObject); lazy {
+		o.self = &lazyObject{
+			val: o,
+			create: func(*Object) objectImpl {
+				impl := f.copyIntrinsic(i)
+				f.flush()
+				return impl
+			},
+		}
+	} else {
+		f.copyIntrinsic(i)
+	}
+	return o
+}
+
+func (f *forker) root(e *snapshotIntrinsic) *Object {
+	if e.field == -1 {
+		return f.r.globalObject
+	}
+	return exposeField(reflect.ValueOf(f.r.global).Elem().Field(e.field)).Interface().(*Object)
+}
+
+// pristineImpl returns the implementation the built-in object had in the new Runtime, initialising it if needed.
+// Its state is saved before it's overwritten, so that it could be used to locate the other built-ins.
+func (f *forker) pristineImpl(i int) objectImpl {
+	if impl := f.impls[i]; impl != nil {
+		return impl
+	}
+	impl := f.orig[i]
+	if l, ok := impl.(*lazyObject); ok {
+		impl = l.create(f.intrinsics[i])
+	}
+	b := *snapshotBaseObject(impl)
+	f.impls[i] = impl
+	f.pristine[i] = &b
+	return impl
+}
+
+// copyIntrinsic copies the state of the template's built-in object into its pristine counterpart, which keeps its
+// own native functions.
+func (f *forker) copyIntrinsic(i int) objectImpl {
+	impl := f.pristineImpl(i)
+	src := f.t.intrinsics[i].self
+	if g, ok := impl.(*guardedObject); ok {
+		if _, guarded := src.(*guardedObject); !guarded {
+			// the guarded properties have been modified in the template
+			impl = &g.baseObject
+		}
+	}
+	kind, _ := snapshotKind(impl)
+	if srcKind, _ := snapshotKind(src); kind != srcKind {
+		panic(&uncatchableException{err: errSnapshotMismatch})
+	}
+	f.intrinsics[i].self = impl
+	f.queue = append(f.queue, forkItem{impl: impl, src: src})
+	return impl
+}
+
+func (f *forker) obj(src *Object) *Object {
+	if src == nil {
+		return nil
+	}
+	if o, exists := f.objs[src]; exists {
+		return o
+	}
+	if i, ok := f.t.ids[src]; ok {
+		return f.intrinsic(i)
+	}
+	kind, ok := snapshotKind(src.self)
+	if !ok {
+		panic(&uncatchableException{err: errForkTemplateModified})
+	}
+	o := &Object{runtime: f.r}
+	f.objs[src] = o
+	o.weakRefs = f.weakRefs(src.weakRefs)
+	if kind == snapKindObject {
+		// The ordinary objects are copied when they are first used. This is only safe for them because the other
+		// kinds are type-asserted in many places which don't expect a lazyObject.
+		o.self = &lazyObject{
+			val: o,
+			create: func(o *Object) objectImpl {
+				impl := newSnapshotObjectImpl(f.r, o, kind)
+				// set before filling, so that the object is not created twice if it's used by a fixup
+				o.self = impl
+				f.queue = append(f.queue, forkItem{impl: impl, src: src.self})
+				f.flush()
+				return impl
+			},
+		}
+		return o
+	}
+	o.self = newSnapshotObjectImpl(f.r, o, kind)
+	f.queue = append(f.queue, forkItem{impl: o.self, src: src.self})
+	return o
+}
+
+func (f *forker) value(v Value) Value {
+	switch v := v.(type) {
+	case *Object:
+		return f.obj(v)
+	case *valueProperty:
+		p := *v
+		p.value = f.value(v.value)
+		p.getterFunc = f.obj(v.getterFunc)
+		p.setterFunc = f.obj(v.setterFunc)
+		return &p
+	}
+	return v
+}
+
+func (f *forker) values(values []Value) []Value {
+	if values == nil {
+		return nil
+	}
+	res := make([]Value, len(values))
+	for i, v := range values {
+		res[i] = f.value(v)
+	}
+	return res
+}
+
+func (f *forker) weakId(wm weakMap) weakMap {
+	id, exists := f.weak[wm]
+	if !exists {
+		id = weakMap(f.r.genId())
+		f.weak[wm] = id
+	}
+	return id
+}
+
+func (f *forker) weakRefs(refs map[weakMap]Value) map[weakMap]Value {
+	if refs == nil {
+		return nil
+	}
+	res := make(map[weakMap]Value, len(refs))
+	for wm, v := range refs {
+		res[f.weakId(wm)] = f.value(v)
+	}
+	return res
+}
+
+func (f *forker) stash(s *stash) *stash {
+	if s == nil {
+		return nil
+	}
+	if s == &f.t.r.global.stash {
+		return &f.r.global.stash
+	}
+	if c, exists := f.stashes[s]; exists {
+		return c
+	}
+	c := &stash{}
+	f.stashes[s] = c
+	f.copyStash(c, s)
+	return c
+}
+
+func (f *forker) copyStash(s, src *stash) {
+	s.values = f.values(src.values)
+	s.extraArgs = f.values(src.extraArgs)
+	s.names = nil
+	if src.names != nil {
+		s.names = make(map[unistring.String]uint32, len(src.names))
+		for name, idx := range src.names {
+			s.names[name] = idx
+		}
+	}
+	s.obj = f.obj(src.obj)
+	s.outer = f.stash(src.outer)
+	s.funcType = src.funcType
+}
+
+func (f *forker) orderedMap(src *orderedMap) *orderedMap {
+	r := f.r
+	m := newOrderedMap(r.getHash())
+	m.runtime = r
+	iter := src.newIter()
+	for entry := iter.next(); entry != nil; entry = iter.next() {
+		m.set(f.value(entry.key), f.value(entry.value))
+	}
+	return m
+}
+
+func (f *forker) copyJsFunc(o, src *baseJsFuncObject) {
+	o.stash = f.stash(src.stash)
+	o.privEnv = src.privEnv
+	o.prg = src.prg
+	o.src = src.src
+	o.strict = src.strict
+}
+
+// fill copies the state of the template's object into the fork's object of the same kind. It is the counterpart of
+// snapshotDecoder.readState().
+func (f *forker) fill(impl, src objectImpl) {
+	r := f.r
+	switch o := impl.(type) {
+	case *arrayObject:
+		s := src.(*arrayObject)
+		o.length, o.objCount, o.propValueCount = s.length, s.objCount, s.propValueCount
+		o.values = f.values(s.values)
+	case *sparseArrayObject:
+		s := src.(*sparseArrayObject)
+		o.length, o.propValueCount = s.length, s.propValueCount
+		o.items = make([]sparseArrayItem, len(s.items))
+		for i, item := range s.items {
+			o.items[i] = sparseArrayItem{idx: item.idx, value: f.value(item.value)}
+		}
+	case *funcObject:
+		f.copyJsFunc(&o.baseJsFuncObject, &src.(*funcObject).baseJsFuncObject)
+	case *methodFuncObject:
+		s := src.(*methodFuncObject)
+		f.copyJsFunc(&o.baseJsFuncObject, &s.baseJsFuncObject)
+		o.homeObject = f.obj(s.homeObject)
+	case *arrowFuncObject:
+		s := src.(*arrowFuncObject)
+		f.copyJsFunc(&o.baseJsFuncObject, &s.baseJsFuncObject)
+		o.funcObj = f.obj(s.funcObj)
+		o.newTarget = f.value(s.newTarget)
+	case *classFuncObject:
+		s := src.(*classFuncObject)
+		f.copyJsFunc(&o.baseJsFuncObject, &s.baseJsFuncObject)
+		o.initFields = s.initFields
+		o.computedKeys = f.values(s.computedKeys)
+		o.privateEnvType = s.privateEnvType
+		o.privateMethods = f.values(s.privateMethods)
+		o.derived = s.derived
+	case *boundFuncObject:
+		s := src.(*boundFuncObject)
+		o.wrapped = f.obj(s.wrapped)
+		o.boundArgs = f.values(s.boundArgs)
+		f.addFixup(o.val, func() {
+			f.runFixup(o.wrapped)
+			o.f = r.boundCallable(r.toCallable(o.wrapped), o.boundArgs)
+			o.construct = r.boundConstruct(o.val, o.wrapped.self.assertConstructor(), o.boundArgs)
+		})
+	case *primitiveValueObject:
+		o.pValue = f.value(src.(*primitiveValueObject).pValue)
+	case *stringObject:
+		s := src.(*stringObject)
+		o.value, o.length = s.value, s.length
+	case *dateObject:
+		o.msec = src.(*dateObject).msec
+	case *regexpObject:
+		s := src.(*regexpObject)
+		o.source, o.standard = s.source, s.standard
+		// the compiled pattern caches the last match and cannot be shared
+		o.pattern = s.pattern.clone()
+	case *errorObject:
+		s := src.(*errorObject)
+		o.stack = append([]StackFrame(nil), s.stack...)
+		o.stackPropAdded = s.stackPropAdded
+	case *mapObject:
+		o.m = f.orderedMap(src.(*mapObject).m)
+	case *setObject:
+		o.m = f.orderedMap(src.(*setObject).m)
+	case *weakMapObject:
+		o.m = f.weakId(src.(*weakMapObject).m)
+	case *weakSetObject:
+		o.s = f.weakId(src.(*weakSetObject).s)
+	case *proxyObject:
+		s := src.(*proxyObject)
+		if s.handler != nil {
+			o.handler = &jsProxyHandler{handler: f.obj(s.handler.(*jsProxyHandler).handler)}
+			o.target = f.obj(s.target)
+			f.addFixup(o.val, func() {
+				f.runFixup(o.target)
+				if call, ok := o.target.self.assertCallable(); ok {
+					o.call = call
+				}
+				if ctor := o.target.self.assertConstructor(); ctor != nil {
+					o.ctor = ctor
+				}
+			})
+		}
+	case *Promise:
+		s := src.(*Promise)
+		o.state, o.handled = s.state, s.handled
+		o.result = f.value(s.result)
+	case *arrayBufferObject:
+		s := src.(*arrayBufferObject)
+		o.detached = s.detached
+		if s.data != nil {
+			o.data = append([]byte(nil), s.data...)
+		}
+	case *typedArrayObject:
+		s := src.(*typedArrayObject)
+		kind := -1
+		for i, ctor := range f.t.typedArrayCtors {
+			if s.defaultCtor == ctor {
+				kind = i
+				break
+			}
+		}
+		if kind == -1 {
+			panic(&uncatchableException{err: errForkTemplateModified})
+		}
+		buf := f.obj(s.viewedArrayBuf.val).self.(*arrayBufferObject)
+		// only the type-specific fields are taken from the temporary object
+		a := r.typedArrayObjectCtors()[kind](buf, s.offset, s.length, nil)
+		o.viewedArrayBuf, o.defaultCtor, o.typedArray = a.viewedArrayBuf, a.defaultCtor, a.typedArray
+		o.offset, o.length, o.elemSize = a.offset, a.length, a.elemSize
+	case *dataViewObject:
+		s := src.(*dataViewObject)
+		o.viewedArrayBuf = f.obj(s.viewedArrayBuf.val).self.(*arrayBufferObject)
+		o.byteOffset, o.byteLen = s.byteOffset, s.byteLen
+	}
+	f.fillBase(snapshotBaseObject(impl), snapshotBaseObject(src), snapshotOwnProperty(impl), snapshotOwnProperty(src))
+}
+
+func (f *forker) fillBase(b, src *baseObject, own, srcOwn *valueProperty) {
+	b.class = src.class
+	b.prototype = f.obj(src.prototype)
+	b.extensible = src.extensible
+	values := make(map[unistring.String]Value, len(src.values))
+	for name, v := range src.values {
+		if srcOwn != nil && v == Value(srcOwn) {
+			*own = *f.value(srcOwn).(*valueProperty)
+			v = own
+		} else {
+			v = f.value(v)
+		}
+		values[name] = v
+	}
+	b.values = values
+	b.propNames = append([]unistring.String(nil), src.propNames...)
+	b.lastSortedPropLen, b.idxPropCount = src.lastSortedPropLen, src.idxPropCount
+	b.symValues = nil
+	if src.symValues != nil {
+		b.symValues = newOrderedMap(nil)
+		iter := src.symValues.newIter()
+		for entry := iter.next(); entry != nil; entry = iter.next() {
+			b.symValues.set(entry.key, f.value(entry.value))
+		}
+	}
+	b.privateElements = nil
+	if src.privateElements != nil {
+		b.privateElements = make(map[*privateEnvType]*privateElements, len(src.privateElements))
+		for typ, elements := range src.privateElements {
+			b.privateElements[typ] = &privateElements{
+				methods: f.values(elements.methods),
+				fields:  f.values(elements.fields),
+			}
+		}
+	}
+}
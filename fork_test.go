@@ -0,0 +1,235 @@
+package goja
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func checkFork(t *testing.T, fork *Runtime) {
+	t.Helper()
+	res, err := fork.RunString(snapshotTestCheck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := res.(*Object).Get("0"); v == nil || v.ToInteger() != 42 {
+		t.Fatalf("Unexpected promise result: %v", v)
+	}
+}
+
+func TestFork(t *testing.T) {
+	tmpl := New()
+	if _, err := tmpl.RunScript("setup.js", snapshotTestSetup); err != nil {
+		t.Fatal(err)
+	}
+	fork1, err := tmpl.Fork(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork2, err := tmpl.Fork(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each fork starts with the state of the template and is independent of the other one
+	checkFork(t, fork1)
+	checkFork(t, fork2)
+	if _, err := fork1.RunString(`Array.prototype.last = null; obj.arr.push(4); u16[0] = 1;`); err != nil {
+		t.Fatal(err)
+	}
+	res, err := fork2.RunString(`[1, 2].last() + ":" + obj.arr.length + ":" + u16[0] + ":" + counter.value`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "2:3:43981:2" {
+		t.Fatal(res)
+	}
+
+	// a fork can be forked too
+	fork3, err := fork2.Fork(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = fork3.RunString(`counter.inc()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ToInteger() != 3 {
+		t.Fatal(res)
+	}
+}
+
+func TestForkConcurrent(t *testing.T) {
+	tmpl := New()
+	if _, err := tmpl.RunScript("setup.js", snapshotTestSetup); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fork, err := tmpl.Fork(nil, nil)
+			if err == nil {
+				_, err = fork.RunString(snapshotTestCheck)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestForkRegistry(t *testing.T) {
+	tmpl := New()
+	reg := NewSnapshotRegistry()
+	log := tmpl.ToValue(func(s string) string {
+		return "tmpl:" + s
+	}).(*Object)
+	reg.Register("log", log)
+	tmpl.Set("log", log)
+	if _, err := tmpl.RunString(`
+	const logger = { write: log };
+	function hello(name) { return logger.write("hello " + name); }
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	setup := func(fork *Runtime, reg *SnapshotRegistry) {
+		reg.Register("log", fork.ToValue(func(s string) string {
+			return "fork:" + s
+		}).(*Object))
+	}
+	if _, err := tmpl.Fork(nil, setup); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := tmpl.Fork(reg, nil); err == nil || !strings.Contains(err.Error(), `"log"`) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fork, err := tmpl.Fork(reg, setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := fork.RunString(`hello("world") + ":" + (log === logger.write)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "fork:hello world:true" {
+		t.Fatal(res)
+	}
+}
+
+func TestForkErrors(t *testing.T) {
+	r := New()
+	if _, err := r.RunString(`var it = [1, 2][Symbol.iterator]();`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Fork(nil, nil); err == nil || !strings.Contains(err.Error(), "cannot fork") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	r = New()
+	r.Set("fork", func() error {
+		_, err := r.Fork(nil, nil)
+		return err
+	})
+	if _, err := r.RunString(`fork()`); err == nil || !strings.Contains(err.Error(), "running") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestForkTemplateCannotRun(t *testing.T) {
+	tmpl := New()
+	if _, err := tmpl.RunString(`function f() { return 1 }`); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := AssertFunction(tmpl.Get("f"))
+	keys, _ := AssertFunction(tmpl.Get("Object").ToObject(tmpl).Get("keys"))
+	if _, err := tmpl.Fork(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.RunString(`1`); err != errForkTemplateRun {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := f(nil); err != errForkTemplateRun {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := keys(nil, tmpl.NewObject()); err != errForkTemplateRun {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestForkLazy(t *testing.T) {
+	tmpl := New()
+	_, err := tmpl.RunString(`
+	class Point {
+		#x;
+		constructor(x) { this.#x = x; }
+		get x() { return this.#x; }
+	}
+	var data = {a: {b: {c: 1}}, p: new Point(2)};
+	var proxied = new Proxy({v: 3}, {});
+	var self = {};
+	self.self = self;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork, err := tmpl.Fork(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fork.Get("data").(*Object)
+	if _, lazy := data.self.(*lazyObject); !lazy {
+		t.Fatalf("Unexpected impl: %T", data.self)
+	}
+	res, err := fork.RunString(`data.a.b.c + ":" + data.p.x + ":" + (data.p instanceof Point) + ":" + proxied.v + ":" +
+		(self.self === self)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "1:2:true:3:true" {
+		t.Fatal(res)
+	}
+	if _, lazy := data.self.(*lazyObject); lazy {
+		t.Fatal("The object has not been copied")
+	}
+	if _, err := fork.RunString(`data.a.b.c = 2`); err != nil {
+		t.Fatal(err)
+	}
+	fork1, err := tmpl.Fork(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := fork1.RunString(`data.a.b.c`); err != nil || res.ToInteger() != 1 {
+		t.Fatal(res, err)
+	}
+
+	// the objects that have not been copied yet can be serialized
+	fork2, err := tmpl.Fork(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fork2.Serialize(fork2.Get("data").(*Object).Get("a")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkFork(b *testing.B) {
+	tmpl := New()
+	if _, err := tmpl.RunScript("setup.js", snapshotTestSetup); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tmpl.Fork(nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
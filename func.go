@@ -10,6 +10,9 @@ type baseFuncObject struct {
 	baseObject
 
 	lenProp valueProperty
+
+	// the realm the function was created in, the function runs in it regardless of the realm of the caller
+	realm *Realm
 }
 
 type baseJsFuncObject struct {
@@ -59,9 +62,15 @@ type nativeFuncObject struct {
 type boundFuncObject struct {
 	nativeFuncObject
 	wrapped *Object
+
+	// the bound 'this' followed by the bound arguments, used to re-create the function from a snapshot
+	boundArgs []Value
 }
 
 func (f *nativeFuncObject) export(*objectExportCtx) interface{} {
+	if f.f != nil && f.inOtherRealm() {
+		return f.callInRealm
+	}
 	return f.f
 }
 
@@ -205,6 +214,7 @@ func (f *classFuncObject) _initFields(instance *Object) {
 	if f.initFields != nil {
 		vm := f.val.runtime.vm
 		vm.pushCtx()
+		vm.enterRealm(f.realm)
 		vm.prg = f.initFields
 		vm.stash = f.stash
 		vm.privEnv = f.privEnv
@@ -293,6 +303,13 @@ func (f *baseJsFuncObject) _call(args []Value, newTarget, this Value) Value {
 	}
 
 	pc := vm.pc
+	if vm.tracer != nil {
+		depth := len(vm.callStack) + 1
+		if pc != -1 {
+			depth++
+		}
+		vm.traceEnter(f.val, f.prg, f.prg.funcName, this, vm.stack[vm.sp-len(args):vm.sp], depth)
+	}
 	if pc != -1 {
 		vm.pc++ // fake "return address" so that captureStack() records the correct call location
 		vm.pushCtx()
@@ -300,6 +317,7 @@ func (f *baseJsFuncObject) _call(args []Value, newTarget, this Value) Value {
 	} else {
 		vm.pushCtx()
 	}
+	vm.enterRealm(f.realm)
 	vm.args = len(args)
 	vm.prg = f.prg
 	vm.stash = f.stash
@@ -345,6 +363,7 @@ func (f *arrowFuncObject) assertCallable() (func(FunctionCall) Value, bool) {
 
 func (f *baseFuncObject) init(name unistring.String, length Value) {
 	f.baseObject.init()
+	f.realm = f.val.runtime.realm
 
 	f.lenProp.configurable = true
 	f.lenProp.value = length
@@ -353,6 +372,10 @@ func (f *baseFuncObject) init(name unistring.String, length Value) {
 	f._putProp("name", stringValueFromRaw(name), false, false, true)
 }
 
+func (f *baseFuncObject) funcRealm() *Realm {
+	return f.realm
+}
+
 func (f *baseFuncObject) hasInstance(v Value) bool {
 	if v, ok := v.(*Object); ok {
 		o := f.val.self.getStr("prototype", nil)
@@ -388,14 +411,40 @@ func (f *nativeFuncObject) defaultConstruct(ccall func(ConstructorCall) *Object,
 	return obj
 }
 
+// inOtherRealm returns true if the function was created in a realm other than the current one. Such functions
+// are called using callInRealm() and constructInRealm().
+func (f *nativeFuncObject) inOtherRealm() bool {
+	return f.realm != nil && f.realm != f.val.runtime.realm
+}
+
+func (f *nativeFuncObject) callInRealm(call FunctionCall) (ret Value) {
+	f.val.runtime.runInRealm(f.realm, func() {
+		ret = f.f(call)
+	})
+	return
+}
+
+func (f *nativeFuncObject) constructInRealm(args []Value, newTarget *Object) (ret *Object) {
+	f.val.runtime.runInRealm(f.realm, func() {
+		ret = f.construct(args, newTarget)
+	})
+	return
+}
+
 func (f *nativeFuncObject) assertCallable() (func(FunctionCall) Value, bool) {
 	if f.f != nil {
+		if f.inOtherRealm() {
+			return f.callInRealm, true
+		}
 		return f.f, true
 	}
 	return nil, false
 }
 
 func (f *nativeFuncObject) assertConstructor() func(args []Value, newTarget *Object) *Object {
+	if f.construct != nil && f.inOtherRealm() {
+		return f.constructInRealm
+	}
 	return f.construct
 }
 
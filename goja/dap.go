@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+// A minimal Debug Adapter Protocol (https://microsoft.github.io/debug-adapter-protocol/) implementation
+// on top of goja.Debugger. It supports a single 'launch' session with breakpoints, stepping, pausing,
+// call stack and variable inspection and expression evaluation.
+
+const dapThreadId = 1
+
+type dapMessage struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type dapSession struct {
+	r   *bufio.Reader
+	w   io.Writer
+	wmu sync.Mutex
+	seq int
+
+	vm          *goja.Runtime
+	dbg         *goja.Debugger
+	breakpoints map[string][]*goja.Breakpoint
+
+	program     string
+	stopOnEntry bool
+	launched    bool
+	configured  bool
+	started     bool
+
+	mu     sync.Mutex
+	paused bool
+	entry  bool
+
+	// requests that can only be served on the vm goroutine while it's paused
+	pausedReqs chan *dapMessage
+
+	// variable references, only valid during a pause and only accessed on the vm goroutine
+	refs []interface{}
+}
+
+// serveDAP serves a single debugging session on addr (or on stdin/stdout if addr is "stdio").
+func serveDAP(addr string) error {
+	if addr == "stdio" {
+		return newDAPSession(os.Stdin, os.Stdout).serve()
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	log.Printf("Debug adapter listening on %s", l.Addr())
+	conn, err := l.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return newDAPSession(conn, conn).serve()
+}
+
+func newDAPSession(r io.Reader, w io.Writer) *dapSession {
+	s := &dapSession{
+		r:           bufio.NewReader(r),
+		w:           w,
+		breakpoints: make(map[string][]*goja.Breakpoint),
+		pausedReqs:  make(chan *dapMessage, 16),
+	}
+	s.vm = newRuntime(s)
+	s.dbg = s.vm.AttachDebugger(s.onPause)
+	return s
+}
+
+// Log, Warn and Error implement console.Printer, so that the script output is sent to the client.
+func (s *dapSession) Log(msg string) {
+	s.output("stdout", msg+"\n")
+}
+
+func (s *dapSession) Warn(msg string) {
+	s.output("console", msg+"\n")
+}
+
+func (s *dapSession) Error(msg string) {
+	s.output("stderr", msg+"\n")
+}
+
+func (s *dapSession) output(category, msg string) {
+	s.sendEvent("output", map[string]interface{}{
+		"category": category,
+		"output":   msg,
+	})
+}
+
+func (s *dapSession) readMessage() (*dapMessage, error) {
+	header, err := textproto.NewReader(s.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %v", err)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		return nil, err
+	}
+	msg := &dapMessage{}
+	if err := json.Unmarshal(buf, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *dapSession) send(msg interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.seq++
+	switch m := msg.(type) {
+	case *dapResponse:
+		m.Seq = s.seq
+	case *dapEvent:
+		m.Seq = s.seq
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Could not encode a DAP message: %v", err)
+		return
+	}
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n", len(b))
+	s.w.Write(b)
+}
+
+func (s *dapSession) respond(req *dapMessage, body interface{}) {
+	s.send(&dapResponse{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    true,
+		Command:    req.Command,
+		Body:       body,
+	})
+}
+
+func (s *dapSession) respondError(req *dapMessage, err error) {
+	s.send(&dapResponse{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Command:    req.Command,
+		Message:    err.Error(),
+	})
+}
+
+func (s *dapSession) sendEvent(event string, body interface{}) {
+	s.send(&dapEvent{
+		Type:  "event",
+		Event: event,
+		Body:  body,
+	})
+}
+
+var errNotPaused = errors.New("not paused")
+
+func (s *dapSession) serve() error {
+	for {
+		req, err := s.readMessage()
+		if err != nil {
+			if err == io.EOF {
+				s.vm.Interrupt("debug session ended")
+				return nil
+			}
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		switch req.Command {
+		case "initialize":
+			s.respond(req, map[string]interface{}{
+				"supportsConfigurationDoneRequest": true,
+				"supportsEvaluateForHovers":        true,
+				"supportsTerminateRequest":         true,
+			})
+			s.sendEvent("initialized", nil)
+		case "launch":
+			var args struct {
+				Program     string `json:"program"`
+				StopOnEntry bool   `json:"stopOnEntry"`
+			}
+			if err := json.Unmarshal(req.Arguments, &args); err != nil {
+				s.respondError(req, err)
+				continue
+			}
+			if args.Program == "" && flag.Arg(0) != "-" {
+				args.Program = flag.Arg(0)
+			}
+			if args.Program == "" {
+				s.respondError(req, errors.New("no program to run"))
+				continue
+			}
+			if p, err := filepath.Abs(args.Program); err == nil {
+				args.Program = p
+			}
+			s.program, s.stopOnEntry, s.launched = args.Program, args.StopOnEntry, true
+			s.respond(req, nil)
+			s.start()
+		case "setBreakpoints":
+			s.setBreakpoints(req)
+		case "setExceptionBreakpoints":
+			s.respond(req, nil)
+		case "configurationDone":
+			s.configured = true
+			s.respond(req, nil)
+			s.start()
+		case "threads":
+			s.respond(req, map[string]interface{}{
+				"threads": []map[string]interface{}{{"id": dapThreadId, "name": "main"}},
+			})
+		case "pause":
+			s.dbg.Pause()
+			s.respond(req, nil)
+		case "stackTrace", "scopes", "variables", "evaluate", "continue", "next", "stepIn", "stepOut":
+			s.mu.Lock()
+			paused := s.paused
+			s.mu.Unlock()
+			if !paused {
+				s.respondError(req, errNotPaused)
+				continue
+			}
+			s.pausedReqs <- req
+		case "disconnect", "terminate":
+			s.vm.Interrupt("debug session ended")
+			s.mu.Lock()
+			paused := s.paused
+			s.mu.Unlock()
+			if paused {
+				s.pausedReqs <- req
+			}
+			s.respond(req, nil)
+			if req.Command == "disconnect" {
+				return nil
+			}
+		default:
+			s.respondError(req, fmt.Errorf("unsupported request %q", req.Command))
+		}
+	}
+}
+
+func (s *dapSession) setBreakpoints(req *dapMessage) {
+	var args struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line   int `json:"line"`
+			Column int `json:"column"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.respondError(req, err)
+		return
+	}
+	path := args.Source.Path
+	for _, bp := range s.breakpoints[path] {
+		s.dbg.RemoveBreakpoint(bp)
+	}
+	bps := make([]*goja.Breakpoint, 0, len(args.Breakpoints))
+	res := make([]map[string]interface{}, 0, len(args.Breakpoints))
+	for _, b := range args.Breakpoints {
+		bp := s.dbg.SetBreakpoint(path, b.Line, b.Column)
+		bps = append(bps, bp)
+		res = append(res, map[string]interface{}{
+			"id":       bp.ID(),
+			"verified": true,
+			"line":     b.Line,
+		})
+	}
+	s.breakpoints[path] = bps
+	s.respond(req, map[string]interface{}{
+		"breakpoints": res,
+	})
+}
+
+func (s *dapSession) start() {
+	if s.started || !s.launched || !s.configured {
+		return
+	}
+	s.started = true
+	go func() {
+		exitCode := 0
+		if err := s.run(); err != nil {
+			exitCode = 64
+			var msg string
+			switch err := err.(type) {
+			case *goja.Exception:
+				msg = err.String()
+			case *goja.InterruptedError:
+				msg = err.String()
+			default:
+				msg = err.Error()
+			}
+			s.output("stderr", msg+"\n")
+		}
+		s.sendEvent("exited", map[string]interface{}{"exitCode": exitCode})
+		s.sendEvent("terminated", nil)
+	}()
+}
+
+func (s *dapSession) run() error {
+	src, err := ioutil.ReadFile(s.program)
+	if err != nil {
+		return err
+	}
+	if s.stopOnEntry {
+		s.mu.Lock()
+		s.entry = true
+		s.mu.Unlock()
+		s.dbg.Pause()
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	_, err = s.vm.RunScriptContext(ctx, s.program, string(src))
+	return err
+}
+
+func (s *dapSession) onPause(p *goja.DebugPause) goja.DebugAction {
+	reason, description := "pause", ""
+	switch p.Reason {
+	case goja.PauseBreakpoint:
+		reason = "breakpoint"
+	case goja.PauseDebuggerStatement:
+		reason, description = "breakpoint", "Paused on debugger statement"
+	case goja.PauseStep:
+		reason = "step"
+	}
+	s.mu.Lock()
+	if s.entry {
+		reason, s.entry = "entry", false
+	}
+	s.paused = true
+	s.mu.Unlock()
+	s.refs = s.refs[:0]
+
+	body := map[string]interface{}{
+		"reason":            reason,
+		"threadId":          dapThreadId,
+		"allThreadsStopped": true,
+	}
+	if description != "" {
+		body["description"] = description
+	}
+	if p.Breakpoint != nil {
+		body["hitBreakpointIds"] = []int{p.Breakpoint.ID()}
+	}
+	s.sendEvent("stopped", body)
+
+	for req := range s.pausedReqs {
+		if action, resume := s.handlePaused(p, req); resume {
+			s.mu.Lock()
+			s.paused = false
+			s.mu.Unlock()
+			for {
+				select {
+				case req := <-s.pausedReqs:
+					if req.Command != "disconnect" && req.Command != "terminate" {
+						s.respondError(req, errNotPaused)
+					}
+					continue
+				default:
+				}
+				break
+			}
+			return action
+		}
+	}
+	return goja.DebugContinue
+}
+
+func (s *dapSession) handlePaused(p *goja.DebugPause, req *dapMessage) (goja.DebugAction, bool) {
+	switch req.Command {
+	case "continue":
+		s.respond(req, map[string]interface{}{"allThreadsContinued": true})
+		return goja.DebugContinue, true
+	case "next":
+		s.respond(req, nil)
+		return goja.DebugStepOver, true
+	case "stepIn":
+		s.respond(req, nil)
+		return goja.DebugStepIn, true
+	case "stepOut":
+		s.respond(req, nil)
+		return goja.DebugStepOut, true
+	case "disconnect", "terminate":
+		// the response is sent by the reader, the execution has been interrupted
+		return goja.DebugContinue, true
+	case "stackTrace":
+		s.stackTrace(p, req)
+	case "scopes":
+		s.scopes(p, req)
+	case "variables":
+		s.variables(p, req)
+	case "evaluate":
+		s.evaluate(p, req)
+	}
+	return goja.DebugContinue, false
+}
+
+func (s *dapSession) frame(p *goja.DebugPause, id int) (*goja.DebugFrame, error) {
+	if id < 1 || id > len(p.Frames) {
+		return nil, fmt.Errorf("invalid frame id %d", id)
+	}
+	return p.Frames[id-1], nil
+}
+
+func (s *dapSession) stackTrace(p *goja.DebugPause, req *dapMessage) {
+	frames := make([]map[string]interface{}, 0, len(p.Frames))
+	for i, f := range p.Frames {
+		frame := map[string]interface{}{
+			"id":   i + 1,
+			"name": f.FuncName(),
+		}
+		if pos := f.Position(); pos.Filename != "" {
+			frame["source"] = dapSource{
+				Name: filepath.Base(pos.Filename),
+				Path: pos.Filename,
+			}
+			frame["line"] = pos.Line
+			frame["column"] = pos.Column
+		} else {
+			frame["line"] = 0
+			frame["column"] = 0
+			frame["presentationHint"] = "subtle"
+		}
+		frames = append(frames, frame)
+	}
+	s.respond(req, map[string]interface{}{
+		"stackFrames": frames,
+		"totalFrames": len(frames),
+	})
+}
+
+func (s *dapSession) scopes(p *goja.DebugPause, req *dapMessage) {
+	var args struct {
+		FrameId int `json:"frameId"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.respondError(req, err)
+		return
+	}
+	f, err := s.frame(p, args.FrameId)
+	if err != nil {
+		s.respondError(req, err)
+		return
+	}
+	scopes := f.Scopes()
+	res := make([]map[string]interface{}, 0, len(scopes))
+	for _, scope := range scopes {
+		name := scope.Type.String()
+		res = append(res, map[string]interface{}{
+			"name":               strings.ToUpper(name[:1]) + name[1:],
+			"variablesReference": s.addRef(scope),
+			"expensive":          scope.Type == goja.DebugScopeGlobal,
+		})
+	}
+	s.respond(req, map[string]interface{}{
+		"scopes": res,
+	})
+}
+
+func (s *dapSession) addRef(v interface{}) int {
+	s.refs = append(s.refs, v)
+	return len(s.refs)
+}
+
+func (s *dapSession) variables(p *goja.DebugPause, req *dapMessage) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.respondError(req, err)
+		return
+	}
+	if args.VariablesReference < 1 || args.VariablesReference > len(s.refs) {
+		s.respondError(req, fmt.Errorf("invalid variables reference %d", args.VariablesReference))
+		return
+	}
+	var vars []goja.DebugVariable
+	switch ref := s.refs[args.VariablesReference-1].(type) {
+	case *goja.DebugScope:
+		vars = ref.Variables
+	case *goja.Object:
+		vars = p.Properties(ref)
+	}
+	res := make([]dapVariable, 0, len(vars))
+	for _, v := range vars {
+		res = append(res, s.variable(v.Name, v.Value))
+	}
+	s.respond(req, map[string]interface{}{
+		"variables": res,
+	})
+}
+
+func (s *dapSession) variable(name string, v goja.Value) dapVariable {
+	res := dapVariable{Name: name}
+	switch v := v.(type) {
+	case nil:
+		res.Value = "<uninitialized>"
+	case *goja.Object:
+		res.Type = v.ClassName()
+		res.Value = describeObject(v)
+		res.VariablesReference = s.addRef(v)
+	case *goja.Symbol:
+		res.Type = "symbol"
+		res.Value = v.String()
+	default:
+		switch e := v.Export().(type) {
+		case string:
+			res.Type = "string"
+			res.Value = strconv.Quote(e)
+		case bool:
+			res.Type = "boolean"
+			res.Value = v.String()
+		case int64, float64:
+			res.Type = "number"
+			res.Value = v.String()
+		case *big.Int:
+			res.Type = "bigint"
+			res.Value = v.String() + "n"
+		default:
+			res.Value = v.String()
+		}
+	}
+	return res
+}
+
+// describeObject returns a short description of the object. It must not run any JavaScript code,
+// because a getter may throw.
+func describeObject(o *goja.Object) string {
+	switch cls := o.ClassName(); cls {
+	case "Function":
+		return "function"
+	case "Array":
+		if a, ok := o.Export().([]interface{}); ok {
+			return "Array(" + strconv.Itoa(len(a)) + ")"
+		}
+		return cls
+	default:
+		return cls
+	}
+}
+
+func (s *dapSession) evaluate(p *goja.DebugPause, req *dapMessage) {
+	var args struct {
+		Expression string `json:"expression"`
+		FrameId    int    `json:"frameId"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.respondError(req, err)
+		return
+	}
+	if args.FrameId == 0 {
+		args.FrameId = 1
+	}
+	f, err := s.frame(p, args.FrameId)
+	if err != nil {
+		s.respondError(req, err)
+		return
+	}
+	v, err := f.Eval(args.Expression)
+	if err != nil {
+		s.respondError(req, err)
+		return
+	}
+	res := s.variable("", v)
+	s.respond(req, map[string]interface{}{
+		"result":             res.Value,
+		"type":               res.Type,
+		"variablesReference": res.VariablesReference,
+	})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type dapTestClient struct {
+	t    *testing.T
+	w    io.Writer
+	seq  int
+	msgs chan map[string]interface{}
+}
+
+func newDAPTestClient(t *testing.T) (*dapTestClient, chan error) {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	c := &dapTestClient{
+		t:    t,
+		w:    reqW,
+		msgs: make(chan map[string]interface{}, 100),
+	}
+	go func() {
+		r := bufio.NewReader(respR)
+		for {
+			header, err := textproto.NewReader(r).ReadMIMEHeader()
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			length, _ := strconv.Atoi(header.Get("Content-Length"))
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				close(c.msgs)
+				return
+			}
+			var msg map[string]interface{}
+			if err := json.Unmarshal(buf, &msg); err != nil {
+				t.Error(err)
+			}
+			c.msgs <- msg
+		}
+	}()
+	done := make(chan error, 1)
+	go func() {
+		done <- newDAPSession(reqR, respW).serve()
+		respW.Close()
+	}()
+	return c, done
+}
+
+func (c *dapTestClient) request(command string, args interface{}) {
+	c.seq++
+	b, err := json.Marshal(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+// expect skips the messages until the response to command (if event is "") or the event is received.
+func (c *dapTestClient) expect(command, event string) map[string]interface{} {
+	for {
+		select {
+		case msg, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatalf("Connection closed while waiting for %q/%q", command, event)
+			}
+			if event != "" {
+				if msg["type"] == "event" && msg["event"] == event {
+					return msg
+				}
+				continue
+			}
+			if msg["type"] == "response" && msg["command"] == command {
+				if msg["success"] != true {
+					c.t.Fatalf("Request %q failed: %v", command, msg["message"])
+				}
+				body, _ := msg["body"].(map[string]interface{})
+				return body
+			}
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("Timeout waiting for %q/%q", command, event)
+		}
+	}
+}
+
+func TestDAPSession(t *testing.T) {
+	const SCRIPT = `var x = 1;
+function f(a) {
+	var y = a + x;
+	return y;
+}
+console.log(f(2));
+`
+	dir, err := ioutil.TempDir("", "goja-dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program := filepath.Join(dir, "test.js")
+	if err := ioutil.WriteFile(program, []byte(SCRIPT), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, done := newDAPTestClient(t)
+	c.request("initialize", map[string]interface{}{"adapterID": "goja"})
+	c.expect("initialize", "")
+	c.expect("", "initialized")
+
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": program},
+		"breakpoints": []map[string]interface{}{{"line": 3}},
+	})
+	body := c.expect("setBreakpoints", "")
+	if bps := body["breakpoints"].([]interface{}); len(bps) != 1 {
+		t.Fatalf("Unexpected breakpoints: %v", bps)
+	}
+	c.request("launch", map[string]interface{}{"program": program})
+	c.expect("launch", "")
+	c.request("configurationDone", nil)
+	c.expect("configurationDone", "")
+
+	stopped := c.expect("", "stopped")
+	if reason := stopped["body"].(map[string]interface{})["reason"]; reason != "breakpoint" {
+		t.Fatalf("Unexpected reason: %v", reason)
+	}
+
+	c.request("stackTrace", map[string]interface{}{"threadId": 1})
+	frames := c.expect("stackTrace", "")["stackFrames"].([]interface{})
+	top := frames[0].(map[string]interface{})
+	if top["name"] != "f" || top["line"] != float64(3) {
+		t.Fatalf("Unexpected top frame: %v", top)
+	}
+
+	c.request("scopes", map[string]interface{}{"frameId": top["id"]})
+	scopes := c.expect("scopes", "")["scopes"].([]interface{})
+	local := scopes[0].(map[string]interface{})
+	if local["name"] != "Local" {
+		t.Fatalf("Unexpected scope: %v", local)
+	}
+	c.request("variables", map[string]interface{}{"variablesReference": local["variablesReference"]})
+	vars := c.expect("variables", "")["variables"].([]interface{})
+	found := false
+	for _, v := range vars {
+		v := v.(map[string]interface{})
+		if v["name"] == "a" {
+			if v["value"] != "2" || v["type"] != "number" {
+				t.Fatalf("Unexpected variable: %v", v)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Variable not found: %v", vars)
+	}
+
+	c.request("evaluate", map[string]interface{}{"expression": "a + x", "frameId": top["id"]})
+	if res := c.expect("evaluate", "")["result"]; res != "3" {
+		t.Fatalf("Unexpected evaluate result: %v", res)
+	}
+
+	c.request("next", map[string]interface{}{"threadId": 1})
+	c.expect("next", "")
+	stopped = c.expect("", "stopped")
+	if reason := stopped["body"].(map[string]interface{})["reason"]; reason != "step" {
+		t.Fatalf("Unexpected reason: %v", reason)
+	}
+	c.request("stackTrace", map[string]interface{}{"threadId": 1})
+	frames = c.expect("stackTrace", "")["stackFrames"].([]interface{})
+	if line := frames[0].(map[string]interface{})["line"]; line != float64(4) {
+		t.Fatalf("Unexpected line after step: %v", line)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": 1})
+	c.expect("continue", "")
+	output := c.expect("", "output")
+	if out := output["body"].(map[string]interface{})["output"]; out != "3\n" {
+		t.Fatalf("Unexpected output: %q", out)
+	}
+	exited := c.expect("", "exited")
+	if code := exited["body"].(map[string]interface{})["exitCode"]; code != float64(0) {
+		t.Fatalf("Unexpected exit code: %v", code)
+	}
+	c.expect("", "terminated")
+
+	c.request("disconnect", nil)
+	c.expect("disconnect", "")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
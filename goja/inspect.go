@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/dop251/goja/inspector"
+)
+
+// inspectPrinter sends the console output both to the standard log and to the DevTools client.
+type inspectPrinter struct {
+	insp *inspector.Inspector
+}
+
+func (p *inspectPrinter) Log(msg string) {
+	log.Print(msg)
+	p.insp.Console("log", msg)
+}
+
+func (p *inspectPrinter) Warn(msg string) {
+	log.Print(msg)
+	p.insp.Console("warning", msg)
+}
+
+func (p *inspectPrinter) Error(msg string) {
+	log.Print(msg)
+	p.insp.Console("error", msg)
+}
+
+// runInspect runs the script with a Chrome DevTools Protocol inspector listening on addr. If brk is true,
+// it waits for a client to connect and pauses before the first statement.
+func runInspect(addr string, brk bool) error {
+	filename := flag.Arg(0)
+	src, err := readSource(filename)
+	if err != nil {
+		return err
+	}
+	if filename == "" || filename == "-" {
+		filename = "<stdin>"
+	}
+
+	printer := &inspectPrinter{}
+	vm := newRuntime(printer)
+	insp := inspector.New(vm)
+	printer.insp = insp
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go http.Serve(l, insp)
+	log.Printf("Debugger listening on ws://%s, open chrome://inspect to connect", l.Addr())
+
+	ctx, cancel := newContext()
+	defer cancel()
+
+	if brk {
+		log.Println("Waiting for the debugger to connect...")
+		if err := insp.WaitForDebugger(ctx); err != nil {
+			return err
+		}
+		insp.Debugger().Pause()
+	}
+
+	_, err = vm.RunScriptContext(ctx, filename, string(src))
+	return err
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	crand "crypto/rand"
 	"encoding/binary"
 	"flag"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"runtime/debug"
 	"runtime/pprof"
+	"strings"
 	"time"
 
 	"github.com/dop251/goja"
@@ -19,7 +21,12 @@ import (
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
+var jsprofile = flag.String("jsprofile", "", "write the JavaScript cpu profile (in pprof format) to file")
+var coverage = flag.String("coverage", "", "write the code coverage to file (in Istanbul JSON format if the file name ends with .json, LCOV otherwise)")
 var timelimit = flag.Int("timelimit", 0, "max time to run (in seconds)")
+var dap = flag.String("dap", "", "serve the Debug Adapter Protocol on the given address (e.g. :4711), or on stdin/stdout if set to \"stdio\"")
+var inspect = flag.String("inspect", "", "serve the Chrome DevTools Protocol on the given address (e.g. 127.0.0.1:9229)")
+var inspectBrk = flag.String("inspect-brk", "", "like -inspect, but wait for the debugger to connect and break before the script starts")
 
 func readSource(filename string) ([]byte, error) {
 	if filename == "" || filename == "-" {
@@ -49,21 +56,16 @@ func newRandSource() goja.RandSource {
 	return rand.New(rand.NewSource(seed)).Float64
 }
 
-func run() error {
-	filename := flag.Arg(0)
-	src, err := readSource(filename)
-	if err != nil {
-		return err
-	}
-
-	if filename == "" || filename == "-" {
-		filename = "<stdin>"
-	}
-
+// newRuntime creates a Runtime with the CLI globals. If printer is nil, the console output goes to the standard log.
+func newRuntime(printer console.Printer) *goja.Runtime {
 	vm := goja.New()
 	vm.SetRandSource(newRandSource())
 
-	new(require.Registry).Enable(vm)
+	registry := new(require.Registry)
+	if printer != nil {
+		registry.RegisterNativeModule("console", console.RequireWithPrinter(printer))
+	}
+	registry.Enable(vm)
 	console.Enable(vm)
 
 	vm.Set("load", func(call goja.FunctionCall) goja.Value {
@@ -78,19 +80,87 @@ func run() error {
 		return string(b), nil
 	})
 
+	return vm
+}
+
+func newContext() (context.Context, context.CancelFunc) {
 	if *timelimit > 0 {
-		time.AfterFunc(time.Duration(*timelimit)*time.Second, func() {
-			vm.Interrupt("timeout")
-		})
+		return context.WithTimeout(context.Background(), time.Duration(*timelimit)*time.Second)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func writeCoverage(cov *goja.Coverage, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(filename, ".json") {
+		err = cov.WriteIstanbulJSON(f)
+	} else {
+		err = cov.WriteLCOV(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func run() (err error) {
+	filename := flag.Arg(0)
+	src, err := readSource(filename)
+	if err != nil {
+		return err
+	}
+
+	if filename == "" || filename == "-" {
+		filename = "<stdin>"
+	}
+
+	vm := newRuntime(nil)
+
+	ctx, cancel := newContext()
+	defer cancel()
+
+	var cov *goja.Coverage
+	if *coverage != "" {
+		cov = goja.NewCoverage()
+		// also covers the scripts loaded with load()
+		vm.SetCoverage(cov)
+		defer func() {
+			if cerr := writeCoverage(cov, *coverage); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
 	}
 
 	//log.Println("Compiling...")
-	prg, err := goja.Compile(filename, string(src), false)
+	var prg *goja.Program
+	if cov != nil {
+		prg, err = cov.Compile(filename, string(src), false)
+	} else {
+		prg, err = goja.Compile(filename, string(src), false)
+	}
 	if err != nil {
 		return err
 	}
+	if *jsprofile != "" {
+		f, err := os.Create(*jsprofile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := vm.StartProfile(f); err != nil {
+			return err
+		}
+		defer func() {
+			if perr := vm.StopProfile(); perr != nil && err == nil {
+				err = perr
+			}
+		}()
+	}
 	//log.Println("Running...")
-	_, err = vm.RunProgram(prg)
+	_, err = vm.RunProgramContext(ctx, prg)
 	//log.Println("Finished.")
 	return err
 }
@@ -112,7 +182,17 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	if err := run(); err != nil {
+	var err error
+	if *dap != "" {
+		err = serveDAP(*dap)
+	} else if *inspectBrk != "" {
+		err = runInspect(*inspectBrk, true)
+	} else if *inspect != "" {
+		err = runInspect(*inspect, false)
+	} else {
+		err = run()
+	}
+	if err != nil {
 		//fmt.Printf("err type: %T\n", err)
 		switch err := err.(type) {
 		case *goja.Exception:
@@ -0,0 +1,828 @@
+// Package inspector implements a Chrome DevTools Protocol (CDP) endpoint for a goja Runtime, so that scripts
+// can be debugged using Chrome DevTools (chrome://inspect) or any other CDP client.
+//
+// The following parts of the protocol are supported: the Debugger domain (breakpoints, stepping, pausing,
+// call frames, evaluation on a call frame and script sources), the Runtime domain (execution context,
+// evaluation while paused, object properties and console messages), the Console domain and the Profiler domain
+// (CPU profiles collected with goja.Runtime.StartProfile(), the sampling interval is fixed to goja.ProfilePeriod).
+//
+// Scripts are identified by the names they have been compiled with. If a source map has been loaded
+// (see file.File.SetSourceMap()), the original sources are reported instead of the generated one.
+//
+// Usage:
+//
+//	insp := inspector.New(vm)
+//	l, err := net.Listen("tcp", "127.0.0.1:9229")
+//	...
+//	go http.Serve(l, insp)
+//	insp.WaitForDebugger(ctx) // optional
+//	vm.RunScript("script.js", src)
+package inspector
+
+import (
+	gocontext "context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/file"
+)
+
+const executionContextId = 1
+
+type request struct {
+	Id     int64           `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	Id     int64       `json:"id"`
+	Result interface{} `json:"result,omitempty"`
+	Error  *rpcError   `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type event struct {
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+type location struct {
+	ScriptId     string `json:"scriptId"`
+	LineNumber   int    `json:"lineNumber"`
+	ColumnNumber int    `json:"columnNumber"`
+}
+
+type script struct {
+	id     string
+	url    string
+	source string
+}
+
+type breakpoint struct {
+	id  string
+	bps []*goja.Breakpoint
+}
+
+// pauseState holds the objects that can be referenced by the client during a pause.
+type pauseState struct {
+	seq     int
+	pause   *goja.DebugPause
+	frames  []*goja.DebugFrame
+	objects []interface{} // *goja.DebugScope or *goja.Object
+}
+
+func (p *pauseState) addObject(o interface{}) string {
+	p.objects = append(p.objects, o)
+	return strconv.Itoa(p.seq) + "." + strconv.Itoa(len(p.objects))
+}
+
+func (p *pauseState) object(id string) (interface{}, bool) {
+	dot := strings.IndexByte(id, '.')
+	if dot == -1 || id[:dot] != strconv.Itoa(p.seq) {
+		return nil, false
+	}
+	idx, err := strconv.Atoi(id[dot+1:])
+	if err != nil || idx < 1 || idx > len(p.objects) {
+		return nil, false
+	}
+	return p.objects[idx-1], true
+}
+
+type session struct {
+	conn            *wsConn
+	debuggerEnabled bool
+	runtimeEnabled  bool
+	profiling       bool
+	done            chan struct{}
+}
+
+// Inspector exposes a Runtime over the Chrome DevTools Protocol. It implements http.Handler, serving the
+// /json/version and /json/list endpoints used for discovery and the WebSocket endpoint itself.
+// Only one client may be connected at a time.
+type Inspector struct {
+	r   *goja.Runtime
+	dbg *goja.Debugger
+	id  string
+
+	mu          sync.Mutex
+	session     *session
+	scripts     map[string]*script // by url
+	scriptIds   map[string]*script
+	scriptList  []*script
+	mapped      []*file.File
+	breakpoints map[string]*breakpoint
+	bpSeq       int
+	paused      bool
+
+	// requests that can only be served on the vm goroutine while it's paused, queued with mu held. pausedNotify
+	// is signalled (without blocking) after a request has been queued.
+	pausedReqs   []*request
+	pausedNotify chan struct{}
+
+	waitOnce sync.Once
+	waiting  chan struct{}
+
+	pauseSeq int
+}
+
+// New creates an Inspector for the Runtime and attaches a goja.Debugger to it (replacing the existing one, if any).
+// Like AttachDebugger, it must be called when the Runtime is not running.
+func New(r *goja.Runtime) *Inspector {
+	var id [16]byte
+	rand.Read(id[:])
+	i := &Inspector{
+		r:            r,
+		id:           hex.EncodeToString(id[:]),
+		scripts:      make(map[string]*script),
+		scriptIds:    make(map[string]*script),
+		breakpoints:  make(map[string]*breakpoint),
+		pausedNotify: make(chan struct{}, 1),
+		waiting:      make(chan struct{}),
+	}
+	i.dbg = r.AttachDebugger(i.onPause)
+	i.dbg.SetSourceHandler(i.onSource)
+	return i
+}
+
+// Debugger returns the underlying Debugger.
+func (i *Inspector) Debugger() *goja.Debugger {
+	return i.dbg
+}
+
+// WaitForDebugger blocks until a client has connected and sent Runtime.runIfWaitingForDebugger (which DevTools
+// does once it has set up the breakpoints), or until ctx is done.
+func (i *Inspector) WaitForDebugger(ctx gocontext.Context) error {
+	select {
+	case <-i.waiting:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Console reports a console message to the connected client (if any). The level is one of the
+// Runtime.consoleAPICalled types, e.g. "log", "warning" or "error". It is safe for concurrent use.
+func (i *Inspector) Console(level, text string) {
+	i.mu.Lock()
+	s := i.session
+	i.mu.Unlock()
+	if s == nil || !s.runtimeEnabled {
+		return
+	}
+	i.sendEvent(s, "Runtime.consoleAPICalled", map[string]interface{}{
+		"type":               level,
+		"args":               []*remoteObject{{Type: "string", Value: jsonValue(text)}},
+		"executionContextId": executionContextId,
+		"timestamp":          float64(time.Now().UnixNano()) / 1e6,
+	})
+}
+
+// ServeHTTP implements http.Handler. Like the Node.js inspector, it only accepts requests with a Host header that is
+// an IP address or localhost (which protects against DNS rebinding attacks) and rejects requests made by web pages,
+// i.e. the ones with an Origin header other than that of DevTools.
+func (i *Inspector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !isAllowedHost(req.Host) {
+		http.Error(w, "Host header is not an IP address or localhost", http.StatusForbidden)
+		return
+	}
+	if !isAllowedOrigin(req.Header.Get("Origin")) {
+		http.Error(w, "Requests from web pages are not allowed", http.StatusForbidden)
+		return
+	}
+	switch req.URL.Path {
+	case "/json", "/json/list":
+		wsURL := req.Host + "/" + i.id
+		writeJSON(w, []map[string]interface{}{{
+			"description":          "goja instance",
+			"devtoolsFrontendUrl":  "devtools://devtools/bundled/js_app.html?experiments=true&v8only=true&ws=" + wsURL,
+			"id":                   i.id,
+			"title":                "goja",
+			"type":                 "node",
+			"url":                  "file://",
+			"webSocketDebuggerUrl": "ws://" + wsURL,
+		}})
+	case "/json/version":
+		writeJSON(w, map[string]string{
+			"Browser":          "goja",
+			"Protocol-Version": "1.3",
+		})
+	case "/" + i.id:
+		i.serveWebSocket(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func isAllowedHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return strings.EqualFold(host, "localhost") || net.ParseIP(host) != nil
+}
+
+func isAllowedOrigin(origin string) bool {
+	return origin == "" || strings.HasPrefix(origin, "devtools://") || strings.HasPrefix(origin, "chrome-devtools://")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (i *Inspector) serveWebSocket(w http.ResponseWriter, req *http.Request) {
+	i.mu.Lock()
+	busy := i.session != nil
+	i.mu.Unlock()
+	if busy {
+		http.Error(w, "Another debugger is already connected", http.StatusConflict)
+		return
+	}
+	conn, err := wsUpgrade(w, req)
+	if err != nil {
+		return
+	}
+	s := &session{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	i.mu.Lock()
+	if i.session != nil {
+		i.mu.Unlock()
+		conn.Close()
+		return
+	}
+	i.session = s
+	i.mu.Unlock()
+
+	defer func() {
+		conn.Close()
+		if s.profiling {
+			i.r.StopProfileData()
+		}
+		i.mu.Lock()
+		i.session = nil
+		i.mu.Unlock()
+		// resumes the execution if paused
+		close(s.done)
+	}()
+
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		req := &request{}
+		if err := json.Unmarshal(msg, req); err != nil {
+			return
+		}
+		i.dispatch(s, req)
+	}
+}
+
+func (i *Inspector) send(s *session, msg interface{}) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.conn.WriteMessage(b)
+}
+
+func (i *Inspector) sendEvent(s *session, method string, params interface{}) {
+	i.send(s, &event{Method: method, Params: params})
+}
+
+func (i *Inspector) respond(s *session, req *request, result interface{}) {
+	if req.Id < 0 {
+		return
+	}
+	if result == nil {
+		result = struct{}{}
+	}
+	i.send(s, &response{Id: req.Id, Result: result})
+}
+
+func (i *Inspector) respondError(s *session, req *request, code int, format string, args ...interface{}) {
+	if req.Id < 0 {
+		return
+	}
+	i.send(s, &response{Id: req.Id, Error: &rpcError{Code: code, Message: fmt.Sprintf(format, args...)}})
+}
+
+const (
+	errCodeInvalidParams  = -32602
+	errCodeMethodNotFound = -32601
+	errCodeServer         = -32000
+)
+
+func (i *Inspector) dispatch(s *session, req *request) {
+	switch req.Method {
+	case "Runtime.enable":
+		s.runtimeEnabled = true
+		i.respond(s, req, nil)
+		i.sendEvent(s, "Runtime.executionContextCreated", map[string]interface{}{
+			"context": map[string]interface{}{
+				"id":     executionContextId,
+				"origin": "",
+				"name":   "goja",
+			},
+		})
+	case "Runtime.disable":
+		s.runtimeEnabled = false
+		i.respond(s, req, nil)
+	case "Debugger.enable":
+		i.mu.Lock()
+		s.debuggerEnabled = true
+		scripts := append([]*script(nil), i.scriptList...)
+		i.mu.Unlock()
+		i.respond(s, req, map[string]interface{}{"debuggerId": i.id})
+		for _, sc := range scripts {
+			i.sendScriptParsed(s, sc)
+		}
+	case "Debugger.disable":
+		i.mu.Lock()
+		s.debuggerEnabled = false
+		i.mu.Unlock()
+		i.respond(s, req, nil)
+	case "Runtime.runIfWaitingForDebugger":
+		i.waitOnce.Do(func() {
+			close(i.waiting)
+		})
+		i.respond(s, req, nil)
+	case "Debugger.setBreakpointByUrl":
+		i.setBreakpointByUrl(s, req)
+	case "Debugger.setBreakpoint":
+		i.setBreakpoint(s, req)
+	case "Debugger.removeBreakpoint":
+		var params struct {
+			BreakpointId string `json:"breakpointId"`
+		}
+		json.Unmarshal(req.Params, &params)
+		i.mu.Lock()
+		bp := i.breakpoints[params.BreakpointId]
+		delete(i.breakpoints, params.BreakpointId)
+		i.mu.Unlock()
+		if bp != nil {
+			for _, b := range bp.bps {
+				i.dbg.RemoveBreakpoint(b)
+			}
+		}
+		i.respond(s, req, nil)
+	case "Debugger.getScriptSource":
+		var params struct {
+			ScriptId string `json:"scriptId"`
+		}
+		json.Unmarshal(req.Params, &params)
+		i.mu.Lock()
+		sc := i.scriptIds[params.ScriptId]
+		i.mu.Unlock()
+		if sc == nil {
+			i.respondError(s, req, errCodeInvalidParams, "No script for id: %s", params.ScriptId)
+			return
+		}
+		i.respond(s, req, map[string]interface{}{"scriptSource": sc.source})
+	case "Debugger.pause":
+		i.dbg.Pause()
+		i.respond(s, req, nil)
+	case "Debugger.setPauseOnExceptions", "Debugger.setAsyncCallStackDepth", "Debugger.setBlackboxPatterns",
+		"Debugger.setBreakpointsActive", "Runtime.setAsyncCallStackDepth", "Runtime.releaseObject",
+		"Runtime.releaseObjectGroup", "Runtime.discardConsoleEntries", "Profiler.enable", "Profiler.disable",
+		"Profiler.setSamplingInterval", "Console.enable", "Console.disable":
+		i.respond(s, req, nil)
+	case "Profiler.start":
+		if s.profiling {
+			i.respond(s, req, nil)
+			return
+		}
+		if err := i.r.StartProfile(nil); err != nil {
+			i.respondError(s, req, errCodeServer, "%v", err)
+			return
+		}
+		s.profiling = true
+		i.respond(s, req, nil)
+	case "Profiler.stop":
+		if !s.profiling {
+			i.respondError(s, req, errCodeServer, "No recording profiles found")
+			return
+		}
+		s.profiling = false
+		p := i.r.StopProfileData()
+		if p == nil {
+			i.respondError(s, req, errCodeServer, "No recording profiles found")
+			return
+		}
+		i.respond(s, req, map[string]interface{}{
+			"profile": i.cdpProfile(p),
+		})
+	case "Debugger.resume", "Debugger.stepOver", "Debugger.stepInto", "Debugger.stepOut",
+		"Debugger.evaluateOnCallFrame", "Runtime.getProperties", "Runtime.evaluate":
+		i.mu.Lock()
+		if !i.paused {
+			i.mu.Unlock()
+			i.respondError(s, req, errCodeServer, "Can only perform operation while paused.")
+			return
+		}
+		i.pausedReqs = append(i.pausedReqs, req)
+		i.mu.Unlock()
+		select {
+		case i.pausedNotify <- struct{}{}:
+		default:
+		}
+	default:
+		i.respondError(s, req, errCodeMethodNotFound, "'%s' wasn't found", req.Method)
+	}
+}
+
+func (i *Inspector) setBreakpointByUrl(s *session, req *request) {
+	var params struct {
+		LineNumber   int    `json:"lineNumber"`
+		Url          string `json:"url"`
+		UrlRegex     string `json:"urlRegex"`
+		ColumnNumber int    `json:"columnNumber"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		i.respondError(s, req, errCodeInvalidParams, "%v", err)
+		return
+	}
+	var urls []string
+	if params.UrlRegex != "" {
+		re, err := regexp.Compile(params.UrlRegex)
+		if err != nil {
+			i.respondError(s, req, errCodeInvalidParams, "Invalid urlRegex: %v", err)
+			return
+		}
+		i.mu.Lock()
+		for _, sc := range i.scriptList {
+			if re.MatchString(sc.url) {
+				urls = append(urls, sc.url)
+			}
+		}
+		i.mu.Unlock()
+	} else {
+		urls = append(urls, params.Url)
+		if path := strings.TrimPrefix(params.Url, "file://"); path != params.Url {
+			urls = append(urls, path)
+		}
+	}
+	bp, locations := i.addBreakpoint(urls, params.LineNumber, params.ColumnNumber)
+	i.respond(s, req, map[string]interface{}{
+		"breakpointId": bp.id,
+		"locations":    locations,
+	})
+}
+
+func (i *Inspector) setBreakpoint(s *session, req *request) {
+	var params struct {
+		Location location `json:"location"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		i.respondError(s, req, errCodeInvalidParams, "%v", err)
+		return
+	}
+	i.mu.Lock()
+	sc := i.scriptIds[params.Location.ScriptId]
+	i.mu.Unlock()
+	if sc == nil {
+		i.respondError(s, req, errCodeInvalidParams, "No script for id: %s", params.Location.ScriptId)
+		return
+	}
+	bp, _ := i.addBreakpoint([]string{sc.url}, params.Location.LineNumber, params.Location.ColumnNumber)
+	i.respond(s, req, map[string]interface{}{
+		"breakpointId":   bp.id,
+		"actualLocation": params.Location,
+	})
+}
+
+// addBreakpoint sets a breakpoint on the given (0-based) line and column in every one of the urls.
+func (i *Inspector) addBreakpoint(urls []string, line, column int) (*breakpoint, []location) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.bpSeq++
+	bp := &breakpoint{
+		id: strconv.Itoa(i.bpSeq),
+	}
+	locations := []location{}
+	for _, url := range urls {
+		bp.bps = append(bp.bps, i.dbg.SetBreakpoint(url, line+1, column+1))
+		if sc := i.scripts[url]; sc != nil {
+			locations = append(locations, location{ScriptId: sc.id, LineNumber: line, ColumnNumber: column})
+		}
+	}
+	i.breakpoints[bp.id] = bp
+	return bp, locations
+}
+
+// onSource is called on the vm goroutine when a new source file is about to be executed.
+func (i *Inspector) onSource(src *file.File) {
+	if src.SourceMap() != nil {
+		// the original sources are announced when they are first seen in a location
+		i.mu.Lock()
+		i.mapped = append(i.mapped, src)
+		i.mu.Unlock()
+		return
+	}
+	i.addScript(src.Name(), src.Source())
+}
+
+func (i *Inspector) addScript(url, source string) *script {
+	i.mu.Lock()
+	if sc := i.scripts[url]; sc != nil && sc.source == source {
+		i.mu.Unlock()
+		return sc
+	}
+	sc := &script{
+		id:     strconv.Itoa(len(i.scriptList) + 1),
+		url:    url,
+		source: source,
+	}
+	i.scripts[url] = sc
+	i.scriptIds[sc.id] = sc
+	i.scriptList = append(i.scriptList, sc)
+	s := i.session
+	enabled := s != nil && s.debuggerEnabled
+	i.mu.Unlock()
+	if enabled {
+		i.sendScriptParsed(s, sc)
+	}
+	return sc
+}
+
+// scriptFor returns the script for the url, announcing it if it's an original source from a source map.
+func (i *Inspector) scriptFor(url string) *script {
+	i.mu.Lock()
+	sc := i.scripts[url]
+	mapped := i.mapped
+	i.mu.Unlock()
+	if sc != nil {
+		return sc
+	}
+	var source string
+	for _, f := range mapped {
+		if src, ok := f.OriginalSource(url); ok {
+			source = src
+			break
+		}
+	}
+	return i.addScript(url, source)
+}
+
+func (i *Inspector) sendScriptParsed(s *session, sc *script) {
+	lines := strings.Split(sc.source, "\n")
+	i.sendEvent(s, "Debugger.scriptParsed", map[string]interface{}{
+		"scriptId":           sc.id,
+		"url":                sc.url,
+		"startLine":          0,
+		"startColumn":        0,
+		"endLine":            len(lines) - 1,
+		"endColumn":          len(lines[len(lines)-1]),
+		"executionContextId": executionContextId,
+		"hash":               fmt.Sprintf("%x", sha1.Sum([]byte(sc.source))),
+	})
+}
+
+var scopeTypes = map[goja.DebugScopeType]string{
+	goja.DebugScopeLocal:   "local",
+	goja.DebugScopeClosure: "closure",
+	goja.DebugScopeWith:    "with",
+	goja.DebugScopeGlobal:  "global",
+}
+
+// onPause is called on the vm goroutine when the execution is paused.
+func (i *Inspector) onPause(p *goja.DebugPause) goja.DebugAction {
+	i.mu.Lock()
+	s := i.session
+	if s == nil || !s.debuggerEnabled {
+		i.mu.Unlock()
+		return goja.DebugContinue
+	}
+	i.paused = true
+	i.mu.Unlock()
+
+	i.pauseSeq++
+	ps := &pauseState{
+		seq:   i.pauseSeq,
+		pause: p,
+	}
+
+	callFrames := make([]map[string]interface{}, 0, len(p.Frames))
+	for _, f := range p.Frames {
+		pos := f.Position()
+		if pos.Filename == "" {
+			continue
+		}
+		sc := i.scriptFor(pos.Filename)
+		ps.frames = append(ps.frames, f)
+		var scopeChain []map[string]interface{}
+		for _, scope := range f.Scopes() {
+			typ := scopeTypes[scope.Type]
+			scopeChain = append(scopeChain, map[string]interface{}{
+				"type": typ,
+				"object": &remoteObject{
+					Type:        "object",
+					ClassName:   "Object",
+					Description: typ,
+					ObjectId:    ps.addObject(scope),
+				},
+			})
+		}
+		name := f.FuncName()
+		if name == "<anonymous>" {
+			name = ""
+		}
+		this := f.This()
+		if this == nil {
+			this = goja.Undefined()
+		}
+		callFrames = append(callFrames, map[string]interface{}{
+			"callFrameId":  strconv.Itoa(len(ps.frames) - 1),
+			"functionName": name,
+			"location": location{
+				ScriptId:     sc.id,
+				LineNumber:   pos.Line - 1,
+				ColumnNumber: pos.Column - 1,
+			},
+			"url":        sc.url,
+			"scopeChain": scopeChain,
+			"this":       ps.remote(this),
+		})
+	}
+
+	reason := "other"
+	if p.Reason == goja.PauseRequested {
+		reason = "debugCommand"
+	}
+	params := map[string]interface{}{
+		"callFrames": callFrames,
+		"reason":     reason,
+	}
+	if p.Breakpoint != nil {
+		i.mu.Lock()
+		for id, bp := range i.breakpoints {
+			for _, b := range bp.bps {
+				if b == p.Breakpoint {
+					params["hitBreakpoints"] = []string{id}
+				}
+			}
+		}
+		i.mu.Unlock()
+	}
+	i.sendEvent(s, "Debugger.paused", params)
+
+	action := i.pausedLoop(s, ps)
+
+	i.mu.Lock()
+	i.paused = false
+	// nothing can be queued after this point
+	reqs := i.pausedReqs
+	i.pausedReqs = nil
+	i.mu.Unlock()
+	for _, req := range reqs {
+		i.respondError(s, req, errCodeServer, "Can only perform operation while paused.")
+	}
+	i.sendEvent(s, "Debugger.resumed", nil)
+	return action
+}
+
+func (i *Inspector) pausedLoop(s *session, ps *pauseState) goja.DebugAction {
+	for {
+		req := i.nextPausedRequest(s)
+		if req == nil {
+			return goja.DebugContinue
+		}
+		switch req.Method {
+		case "Debugger.resume":
+			i.respond(s, req, nil)
+			return goja.DebugContinue
+		case "Debugger.stepOver":
+			i.respond(s, req, nil)
+			return goja.DebugStepOver
+		case "Debugger.stepInto":
+			i.respond(s, req, nil)
+			return goja.DebugStepIn
+		case "Debugger.stepOut":
+			i.respond(s, req, nil)
+			return goja.DebugStepOut
+		case "Debugger.evaluateOnCallFrame":
+			var params struct {
+				CallFrameId string `json:"callFrameId"`
+				Expression  string `json:"expression"`
+			}
+			json.Unmarshal(req.Params, &params)
+			idx, err := strconv.Atoi(params.CallFrameId)
+			if err != nil || idx < 0 || idx >= len(ps.frames) {
+				i.respondError(s, req, errCodeInvalidParams, "Invalid call frame id: %s", params.CallFrameId)
+				continue
+			}
+			i.respond(s, req, i.evaluate(ps, ps.frames[idx], params.Expression))
+		case "Runtime.evaluate":
+			var params struct {
+				Expression string `json:"expression"`
+			}
+			json.Unmarshal(req.Params, &params)
+			if len(ps.frames) == 0 {
+				i.respondError(s, req, errCodeServer, "No call frames")
+				continue
+			}
+			i.respond(s, req, i.evaluate(ps, ps.frames[0], params.Expression))
+		case "Runtime.getProperties":
+			i.getProperties(s, ps, req)
+		}
+	}
+}
+
+// nextPausedRequest waits for a request queued while paused. It returns nil if the session has ended.
+func (i *Inspector) nextPausedRequest(s *session) *request {
+	for {
+		i.mu.Lock()
+		if len(i.pausedReqs) > 0 {
+			req := i.pausedReqs[0]
+			i.pausedReqs = i.pausedReqs[1:]
+			i.mu.Unlock()
+			return req
+		}
+		i.mu.Unlock()
+		select {
+		case <-i.pausedNotify:
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+func (i *Inspector) evaluate(ps *pauseState, f *goja.DebugFrame, expr string) map[string]interface{} {
+	v, err := f.Eval(expr)
+	if err != nil {
+		var exception *remoteObject
+		if ex, ok := err.(*goja.Exception); ok {
+			exception = ps.remote(ex.Value())
+		} else {
+			exception = ps.remote(i.r.ToValue(err.Error()))
+		}
+		return map[string]interface{}{
+			"result": exception,
+			"exceptionDetails": map[string]interface{}{
+				"exceptionId":  1,
+				"text":         "Uncaught",
+				"lineNumber":   0,
+				"columnNumber": 0,
+				"exception":    exception,
+			},
+		}
+	}
+	return map[string]interface{}{
+		"result": ps.remote(v),
+	}
+}
+
+func (i *Inspector) getProperties(s *session, ps *pauseState, req *request) {
+	var params struct {
+		ObjectId               string `json:"objectId"`
+		AccessorPropertiesOnly bool   `json:"accessorPropertiesOnly"`
+	}
+	json.Unmarshal(req.Params, &params)
+	obj, ok := ps.object(params.ObjectId)
+	if !ok {
+		i.respondError(s, req, errCodeInvalidParams, "Could not find object with given id")
+		return
+	}
+	result := []propertyDescriptor{}
+	if !params.AccessorPropertiesOnly {
+		var vars []goja.DebugVariable
+		switch obj := obj.(type) {
+		case *goja.DebugScope:
+			vars = obj.Variables
+		case *goja.Object:
+			vars = ps.pause.Properties(obj)
+		}
+		for _, v := range vars {
+			result = append(result, propertyDescriptor{
+				Name:         v.Name,
+				Value:        ps.remote(v.Value),
+				Writable:     true,
+				Configurable: true,
+				Enumerable:   true,
+				IsOwn:        true,
+			})
+		}
+	}
+	i.respond(s, req, map[string]interface{}{
+		"result": result,
+	})
+}
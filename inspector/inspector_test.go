@@ -0,0 +1,447 @@
+package inspector
+
+import (
+	"bufio"
+	gocontext "context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	seq  int64
+	msgs chan map[string]interface{}
+	// received, but not yet expected messages
+	pending []map[string]interface{}
+}
+
+func dialTestClient(t *testing.T, srv *httptest.Server, path string) *testClient {
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", path, srv.Listener.Addr())
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Unexpected status: %s", resp.Status)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected accept: %q", accept)
+	}
+	c := &testClient{
+		t:    t,
+		conn: conn,
+		msgs: make(chan map[string]interface{}, 100),
+	}
+	go func() {
+		defer close(c.msgs)
+		for {
+			var hdr [2]byte
+			if _, err := io.ReadFull(r, hdr[:]); err != nil {
+				return
+			}
+			length := int(hdr[1] & 0x7F)
+			switch length {
+			case 126:
+				var b [2]byte
+				io.ReadFull(r, b[:])
+				length = int(binary.BigEndian.Uint16(b[:]))
+			case 127:
+				var b [8]byte
+				io.ReadFull(r, b[:])
+				length = int(binary.BigEndian.Uint64(b[:]))
+			}
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			if hdr[0]&0x0F != wsOpText {
+				continue
+			}
+			var msg map[string]interface{}
+			if err := json.Unmarshal(buf, &msg); err != nil {
+				t.Error(err)
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+func (c *testClient) send(method string, params interface{}) int64 {
+	c.seq++
+	b, err := json.Marshal(map[string]interface{}{
+		"id":     c.seq,
+		"method": method,
+		"params": params,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	// client frames must be masked
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | wsOpText}
+	if len(b) < 126 {
+		frame = append(frame, 0x80|byte(len(b)))
+	} else {
+		frame = append(frame, 0x80|126, byte(len(b)>>8), byte(len(b)))
+	}
+	frame = append(frame, mask[:]...)
+	for i, x := range b {
+		frame = append(frame, x^mask[i&3])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.seq
+}
+
+// expect waits for the response to the request with the id (if method is "") or for the event. Events received
+// in the meantime are kept for the subsequent calls.
+func (c *testClient) expect(id int64, method string) map[string]interface{} {
+	match := func(msg map[string]interface{}) bool {
+		if method != "" {
+			return msg["method"] == method
+		}
+		return msg["id"] == float64(id)
+	}
+	var msg map[string]interface{}
+	for idx, m := range c.pending {
+		if match(m) {
+			msg = m
+			c.pending = append(c.pending[:idx], c.pending[idx+1:]...)
+			break
+		}
+	}
+	for msg == nil {
+		select {
+		case m, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatalf("Connection closed while waiting for %d/%q", id, method)
+			}
+			if match(m) {
+				msg = m
+			} else if m["method"] != nil {
+				c.pending = append(c.pending, m)
+			}
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("Timeout waiting for %d/%q", id, method)
+		}
+	}
+	if method != "" {
+		params, _ := msg["params"].(map[string]interface{})
+		return params
+	}
+	if msg["error"] != nil {
+		c.t.Fatalf("Request %d failed: %v", id, msg["error"])
+	}
+	result, _ := msg["result"].(map[string]interface{})
+	return result
+}
+
+func (c *testClient) call(method string, params interface{}) map[string]interface{} {
+	return c.expect(c.send(method, params), "")
+}
+
+func TestInspector(t *testing.T) {
+	const SCRIPT = `var x = 1;
+function f(a) {
+	var y = a + x;
+	return y;
+}
+var res = f(2);
+`
+	vm := goja.New()
+	insp := New(vm)
+	srv := httptest.NewServer(insp)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/json/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || !strings.HasPrefix(list[0]["webSocketDebuggerUrl"].(string), "ws://") {
+		t.Fatalf("Unexpected list: %v", list)
+	}
+
+	c := dialTestClient(t, srv, "/"+insp.id)
+	defer c.conn.Close()
+	c.call("Runtime.enable", nil)
+	c.call("Debugger.enable", nil)
+	bp := c.call("Debugger.setBreakpointByUrl", map[string]interface{}{
+		"url":        "test.js",
+		"lineNumber": 2,
+	})
+	if bp["breakpointId"] == "" {
+		t.Fatalf("Unexpected result: %v", bp)
+	}
+	c.call("Runtime.runIfWaitingForDebugger", nil)
+	if err := insp.WaitForDebugger(gocontext.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := vm.RunScript("test.js", SCRIPT)
+		done <- err
+	}()
+
+	parsed := c.expect(0, "Debugger.scriptParsed")
+	if parsed["url"] != "test.js" {
+		t.Fatalf("Unexpected script: %v", parsed)
+	}
+	src := c.call("Debugger.getScriptSource", map[string]interface{}{"scriptId": parsed["scriptId"]})
+	if src["scriptSource"] != SCRIPT {
+		t.Fatalf("Unexpected source: %v", src)
+	}
+
+	paused := c.expect(0, "Debugger.paused")
+	if hit := paused["hitBreakpoints"].([]interface{}); len(hit) != 1 || hit[0] != bp["breakpointId"] {
+		t.Fatalf("Unexpected hitBreakpoints: %v", hit)
+	}
+	frames := paused["callFrames"].([]interface{})
+	top := frames[0].(map[string]interface{})
+	loc := top["location"].(map[string]interface{})
+	if top["functionName"] != "f" || loc["lineNumber"] != float64(2) {
+		t.Fatalf("Unexpected top frame: %v", top)
+	}
+
+	local := top["scopeChain"].([]interface{})[0].(map[string]interface{})
+	if local["type"] != "local" {
+		t.Fatalf("Unexpected scope: %v", local)
+	}
+	props := c.call("Runtime.getProperties", map[string]interface{}{
+		"objectId": local["object"].(map[string]interface{})["objectId"],
+	})["result"].([]interface{})
+	found := false
+	for _, p := range props {
+		p := p.(map[string]interface{})
+		if p["name"] == "a" {
+			if v := p["value"].(map[string]interface{}); v["type"] != "number" || v["value"] != float64(2) {
+				t.Fatalf("Unexpected property: %v", p)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Variable not found: %v", props)
+	}
+
+	res := c.call("Debugger.evaluateOnCallFrame", map[string]interface{}{
+		"callFrameId": top["callFrameId"],
+		"expression":  "a + x",
+	})
+	if v := res["result"].(map[string]interface{}); v["value"] != float64(3) {
+		t.Fatalf("Unexpected evaluate result: %v", res)
+	}
+
+	c.call("Debugger.stepOver", nil)
+	c.expect(0, "Debugger.resumed")
+	paused = c.expect(0, "Debugger.paused")
+	loc = paused["callFrames"].([]interface{})[0].(map[string]interface{})["location"].(map[string]interface{})
+	if loc["lineNumber"] != float64(3) {
+		t.Fatalf("Unexpected location after step: %v", loc)
+	}
+
+	c.call("Debugger.resume", nil)
+	c.expect(0, "Debugger.resumed")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if res := vm.Get("res"); res.ToInteger() != 3 {
+		t.Fatalf("Unexpected result: %v", res)
+	}
+
+	id := c.send("Debugger.resume", nil)
+	for msg := range c.msgs {
+		if msg["id"] == float64(id) {
+			if msg["error"] == nil {
+				t.Fatalf("Expected an error: %v", msg)
+			}
+			break
+		}
+	}
+}
+
+func TestInspectorDisconnectResumes(t *testing.T) {
+	vm := goja.New()
+	insp := New(vm)
+	srv := httptest.NewServer(insp)
+	defer srv.Close()
+
+	c := dialTestClient(t, srv, "/"+insp.id)
+	c.call("Debugger.enable", nil)
+	done := make(chan error, 1)
+	go func() {
+		_, err := vm.RunString("debugger; 42")
+		done <- err
+	}()
+	c.expect(0, "Debugger.paused")
+	c.conn.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Execution was not resumed")
+	}
+}
+
+func TestInspectorManyPausedRequests(t *testing.T) {
+	vm := goja.New()
+	insp := New(vm)
+	srv := httptest.NewServer(insp)
+	defer srv.Close()
+
+	c := dialTestClient(t, srv, "/"+insp.id)
+	defer c.conn.Close()
+	c.call("Debugger.enable", nil)
+	done := make(chan error, 1)
+	go func() {
+		_, err := vm.RunScript("test.js", "var x = 42; debugger;")
+		done <- err
+	}()
+	c.expect(0, "Debugger.paused")
+	ids := make([]int64, 100)
+	for idx := range ids {
+		ids[idx] = c.send("Runtime.evaluate", map[string]interface{}{"expression": "x"})
+	}
+	for _, id := range ids {
+		if v := c.expect(id, "")["result"].(map[string]interface{}); v["value"] != float64(42) {
+			t.Fatalf("Unexpected evaluate result: %v", v)
+		}
+	}
+	c.call("Debugger.resume", nil)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInspectorRejectsRequests(t *testing.T) {
+	insp := New(goja.New())
+	srv := httptest.NewServer(insp)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		host, origin string
+		status       int
+	}{
+		{"", "", http.StatusOK},
+		{"localhost:9229", "", http.StatusOK},
+		{"[::1]:9229", "devtools://devtools", http.StatusOK},
+		{"evil.example.com:9229", "", http.StatusForbidden},
+		{"", "http://evil.example.com", http.StatusForbidden},
+		{"", "http://127.0.0.1:8080", http.StatusForbidden},
+	} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/json/list", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.host != "" {
+			req.Host = tc.host
+		}
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%q, %q: unexpected status: %s", tc.host, tc.origin, resp.Status)
+		}
+	}
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /%s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n\r\n", insp.id, srv.Listener.Addr())
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Fatalf("Unexpected response: %s %v", resp.Status, resp.Header)
+	}
+}
+
+func TestInspectorProfiler(t *testing.T) {
+	vm := goja.New()
+	insp := New(vm)
+	srv := httptest.NewServer(insp)
+	defer srv.Close()
+
+	c := dialTestClient(t, srv, "/"+insp.id)
+	defer c.conn.Close()
+	c.call("Profiler.enable", nil)
+	c.call("Profiler.start", nil)
+	_, err := vm.RunScript("test.js", `
+	function hot() {
+		var s = 0;
+		for (var i = 0; i < 2e5; i++) {
+			s += i;
+		}
+		return s;
+	}
+	hot();
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := c.call("Profiler.stop", nil)["profile"].(map[string]interface{})
+	nodes := p["nodes"].([]interface{})
+	if root := nodes[0].(map[string]interface{}); root["callFrame"].(map[string]interface{})["functionName"] != "(root)" {
+		t.Fatalf("Unexpected root: %v", root)
+	}
+	found := false
+	for _, n := range nodes {
+		n := n.(map[string]interface{})
+		cf := n["callFrame"].(map[string]interface{})
+		if cf["functionName"] == "hot" && cf["url"] == "test.js" && n["hitCount"].(float64) > 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Function not found: %v", nodes)
+	}
+	if samples := p["samples"].([]interface{}); len(samples) == 0 || len(samples) != len(p["timeDeltas"].([]interface{})) {
+		t.Fatalf("Unexpected samples: %v", p)
+	}
+
+	id := c.send("Profiler.stop", nil)
+	for msg := range c.msgs {
+		if msg["id"] == float64(id) {
+			if msg["error"] == nil {
+				t.Fatalf("Expected an error: %v", msg)
+			}
+			break
+		}
+	}
+}
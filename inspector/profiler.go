@@ -0,0 +1,126 @@
+package inspector
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+type callFrame struct {
+	FunctionName string `json:"functionName"`
+	ScriptId     string `json:"scriptId"`
+	Url          string `json:"url"`
+	LineNumber   int    `json:"lineNumber"`
+	ColumnNumber int    `json:"columnNumber"`
+}
+
+type positionTick struct {
+	Line  int   `json:"line"`
+	Ticks int64 `json:"ticks"`
+}
+
+type profileNode struct {
+	Id            int            `json:"id"`
+	CallFrame     callFrame      `json:"callFrame"`
+	HitCount      int64          `json:"hitCount"`
+	Children      []int          `json:"children,omitempty"`
+	PositionTicks []positionTick `json:"positionTicks,omitempty"`
+
+	children map[profileNodeKey]*profileNode
+	ticks    map[int]int64
+}
+
+type profileNodeKey struct {
+	name, filename string
+}
+
+type profile struct {
+	Nodes      []*profileNode `json:"nodes"`
+	StartTime  int64          `json:"startTime"`
+	EndTime    int64          `json:"endTime"`
+	Samples    []int          `json:"samples"`
+	TimeDeltas []int64        `json:"timeDeltas"`
+}
+
+func (p *profile) addNode(cf callFrame) *profileNode {
+	n := &profileNode{
+		Id:        len(p.Nodes) + 1,
+		CallFrame: cf,
+		children:  make(map[profileNodeKey]*profileNode),
+		ticks:     make(map[int]int64),
+	}
+	p.Nodes = append(p.Nodes, n)
+	return n
+}
+
+// cdpProfile converts the goja profile into a Profiler.Profile. The functions are identified by their names and
+// source files, and the call frame positions are those of the first sample. Since goja only records how many times
+// each call stack has been sampled, the samples are not in the chronological order.
+func (i *Inspector) cdpProfile(gp *goja.Profile) *profile {
+	start := gp.Start.UnixNano() / int64(time.Microsecond)
+	p := &profile{
+		StartTime:  start,
+		EndTime:    start + int64(gp.Duration/time.Microsecond),
+		Samples:    []int{},
+		TimeDeltas: []int64{},
+	}
+	root := p.addNode(callFrame{
+		FunctionName: "(root)",
+		ScriptId:     "0",
+		LineNumber:   -1,
+		ColumnNumber: -1,
+	})
+	delta := int64(goja.ProfilePeriod / time.Microsecond)
+	for _, s := range gp.Samples {
+		n := root
+		for idx := len(s.Stack) - 1; idx >= 0; idx-- {
+			f := &s.Stack[idx]
+			key := profileNodeKey{name: f.FuncName, filename: f.Filename}
+			child := n.children[key]
+			if child == nil {
+				child = p.addNode(i.profileCallFrame(f))
+				n.children[key] = child
+				n.Children = append(n.Children, child.Id)
+			}
+			n = child
+		}
+		n.HitCount += s.Count
+		if len(s.Stack) > 0 && s.Stack[0].Line > 0 {
+			n.ticks[s.Stack[0].Line] += s.Count
+		}
+		for c := int64(0); c < s.Count; c++ {
+			p.Samples = append(p.Samples, n.Id)
+			p.TimeDeltas = append(p.TimeDeltas, delta)
+		}
+	}
+	for _, n := range p.Nodes {
+		for line, ticks := range n.ticks {
+			n.PositionTicks = append(n.PositionTicks, positionTick{Line: line, Ticks: ticks})
+		}
+		sort.Slice(n.PositionTicks, func(a, b int) bool {
+			return n.PositionTicks[a].Line < n.PositionTicks[b].Line
+		})
+	}
+	return p
+}
+
+func (i *Inspector) profileCallFrame(f *goja.ProfileFrame) callFrame {
+	name := f.FuncName
+	if name == "<anonymous>" {
+		name = ""
+	}
+	cf := callFrame{
+		FunctionName: name,
+		ScriptId:     "0",
+		LineNumber:   f.Line - 1,
+		ColumnNumber: f.Column - 1,
+	}
+	i.mu.Lock()
+	if sc := i.scripts[f.Filename]; sc != nil {
+		cf.ScriptId = sc.id
+		cf.Url = sc.url
+	}
+	i.mu.Unlock()
+	return cf
+}
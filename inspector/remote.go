@@ -0,0 +1,101 @@
+package inspector
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/dop251/goja"
+)
+
+// remoteObject is a CDP Runtime.RemoteObject.
+type remoteObject struct {
+	Type                string          `json:"type"`
+	Subtype             string          `json:"subtype,omitempty"`
+	ClassName           string          `json:"className,omitempty"`
+	Value               json.RawMessage `json:"value,omitempty"`
+	UnserializableValue string          `json:"unserializableValue,omitempty"`
+	Description         string          `json:"description,omitempty"`
+	ObjectId            string          `json:"objectId,omitempty"`
+}
+
+type propertyDescriptor struct {
+	Name         string        `json:"name"`
+	Value        *remoteObject `json:"value,omitempty"`
+	Writable     bool          `json:"writable"`
+	Configurable bool          `json:"configurable"`
+	Enumerable   bool          `json:"enumerable"`
+	IsOwn        bool          `json:"isOwn"`
+}
+
+func jsonValue(v interface{}) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+var objectSubtypes = map[string]string{
+	"Array":   "array",
+	"Error":   "error",
+	"RegExp":  "regexp",
+	"Date":    "date",
+	"Map":     "map",
+	"Set":     "set",
+	"Promise": "promise",
+}
+
+// remote converts a value into a RemoteObject. Objects are registered in the pause state so that
+// their properties can be requested. It must not run any JavaScript code.
+func (p *pauseState) remote(v goja.Value) *remoteObject {
+	switch v := v.(type) {
+	case nil:
+		return &remoteObject{Type: "undefined", Description: "<uninitialized>"}
+	case *goja.Object:
+		cls := v.ClassName()
+		o := &remoteObject{
+			Type:        "object",
+			ClassName:   cls,
+			Subtype:     objectSubtypes[cls],
+			Description: cls,
+			ObjectId:    p.addObject(v),
+		}
+		if _, ok := goja.AssertFunction(v); ok {
+			o.Type = "function"
+			o.Subtype = ""
+			o.Description = "function"
+		} else if cls == "Array" {
+			if a, ok := v.Export().([]interface{}); ok {
+				o.Description = "Array(" + strconv.Itoa(len(a)) + ")"
+			}
+		}
+		return o
+	case *goja.Symbol:
+		return &remoteObject{Type: "symbol", Description: v.String()}
+	}
+	if goja.IsUndefined(v) {
+		return &remoteObject{Type: "undefined"}
+	}
+	if goja.IsNull(v) {
+		return &remoteObject{Type: "object", Subtype: "null", Value: json.RawMessage("null")}
+	}
+	switch e := v.Export().(type) {
+	case string:
+		return &remoteObject{Type: "string", Value: jsonValue(e)}
+	case bool:
+		return &remoteObject{Type: "boolean", Value: jsonValue(e)}
+	case int64:
+		return &remoteObject{Type: "number", Value: jsonValue(e), Description: v.String()}
+	case float64:
+		o := &remoteObject{Type: "number", Description: v.String()}
+		switch {
+		case math.IsNaN(e), math.IsInf(e, 0):
+			o.UnserializableValue = v.String()
+		case e == 0 && math.Signbit(e):
+			o.UnserializableValue = "-0"
+			o.Description = "-0"
+		default:
+			o.Value = jsonValue(e)
+		}
+		return o
+	}
+	return &remoteObject{Type: "object", Description: v.String()}
+}
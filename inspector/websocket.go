@@ -0,0 +1,222 @@
+package inspector
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A minimal server-side WebSocket (RFC 6455) implementation, sufficient for the DevTools protocol:
+// text messages, fragmentation, ping/pong and close. Extensions and subprotocols are not supported.
+
+const (
+	wsOpContinuation = 0
+	wsOpText         = 1
+	wsOpBinary       = 2
+	wsOpClose        = 8
+	wsOpPing         = 9
+	wsOpPong         = 10
+
+	wsMaxMessageSize = 64 << 20
+
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var (
+	errWsMessageTooBig = errors.New("websocket: message too big")
+	errWsClosed        = errors.New("websocket: connection closed")
+)
+
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+func wsAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func wsUpgrade(w http.ResponseWriter, req *http.Request) (*wsConn, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet || !headerContains(req.Header, "Upgrade", "websocket") ||
+		!headerContains(req.Header, "Connection", "upgrade") || key == "" {
+		http.Error(w, "Bad WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("websocket: bad handshake")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket is not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: the response writer does not support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	rw.WriteString(wsAccept(key))
+	rw.WriteString("\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{
+		conn: conn,
+		r:    rw.Reader,
+	}, nil
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.r, hdr[:]); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	opcode = hdr[0] & 0x0F
+	masked := hdr[1]&0x80 != 0
+	length := uint64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.r, b[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.r, b[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	if length > wsMaxMessageSize {
+		err = errWsMessageTooBig
+		return
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i&3]
+		}
+	}
+	return
+}
+
+// ReadMessage returns the next text or binary message. Returns io.EOF when the connection is closed by the peer.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.writeFrame(wsOpClose, nil)
+			return nil, io.EOF
+		case wsOpText, wsOpBinary:
+			if started {
+				return nil, errors.New("websocket: unexpected data frame")
+			}
+			started = true
+			msg = payload
+		case wsOpContinuation:
+			if !started {
+				return nil, errors.New("websocket: unexpected continuation frame")
+			}
+			if len(msg)+len(payload) > wsMaxMessageSize {
+				return nil, errWsMessageTooBig
+			}
+			msg = append(msg, payload...)
+		default:
+			return nil, errors.New("websocket: unknown opcode")
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// WriteMessage sends a text message. It is safe for concurrent use.
+func (c *wsConn) WriteMessage(data []byte) error {
+	return c.writeFrame(wsOpText, data)
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return errWsClosed
+	}
+	var hdr [10]byte
+	hdr[0] = 0x80 | opcode
+	n := 2
+	switch l := len(payload); {
+	case l < 126:
+		hdr[1] = byte(l)
+	case l <= 0xFFFF:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n = 10
+	}
+	if _, err := c.conn.Write(hdr[:n]); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	if opcode == wsOpClose {
+		c.closed = true
+	}
+	return err
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,372 @@
+package goja
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja/unistring"
+)
+
+const classCompartment = "Compartment"
+
+// LockdownOptions configures Runtime.Lockdown().
+type LockdownOptions struct {
+	// RandSource is used by Math.random() after the lockdown. If nil, Math.random() throws a TypeError.
+	RandSource RandSource
+
+	// TimeSource is used by Date.now() and new Date() after the lockdown. If nil, they throw a TypeError.
+	TimeSource Now
+}
+
+type lockdownGlobal struct {
+	name  unistring.String
+	value Value
+}
+
+type lockdownState struct {
+	// intrinsics contains all objects frozen by the lockdown, they are skipped by harden()
+	intrinsics map[*Object]struct{}
+
+	// globals are the shared properties of the global object every Compartment starts with
+	globals []lockdownGlobal
+
+	compartment, compartmentProto *Object
+	terminator                    *Object
+}
+
+type compartmentObject struct {
+	baseObject
+	global *Object
+	scope  *stash
+}
+
+var errLockedDown = errors.New("goja: Lockdown() has already been called")
+
+var (
+	standardGlobalNamesOnce sync.Once
+	standardGlobalNames     []unistring.String
+)
+
+// getStandardGlobalNames returns the names of the global object properties of a pristine Runtime.
+func getStandardGlobalNames() []unistring.String {
+	standardGlobalNamesOnce.Do(func() {
+		r := New()
+		forceLazy(r.globalObject)
+		standardGlobalNames = append([]unistring.String(nil), r.globalObject.self.(*baseObject).propNames...)
+	})
+	return standardGlobalNames
+}
+
+// Lockdown turns the Runtime into a hardened JavaScript environment, so that the code loaded by different parties
+// (e.g. plugins) can share it without being able to interfere with each other:
+//
+//   - all built-in objects (including the ones which are not directly reachable, such as %ArrayIteratorPrototype%)
+//     and everything reachable from them are frozen, so that for example Array.prototype cannot be modified;
+//   - Math.random() and the current time are only available if provided in the options;
+//   - Function.prototype.constructor is replaced with a function that throws a TypeError, so the Function
+//     constructor cannot be reached from the functions defined in a Compartment;
+//   - harden() and Compartment are added to the global object.
+//
+// harden(value) freezes the value and everything reachable from it through the properties and the prototypes and
+// returns the value.
+//
+// new Compartment(endowments) creates a separate global object which contains the shared (frozen) standard
+// built-ins, its own globalThis, eval and Function, and the own enumerable properties of endowments.
+// compartment.evaluate(src) runs src as strict mode code in the scope of that global object (which is also the
+// value of 'this' at the top level). The var and function declarations are local to the evaluation. As in SES, a
+// reference to a name that is not a property of the Compartment's global object throws a ReferenceError, including
+// typeof.
+//
+// The global object itself is not frozen and any values added to it by the host before the lockdown are left
+// intact unless they are reachable from the built-ins. Note that assigning to a property of an object which
+// inherits a property with the same name from a frozen prototype (e.g. obj.toString = ...) fails. Use
+// Object.defineProperty() instead.
+//
+// The lockdown cannot be undone. It should be performed before running any untrusted code. ShadowRealm instances
+// have their own built-ins which are not affected.
+func (r *Runtime) Lockdown(opts *LockdownOptions) error {
+	if r.lockdown != nil {
+		return errLockedDown
+	}
+	if opts == nil {
+		opts = &LockdownOptions{}
+	}
+	return r.try(func() {
+		ld := &lockdownState{
+			intrinsics: make(map[*Object]struct{}),
+		}
+		r.initCompartment(ld)
+		r.addToGlobal("harden", r.newNativeFunc(r.builtin_harden, nil, "harden", nil, 1))
+		r.addToGlobal("Compartment", ld.compartment)
+
+		fp := r.global.FunctionPrototype
+		inert := &Object{runtime: r}
+		inert.self = r.newNativeFuncConstructObj(inert, func(args []Value, proto *Object) *Object {
+			panic(r.NewTypeError("Function.prototype.constructor is not a valid constructor"))
+		}, "Function", fp, 1)
+		fp.self._putProp("constructor", inert, true, false, true)
+
+		forceLazy(r.globalObject)
+		for _, name := range getStandardGlobalNames() {
+			if name == "globalThis" || name == "eval" || name == "Function" {
+				continue
+			}
+			if v := r.globalObject.self.getOwnPropStr(name); v != nil {
+				ld.globals = append(ld.globals, lockdownGlobal{name: name, value: v})
+			}
+		}
+		for _, name := range []unistring.String{"harden", "Compartment"} {
+			ld.globals = append(ld.globals, lockdownGlobal{name: name, value: r.globalObject.self.getOwnPropStr(name)})
+		}
+
+		for _, g := range ld.globals {
+			v := g.value
+			if prop, ok := v.(*valueProperty); ok {
+				v = prop.value
+			}
+			r.hardenValue(v, ld.intrinsics)
+		}
+		// hardening may initialise built-ins which set more fields of the global struct
+		for n := -1; n != len(ld.intrinsics); {
+			n = len(ld.intrinsics)
+			for _, o := range r.globalFields() {
+				r.hardenValue(o, ld.intrinsics)
+			}
+		}
+
+		if opts.RandSource != nil {
+			r.rand = opts.RandSource
+		} else {
+			r.rand = func() float64 {
+				panic(r.NewTypeError("Math.random() is not available after Lockdown()"))
+			}
+		}
+		if opts.TimeSource != nil {
+			r.now = opts.TimeSource
+		} else {
+			r.now = func() time.Time {
+				panic(r.NewTypeError("The current time is not available after Lockdown()"))
+			}
+		}
+		r.lockdown = ld
+	})
+}
+
+// globalFields returns the non-nil *Object fields of the global struct.
+func (r *Runtime) globalFields() []*Object {
+	var objs []*Object
+	g := reflect.ValueOf(r.global).Elem()
+	for i := 0; i < g.NumField(); i++ {
+		if f := g.Field(i); f.Type() == typeObject {
+			if o := exposeField(f).Interface().(*Object); o != nil {
+				objs = append(objs, o)
+			}
+		}
+	}
+	return objs
+}
+
+// hardenValue freezes the value and all objects reachable from it. The objects in seen (or the ones frozen by the
+// lockdown) are skipped, the frozen ones are added to seen.
+func (r *Runtime) hardenValue(v Value, seen map[*Object]struct{}) {
+	o, ok := v.(*Object)
+	if !ok {
+		return
+	}
+	var intrinsics map[*Object]struct{}
+	if r.lockdown != nil {
+		intrinsics = r.lockdown.intrinsics
+	}
+	queue := []*Object{o}
+	push := func(v Value) {
+		if o, ok := v.(*Object); ok {
+			queue = append(queue, o)
+		}
+	}
+	for len(queue) > 0 {
+		o := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if _, exists := seen[o]; exists {
+			continue
+		}
+		if _, exists := intrinsics[o]; exists {
+			continue
+		}
+		seen[o] = struct{}{}
+		freezeObject(o)
+		if proto := o.self.proto(); proto != nil {
+			queue = append(queue, proto)
+		}
+		for item, next := o.self.iterateKeys()(); next != nil; item, next = next() {
+			v := item.value
+			if v == nil {
+				v = o.getOwnProp(item.name)
+			}
+			if prop, ok := v.(*valueProperty); ok {
+				if prop.accessor {
+					if prop.getterFunc != nil {
+						queue = append(queue, prop.getterFunc)
+					}
+					if prop.setterFunc != nil {
+						queue = append(queue, prop.setterFunc)
+					}
+					continue
+				}
+				v = prop.value
+			}
+			push(v)
+		}
+	}
+}
+
+func (r *Runtime) builtin_harden(call FunctionCall) Value {
+	arg := call.Argument(0)
+	r.hardenValue(arg, make(map[*Object]struct{}))
+	return arg
+}
+
+func (r *Runtime) compartmentProtoThis(call FunctionCall, name string) *compartmentObject {
+	thisObj := r.toObject(call.This)
+	if c, ok := thisObj.self.(*compartmentObject); ok {
+		return c
+	}
+	panic(r.NewTypeError("Method Compartment.prototype.%s called on incompatible receiver %s", name, r.objectproto_toString(FunctionCall{This: thisObj})))
+}
+
+// compartmentEval runs the source as strict mode eval code in the scope of the Compartment's global object.
+func (r *Runtime) compartmentEval(c *compartmentObject, srcVal valueString) Value {
+	src := escapeInvalidUtf16(srcVal)
+	vm := r.vm
+	vm.pushCtx()
+	vm.stash = c.scope
+	vm.privEnv = nil
+	p, err := r.compile("<eval>", src, true, false, vm)
+	if err != nil {
+		panic(err)
+	}
+
+	vm.prg = p
+	vm.pc = 0
+	vm.args = 0
+	vm.result = _undefined
+	vm.push(_undefined)
+	vm.sb = vm.sp
+	vm.push(nil) // this
+	vm.run()
+	retval := vm.result
+	vm.popCtx()
+	vm.halt = false
+	vm.sp -= 2
+	return retval
+}
+
+func (r *Runtime) compartmentProto_evaluate(call FunctionCall) Value {
+	c := r.compartmentProtoThis(call, "evaluate")
+	src, ok := call.Argument(0).(valueString)
+	if !ok {
+		panic(r.NewTypeError("Compartment.prototype.evaluate: source must be a string"))
+	}
+	r.checkDynamicCode(DynamicCodeEval, src)
+	return r.compartmentEval(c, src)
+}
+
+func (r *Runtime) compartmentProto_getGlobalThis(call FunctionCall) Value {
+	return r.compartmentProtoThis(call, "globalThis").global
+}
+
+func (r *Runtime) newCompartmentGlobal(c *compartmentObject) *Object {
+	g := r.newBaseObject(r.global.ObjectPrototype, classObject)
+	for _, item := range r.lockdown.globals {
+		v := item.value
+		if prop, ok := v.(*valueProperty); ok {
+			cp := *prop
+			v = &cp
+		}
+		g._put(item.name, v)
+	}
+	g._putProp("globalThis", g.val, true, false, true)
+	g._putProp("eval", r.newNativeFunc(func(call FunctionCall) Value {
+		src, ok := call.Argument(0).(valueString)
+		if !ok {
+			return call.Argument(0)
+		}
+		r.checkDynamicCode(DynamicCodeEval, src)
+		return r.compartmentEval(c, src)
+	}, nil, "eval", nil, 1), true, false, true)
+	fn := &Object{runtime: r}
+	fn.self = r.newNativeFuncConstructObj(fn, func(args []Value, proto *Object) *Object {
+		src := functionSource(args)
+		r.checkDynamicCode(DynamicCodeFunction, src)
+		ret := r.toObject(r.compartmentEval(c, src))
+		ret.self.setProto(proto, true)
+		return ret
+	}, "Function", r.global.FunctionPrototype, 1)
+	g._putProp("Function", fn, true, false, true)
+	return g.val
+}
+
+func (r *Runtime) builtin_newCompartment(args []Value, newTarget *Object) *Object {
+	if newTarget == nil {
+		panic(r.needNew("Compartment"))
+	}
+	ld := r.lockdown
+	proto := r.getPrototypeFromCtor(newTarget, ld.compartment, ld.compartmentProto)
+	o := &Object{runtime: r}
+	c := &compartmentObject{}
+	c.class = classObject
+	c.val = o
+	c.extensible = true
+	o.self = c
+	c.prototype = proto
+	c.init()
+	c.global = r.newCompartmentGlobal(c)
+	c.scope = &stash{
+		obj: c.global,
+		outer: &stash{
+			// the value of 'this' at the top level
+			values: []Value{c.global},
+			names:  map[unistring.String]uint32{thisBindingName: 0},
+			outer:  &stash{obj: ld.terminator},
+		},
+	}
+	if len(args) > 0 && args[0] != _undefined && args[0] != _null {
+		source := args[0].ToObject(r)
+		for item, next := iterateEnumerableProperties(source)(); next != nil; item, next = next() {
+			c.global.setOwn(item.name, item.value, true)
+		}
+	}
+	return o
+}
+
+func (r *Runtime) initCompartment(ld *lockdownState) {
+	ld.compartmentProto = r.newBaseObject(r.global.ObjectPrototype, classObject).val
+	ld.compartment = &Object{runtime: r}
+	r.newNativeConstructOnly(ld.compartment, r.builtin_newCompartment, ld.compartmentProto, "Compartment", 0)
+	o := ld.compartmentProto.self
+	o._putProp("constructor", ld.compartment, true, false, true)
+	o._putProp("evaluate", r.newNativeFunc(r.compartmentProto_evaluate, nil, "evaluate", nil, 1), true, false, true)
+	o.setOwnStr("globalThis", &valueProperty{
+		getterFunc:   r.newNativeFunc(r.compartmentProto_getGlobalThis, nil, "get globalThis", nil, 0),
+		accessor:     true,
+		configurable: true,
+	}, true)
+	o._putSym(SymToStringTag, valueProp(asciiString(classCompartment), false, false, true))
+
+	// terminates the scope chain of the Compartments, so that the names which are not found in the Compartment's
+	// global object are not looked up in the global object of the Runtime
+	ld.terminator = r.NewProxy(r.newBaseObject(nil, classObject).val, &ProxyTrapConfig{
+		Has: func(target *Object, property string) bool {
+			return true
+		},
+		Get: func(target *Object, property string, receiver Value) Value {
+			r.throwReferenceError(unistring.NewFromString(property))
+			return nil
+		},
+		Set: func(target *Object, property string, value Value, receiver Value) bool {
+			r.throwReferenceError(unistring.NewFromString(property))
+			return false
+		},
+	}).proxy.val
+}
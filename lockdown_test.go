@@ -0,0 +1,134 @@
+package goja
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockdown(t *testing.T) {
+	r := New()
+	if _, err := r.RunProgram(testLib()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RunString(`var hostState = { count: 0 };`); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Lockdown(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Lockdown(nil); err != errLockedDown {
+		t.Fatal(err)
+	}
+	const SCRIPT = `
+	"use strict";
+	assert(Object.isFrozen(Array.prototype), "Array.prototype");
+	assert(Object.isFrozen(Array.prototype.push), "Array.prototype.push");
+	assert(Object.isFrozen(Object.getPrototypeOf([][Symbol.iterator]())), "%ArrayIteratorPrototype%");
+	assert(Object.isFrozen(Math), "Math");
+	assert(Object.isFrozen(Compartment.prototype), "Compartment.prototype");
+	assert.throws(TypeError, () => { Array.prototype.last = null; });
+	assert.throws(TypeError, () => { Object.prototype.polluted = true; });
+	assert.throws(TypeError, () => Math.random());
+	assert.throws(TypeError, () => Date.now());
+	assert.throws(TypeError, () => new Date());
+	assert.sameValue(new Date(0).getTime(), 0);
+	assert.throws(TypeError, () => (function() {}).constructor("return 1"));
+	assert.sameValue(Function("return 1")(), 1);
+
+	assert(!Object.isFrozen(globalThis), "globalThis");
+	hostState.count++;
+	assert.sameValue(hostState.count, 1);
+
+	var obj = harden({ nested: { arr: [1, 2] }, get acc() { return 1; } });
+	assert.sameValue(harden(42), 42);
+	assert(Object.isFrozen(obj), "obj");
+	assert(Object.isFrozen(obj.nested.arr), "obj.nested.arr");
+	assert(Object.isFrozen(Object.getOwnPropertyDescriptor(obj, "acc").get), "getter");
+	`
+	if _, err := r.RunString(SCRIPT); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockdownOptions(t *testing.T) {
+	r := New()
+	err := r.Lockdown(&LockdownOptions{
+		RandSource: func() float64 {
+			return 0.5
+		},
+		TimeSource: func() time.Time {
+			return time.Unix(1, 0)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := r.RunString(`Math.random() + ":" + Date.now() + ":" + new Date().getTime()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "0.5:1000:1000" {
+		t.Fatal(res)
+	}
+}
+
+func TestCompartment(t *testing.T) {
+	r := New()
+	if _, err := r.RunProgram(testLib()); err != nil {
+		t.Fatal(err)
+	}
+	r.Set("secret", "host")
+	if err := r.Lockdown(nil); err != nil {
+		t.Fatal(err)
+	}
+	const SCRIPT = `
+	const c1 = new Compartment({ x: 40 });
+	const c2 = new Compartment();
+	assert.sameValue(c1.evaluate("x + 2"), 42);
+	assert.sameValue(c1.evaluate("Array"), Array, "the intrinsics are shared");
+	assert.sameValue(c1.evaluate("globalThis"), c1.globalThis);
+	assert.sameValue(c1.evaluate("this"), c1.globalThis);
+	assert(c1.globalThis !== globalThis);
+	assert(c1.globalThis !== c2.globalThis);
+
+	// the globals of the Runtime and the other Compartments cannot be reached
+	assert.throws(ReferenceError, () => c1.evaluate("secret"));
+	assert.throws(ReferenceError, () => c1.evaluate("typeof secret"));
+	assert.throws(ReferenceError, () => c1.evaluate("undeclared = 1"));
+	c1.evaluate("globalThis.shared = 1");
+	assert.sameValue(c1.evaluate("shared"), 1);
+	assert.throws(ReferenceError, () => c2.evaluate("shared"));
+	assert.sameValue(typeof shared, "undefined");
+
+	// the declarations are local to the evaluation
+	assert.sameValue(c1.evaluate("var v = 1; function f() { return v; } f()"), 1);
+	assert.sameValue(c1.globalThis.v, undefined);
+
+	// the code is strict
+	assert.throws(TypeError, () => c1.evaluate("Array.prototype.x = 1"));
+	assert.sameValue(c1.evaluate("(function() { return this; })()"), undefined);
+
+	// eval and Function evaluate in the Compartment
+	assert.sameValue(c1.evaluate("eval('x')"), 40);
+	assert.sameValue(c1.evaluate("(0, eval)('x')"), 40);
+	assert.sameValue(c1.evaluate("new Function('a', 'return a + x')(2)"), 42);
+	assert.sameValue(c1.evaluate("Function.prototype"), Function.prototype);
+	assert.throws(TypeError, () => c1.evaluate("(() => {}).constructor('return secret')"));
+	assert.sameValue(c1.evaluate("new Compartment({ y: 1 }).evaluate('y')"), 1);
+
+	assert.throws(TypeError, () => Compartment());
+	assert.throws(TypeError, () => c1.evaluate(1));
+	assert.throws(TypeError, () => Compartment.prototype.evaluate.call({}, "1"));
+	assert.sameValue(String(c1), "[object Compartment]");
+	`
+	if _, err := r.RunString(SCRIPT); err != nil {
+		t.Fatal(err)
+	}
+
+	r.SetDynamicCodePolicy(func(kind DynamicCodeKind, src string) bool {
+		return false
+	})
+	if _, err := r.RunString(`new Compartment().evaluate("1")`); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
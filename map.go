@@ -16,6 +16,9 @@ type orderedMap struct {
 	hashTable           map[uint64]*mapEntry
 	iterFirst, iterLast *mapEntry
 	size                int
+
+	// if set, new entries are added to the Runtime's memory usage
+	runtime *Runtime
 }
 
 type orderedMapIter struct {
@@ -38,6 +41,9 @@ func (m *orderedMap) set(key, value Value) {
 	if entry != nil {
 		entry.value = value
 	} else {
+		if m.runtime != nil {
+			m.runtime.allocMem(memMapEntrySize)
+		}
 		if key == _negativeZero {
 			key = intToValue(0)
 		}
@@ -154,6 +160,15 @@ func (m *orderedMap) newIter() *orderedMapIter {
 	return iter
 }
 
+func (m *orderedMap) copy() *orderedMap {
+	c := newOrderedMap(m.hash)
+	c.runtime = m.runtime
+	for item := m.iterFirst; item != nil; item = item.iterNext {
+		c.set(item.key, item.value)
+	}
+	return c
+}
+
 func (m *orderedMap) clear() {
 	for item := m.iterFirst; item != nil; item = item.iterNext {
 		item.key = nil
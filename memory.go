@@ -0,0 +1,494 @@
+package goja
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// Approximate sizes (in bytes) used for memory accounting.
+const (
+	memValueSize       = 16 // an interface value
+	memObjectSize      = 96 // an Object with its baseObject and the property map header
+	memPropertySize    = 48 // a property map entry and its name
+	memSparseItemSize  = 24 // a sparseArrayItem
+	memMapEntrySize    = 72 // a mapEntry and its hash table slot
+	memUnicodeCharSize = 2
+
+	// strings of at least this many characters are only counted once, no matter how many times they are referenced
+	memSharedStringLen = 64
+
+	// once the live heap has been measured, the next measurement is made after 1/memCheckRatio of the limit
+	// has been allocated (or when the limit is reached, whichever is later)
+	memCheckRatio = 8
+)
+
+// MemoryUsage contains the approximate size (in bytes) of the live heap of a Runtime, broken down by the kind
+// of allocation. See Runtime.MemoryUsage() and Runtime.SetMemoryLimit().
+type MemoryUsage struct {
+	// Strings is the size of strings.
+	Strings uint64
+	// Arrays is the size of array elements storage.
+	Arrays uint64
+	// Objects is the size of objects, their own properties and the variables captured by closures.
+	Objects uint64
+	// ArrayBuffers is the size of ArrayBuffer data.
+	ArrayBuffers uint64
+	// Maps is the size of Map and Set entries.
+	Maps uint64
+}
+
+// Total returns the sum of all the counters.
+func (u MemoryUsage) Total() uint64 {
+	return addMemSat(addMemSat(addMemSat(addMemSat(u.Strings, u.Arrays), u.Objects), u.ArrayBuffers), u.Maps)
+}
+
+// MemoryLimitExceededError is returned by RunProgram or by a Callable call when the limit set with
+// SetMemoryLimit() has been exceeded. It cannot be caught by JavaScript code.
+type MemoryLimitExceededError struct {
+	Exception
+	limit uint64
+}
+
+// Limit returns the memory limit that was in effect.
+func (e *MemoryLimitExceededError) Limit() uint64 {
+	return e.limit
+}
+
+func (e *MemoryLimitExceededError) String() string {
+	if e == nil {
+		return "<nil>"
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "out of memory: limit (%d bytes) exceeded\n", e.limit)
+	e.writeFullStack(&b)
+	return b.String()
+}
+
+func (e *MemoryLimitExceededError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "out of memory: limit (%d bytes) exceeded", e.limit)
+	e.writeShortStack(&b)
+	return b.String()
+}
+
+func addMemSat(a, b uint64) uint64 {
+	if c := a + b; c >= a {
+		return c
+	}
+	return ^uint64(0)
+}
+
+// allocMem accounts for an allocation of n bytes. It does nothing unless a memory limit is set. It should be called
+// before the allocation takes place where possible, so that a single large allocation is prevented rather than
+// detected.
+// The allocated memory is never subtracted, instead, once the allocations exceed the limit, the live heap is
+// measured (see measureMemory()) and an uncatchable MemoryLimitExceededError is thrown if the live heap and
+// the pending allocation exceed the limit. This is only done while the vm is running: the allocations made by
+// the host (for example with NewObject() or ToValue()) outside of JavaScript code never fail.
+func (r *Runtime) allocMem(n int64) {
+	if r.memLimit == 0 || n <= 0 {
+		return
+	}
+	r.memAllocated = addMemSat(r.memAllocated, uint64(n))
+	if r.memAllocated > r.memNextCheck && r.vm.running > 0 {
+		r.checkMemLimit(uint64(n))
+	}
+}
+
+func (r *Runtime) checkMemLimit(pending uint64) {
+	live := addMemSat(r.measureMemory().Total(), pending)
+	if live > r.memLimit {
+		ex := &MemoryLimitExceededError{
+			limit: r.memLimit,
+		}
+		ex.stack = r.vm.captureStack(nil, 0)
+		panic(&uncatchableException{
+			err: ex,
+		})
+	}
+	r.memAllocated = live
+	r.memNextCheck = addMemSat(live, r.memLimit/memCheckRatio)
+	if r.memNextCheck < r.memLimit {
+		r.memNextCheck = r.memLimit
+	}
+}
+
+func (r *Runtime) allocStringMem(length int64, ascii bool) {
+	if !ascii {
+		if length > (1<<63-1)/memUnicodeCharSize {
+			length = 1<<63 - 1
+		} else {
+			length *= memUnicodeCharSize
+		}
+	}
+	r.allocMem(length)
+}
+
+// allocString accounts for a string created by a built-in function (once it has been created, because its size
+// is not known in advance) and returns it.
+func (r *Runtime) allocString(s valueString) valueString {
+	if r.memLimit != 0 {
+		_, ascii := s.(asciiString)
+		r.allocStringMem(int64(s.length()), ascii)
+	}
+	return s
+}
+
+// SetMemoryLimit sets the maximum approximate size (in bytes) of the live heap of the Runtime. The size covers
+// strings, array storage, objects and their properties, variables captured by closures, ArrayBuffer data and
+// Map/Set entries which are reachable from the global objects of the realms or from the code that is running.
+// The values that are only referenced from Go (including the realms created by NewRealm() while no code is running
+// in them) are not included.
+// The memory allocated by the Runtime is accounted for as it is allocated, and once the allocations exceed
+// the limit the live heap is measured. Because this requires traversing the heap, the next measurement is
+// only made after another 1/8 of the limit has been allocated, so the live heap may exceed the limit by up to
+// this amount. When the limit is exceeded by JavaScript code the execution is stopped and
+// a *MemoryLimitExceededError is returned by RunProgram or by a Callable call. Where possible, the check is done
+// before the allocation, so for example "x".repeat(1e9) fails without allocating the string. The allocations made
+// by the host outside of JavaScript code (such as NewObject() or ToValue()) never fail.
+// Setting the limit to 0 removes it. No accounting is done while there is no limit.
+// This method (as the rest of the Set* methods) is not safe for concurrent use and may only be called
+// from the vm goroutine or when the vm is not running.
+func (r *Runtime) SetMemoryLimit(bytes uint64) {
+	r.memLimit = bytes
+	// the allocations made while there was no limit are unknown, so measure on the next allocation
+	r.memAllocated = 0
+	r.memNextCheck = 0
+}
+
+// MemoryUsage returns the approximate size of the live heap of the Runtime (see SetMemoryLimit() for what is
+// included). Note this includes the built-in objects created by the Runtime itself. The heap is traversed every
+// time this method is called.
+func (r *Runtime) MemoryUsage() MemoryUsage {
+	return r.measureMemory()
+}
+
+// memMeter measures the approximate size of the heap reachable from a set of roots.
+type memMeter struct {
+	usage   MemoryUsage
+	objs    map[*Object]struct{}
+	stashes map[*stash]struct{}
+	realms  map[*Realm]struct{}
+	maps    map[*orderedMap]struct{}
+	strings map[uintptr]struct{}
+	queue   []*Object
+}
+
+// measureMemory measures the heap which is reachable from the main and the current realms and from the vm stacks.
+func (r *Runtime) measureMemory() MemoryUsage {
+	m := &memMeter{
+		objs:    make(map[*Object]struct{}),
+		stashes: make(map[*stash]struct{}),
+		realms:  make(map[*Realm]struct{}),
+		maps:    make(map[*orderedMap]struct{}),
+		strings: make(map[uintptr]struct{}),
+	}
+	m.realm(r.mainRealm)
+	m.realm(r.realm)
+	vm := r.vm
+	m.values(vm.stack[:vm.sp])
+	m.stash(vm.stash)
+	m.value(vm.result)
+	m.value(vm.newTarget)
+	for i := range vm.callStack {
+		ctx := &vm.callStack[i]
+		m.stash(ctx.stash)
+		m.value(ctx.result)
+		m.value(ctx.newTarget)
+		m.realm(ctx.realm)
+	}
+	for _, item := range vm.iterStack {
+		m.value(item.val)
+		if item.iter != nil {
+			m.object(item.iter.iterator)
+		}
+	}
+	for len(m.queue) > 0 {
+		o := m.queue[len(m.queue)-1]
+		m.queue = m.queue[:len(m.queue)-1]
+		m.walk(o)
+	}
+	return m.usage
+}
+
+var typeObjectPtr = reflect.TypeOf((*Object)(nil))
+
+func (m *memMeter) realm(realm *Realm) {
+	if realm == nil {
+		return
+	}
+	if _, exists := m.realms[realm]; exists {
+		return
+	}
+	m.realms[realm] = struct{}{}
+	m.object(realm.globalObject)
+	m.stash(&realm.global.stash)
+	// the intrinsics which are not reachable from the global object (such as %ArrayIteratorPrototype%)
+	// may still have properties added to them
+	g := reflect.ValueOf(&realm.global).Elem()
+	for i := 0; i < g.NumField(); i++ {
+		if f := g.Field(i); f.Type() == typeObjectPtr && f.CanInterface() {
+			m.object(f.Interface().(*Object))
+		}
+	}
+}
+
+func (m *memMeter) object(o *Object) {
+	if o == nil {
+		return
+	}
+	if _, exists := m.objs[o]; exists {
+		return
+	}
+	m.objs[o] = struct{}{}
+	m.queue = append(m.queue, o)
+}
+
+func (m *memMeter) value(v Value) {
+	switch v := v.(type) {
+	case *Object:
+		m.object(v)
+	case valueString:
+		m.string(v)
+	}
+}
+
+func (m *memMeter) values(values []Value) {
+	for _, v := range values {
+		m.value(v)
+	}
+}
+
+func (m *memMeter) string(s valueString) {
+	var size int
+	var data uintptr
+	switch s := s.(type) {
+	case asciiString:
+		size = len(s)
+		if size >= memSharedStringLen {
+			data = (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+		}
+	case unicodeString:
+		size = len(s) * memUnicodeCharSize
+		if len(s) >= memSharedStringLen {
+			data = uintptr(unsafe.Pointer(&s[0]))
+		}
+	default:
+		return
+	}
+	if data != 0 {
+		if _, exists := m.strings[data]; exists {
+			return
+		}
+		m.strings[data] = struct{}{}
+	}
+	m.usage.Strings = addMemSat(m.usage.Strings, uint64(size))
+}
+
+func (m *memMeter) stash(s *stash) {
+	for ; s != nil; s = s.outer {
+		if _, exists := m.stashes[s]; exists {
+			return
+		}
+		m.stashes[s] = struct{}{}
+		m.usage.Objects += uint64(len(s.values)+len(s.extraArgs)) * memValueSize
+		m.values(s.values)
+		m.values(s.extraArgs)
+		m.object(s.obj)
+	}
+}
+
+func (m *memMeter) orderedMap(om *orderedMap) {
+	if om == nil {
+		return
+	}
+	if _, exists := m.maps[om]; exists {
+		return
+	}
+	m.maps[om] = struct{}{}
+	m.usage.Maps += uint64(om.size) * memMapEntrySize
+	iter := om.newIter()
+	for entry := iter.next(); entry != nil; entry = iter.next() {
+		m.value(entry.key)
+		m.value(entry.value)
+	}
+}
+
+func (m *memMeter) jsFunc(f *baseJsFuncObject) {
+	m.stash(f.stash)
+	m.realm(f.realm)
+}
+
+// walk adds the size of the object and queues the objects it references. Only the internal state is examined,
+// so no JavaScript code (such as getters or proxy traps) is run.
+func (m *memMeter) walk(o *Object) {
+	var b *baseObject
+	switch impl := o.self.(type) {
+	case nil, *lazyObject:
+		// not created yet
+		return
+	case *arrayObject:
+		m.usage.Arrays += uint64(cap(impl.values)) * memValueSize
+		m.values(impl.values)
+		b = &impl.baseObject
+	case *sparseArrayObject:
+		m.usage.Arrays += uint64(len(impl.items)) * memSparseItemSize
+		for _, item := range impl.items {
+			m.value(item.value)
+		}
+		b = &impl.baseObject
+	case *funcObject:
+		m.jsFunc(&impl.baseJsFuncObject)
+		b = &impl.baseObject
+	case *methodFuncObject:
+		m.jsFunc(&impl.baseJsFuncObject)
+		m.object(impl.homeObject)
+		b = &impl.baseObject
+	case *arrowFuncObject:
+		m.jsFunc(&impl.baseJsFuncObject)
+		m.object(impl.funcObj)
+		m.value(impl.newTarget)
+		b = &impl.baseObject
+	case *classFuncObject:
+		m.jsFunc(&impl.baseJsFuncObject)
+		m.values(impl.computedKeys)
+		m.values(impl.privateMethods)
+		b = &impl.baseObject
+	case *nativeFuncObject:
+		m.realm(impl.realm)
+		b = &impl.baseObject
+	case *boundFuncObject:
+		m.realm(impl.realm)
+		m.object(impl.wrapped)
+		m.values(impl.boundArgs)
+		b = &impl.baseObject
+	case *primitiveValueObject:
+		m.value(impl.pValue)
+		b = &impl.baseObject
+	case *stringObject:
+		m.value(impl.value)
+		b = &impl.baseObject
+	case *regexpObject:
+		m.value(impl.source)
+		b = &impl.baseObject
+	case *mapObject:
+		m.orderedMap(impl.m)
+		b = &impl.baseObject
+	case *setObject:
+		m.orderedMap(impl.m)
+		b = &impl.baseObject
+	case *mapIterObject:
+		if impl.iter != nil {
+			m.orderedMap(impl.iter.m)
+		}
+		b = &impl.baseObject
+	case *setIterObject:
+		if impl.iter != nil {
+			m.orderedMap(impl.iter.m)
+		}
+		b = &impl.baseObject
+	case *arrayIterObject:
+		m.object(impl.obj)
+		b = &impl.baseObject
+	case *regExpStringIterObject:
+		m.object(impl.matcher)
+		m.value(impl.s)
+		b = &impl.baseObject
+	case *wrapForValidIteratorObject:
+		if impl.iterated != nil {
+			m.object(impl.iterated.iterator)
+		}
+		b = &impl.baseObject
+	case *proxyObject:
+		m.object(impl.target)
+		if h, ok := impl.handler.(*jsProxyHandler); ok {
+			m.object(h.handler)
+		}
+		b = &impl.baseObject
+	case *Promise:
+		m.value(impl.result)
+		for _, reactions := range [2][]*promiseReaction{impl.fulfillReactions, impl.rejectReactions} {
+			for _, reaction := range reactions {
+				if c := reaction.capability; c != nil {
+					m.object(c.promise)
+					m.object(c.resolveObj)
+					m.object(c.rejectObj)
+				}
+			}
+		}
+		b = &impl.baseObject
+	case *arrayBufferObject:
+		m.usage.ArrayBuffers += uint64(len(impl.data))
+		b = &impl.baseObject
+	case *typedArrayObject:
+		if impl.viewedArrayBuf != nil {
+			m.object(impl.viewedArrayBuf.val)
+		}
+		m.object(impl.defaultCtor)
+		b = &impl.baseObject
+	case *dataViewObject:
+		if impl.viewedArrayBuf != nil {
+			m.object(impl.viewedArrayBuf.val)
+		}
+		b = &impl.baseObject
+	case *shadowRealmObject:
+		m.realm(impl.realm)
+		b = &impl.baseObject
+	case *compartmentObject:
+		m.object(impl.global)
+		m.stash(impl.scope)
+		b = &impl.baseObject
+	case *argumentsObject:
+		b = &impl.baseObject
+	case *iteratorHelperObject:
+		b = &impl.baseObject
+	case *stringIterObject:
+		b = &impl.baseObject
+	default:
+		// the rest of the objects either have no state other than the baseObject or wrap Go values
+		b = snapshotBaseObject(impl)
+	}
+	m.usage.Objects += memObjectSize
+	for _, v := range o.weakRefs {
+		m.value(v)
+	}
+	if b == nil {
+		return
+	}
+	m.object(b.prototype)
+	m.usage.Objects += uint64(len(b.propNames)) * memPropertySize
+	for _, name := range b.propNames {
+		switch v := b.values[name].(type) {
+		case *valueProperty:
+			m.value(v.value)
+			m.object(v.getterFunc)
+			m.object(v.setterFunc)
+		case *mappedProperty:
+			// the value is in the stash which is walked separately
+		default:
+			m.value(v)
+		}
+	}
+	if b.symValues != nil {
+		m.usage.Objects += uint64(b.symValues.size) * memPropertySize
+		iter := b.symValues.newIter()
+		for entry := iter.next(); entry != nil; entry = iter.next() {
+			if p, ok := entry.value.(*valueProperty); ok {
+				m.value(p.value)
+				m.object(p.getterFunc)
+				m.object(p.setterFunc)
+			} else {
+				m.value(entry.value)
+			}
+		}
+	}
+	for _, elements := range b.privateElements {
+		m.values(elements.methods)
+		m.values(elements.fields)
+	}
+}
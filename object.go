@@ -1,6 +1,7 @@
 package goja
 
 import (
+	gocontext "context"
 	"fmt"
 	"math"
 	"reflect"
@@ -29,6 +30,10 @@ const (
 	classGlobal   = "global"
 	classPromise  = "Promise"
 
+	classShadowRealm = "ShadowRealm"
+
+	classIterator             = "Iterator"
+	classIteratorHelper       = "Iterator Helper"
 	classArrayIterator        = "Array Iterator"
 	classMapIterator          = "Map Iterator"
 	classSetIterator          = "Set Iterator"
@@ -247,6 +252,8 @@ func (o *primitiveValueObject) exportType() reflect.Type {
 type FunctionCall struct {
 	This      Value
 	Arguments []Value
+
+	ctx gocontext.Context
 }
 
 type ConstructorCall struct {
@@ -262,6 +269,15 @@ func (f FunctionCall) Argument(idx int) Value {
 	return _undefined
 }
 
+// Context returns the context.Context of the execution the function has been called from (see Runtime.Context()).
+// It is only set for the functions created by ToValue(), for others context.Background() is returned.
+func (f FunctionCall) Context() gocontext.Context {
+	if f.ctx != nil {
+		return f.ctx
+	}
+	return gocontext.Background()
+}
+
 func (f ConstructorCall) Argument(idx int) Value {
 	if idx < len(f.Arguments) {
 		return f.Arguments[idx]
@@ -270,6 +286,9 @@ func (f ConstructorCall) Argument(idx int) Value {
 }
 
 func (o *baseObject) init() {
+	if r := o.val.runtime; r != nil {
+		r.allocMem(memObjectSize)
+	}
 	o.values = make(map[unistring.String]Value)
 }
 
@@ -781,6 +800,9 @@ func (o *baseObject) defineOwnPropertySym(s *Symbol, descr PropertyDescriptor, t
 
 func (o *baseObject) _put(name unistring.String, v Value) {
 	if _, exists := o.values[name]; !exists {
+		if r := o.val.runtime; r != nil {
+			r.allocMem(memPropertySize)
+		}
 		names := copyNamesIfNeeded(o.propNames, 1)
 		o.propNames = append(names, name)
 	}
@@ -1076,6 +1076,32 @@ func TestPosition(t *testing.T) {
 	})
 }
 
+func TestStatementPosition(t *testing.T) {
+	tt(t, func() {
+		for _, src := range []string{
+			"if (a) b; else c",
+			"while (a) b",
+			"with (a) b",
+			"switch (a) {}",
+			"switch (a) { case 1: }",
+			"l: for (;;) {}",
+			"a ? b : c",
+		} {
+			parser := newParser("", src)
+			program, err := parser.parse()
+			is(err, nil)
+			node := program.Body[0]
+			is(node.Idx0(), file.Idx(1))
+			is(parser.slice(node.Idx0(), node.Idx1()), src)
+		}
+
+		parser := newParser("", "do a; while (b)")
+		program, err := parser.parse()
+		is(err, nil)
+		is(program.Body[0].Idx0(), file.Idx(1))
+	})
+}
+
 func TestExtractSourceMapLine(t *testing.T) {
 	tt(t, func() {
 		is(extractSourceMapLine(""), "")
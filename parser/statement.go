@@ -420,9 +420,10 @@ func (self *_parser) parseThrowStatement() ast.Statement {
 }
 
 func (self *_parser) parseSwitchStatement() ast.Statement {
-	self.expect(token.SWITCH)
+	idx := self.expect(token.SWITCH)
 	self.expect(token.LEFT_PARENTHESIS)
 	node := &ast.SwitchStatement{
+		Switch:       idx,
 		Discriminant: self.parseExpression(),
 		Default:      -1,
 	}
@@ -438,6 +439,7 @@ func (self *_parser) parseSwitchStatement() ast.Statement {
 
 	for index := 0; self.token != token.EOF; index++ {
 		if self.token == token.RIGHT_BRACE {
+			node.RightBrace = self.idx
 			self.next()
 			break
 		}
@@ -456,9 +458,10 @@ func (self *_parser) parseSwitchStatement() ast.Statement {
 }
 
 func (self *_parser) parseWithStatement() ast.Statement {
-	self.expect(token.WITH)
+	idx := self.expect(token.WITH)
 	self.expect(token.LEFT_PARENTHESIS)
 	node := &ast.WithStatement{
+		With:   idx,
 		Object: self.parseExpression(),
 	}
 	self.expect(token.RIGHT_PARENTHESIS)
@@ -479,7 +482,7 @@ func (self *_parser) parseCaseStatement() *ast.CaseStatement {
 		self.expect(token.CASE)
 		node.Test = self.parseExpression()
 	}
-	self.expect(token.COLON)
+	node.Colon = self.expect(token.COLON)
 
 	for {
 		if self.token == token.EOF ||
@@ -724,8 +727,9 @@ func (self *_parser) parseDoWhileStatement() ast.Statement {
 		self.scope.inIteration = inIteration
 	}()
 
-	self.expect(token.DO)
-	node := &ast.DoWhileStatement{}
+	node := &ast.DoWhileStatement{
+		Do: self.expect(token.DO),
+	}
 	if self.token == token.LEFT_BRACE {
 		node.Body = self.parseBlockStatement()
 	} else {
@@ -745,10 +749,11 @@ func (self *_parser) parseDoWhileStatement() ast.Statement {
 }
 
 func (self *_parser) parseWhileStatement() ast.Statement {
-	self.expect(token.WHILE)
+	idx := self.expect(token.WHILE)
 	self.expect(token.LEFT_PARENTHESIS)
 	node := &ast.WhileStatement{
-		Test: self.parseExpression(),
+		While: idx,
+		Test:  self.parseExpression(),
 	}
 	self.expect(token.RIGHT_PARENTHESIS)
 	node.Body = self.parseIterationStatement()
@@ -757,9 +762,10 @@ func (self *_parser) parseWhileStatement() ast.Statement {
 }
 
 func (self *_parser) parseIfStatement() ast.Statement {
-	self.expect(token.IF)
+	idx := self.expect(token.IF)
 	self.expect(token.LEFT_PARENTHESIS)
 	node := &ast.IfStatement{
+		If:   idx,
 		Test: self.parseExpression(),
 	}
 	self.expect(token.RIGHT_PARENTHESIS)
@@ -797,7 +803,9 @@ func (self *_parser) parseProgram() *ast.Program {
 		DeclarationList: self.scope.declarationList,
 		File:            self.file,
 	}
-	self.file.SetSourceMap(self.parseSourceMap())
+	if sm, data := self.parseSourceMap(); sm != nil {
+		self.file.SetSourceMapData(sm, data)
+	}
 	return prg
 }
 
@@ -820,9 +828,9 @@ func extractSourceMapLine(str string) string {
 	return ""
 }
 
-func (self *_parser) parseSourceMap() *sourcemap.Consumer {
+func (self *_parser) parseSourceMap() (*sourcemap.Consumer, []byte) {
 	if self.opts.disableSourceMaps {
-		return nil
+		return nil, nil
 	}
 	if smLine := extractSourceMapLine(self.str); smLine != "" {
 		urlIndex := strings.Index(smLine, "=")
@@ -850,19 +858,19 @@ func (self *_parser) parseSourceMap() *sourcemap.Consumer {
 
 		if err != nil {
 			self.error(file.Idx(0), "Could not load source map: %v", err)
-			return nil
+			return nil, nil
 		}
 		if data == nil {
-			return nil
+			return nil, nil
 		}
 
 		if sm, err := sourcemap.Parse(self.file.Name(), data); err == nil {
-			return sm
+			return sm, data
 		} else {
 			self.error(file.Idx(0), "Could not parse source map: %v", err)
 		}
 	}
-	return nil
+	return nil, nil
 }
 
 func (self *_parser) parseBreakStatement() ast.Statement {
@@ -0,0 +1,212 @@
+package goja
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dop251/goja/unistring"
+)
+
+// RuntimePool hands out Runtimes initialised with the same function. A Runtime obtained with Get() is for exclusive
+// use of the caller until it's returned with Put(). On return the Runtime is reset to the state it had after the
+// initialisation:
+//
+//   - the own properties of the global object, its prototype and extensibility are restored;
+//   - the global lexical declarations (let, const and class) and the global var declarations are restored;
+//   - the job queue is cleared and the interrupt flag is reset;
+//   - the memory accounting state (see Runtime.SetMemoryLimit()) and the instruction counter are restored.
+//
+// Note that the objects referenced by the globals (including the built-in objects, such as Array.prototype) are not
+// reset, so modifications made to them persist. Use SetMaxUses() to bound the effect of such modifications, or
+// Runtime.Fork() if every use requires a fully pristine Runtime.
+//
+// A RuntimePool is safe for concurrent use, but the settings must be configured before the first Get().
+type RuntimePool struct {
+	init        func(r *Runtime) error
+	maxUses     int
+	maxIdle     int
+	healthCheck func(r *Runtime) error
+
+	mu    sync.Mutex
+	idle  []*Runtime
+	state map[*Runtime]*pooledRuntime
+}
+
+// pooledRuntime is the state of a Runtime recorded after the initialisation.
+type pooledRuntime struct {
+	uses int
+
+	global       baseObject
+	stash        stash
+	varNames     map[unistring.String]struct{}
+	memAllocated uint64
+	memNextCheck uint64
+	instructions uint64
+}
+
+var errPoolRuntimeRunning = errors.New("goja: the Runtime is still running")
+
+// NewRuntimePool creates a new RuntimePool. init, if not nil, is called for every new Runtime. If it returns an
+// error the Runtime is discarded and the error is returned by Get().
+func NewRuntimePool(init func(r *Runtime) error) *RuntimePool {
+	return &RuntimePool{
+		init:  init,
+		state: make(map[*Runtime]*pooledRuntime),
+	}
+}
+
+// SetMaxUses sets the number of times a Runtime is handed out before it's discarded. 0 (the default) means no limit.
+func (p *RuntimePool) SetMaxUses(n int) {
+	p.maxUses = n
+}
+
+// SetMaxIdle sets the maximum number of Runtimes kept in the pool when they are not in use, the ones returned in
+// excess are discarded. 0 (the default) means no limit.
+func (p *RuntimePool) SetMaxIdle(n int) {
+	p.maxIdle = n
+}
+
+// SetHealthCheck sets a function which is called for every Runtime returned to the pool after it has been reset. If
+// it returns an error the Runtime is discarded.
+func (p *RuntimePool) SetHealthCheck(check func(r *Runtime) error) {
+	p.healthCheck = check
+}
+
+// Get returns an idle Runtime from the pool or creates a new one.
+func (p *RuntimePool) Get() (*Runtime, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		r := p.idle[n-1]
+		p.idle[n-1] = nil
+		p.idle = p.idle[:n-1]
+		p.state[r].uses++
+		p.mu.Unlock()
+		return r, nil
+	}
+	p.mu.Unlock()
+
+	r := New()
+	if p.init != nil {
+		if err := p.init(r); err != nil {
+			return nil, err
+		}
+	}
+	if len(r.vm.callStack) > 0 {
+		return nil, errPoolRuntimeRunning
+	}
+	st := &pooledRuntime{uses: 1}
+	st.save(r)
+	p.mu.Lock()
+	p.state[r] = st
+	p.mu.Unlock()
+	return r, nil
+}
+
+// Put returns the Runtime obtained with Get() to the pool. The Runtime must not be running and must not be used
+// after the call. It's discarded if it has reached the maximum number of uses, fails the health check, or if the
+// pool is full. Runtimes that do not belong to the pool are ignored.
+func (p *RuntimePool) Put(r *Runtime) {
+	p.mu.Lock()
+	st := p.state[r]
+	p.mu.Unlock()
+	if st == nil {
+		return
+	}
+	if p.maxUses > 0 && st.uses >= p.maxUses || st.restore(r) != nil || p.healthCheck != nil && p.healthCheck(r) != nil {
+		p.Discard(r)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.maxIdle > 0 && len(p.idle) >= p.maxIdle {
+		delete(p.state, r)
+		return
+	}
+	p.idle = append(p.idle, r)
+}
+
+// Discard removes the Runtime obtained with Get() from the pool, for example if it has been left in a state that
+// cannot be reset.
+func (p *RuntimePool) Discard(r *Runtime) {
+	p.mu.Lock()
+	delete(p.state, r)
+	p.mu.Unlock()
+}
+
+// Len returns the number of idle Runtimes in the pool.
+func (p *RuntimePool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+func copyGlobalObject(dst, src *baseObject) {
+	dst.prototype = src.prototype
+	dst.extensible = src.extensible
+	dst.values = make(map[unistring.String]Value, len(src.values))
+	for name, v := range src.values {
+		if prop, ok := v.(*valueProperty); ok {
+			c := *prop
+			v = &c
+		}
+		dst.values[name] = v
+	}
+	dst.propNames = append([]unistring.String(nil), src.propNames...)
+	dst.lastSortedPropLen, dst.idxPropCount = src.lastSortedPropLen, src.idxPropCount
+	dst.symValues = nil
+	if src.symValues != nil {
+		dst.symValues = newOrderedMap(nil)
+		iter := src.symValues.newIter()
+		for entry := iter.next(); entry != nil; entry = iter.next() {
+			v := entry.value
+			if prop, ok := v.(*valueProperty); ok {
+				c := *prop
+				v = &c
+			}
+			dst.symValues.set(entry.key, v)
+		}
+	}
+}
+
+func copyGlobalStash(dst, src *stash) {
+	dst.values = append([]Value(nil), src.values...)
+	dst.names = make(map[unistring.String]uint32, len(src.names))
+	for name, idx := range src.names {
+		dst.names[name] = idx
+	}
+}
+
+func (st *pooledRuntime) save(r *Runtime) {
+	forceLazy(r.globalObject)
+	if b, ok := r.globalObject.self.(*baseObject); ok {
+		copyGlobalObject(&st.global, b)
+	}
+	copyGlobalStash(&st.stash, &r.global.stash)
+	st.varNames = make(map[unistring.String]struct{}, len(r.global.varNames))
+	for name := range r.global.varNames {
+		st.varNames[name] = struct{}{}
+	}
+	st.memAllocated, st.memNextCheck = r.memAllocated, r.memNextCheck
+	st.instructions = r.vm.instructions
+}
+
+func (st *pooledRuntime) restore(r *Runtime) error {
+	if len(r.vm.callStack) > 0 {
+		return errPoolRuntimeRunning
+	}
+	b, ok := r.globalObject.self.(*baseObject)
+	if !ok || st.global.values == nil {
+		return errors.New("goja: the global object cannot be reset")
+	}
+	copyGlobalObject(b, &st.global)
+	copyGlobalStash(&r.global.stash, &st.stash)
+	r.global.varNames = make(map[unistring.String]struct{}, len(st.varNames))
+	for name := range st.varNames {
+		r.global.varNames[name] = struct{}{}
+	}
+	r.jobQueue = nil
+	r.ClearInterrupt()
+	r.memAllocated, r.memNextCheck = st.memAllocated, st.memNextCheck
+	r.vm.instructions = st.instructions
+	return nil
+}
@@ -0,0 +1,143 @@
+package goja
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func newTestRuntimePool() *RuntimePool {
+	return NewRuntimePool(func(r *Runtime) error {
+		_, err := r.RunString(`
+		var config = { debug: false };
+		let counter = 0;
+		function inc() { return ++counter; }
+		`)
+		return err
+	})
+}
+
+func TestRuntimePool(t *testing.T) {
+	p := newTestRuntimePool()
+	r, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RunString(`
+	inc(); inc();
+	config = null;
+	globalThis.leaked = 1;
+	var declared = 2;
+	let lexical = 3;
+	Object.defineProperty(globalThis, "inc", { value: null });
+	`); err != nil {
+		t.Fatal(err)
+	}
+	promise, resolve, _ := r.NewPromise()
+	r.Set("promise", promise)
+	if _, err := r.RunString(`promise.then(() => { globalThis.ran = true; })`); err != nil {
+		t.Fatal(err)
+	}
+	resolve(true) // enqueues a job which must not survive the reset
+	r.Interrupt("stale")
+	p.Put(r)
+	if p.Len() != 1 {
+		t.Fatal(p.Len())
+	}
+
+	r1, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r1 != r {
+		t.Fatal("The Runtime was not reused")
+	}
+	res, err := r1.RunString(`
+	[inc(), config.debug, typeof leaked, typeof declared, typeof lexical, typeof ran].join()
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "1,false,undefined,undefined,undefined,undefined" {
+		t.Fatal(res)
+	}
+	// the lexical declaration can be repeated
+	if _, err := r1.RunString(`let lexical = 4; var declared = 5;`); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(r1)
+}
+
+func TestRuntimePoolMaxUses(t *testing.T) {
+	p := newTestRuntimePool()
+	p.SetMaxUses(2)
+	r, _ := p.Get()
+	p.Put(r)
+	r1, _ := p.Get()
+	if r1 != r {
+		t.Fatal("The Runtime was not reused")
+	}
+	p.Put(r1)
+	if p.Len() != 0 {
+		t.Fatal("The Runtime was not discarded")
+	}
+	r2, _ := p.Get()
+	if r2 == r {
+		t.Fatal("The Runtime was reused")
+	}
+}
+
+func TestRuntimePoolHealthCheck(t *testing.T) {
+	p := newTestRuntimePool()
+	p.SetHealthCheck(func(r *Runtime) error {
+		if r.Get("broken") != nil {
+			return errors.New("broken")
+		}
+		return nil
+	})
+	p.SetMaxIdle(1)
+	r, _ := p.Get()
+	r1, _ := p.Get()
+	if _, err := r.RunString(`Object.defineProperty(Object.prototype, "broken", { value: true })`); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(r)
+	if p.Len() != 0 {
+		t.Fatal("The Runtime was not discarded")
+	}
+	p.Put(r1)
+	p.Put(New()) // does not belong to the pool
+	if p.Len() != 1 {
+		t.Fatal(p.Len())
+	}
+
+	p = NewRuntimePool(func(r *Runtime) error {
+		return errors.New("init failed")
+	})
+	if _, err := p.Get(); err == nil || err.Error() != "init failed" {
+		t.Fatal(err)
+	}
+}
+
+func TestRuntimePoolConcurrent(t *testing.T) {
+	p := newTestRuntimePool()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				r, err := p.Get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if v, err := r.RunString(`inc()`); err != nil || v.ToInteger() != 1 {
+					t.Errorf("Unexpected result: %v, %v", v, err)
+				}
+				p.Put(r)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,378 @@
+package goja
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja/unistring"
+)
+
+// ProfilePeriod is the interval between the samples taken by the JavaScript profiler (see StartProfile()).
+const ProfilePeriod = time.Millisecond
+
+var errProfileActive = errors.New("goja: profiling already in use")
+
+type profLocationKey struct {
+	prg      *Program
+	pc       int
+	funcName unistring.String
+}
+
+type profFunctionKey struct {
+	name, filename string
+}
+
+type profSample struct {
+	locations []uint64
+	count     int64
+}
+
+type profLocation struct {
+	function uint64
+	line     int64
+	column   int64
+}
+
+// ProfileFrame is a frame of a call stack sampled by the profiler.
+type ProfileFrame struct {
+	FuncName string
+	// Filename is the name of the source file, "<eval>" if the source has no name or the source name of the native
+	// function (see StackFrame.SrcName()).
+	Filename string
+	// Line and Column are 1-based, they are 0 if the position is unknown (e.g. in native functions).
+	Line, Column int
+}
+
+// ProfileSample is a distinct call stack sampled by the profiler.
+type ProfileSample struct {
+	// Stack contains the frames, the innermost first.
+	Stack []ProfileFrame
+	// Count is the number of times the call stack has been sampled (once every ProfilePeriod).
+	Count int64
+}
+
+// Profile contains the data collected by the profiler, see StopProfileData().
+type Profile struct {
+	Start    time.Time
+	Duration time.Duration
+	Samples  []ProfileSample
+}
+
+// profiler collects the JavaScript call stacks. The samples are taken on the vm goroutine (see profileSample())
+// while the profiler is started and stopped by the user, so all fields are protected by Runtime.profLock.
+type profiler struct {
+	w         io.Writer
+	start     time.Time
+	last      time.Time
+	stop      chan struct{}
+	done      chan struct{}
+	samples   map[string]*profSample
+	locations map[profLocationKey]uint64
+	locList   []profLocation
+	functions map[profFunctionKey]uint64
+	funcList  []profFunctionKey
+	stack     []StackFrame
+	key       []byte
+}
+
+// StartProfile enables sampling of the JavaScript call stacks of the Runtime. The samples are taken every
+// ProfilePeriod of running JavaScript code (including the native functions it calls). The profile is written to w
+// in the pprof format (see https://github.com/google/pprof) when StopProfile() is called, so it can be examined
+// with 'go tool pprof'. Alternatively, the samples can be retrieved with StopProfileData().
+//
+// Unlike runtime/pprof.StartCPUProfile(), which profiles the interpreter itself, this profiles the script functions.
+// StartProfile returns an error if profiling is already enabled for the Runtime. Both StartProfile and StopProfile
+// are safe to call concurrently with the running script.
+func (r *Runtime) StartProfile(w io.Writer) error {
+	r.profLock.Lock()
+	defer r.profLock.Unlock()
+	if r.profiler != nil {
+		return errProfileActive
+	}
+	p := &profiler{
+		w:         w,
+		start:     time.Now(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		samples:   make(map[string]*profSample),
+		locations: make(map[profLocationKey]uint64),
+		functions: make(map[profFunctionKey]uint64),
+	}
+	r.profiler = p
+	atomic.StoreUint32(&r.profActive, 1)
+	go func() {
+		defer close(p.done)
+		t := time.NewTicker(ProfilePeriod)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				atomicSetFlag(&r.vm.interrupted, vmFlagProfileSample)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// StopProfile stops the profiling started by StartProfile() and writes the profile. It does nothing if the
+// profiling is not enabled.
+func (r *Runtime) StopProfile() error {
+	p := r.stopProfile()
+	if p == nil {
+		return nil
+	}
+	return p.write(time.Since(p.start))
+}
+
+// StopProfileData stops the profiling started by StartProfile() like StopProfile(), but instead of writing the
+// profile it returns the collected samples, so that they can be converted into a different format. In this case
+// the writer passed to StartProfile() is not used and may be nil. It returns nil if the profiling is not enabled.
+func (r *Runtime) StopProfileData() *Profile {
+	p := r.stopProfile()
+	if p == nil {
+		return nil
+	}
+	return p.profile(time.Since(p.start))
+}
+
+func (r *Runtime) stopProfile() *profiler {
+	r.profLock.Lock()
+	p := r.profiler
+	r.profiler = nil
+	atomic.StoreUint32(&r.profActive, 0)
+	r.profLock.Unlock()
+	if p == nil {
+		return nil
+	}
+	close(p.stop)
+	<-p.done
+	atomicClearFlag(&r.vm.interrupted, vmFlagProfileSample)
+	return p
+}
+
+// profileSample is called by the run loop when a sample is due.
+func (vm *vm) profileSample() {
+	atomicClearFlag(&vm.interrupted, vmFlagProfileSample)
+	r := vm.r
+	r.profLock.Lock()
+	if p := r.profiler; p != nil {
+		p.sample(vm)
+	}
+	r.profLock.Unlock()
+}
+
+// profileLeave is called when the control is passed outside the Runtime, so that the time spent outside is not
+// attributed to the next sample.
+func (r *Runtime) profileLeave() {
+	if atomic.LoadUint32(&r.profActive) == 0 {
+		return
+	}
+	r.profLock.Lock()
+	if p := r.profiler; p != nil {
+		p.last = time.Time{}
+	}
+	r.profLock.Unlock()
+}
+
+func (p *profiler) sample(vm *vm) {
+	now := time.Now()
+	count := int64(1)
+	if !p.last.IsZero() {
+		if n := int64(math.Round(float64(now.Sub(p.last)) / float64(ProfilePeriod))); n > 1 {
+			count = n
+		}
+	}
+	p.last = now
+
+	p.stack = vm.captureStack(p.stack[:0], 0)
+	key := p.key[:0]
+	locs := make([]uint64, 0, len(p.stack))
+	for i := range p.stack {
+		id := p.location(&p.stack[i])
+		locs = append(locs, id)
+		// varint, so that the key is unambiguous
+		for ; id >= 0x80; id >>= 7 {
+			key = append(key, byte(id)|0x80)
+		}
+		key = append(key, byte(id))
+	}
+	p.key = key
+	if s := p.samples[string(key)]; s != nil {
+		s.count += count
+	} else {
+		p.samples[string(key)] = &profSample{
+			locations: locs,
+			count:     count,
+		}
+	}
+}
+
+func (p *profiler) location(f *StackFrame) uint64 {
+	key := profLocationKey{prg: f.prg, funcName: f.funcName}
+	if f.prg != nil {
+		key.pc = f.pc
+	}
+	if id, exists := p.locations[key]; exists {
+		return id
+	}
+	pos := f.Position()
+	fkey := profFunctionKey{name: f.FuncName(), filename: pos.Filename}
+	if f.prg == nil {
+		fkey.filename = f.SrcName()
+	} else if fkey.filename == "" {
+		fkey.filename = "<eval>"
+	}
+	fid, exists := p.functions[fkey]
+	if !exists {
+		p.funcList = append(p.funcList, fkey)
+		fid = uint64(len(p.funcList))
+		p.functions[fkey] = fid
+	}
+	p.locList = append(p.locList, profLocation{function: fid, line: int64(pos.Line), column: int64(pos.Column)})
+	id := uint64(len(p.locList))
+	p.locations[key] = id
+	return id
+}
+
+func (p *profiler) profile(duration time.Duration) *Profile {
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	// make the order deterministic
+	sort.Strings(keys)
+	prof := &Profile{
+		Start:    p.start,
+		Duration: duration,
+		Samples:  make([]ProfileSample, 0, len(keys)),
+	}
+	for _, key := range keys {
+		s := p.samples[key]
+		stack := make([]ProfileFrame, 0, len(s.locations))
+		for _, id := range s.locations {
+			l := p.locList[id-1]
+			f := p.funcList[l.function-1]
+			stack = append(stack, ProfileFrame{
+				FuncName: f.name,
+				Filename: f.filename,
+				Line:     int(l.line),
+				Column:   int(l.column),
+			})
+		}
+		prof.Samples = append(prof.Samples, ProfileSample{
+			Stack: stack,
+			Count: s.count,
+		})
+	}
+	return prof
+}
+
+// protoBuf is a minimal protocol buffers encoder, sufficient for the pprof profile.proto.
+type protoBuf struct {
+	data []byte
+}
+
+func (b *protoBuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuf) uint64(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(tag) << 3)
+	b.varint(x)
+}
+
+func (b *protoBuf) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+func (b *protoBuf) bytes(tag int, x []byte) {
+	b.varint(uint64(tag)<<3 | 2)
+	b.varint(uint64(len(x)))
+	b.data = append(b.data, x...)
+}
+
+func (b *protoBuf) packed(tag int, x []uint64) {
+	var p protoBuf
+	for _, v := range x {
+		p.varint(v)
+	}
+	b.bytes(tag, p.data)
+}
+
+func (p *profiler) write(duration time.Duration) error {
+	strings := []string{""}
+	stringIds := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		if id, exists := stringIds[s]; exists {
+			return id
+		}
+		id := int64(len(strings))
+		strings = append(strings, s)
+		stringIds[s] = id
+		return id
+	}
+	valueType := func(typ, unit string) []byte {
+		var b protoBuf
+		b.int64(1, str(typ))
+		b.int64(2, str(unit))
+		return b.data
+	}
+
+	// see https://github.com/google/pprof/blob/main/proto/profile.proto
+	var b protoBuf
+	b.bytes(1, valueType("samples", "count"))
+	b.bytes(1, valueType("cpu", "nanoseconds"))
+	for _, s := range p.samples {
+		var sb protoBuf
+		sb.packed(1, s.locations)
+		sb.packed(2, []uint64{uint64(s.count), uint64(s.count * int64(ProfilePeriod))})
+		b.bytes(2, sb.data)
+	}
+	for i, l := range p.locList {
+		var line protoBuf
+		line.uint64(1, l.function)
+		line.int64(2, l.line)
+		var lb protoBuf
+		lb.uint64(1, uint64(i+1))
+		lb.bytes(4, line.data)
+		b.bytes(4, lb.data)
+	}
+	for i, f := range p.funcList {
+		var fb protoBuf
+		fb.uint64(1, uint64(i+1))
+		name := str(f.name)
+		fb.int64(2, name)
+		fb.int64(3, name)
+		fb.int64(4, str(f.filename))
+		b.bytes(5, fb.data)
+	}
+	periodType := valueType("cpu", "nanoseconds")
+	// the string table must be complete before it's written
+	for _, s := range strings {
+		b.bytes(6, []byte(s))
+	}
+	b.int64(9, p.start.UnixNano())
+	b.int64(10, int64(duration))
+	b.bytes(11, periodType)
+	b.int64(12, int64(ProfilePeriod))
+
+	zw := gzip.NewWriter(p.w)
+	if _, err := zw.Write(b.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
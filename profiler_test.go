@@ -0,0 +1,134 @@
+package goja
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+// readProtoFields returns the top level fields of a protocol buffers message.
+func readProtoFields(t *testing.T, data []byte) map[int][][]byte {
+	fields := make(map[int][][]byte)
+	varint := func() uint64 {
+		var x uint64
+		for shift := uint(0); ; shift += 7 {
+			if len(data) == 0 {
+				t.Fatal("Unexpected end of data")
+			}
+			b := data[0]
+			data = data[1:]
+			x |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				return x
+			}
+		}
+	}
+	for len(data) > 0 {
+		tag := varint()
+		switch tag & 7 {
+		case 0:
+			varint()
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], nil)
+		case 2:
+			l := varint()
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], data[:l])
+			data = data[l:]
+		default:
+			t.Fatalf("Unexpected wire type: %d", tag&7)
+		}
+	}
+	return fields
+}
+
+func TestProfile(t *testing.T) {
+	const SCRIPT = `
+	function hot() {
+		var s = 0;
+		for (var i = 0; i < 1e6; i++) {
+			s += i;
+		}
+		return s;
+	}
+	hot();
+	`
+	vm := New()
+	var buf bytes.Buffer
+	if err := vm.StartProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.StartProfile(&buf); err == nil {
+		t.Fatal("Expected an error")
+	}
+	_, err := vm.RunScript("test.js", SCRIPT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.StopProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.StopProfile(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := readProtoFields(t, data)
+	if len(fields[2]) == 0 {
+		t.Fatal("No samples")
+	}
+	strs := make(map[string]bool)
+	for _, s := range fields[6] {
+		strs[string(s)] = true
+	}
+	for _, s := range []string{"hot", "test.js", "cpu", "nanoseconds"} {
+		if !strs[s] {
+			t.Fatalf("%q is not in the string table: %v", s, strs)
+		}
+	}
+}
+
+func TestProfileData(t *testing.T) {
+	vm := New()
+	if p := vm.StopProfileData(); p != nil {
+		t.Fatal(p)
+	}
+	if err := vm.StartProfile(nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := vm.RunScript("test.js", `
+	function hot() {
+		var s = 0;
+		for (var i = 0; i < 1e6; i++) {
+			s += i;
+		}
+		return s;
+	}
+	hot();
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := vm.StopProfileData()
+	if p == nil || p.Duration <= 0 || len(p.Samples) == 0 {
+		t.Fatal(p)
+	}
+	found := false
+	for _, s := range p.Samples {
+		if s.Count <= 0 || len(s.Stack) == 0 {
+			t.Fatalf("%+v", s)
+		}
+		if f := s.Stack[0]; f.FuncName == "hot" && f.Filename == "test.js" && f.Line >= 3 && f.Line <= 6 && f.Column > 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("%+v", p.Samples)
+	}
+}
@@ -0,0 +1,912 @@
+package goja
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"unsafe"
+
+	"github.com/dop251/goja/file"
+	"github.com/dop251/goja/unistring"
+	"github.com/go-sourcemap/sourcemap"
+)
+
+// The format produced by Program.MarshalBinary(). It starts with programMagic followed by the format version and
+// the top-level program. The source files and the nested programs are written the first time they are referenced
+// and subsequently referred to by their index. Each instruction is encoded as its index in programInstructions
+// followed by its fields. The string fields that are substrings of the program source (such as the function sources)
+// are encoded as an offset and a length.
+//
+// programVersion must be incremented whenever programInstructions or the fields of any instruction change.
+const (
+	programMagic   = "goja\x00prg"
+	programVersion = 1
+)
+
+var (
+	errProgramVersion     = errors.New("goja: unsupported compiled program version")
+	errInvalidProgramData = errors.New("goja: invalid compiled program data")
+)
+
+const (
+	prgValUndefined byte = iota
+	prgValNull
+	prgValTrue
+	prgValFalse
+	prgValInt
+	prgValFloat
+	prgValString
+	prgValProperty // the elements of the tagged template objects, see getTaggedTmplObject
+)
+
+// programInstructions lists all instruction types. The order defines the opcodes and must not be changed without
+// incrementing programVersion.
+var programInstructions = []instruction{
+	(*bindGlobal)(nil),
+	(*bindVars)(nil),
+	(*coverCounter)(nil),
+	(*defineGetter)(nil),
+	(*defineGetterKeyed)(nil),
+	(*defineMethod)(nil),
+	(*defineMethodKeyed)(nil),
+	(*definePrivateGetter)(nil),
+	(*definePrivateMethod)(nil),
+	(*definePrivateProp)(nil),
+	(*definePrivateSetter)(nil),
+	(*defineSetter)(nil),
+	(*defineSetterKeyed)(nil),
+	(*enterBlock)(nil),
+	(*enterCatchBlock)(nil),
+	(*enterFunc)(nil),
+	(*enterFunc1)(nil),
+	(*enterFuncBody)(nil),
+	(*enterFuncStashless)(nil),
+	(*getPrivatePropId)(nil),
+	(*getPrivatePropIdCallee)(nil),
+	(*getPrivatePropRes)(nil),
+	(*getPrivatePropResCallee)(nil),
+	(*getPrivateRefId)(nil),
+	(*getPrivateRefRes)(nil),
+	(*getTaggedTmplObject)(nil),
+	(*initStaticElements)(nil),
+	(*leaveBlock)(nil),
+	(*loadMixed)(nil),
+	(*loadMixedLex)(nil),
+	(*loadMixedStack)(nil),
+	(*loadMixedStack1)(nil),
+	(*loadMixedStack1Lex)(nil),
+	(*loadMixedStackLex)(nil),
+	(*newArrowFunc)(nil),
+	(*newClass)(nil),
+	(*newDerivedClass)(nil),
+	(*newFunc)(nil),
+	(*newMethod)(nil),
+	(*newRegexp)(nil),
+	(*newStaticFieldInit)(nil),
+	(*privateInId)(nil),
+	(*privateInRes)(nil),
+	(*resolveMixed)(nil),
+	(*resolveMixedStack)(nil),
+	(*resolveMixedStack1)(nil),
+	(*setPrivatePropId)(nil),
+	(*setPrivatePropIdP)(nil),
+	(*setPrivatePropRes)(nil),
+	(*setPrivatePropResP)(nil),
+	_add{},
+	_and{},
+	_bnot{},
+	_boxThis{},
+	_callEvalVariadic{},
+	_callEvalVariadicStrict{},
+	_callVariadic{},
+	_checkObjectCoercible{},
+	_clearResult{},
+	_copyRest{},
+	_copySpread{},
+	_createArgsRestStash{},
+	_createDestructSrc{},
+	_debuggerStatement{},
+	_dec{},
+	_deleteElem{},
+	_deleteElemStrict{},
+	_div{},
+	_dup{},
+	_endVariadic{},
+	_enterWith{},
+	_enumGet{},
+	_enumPop{},
+	_enumPopClose{},
+	_enumerate{},
+	_exp{},
+	_getElem{},
+	_getElemCallee{},
+	_getElemRecv{},
+	_getElemRecvCallee{},
+	_getElemRef{},
+	_getElemRefRecv{},
+	_getElemRefRecvStrict{},
+	_getElemRefStrict{},
+	_getKey{},
+	_getValue{},
+	_halt{},
+	_inc{},
+	_initValueP{},
+	_iterate{},
+	_iterateP{},
+	_leaveWith{},
+	_loadCallee{},
+	_loadGlobalObject{},
+	_loadNewTarget{},
+	_loadNil{},
+	_loadSuper{},
+	_loadUndef{},
+	_mod{},
+	_mul{},
+	_neg{},
+	_new(0),
+	_newArrayFromIter{},
+	_newObject{},
+	_newVariadic{},
+	_not{},
+	_op_eq{},
+	_op_gt{},
+	_op_gte{},
+	_op_in{},
+	_op_instanceof{},
+	_op_lt{},
+	_op_lte{},
+	_op_neq{},
+	_op_strict_eq{},
+	_op_strict_neq{},
+	_or{},
+	_plus{},
+	_pop{},
+	_pushArrayItem{},
+	_pushArraySpread{},
+	_pushSpread{},
+	_putValue{},
+	_putValueP{},
+	_ret{},
+	_retFinally{},
+	_sal{},
+	_sar{},
+	_saveResult{},
+	_setElem{},
+	_setElem1{},
+	_setElem1Named{},
+	_setElemP{},
+	_setElemRecv{},
+	_setElemRecvP{},
+	_setElemRecvStrict{},
+	_setElemRecvStrictP{},
+	_setElemStrict{},
+	_setElemStrictP{},
+	_setProto{},
+	_shr{},
+	_startVariadic{},
+	_sub{},
+	_superCallVariadic{},
+	_throw{},
+	_throwAssignToConst{},
+	_toNumber{},
+	_toPropertyKey{},
+	_toString{},
+	_typeof{},
+	_xor{},
+	call(0),
+	callEval(0),
+	callEvalStrict(0),
+	concatStrings(0),
+	copyStash{},
+	createArgsMapped(0),
+	createArgsRestStack(0),
+	createArgsUnmapped(0),
+	cret(0),
+	defineComputedKey(0),
+	defineProp{},
+	definePropKeyed(""),
+	deleteGlobal(""),
+	deleteProp(""),
+	deletePropStrict(""),
+	deleteVar(""),
+	dupLast(0),
+	dupN(0),
+	enumNext(0),
+	getProp(""),
+	getPropCallee(""),
+	getPropRecv(""),
+	getPropRecvCallee(""),
+	getPropRef(""),
+	getPropRefRecv(""),
+	getPropRefRecvStrict(""),
+	getPropRefStrict(""),
+	getThisDynamic{},
+	initGlobal(""),
+	initGlobalP(""),
+	initStack(0),
+	initStack1(0),
+	initStack1P(0),
+	initStackP(0),
+	initStash(0),
+	initStashP(0),
+	iterGetNextOrUndef{},
+	iterNext(0),
+	jcoalesc(0),
+	jdef(0),
+	jdefP(0),
+	jeq(0),
+	jeq1(0),
+	jne(0),
+	jneq1(0),
+	jopt(0),
+	joptc(0),
+	jump(0),
+	loadComputedKey(0),
+	loadDynamic(""),
+	loadDynamicCallee(""),
+	loadDynamicRef(""),
+	loadStack(0),
+	loadStack1(0),
+	loadStack1Lex(0),
+	loadStackLex(0),
+	loadStash(0),
+	loadStashLex(0),
+	loadThisStack{},
+	loadThisStash(0),
+	loadVal(0),
+	newArray(0),
+	popPrivateEnv{},
+	putProp(""),
+	rdupN(0),
+	resolveThisDynamic{},
+	resolveThisStack{},
+	resolveThisStash(0),
+	resolveVar1(""),
+	resolveVar1Strict(""),
+	setGlobal(""),
+	setGlobalStrict(""),
+	setProp(""),
+	setPropP(""),
+	setPropRecv(""),
+	setPropRecvP(""),
+	setPropRecvStrict(""),
+	setPropRecvStrictP(""),
+	setPropStrict(""),
+	setPropStrictP(""),
+	storeStack(0),
+	storeStack1(0),
+	storeStack1Lex(0),
+	storeStack1LexP(0),
+	storeStack1P(0),
+	storeStackLex(0),
+	storeStackLexP(0),
+	storeStackP(0),
+	storeStash(0),
+	storeStashLex(0),
+	storeStashLexP(0),
+	storeStashP(0),
+	superCall(0),
+	throwConst{},
+	try{},
+}
+
+var programOpcodes = func() map[reflect.Type]uint64 {
+	m := make(map[reflect.Type]uint64, len(programInstructions))
+	for i, ins := range programInstructions {
+		m[reflect.TypeOf(ins)] = uint64(i)
+	}
+	return m
+}()
+
+var typeProgramPtr = reflect.TypeOf((*Program)(nil))
+
+type programEncoder struct {
+	buf   []byte
+	files map[*file.File]uint64
+	progs map[*Program]uint64
+
+	// the source file of the program being written
+	src *file.File
+}
+
+type programDecoder struct {
+	data  []byte
+	pos   int
+	files []*file.File
+	progs []*Program
+
+	src *file.File
+}
+
+// MarshalBinary encodes the compiled program (including the nested functions and the source file it was compiled
+// from), so that it can be stored and later restored with UnmarshalBinary() without parsing the source again.
+//
+// The format is versioned and is only guaranteed to be readable by the same version of this package.
+// Programs compiled with coverage (see Coverage.Compile()) cannot be marshalled.
+func (p *Program) MarshalBinary() (data []byte, err error) {
+	e := &programEncoder{
+		files: make(map[*file.File]uint64),
+		progs: make(map[*Program]uint64),
+	}
+	e.buf = append(e.buf, programMagic...)
+	e.writeUint(programVersion)
+	defer func() {
+		if x := recover(); x != nil {
+			if er, ok := x.(programEncodeError); ok {
+				err = er.err
+				return
+			}
+			panic(x)
+		}
+	}()
+	e.writeProgram(p)
+	return e.buf, nil
+}
+
+// UnmarshalBinary restores a program encoded by MarshalBinary(). The program can then be run in any Runtime
+// using RunProgram(), just like the one returned by Compile().
+func (p *Program) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < len(programMagic) || string(data[:len(programMagic)]) != programMagic {
+		return errInvalidProgramData
+	}
+	d := &programDecoder{
+		data: data,
+		pos:  len(programMagic),
+	}
+	defer func() {
+		if x := recover(); x != nil {
+			if er, ok := x.(programEncodeError); ok {
+				err = er.err
+				return
+			}
+			panic(x)
+		}
+	}()
+	if d.readUint() != programVersion {
+		return errProgramVersion
+	}
+	d.readProgramInto(p)
+	if d.pos != len(d.data) {
+		return errInvalidProgramData
+	}
+	return nil
+}
+
+// programEncodeError is used to abort encoding or decoding, it's recovered in MarshalBinary() and UnmarshalBinary().
+type programEncodeError struct {
+	err error
+}
+
+func (e *programEncoder) fail(format string, args ...interface{}) {
+	panic(programEncodeError{fmt.Errorf("goja: cannot marshal program: "+format, args...)})
+}
+
+func (e *programEncoder) writeUint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutUvarint(b[:], n)]...)
+}
+
+func (e *programEncoder) writeInt(n int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutVarint(b[:], n)]...)
+}
+
+func (e *programEncoder) writeString(s string) {
+	e.writeUint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *programEncoder) writeBool(b bool) {
+	if b {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *programEncoder) writeFile(f *file.File) {
+	if f == nil {
+		e.writeUint(0)
+		return
+	}
+	if idx, exists := e.files[f]; exists {
+		e.writeUint(idx)
+		return
+	}
+	idx := uint64(len(e.files) + 1)
+	e.files[f] = idx
+	e.writeUint(idx)
+	e.writeString(f.Name())
+	e.writeString(f.Source())
+	e.writeUint(uint64(f.Base()))
+	e.writeString(string(f.SourceMapData()))
+}
+
+// writeSrcString writes s as a reference into the source of the current program if it's a substring of it, or as
+// a literal string otherwise.
+func (e *programEncoder) writeSrcString(s string) {
+	if e.src != nil {
+		if offset, ok := substringOffset(e.src.Source(), s); ok {
+			e.writeUint(uint64(offset) + 1)
+			e.writeUint(uint64(len(s)))
+			return
+		}
+	}
+	e.writeUint(0)
+	e.writeString(s)
+}
+
+// substringOffset returns the offset of sub within s if sub shares the memory with s.
+func substringOffset(s, sub string) (int, bool) {
+	if len(sub) == 0 || len(sub) > len(s) {
+		return 0, false
+	}
+	start := (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+	p := (*reflect.StringHeader)(unsafe.Pointer(&sub)).Data
+	if p < start || p-start > uintptr(len(s)-len(sub)) {
+		return 0, false
+	}
+	return int(p - start), true
+}
+
+// writeProgramRef writes a reference to a program that has already been written or the program itself.
+func (e *programEncoder) writeProgramRef(p *Program) {
+	if p == nil {
+		e.writeUint(0)
+		return
+	}
+	if idx, exists := e.progs[p]; exists {
+		e.writeUint(idx + 2)
+		return
+	}
+	e.writeUint(1)
+	e.writeProgram(p)
+}
+
+func (e *programEncoder) writeProgram(p *Program) {
+	e.progs[p] = uint64(len(e.progs))
+	prevSrc := e.src
+	e.src = p.src
+	defer func() {
+		e.src = prevSrc
+	}()
+	e.writeString(string(p.funcName))
+	e.writeFile(p.src)
+	e.writeUint(uint64(len(p.values)))
+	for _, v := range p.values {
+		e.writeValue(v)
+	}
+	e.writeUint(uint64(len(p.srcMap)))
+	lastPc := 0
+	for _, item := range p.srcMap {
+		e.writeUint(uint64(item.pc - lastPc))
+		e.writeInt(int64(item.srcPos))
+		lastPc = item.pc
+	}
+	e.writeUint(uint64(len(p.code)))
+	for _, ins := range p.code {
+		e.writeInstruction(ins)
+	}
+}
+
+func (e *programEncoder) writeValue(v Value) {
+	switch v := v.(type) {
+	case valueUndefined:
+		e.buf = append(e.buf, prgValUndefined)
+	case valueNull:
+		e.buf = append(e.buf, prgValNull)
+	case valueBool:
+		if v {
+			e.buf = append(e.buf, prgValTrue)
+		} else {
+			e.buf = append(e.buf, prgValFalse)
+		}
+	case valueInt:
+		e.buf = append(e.buf, prgValInt)
+		e.writeInt(int64(v))
+	case valueFloat:
+		e.buf = append(e.buf, prgValFloat)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(float64(v)))
+		e.buf = append(e.buf, b[:]...)
+	case valueString:
+		e.buf = append(e.buf, prgValString)
+		e.writeString(string(v.string()))
+	case *valueProperty:
+		if v.accessor {
+			e.fail("unsupported accessor property value")
+		}
+		e.buf = append(e.buf, prgValProperty)
+		e.writeBool(v.writable)
+		e.writeBool(v.configurable)
+		e.writeBool(v.enumerable)
+		e.writeValue(v.value)
+	default:
+		e.fail("unsupported literal value type %T", v)
+	}
+}
+
+func (e *programEncoder) writeInstruction(ins instruction) {
+	switch ins := ins.(type) {
+	case *coverCounter:
+		e.fail("programs with coverage counters are not supported")
+	case *getPrivatePropId, *getPrivatePropIdCallee, *setPrivatePropId, *setPrivatePropIdP, *getPrivateRefId,
+		*privateInId:
+		e.fail("the program is bound to a Runtime")
+	case *newRegexp:
+		e.writeUint(programOpcodes[reflect.TypeOf(ins)])
+		e.writeString(ins.src.String())
+		e.writeString(ins.pattern.flags())
+		return
+	case throwConst:
+		msg, ok := ins.v.(referenceError)
+		if !ok {
+			e.fail("unsupported constant exception type %T", ins.v)
+		}
+		e.writeUint(programOpcodes[reflect.TypeOf(ins)])
+		e.writeString(string(msg))
+		return
+	}
+	t := reflect.TypeOf(ins)
+	op, exists := programOpcodes[t]
+	if !exists {
+		e.fail("unsupported instruction %T", ins)
+	}
+	e.writeUint(op)
+	v := reflect.ValueOf(ins)
+	if t.Kind() == reflect.Ptr {
+		v = v.Elem()
+	} else {
+		// make it addressable, so that the unexported fields could be accessed
+		pv := reflect.New(t).Elem()
+		pv.Set(v)
+		v = pv
+	}
+	e.writeField(v)
+}
+
+func (e *programEncoder) writeField(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Bool:
+		e.writeBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		e.writeUint(v.Uint())
+	case reflect.String:
+		e.writeSrcString(v.String())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			e.writeField(v.Field(i))
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			e.writeUint(0)
+			return
+		}
+		e.writeUint(uint64(v.Len()) + 1)
+		if v.Type().Elem() == typeValue {
+			for _, val := range exposeField(v).Interface().([]Value) {
+				e.writeValue(val)
+			}
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			e.writeField(v.Index(i))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.writeUint(0)
+			return
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		e.writeUint(uint64(len(keys)) + 1)
+		for _, key := range keys {
+			e.writeField(key)
+			e.writeField(v.MapIndex(key))
+		}
+	case reflect.Ptr:
+		if v.Type() != typeProgramPtr {
+			e.fail("unsupported field type %s", v.Type())
+		}
+		e.writeProgramRef(exposeField(v).Interface().(*Program))
+	default:
+		e.fail("unsupported field type %s", v.Type())
+	}
+}
+
+// exposeField returns a copy of the addressable v that can be used to read and modify an unexported field.
+func exposeField(v reflect.Value) reflect.Value {
+	return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+}
+
+func (d *programDecoder) fail() {
+	panic(programEncodeError{errInvalidProgramData})
+}
+
+func (d *programDecoder) readByte() byte {
+	if d.pos >= len(d.data) {
+		d.fail()
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *programDecoder) readUint() uint64 {
+	n, size := binary.Uvarint(d.data[d.pos:])
+	if size <= 0 {
+		d.fail()
+	}
+	d.pos += size
+	return n
+}
+
+func (d *programDecoder) readLen() int {
+	n := d.readUint()
+	if n > uint64(len(d.data)) {
+		d.fail()
+	}
+	return int(n)
+}
+
+func (d *programDecoder) readInt() int64 {
+	n, size := binary.Varint(d.data[d.pos:])
+	if size <= 0 {
+		d.fail()
+	}
+	d.pos += size
+	return n
+}
+
+func (d *programDecoder) readString() string {
+	l := d.readLen()
+	if d.pos+l > len(d.data) {
+		d.fail()
+	}
+	s := string(d.data[d.pos : d.pos+l])
+	d.pos += l
+	return s
+}
+
+func (d *programDecoder) readBool() bool {
+	switch d.readByte() {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	d.fail()
+	return false
+}
+
+func (d *programDecoder) readFile() *file.File {
+	idx := d.readUint()
+	if idx == 0 {
+		return nil
+	}
+	if idx <= uint64(len(d.files)) {
+		return d.files[idx-1]
+	}
+	if idx != uint64(len(d.files)+1) {
+		d.fail()
+	}
+	name := d.readString()
+	src := d.readString()
+	base := d.readUint()
+	if base < 1 || base > math.MaxInt32 {
+		d.fail()
+	}
+	f := file.NewFile(name, src, int(base))
+	if smData := d.readString(); smData != "" {
+		sm, err := sourcemap.Parse(name, []byte(smData))
+		if err != nil {
+			panic(programEncodeError{err})
+		}
+		f.SetSourceMapData(sm, []byte(smData))
+	}
+	d.files = append(d.files, f)
+	return f
+}
+
+func (d *programDecoder) readSrcString() string {
+	offset := d.readUint()
+	if offset == 0 {
+		return d.readString()
+	}
+	offset--
+	l := d.readUint()
+	if d.src == nil {
+		d.fail()
+	}
+	src := d.src.Source()
+	if offset > uint64(len(src)) || l > uint64(len(src))-offset {
+		d.fail()
+	}
+	return src[offset : offset+l]
+}
+
+func (d *programDecoder) readProgramRef() *Program {
+	idx := d.readUint()
+	switch idx {
+	case 0:
+		return nil
+	case 1:
+		p := &Program{}
+		d.readProgramInto(p)
+		return p
+	}
+	idx -= 2
+	if idx >= uint64(len(d.progs)) {
+		d.fail()
+	}
+	return d.progs[idx]
+}
+
+func (d *programDecoder) readProgramInto(p *Program) {
+	d.progs = append(d.progs, p)
+	p.funcName = unistring.String(d.readString())
+	p.src = d.readFile()
+	prevSrc := d.src
+	d.src = p.src
+	defer func() {
+		d.src = prevSrc
+	}()
+	if n := d.readLen(); n > 0 {
+		p.values = make([]Value, n)
+		for i := range p.values {
+			p.values[i] = d.readValue()
+		}
+	}
+	if n := d.readLen(); n > 0 {
+		p.srcMap = make([]srcMapItem, n)
+		pc := 0
+		for i := range p.srcMap {
+			pc += d.readLen()
+			p.srcMap[i] = srcMapItem{pc: pc, srcPos: int(d.readInt())}
+		}
+	}
+	p.code = make([]instruction, d.readLen())
+	for i := range p.code {
+		p.code[i] = d.readInstruction()
+	}
+}
+
+func (d *programDecoder) readValue() Value {
+	switch d.readByte() {
+	case prgValUndefined:
+		return _undefined
+	case prgValNull:
+		return _null
+	case prgValTrue:
+		return valueTrue
+	case prgValFalse:
+		return valueFalse
+	case prgValInt:
+		return valueInt(d.readInt())
+	case prgValFloat:
+		if d.pos+8 > len(d.data) {
+			d.fail()
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(d.data[d.pos:]))
+		d.pos += 8
+		return valueFloat(f)
+	case prgValString:
+		return stringValueFromRaw(unistring.String(d.readString()))
+	case prgValProperty:
+		prop := &valueProperty{}
+		prop.writable = d.readBool()
+		prop.configurable = d.readBool()
+		prop.enumerable = d.readBool()
+		prop.value = d.readValue()
+		return prop
+	}
+	d.fail()
+	return nil
+}
+
+func (d *programDecoder) readInstruction() instruction {
+	op := d.readUint()
+	if op >= uint64(len(programInstructions)) {
+		d.fail()
+	}
+	proto := programInstructions[op]
+	switch proto.(type) {
+	case *newRegexp:
+		src := d.readString()
+		pattern, err := compileRegexp(src, d.readString())
+		if err != nil {
+			d.fail()
+		}
+		return &newRegexp{pattern: pattern, src: newStringValue(src)}
+	case throwConst:
+		return throwConst{referenceError(d.readString())}
+	}
+	t := reflect.TypeOf(proto)
+	if t.Kind() == reflect.Ptr {
+		v := reflect.New(t.Elem())
+		d.readField(v.Elem())
+		return v.Interface().(instruction)
+	}
+	v := reflect.New(t).Elem()
+	d.readField(v)
+	return v.Interface().(instruction)
+}
+
+func (d *programDecoder) readField(v reflect.Value) {
+	if !v.CanSet() {
+		v = exposeField(v)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(d.readBool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := d.readInt()
+		if v.OverflowInt(n) {
+			d.fail()
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n := d.readUint()
+		if v.OverflowUint(n) {
+			d.fail()
+		}
+		v.SetUint(n)
+	case reflect.String:
+		v.SetString(d.readSrcString())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			d.readField(v.Field(i))
+		}
+	case reflect.Slice:
+		n := d.readLen()
+		if n == 0 {
+			return
+		}
+		n--
+		if v.Type().Elem() == typeValue {
+			values := make([]Value, n)
+			for i := range values {
+				values[i] = d.readValue()
+			}
+			v.Set(reflect.ValueOf(values))
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			d.readField(s.Index(i))
+		}
+		v.Set(s)
+	case reflect.Map:
+		n := d.readLen()
+		if n == 0 {
+			return
+		}
+		n--
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		kt, et := v.Type().Key(), v.Type().Elem()
+		for i := 0; i < n; i++ {
+			key := reflect.New(kt).Elem()
+			d.readField(key)
+			elem := reflect.New(et).Elem()
+			d.readField(elem)
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Ptr:
+		if v.Type() != typeProgramPtr {
+			d.fail()
+		}
+		if p := d.readProgramRef(); p != nil {
+			v.Set(reflect.ValueOf(p))
+		}
+	default:
+		d.fail()
+	}
+}
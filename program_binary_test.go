@@ -0,0 +1,134 @@
+package goja
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestProgramMarshalBinary(t *testing.T) {
+	const SCRIPT = `
+	class A {
+		#x = 1;
+		static s = "static";
+		get x() { return this.#x; }
+		inc() { this.#x++; return this; }
+	}
+	class B extends A {
+		constructor() { super(); this.b = [..."ab"]; }
+	}
+	function tag(s, ...args) { return s.raw.join("|") + args.join(","); }
+	const re = /a(b+)/gi;
+	let { p = 2, ...rest } = { q: 3, r: 4 };
+	var res = [];
+	for (const v of [1.5, -2, 2**53]) {
+		res.push(v);
+	}
+	try {
+		null.x;
+	} catch (e) {
+		res.push(e instanceof TypeError);
+	} finally {
+		res.push("finally");
+	}
+	res.push(new B().inc().x, A.s, tag` + "`a${1}\\n${2}b`" + `, "xABBx".replace(re, "$1"), p, rest.r,
+		(x => x * 2)(21), typeof undeclared, "\u{1F600}".length, 0.1 + 0.2, null ?? "n");
+	res.join(";");
+	`
+	prg, err := Compile("test.js", SCRIPT, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := New().RunProgram(prg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := prg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prg1 Program
+	if err := prg1.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	res, err := New().RunProgram(&prg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.SameAs(expected) {
+		t.Fatalf("Unexpected result: %v, expected: %v", res, expected)
+	}
+	data1, err := prg1.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data1) != string(data) {
+		t.Fatal("Re-encoded program differs")
+	}
+}
+
+func TestProgramMarshalBinaryPositions(t *testing.T) {
+	sourceMap := `{"version":3,"sources":["orig.js"],"names":[],"mappings":";;;AAAA,QAAQ"}`
+	src := "\n\n(function f() {\n\tthrow new Error('boom');\n})();\n//# sourceMappingURL=data:application/json;base64," +
+		base64.StdEncoding.EncodeToString([]byte(sourceMap))
+	prg, err := Compile("test.js", src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := prg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prg1 Program
+	if err := prg1.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if prg1.src == nil || prg1.src.SourceMap() == nil {
+		t.Fatal("Source map was not restored")
+	}
+	_, err1 := New().RunProgram(prg)
+	_, err2 := New().RunProgram(&prg1)
+	if err1 == nil || err2 == nil {
+		t.Fatal("Expected errors")
+	}
+	if err1.Error() != err2.Error() {
+		t.Fatalf("%q != %q", err1, err2)
+	}
+	if !strings.Contains(err2.Error(), "orig.js") {
+		t.Fatal(err2)
+	}
+}
+
+func TestProgramUnmarshalBinaryInvalid(t *testing.T) {
+	prg := MustCompile("test.js", "function f(a) { return a + 'x'; } f(1);", false)
+	data, err := prg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p Program
+	if err := p.UnmarshalBinary([]byte("not a program")); err != errInvalidProgramData {
+		t.Fatal(err)
+	}
+	badVersion := append([]byte(programMagic), programVersion+1)
+	if err := p.UnmarshalBinary(badVersion); err != errProgramVersion {
+		t.Fatal(err)
+	}
+	for i := len(programMagic) + 1; i < len(data); i++ {
+		if err := p.UnmarshalBinary(data[:i]); err == nil {
+			t.Fatalf("Truncated data (%d bytes) was accepted", i)
+		}
+	}
+	if err := p.UnmarshalBinary(append(data, 0)); err != errInvalidProgramData {
+		t.Fatal(err)
+	}
+}
+
+func TestProgramMarshalBinaryCoverage(t *testing.T) {
+	prg, err := NewCoverage().Compile("test.js", "1 + 1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prg.MarshalBinary(); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
@@ -0,0 +1,106 @@
+package goja
+
+// Realm is a set of intrinsics (Object, Array, Function.prototype, etc.) together with a global object
+// and global lexical bindings. Each Runtime has a main realm that is created by New(). Additional realms
+// can be created either from Go using Runtime.NewRealm() or from JavaScript using ShadowRealm.
+//
+// All realms share the same Runtime and vm, therefore they must not be used concurrently with each other
+// or with the Runtime.
+//
+// Each function remembers the realm it was created in and runs in that realm regardless of where it is called
+// from, so a function obtained from one realm (for example using Realm.Get()) resolves global variables and
+// intrinsics in its own realm when it is called from another realm or from Go.
+type Realm struct {
+	r               *Runtime
+	global          global
+	globalObject    *Object
+	stringSingleton *stringObject
+}
+
+func (r *Runtime) setRealm(realm *Realm) {
+	r.realm = realm
+	r.global = &realm.global
+	r.globalObject = realm.globalObject
+	r.stringSingleton = realm.stringSingleton
+}
+
+func (r *Runtime) newRealm() *Realm {
+	realm := &Realm{r: r}
+	prev := r.realm
+	defer r.setRealm(prev)
+	r.setRealm(realm)
+	r.initRealm()
+	return realm
+}
+
+// runInRealm runs f with realm as the current realm and restores the previous realm afterwards.
+func (r *Runtime) runInRealm(realm *Realm, f func()) {
+	if prev := r.realm; prev != realm {
+		defer r.setRealm(prev)
+		r.setRealm(realm)
+	}
+	f()
+}
+
+// NewRealm creates a new Realm with a fresh set of intrinsics and a new global object. This is considerably
+// cheaper than creating a new Runtime.
+func (r *Runtime) NewRealm() *Realm {
+	return r.newRealm()
+}
+
+// Realm returns the current realm of the Runtime. When called from a Go function that was called from
+// JavaScript this is the realm the function was created in (i.e. the realm it was passed to using Set()),
+// otherwise it is the main realm.
+func (r *Runtime) Realm() *Realm {
+	return r.realm
+}
+
+// Runtime returns the Runtime this Realm belongs to.
+func (realm *Realm) Runtime() *Runtime {
+	return realm.r
+}
+
+// GlobalObject returns the global object of the realm.
+func (realm *Realm) GlobalObject() *Object {
+	return realm.globalObject
+}
+
+// RunString executes the given string in the global context of the realm.
+func (realm *Realm) RunString(str string) (Value, error) {
+	return realm.RunScript("", str)
+}
+
+// RunScript executes the given string in the global context of the realm.
+func (realm *Realm) RunScript(name, src string) (Value, error) {
+	p, err := realm.r.compile(name, src, false, true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return realm.RunProgram(p)
+}
+
+// RunProgram executes a pre-compiled (see Compile()) code in the global context of the realm.
+// A Program may be run in any number of realms.
+func (realm *Realm) RunProgram(p *Program) (result Value, err error) {
+	realm.r.runInRealm(realm, func() {
+		result, err = realm.r.RunProgram(p)
+	})
+	return
+}
+
+// Set the specified variable in the global context of the realm. See Runtime.Set() for details.
+func (realm *Realm) Set(name string, value interface{}) (err error) {
+	realm.r.runInRealm(realm, func() {
+		err = realm.r.Set(name, value)
+	})
+	return
+}
+
+// Get the specified variable in the global context of the realm. See Runtime.Get() for details.
+func (realm *Realm) Get(name string) (ret Value) {
+	realm.r.runInRealm(realm, func() {
+		ret = realm.r.Get(name)
+	})
+	return
+}
@@ -8,6 +8,8 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf16"
 )
 
@@ -17,9 +19,31 @@ type regexp2MatchCache struct {
 	posMap []int
 }
 
+// regexp2 cannot be cancelled, but it does check MatchTimeout periodically. To make a match interruptible
+// it is run with a short timeout first. If the timeout expires and the vm has not been interrupted the match
+// is restarted from the beginning with a doubled timeout, so a match that takes longer than
+// regexp2InterruptCheckTimeout does up to twice its normal work. The interrupt latency is bounded by the
+// timeout of the current attempt, i.e. roughly by the time the match has already been running, but no more
+// than regexp2InterruptCheckTimeout << (regexp2MaxTimeoutDoublings - 1). After that the match runs without
+// a timeout.
+const (
+	regexp2InterruptCheckTimeout = 50 * time.Millisecond
+	regexp2MaxTimeoutDoublings   = 16
+)
+
+// regexp2Timed holds a lazily compiled copy of a regexp with MatchTimeout set to regexp2InterruptCheckTimeout.
+// It is shared between the clones of a regexp2Wrapper.
+type regexp2Timed struct {
+	once sync.Once
+	src  string
+	opts regexp2.RegexOptions
+	rx   *regexp2.Regexp
+}
+
 // Not goroutine-safe. Use regexp2Wrapper.clone()
 type regexp2Wrapper struct {
 	rx    *regexp2.Regexp
+	timed *regexp2Timed
 	cache *regexp2MatchCache
 }
 
@@ -67,6 +91,27 @@ type regexpPattern struct {
 	regexp2Wrapper *regexp2Wrapper
 }
 
+// flags returns the flags string the pattern was compiled with.
+func (p *regexpPattern) flags() string {
+	var flags []byte
+	if p.global {
+		flags = append(flags, 'g')
+	}
+	if p.ignoreCase {
+		flags = append(flags, 'i')
+	}
+	if p.multiline {
+		flags = append(flags, 'm')
+	}
+	if p.unicode {
+		flags = append(flags, 'u')
+	}
+	if p.sticky {
+		flags = append(flags, 'y')
+	}
+	return string(flags)
+}
+
 func compileRegexp2(src string, multiline, ignoreCase bool) (*regexp2Wrapper, error) {
 	var opts regexp2.RegexOptions = regexp2.ECMAScript
 	if multiline {
@@ -80,7 +125,53 @@ func compileRegexp2(src string, multiline, ignoreCase bool) (*regexp2Wrapper, er
 		return nil, fmt.Errorf("Invalid regular expression (regexp2): %s (%v)", src, err1)
 	}
 
-	return &regexp2Wrapper{rx: regexp2Pattern}, nil
+	return &regexp2Wrapper{
+		rx: regexp2Pattern,
+		timed: &regexp2Timed{
+			src:  src,
+			opts: opts,
+		},
+	}, nil
+}
+
+func (t *regexp2Timed) compile(timeout time.Duration) *regexp2.Regexp {
+	rx, err := regexp2.Compile(t.src, t.opts)
+	if err != nil {
+		// the same source has been compiled successfully before
+		panic(err)
+	}
+	rx.MatchTimeout = timeout
+	return rx
+}
+
+// get returns a copy of the regexp with MatchTimeout set to regexp2InterruptCheckTimeout << i. Only the copy
+// for i == 0 is cached, the others are only needed for matches that have already been running for a while,
+// so the cost of compiling them is negligible.
+func (t *regexp2Timed) get(i int) *regexp2.Regexp {
+	if i > 0 {
+		return t.compile(regexp2InterruptCheckTimeout << uint(i))
+	}
+	t.once.Do(func() {
+		t.rx = t.compile(regexp2InterruptCheckTimeout)
+	})
+	return t.rx
+}
+
+// run calls f with the regexp to match with. If vm is not nil the match is stopped when the vm is interrupted,
+// see regexp2InterruptCheckTimeout for the latency.
+func (r *regexp2Wrapper) run(vm *vm, f func(rx *regexp2.Regexp) (*regexp2.Match, error)) (*regexp2.Match, error) {
+	if vm == nil {
+		return f(r.rx)
+	}
+	vm.checkInterrupt()
+	for i := 0; i < regexp2MaxTimeoutDoublings; i++ {
+		match, err := f(r.timed.get(i))
+		if err == nil {
+			return match, nil
+		}
+		vm.checkInterrupt()
+	}
+	return f(r.rx)
 }
 
 func (p *regexpPattern) createRegexp2() {
@@ -117,23 +208,23 @@ func buildUTF8PosMap(s valueString) (positionMap, string) {
 	return pm, sb.String()
 }
 
-func (p *regexpPattern) findSubmatchIndex(s valueString, start int) []int {
+func (p *regexpPattern) findSubmatchIndex(vm *vm, s valueString, start int) []int {
 	if p.regexpWrapper == nil {
-		return p.regexp2Wrapper.findSubmatchIndex(s, start, p.unicode, p.global || p.sticky)
+		return p.regexp2Wrapper.findSubmatchIndex(vm, s, start, p.unicode, p.global || p.sticky)
 	}
 	if start != 0 {
 		// Unfortunately Go's regexp library does not allow starting from an arbitrary position.
 		// If we just drop the first _start_ characters of the string the assertions (^, $, \b and \B) will not
 		// work correctly.
 		p.createRegexp2()
-		return p.regexp2Wrapper.findSubmatchIndex(s, start, p.unicode, p.global || p.sticky)
+		return p.regexp2Wrapper.findSubmatchIndex(vm, s, start, p.unicode, p.global || p.sticky)
 	}
 	return p.regexpWrapper.findSubmatchIndex(s, p.unicode)
 }
 
-func (p *regexpPattern) findAllSubmatchIndex(s valueString, start int, limit int, sticky bool) [][]int {
+func (p *regexpPattern) findAllSubmatchIndex(vm *vm, s valueString, start int, limit int, sticky bool) [][]int {
 	if p.regexpWrapper == nil {
-		return p.regexp2Wrapper.findAllSubmatchIndex(s, start, limit, sticky, p.unicode)
+		return p.regexp2Wrapper.findAllSubmatchIndex(vm, s, start, limit, sticky, p.unicode)
 	}
 	if start == 0 {
 		if s, ok := s.(asciiString); ok {
@@ -164,7 +255,7 @@ func (p *regexpPattern) findAllSubmatchIndex(s valueString, start int, limit int
 	}
 
 	p.createRegexp2()
-	return p.regexp2Wrapper.findAllSubmatchIndex(s, start, limit, sticky, p.unicode)
+	return p.regexp2Wrapper.findAllSubmatchIndex(vm, s, start, limit, sticky, p.unicode)
 }
 
 // clone creates a copy of the regexpPattern which can be used concurrently.
@@ -194,15 +285,14 @@ type regexpObject struct {
 	standard bool
 }
 
-func (r *regexp2Wrapper) findSubmatchIndex(s valueString, start int, fullUnicode, doCache bool) (result []int) {
+func (r *regexp2Wrapper) findSubmatchIndex(vm *vm, s valueString, start int, fullUnicode, doCache bool) (result []int) {
 	if fullUnicode {
-		return r.findSubmatchIndexUnicode(s, start, doCache)
+		return r.findSubmatchIndexUnicode(vm, s, start, doCache)
 	}
-	return r.findSubmatchIndexUTF16(s, start, doCache)
+	return r.findSubmatchIndexUTF16(vm, s, start, doCache)
 }
 
-func (r *regexp2Wrapper) findUTF16Cached(s valueString, start int, doCache bool) (match *regexp2.Match, runes []rune, err error) {
-	wrapped := r.rx
+func (r *regexp2Wrapper) findUTF16Cached(vm *vm, s valueString, start int, doCache bool) (match *regexp2.Match, runes []rune, err error) {
 	cache := r.cache
 	if cache != nil && cache.posMap == nil && cache.target.SameAs(s) {
 		runes = cache.runes
@@ -210,7 +300,9 @@ func (r *regexp2Wrapper) findUTF16Cached(s valueString, start int, doCache bool)
 		runes = s.utf16Runes()
 		cache = nil
 	}
-	match, err = wrapped.FindRunesMatchStartingAt(runes, start)
+	match, err = r.run(vm, func(rx *regexp2.Regexp) (*regexp2.Match, error) {
+		return rx.FindRunesMatchStartingAt(runes, start)
+	})
 	if doCache && match != nil && err == nil {
 		if cache == nil {
 			if r.cache == nil {
@@ -227,8 +319,8 @@ func (r *regexp2Wrapper) findUTF16Cached(s valueString, start int, doCache bool)
 	return
 }
 
-func (r *regexp2Wrapper) findSubmatchIndexUTF16(s valueString, start int, doCache bool) (result []int) {
-	match, _, err := r.findUTF16Cached(s, start, doCache)
+func (r *regexp2Wrapper) findSubmatchIndexUTF16(vm *vm, s valueString, start int, doCache bool) (result []int) {
+	match, _, err := r.findUTF16Cached(vm, s, start, doCache)
 	if err != nil {
 		return
 	}
@@ -249,14 +341,13 @@ func (r *regexp2Wrapper) findSubmatchIndexUTF16(s valueString, start int, doCach
 	return
 }
 
-func (r *regexp2Wrapper) findUnicodeCached(s valueString, start int, doCache bool) (match *regexp2.Match, posMap []int, err error) {
+func (r *regexp2Wrapper) findUnicodeCached(vm *vm, s valueString, start int, doCache bool) (match *regexp2.Match, posMap []int, err error) {
 	var (
 		runes       []rune
 		mappedStart int
 		splitPair   bool
 		savedRune   rune
 	)
-	wrapped := r.rx
 	cache := r.cache
 	if cache != nil && cache.posMap != nil && cache.target.SameAs(s) {
 		runes, posMap = cache.runes, cache.posMap
@@ -270,7 +361,9 @@ func (r *regexp2Wrapper) findUnicodeCached(s valueString, start int, doCache boo
 		_, second := utf16.EncodeRune(runes[mappedStart])
 		savedRune, runes[mappedStart] = runes[mappedStart], second
 	}
-	match, err = wrapped.FindRunesMatchStartingAt(runes, mappedStart)
+	match, err = r.run(vm, func(rx *regexp2.Regexp) (*regexp2.Match, error) {
+		return rx.FindRunesMatchStartingAt(runes, mappedStart)
+	})
 	if doCache && match != nil && err == nil {
 		if splitPair {
 			runes[mappedStart] = savedRune
@@ -292,8 +385,8 @@ func (r *regexp2Wrapper) findUnicodeCached(s valueString, start int, doCache boo
 	return
 }
 
-func (r *regexp2Wrapper) findSubmatchIndexUnicode(s valueString, start int, doCache bool) (result []int) {
-	match, posMap, err := r.findUnicodeCached(s, start, doCache)
+func (r *regexp2Wrapper) findSubmatchIndexUnicode(vm *vm, s valueString, start int, doCache bool) (result []int) {
+	match, posMap, err := r.findUnicodeCached(vm, s, start, doCache)
 	if match == nil || err != nil {
 		return
 	}
@@ -311,9 +404,8 @@ func (r *regexp2Wrapper) findSubmatchIndexUnicode(s valueString, start int, doCa
 	return
 }
 
-func (r *regexp2Wrapper) findAllSubmatchIndexUTF16(s valueString, start, limit int, sticky bool) [][]int {
-	wrapped := r.rx
-	match, runes, err := r.findUTF16Cached(s, start, false)
+func (r *regexp2Wrapper) findAllSubmatchIndexUTF16(vm *vm, s valueString, start, limit int, sticky bool) [][]int {
+	match, runes, err := r.findUTF16Cached(vm, s, start, false)
 	if match == nil || err != nil {
 		return nil
 	}
@@ -348,7 +440,10 @@ func (r *regexp2Wrapper) findAllSubmatchIndexUTF16(s valueString, start, limit i
 		if limit <= 0 {
 			break
 		}
-		match, err = wrapped.FindNextMatch(match)
+		prev := match
+		match, err = r.run(vm, func(rx *regexp2.Regexp) (*regexp2.Match, error) {
+			return rx.FindNextMatch(prev)
+		})
 		if err != nil {
 			return nil
 		}
@@ -394,13 +489,12 @@ func posMapReverseLookup(posMap []int, pos int) (int, bool) {
 	return mapped, false
 }
 
-func (r *regexp2Wrapper) findAllSubmatchIndexUnicode(s unicodeString, start, limit int, sticky bool) [][]int {
-	wrapped := r.rx
+func (r *regexp2Wrapper) findAllSubmatchIndexUnicode(vm *vm, s unicodeString, start, limit int, sticky bool) [][]int {
 	if limit < 0 {
 		limit = len(s) + 1
 	}
 	results := make([][]int, 0, limit)
-	match, posMap, err := r.findUnicodeCached(s, start, false)
+	match, posMap, err := r.findUnicodeCached(vm, s, start, false)
 	if err != nil {
 		return nil
 	}
@@ -427,7 +521,10 @@ func (r *regexp2Wrapper) findAllSubmatchIndexUnicode(s unicodeString, start, lim
 		}
 
 		results = append(results, result)
-		match, err = wrapped.FindNextMatch(match)
+		prev := match
+		match, err = r.run(vm, func(rx *regexp2.Regexp) (*regexp2.Match, error) {
+			return rx.FindNextMatch(prev)
+		})
 		if err != nil {
 			return nil
 		}
@@ -435,15 +532,15 @@ func (r *regexp2Wrapper) findAllSubmatchIndexUnicode(s unicodeString, start, lim
 	return results
 }
 
-func (r *regexp2Wrapper) findAllSubmatchIndex(s valueString, start, limit int, sticky, fullUnicode bool) [][]int {
+func (r *regexp2Wrapper) findAllSubmatchIndex(vm *vm, s valueString, start, limit int, sticky, fullUnicode bool) [][]int {
 	switch s := s.(type) {
 	case asciiString:
-		return r.findAllSubmatchIndexUTF16(s, start, limit, sticky)
+		return r.findAllSubmatchIndexUTF16(vm, s, start, limit, sticky)
 	case unicodeString:
 		if fullUnicode {
-			return r.findAllSubmatchIndexUnicode(s, start, limit, sticky)
+			return r.findAllSubmatchIndexUnicode(vm, s, start, limit, sticky)
 		}
-		return r.findAllSubmatchIndexUTF16(s, start, limit, sticky)
+		return r.findAllSubmatchIndexUTF16(vm, s, start, limit, sticky)
 	default:
 		panic("Unsupported string type")
 	}
@@ -451,7 +548,8 @@ func (r *regexp2Wrapper) findAllSubmatchIndex(s valueString, start, limit int, s
 
 func (r *regexp2Wrapper) clone() *regexp2Wrapper {
 	return &regexp2Wrapper{
-		rx: r.rx,
+		rx:    r.rx,
+		timed: r.timed,
 	}
 }
 
@@ -560,7 +658,7 @@ func (r *regexpObject) updateLastIndex(index int64, firstResult, lastResult []in
 func (r *regexpObject) execRegexp(target valueString) (match bool, result []int) {
 	index := r.getLastIndex()
 	if index >= 0 && index <= int64(target.length()) {
-		result = r.pattern.findSubmatchIndex(target, int(index))
+		result = r.pattern.findSubmatchIndex(r.val.runtime.vm, target, int(index))
 	}
 	match = r.updateLastIndex(index, result, result)
 	return
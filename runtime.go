@@ -2,6 +2,7 @@ package goja
 
 import (
 	"bytes"
+	gocontext "context"
 	"errors"
 	"fmt"
 	"github.com/dop251/goja/file"
@@ -13,6 +14,8 @@ import (
 	"reflect"
 	"runtime"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/text/collate"
@@ -59,6 +62,9 @@ type global struct {
 	Symbol   *Object
 	Proxy    *Object
 	Promise  *Object
+	Iterator *Object
+
+	ShadowRealm *Object
 
 	ArrayBuffer       *Object
 	DataView          *Object
@@ -107,8 +113,11 @@ type global struct {
 	MapPrototype         *Object
 	SetPrototype         *Object
 	PromisePrototype     *Object
+	ShadowRealmPrototype *Object
 
 	IteratorPrototype             *Object
+	IteratorHelperPrototype       *Object
+	WrapForValidIteratorPrototype *Object
 	ArrayIteratorPrototype        *Object
 	MapIteratorPrototype          *Object
 	SetIteratorPrototype          *Object
@@ -137,6 +146,9 @@ type global struct {
 	weakMapAdder  *Object
 	mapAdder      *Object
 	setAdder      *Object
+	setHas        *Object
+	setValues     *Object
+	setIterNext   *Object
 	arrayValues   *Object
 	arrayToString *Object
 }
@@ -164,15 +176,37 @@ type RandSource func() float64
 
 type Now func() time.Time
 
+// DynamicCodeKind is the way the code is compiled from a string at runtime, see DynamicCodePolicy.
+type DynamicCodeKind int
+
+const (
+	// DynamicCodeEval is a direct or an indirect call to eval().
+	DynamicCodeEval DynamicCodeKind = iota
+	// DynamicCodeFunction is a call to the Function constructor.
+	DynamicCodeFunction
+	// DynamicCodeShadowRealm is a call to ShadowRealm.prototype.evaluate().
+	DynamicCodeShadowRealm
+)
+
+// DynamicCodePolicy decides whether the source may be compiled and run. For the Function constructor src is the
+// complete source of the function expression built from the arguments, e.g. "(function anonymous(a,b\n) {\nreturn a + b\n})".
+type DynamicCodePolicy func(kind DynamicCodeKind, src string) bool
+
 type Runtime struct {
-	global          global
+	// global, globalObject and stringSingleton belong to the current realm, see setRealm()
+	global          *global
 	globalObject    *Object
 	stringSingleton *stringObject
+	realm           *Realm
+	mainRealm       *Realm
 	rand            RandSource
 	now             Now
+	tz              *time.Location
 	_collator       *collate.Collator
 	parserOptions   []parser.Option
 
+	dynamicCodePolicy DynamicCodePolicy
+
 	symbolRegistry map[unistring.String]*Symbol
 
 	typeInfoCache   map[reflect.Type]*reflectTypeInfo
@@ -185,6 +219,31 @@ type Runtime struct {
 	jobQueue []func()
 
 	promiseRejectionTracker PromiseRejectionTracker
+	hostEnqueuePromiseJob   HostEnqueuePromiseJob
+	shadowRealmImporter     ShadowRealmImporter
+
+	ctx gocontext.Context
+
+	debugger *Debugger
+
+	// profiler is protected by profLock, profActive is set while it's not nil
+	profiler   *profiler
+	profLock   sync.Mutex
+	profActive uint32
+
+	coverage *Coverage
+
+	// forkTmpl is set by the first Fork(), forkLock protects it. forked is set (atomically) at the same time,
+	// so that running code in a template can be rejected without taking the lock.
+	forkTmpl *forkTemplate
+	forkLock sync.Mutex
+	forked   uint32
+
+	lockdown *lockdownState
+
+	// see SetMemoryLimit(), memLimit is 0 if there is no limit. memAllocated is the size of the live heap at the last
+	// measurement plus the allocations made since, the heap is measured again once it exceeds memNextCheck.
+	memLimit, memAllocated, memNextCheck uint64
 }
 
 type StackFrame struct {
@@ -314,6 +373,38 @@ type StackOverflowError struct {
 	Exception
 }
 
+// InstructionLimitExceededError is returned by RunProgram or by a Callable call when the limit set
+// with SetInstructionLimit() has been exceeded. It cannot be caught by JavaScript code.
+type InstructionLimitExceededError struct {
+	Exception
+	limit uint64
+}
+
+// Limit returns the instruction limit that was in effect.
+func (e *InstructionLimitExceededError) Limit() uint64 {
+	return e.limit
+}
+
+func (e *InstructionLimitExceededError) String() string {
+	if e == nil {
+		return "<nil>"
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "instruction limit (%d) exceeded\n", e.limit)
+	e.writeFullStack(&b)
+	return b.String()
+}
+
+func (e *InstructionLimitExceededError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "instruction limit (%d) exceeded", e.limit)
+	e.writeShortStack(&b)
+	return b.String()
+}
+
 func (e *InterruptedError) Value() interface{} {
 	return e.iface
 }
@@ -387,31 +478,32 @@ func (r *Runtime) addToGlobal(name string, value Value) {
 	r.globalObject.self._putProp(unistring.String(name), value, true, false, true)
 }
 
-func (r *Runtime) createIterProto(val *Object) objectImpl {
-	o := newBaseObjectObj(val, r.global.ObjectPrototype, classObject)
-
-	o._putSym(SymIterator, valueProp(r.newNativeFunc(r.returnThis, nil, "[Symbol.iterator]", nil, 0), true, false, true))
-	return o
-}
-
 func (r *Runtime) init() {
 	r.rand = rand.Float64
 	r.now = time.Now
-	r.global.ObjectPrototype = r.newBaseObject(nil, classObject).val
-	r.globalObject = r.NewObject()
+	r.mainRealm = &Realm{r: r}
+	r.setRealm(r.mainRealm)
 
 	r.vm = &vm{
 		r: r,
 	}
 	r.vm.init()
 
+	r.initRealm()
+}
+
+// initRealm creates the intrinsics and the global object of the current realm.
+func (r *Runtime) initRealm() {
+	r.global.ObjectPrototype = r.newBaseObject(nil, classObject).val
+	r.globalObject = r.NewObject()
+
 	funcProto := r.newNativeFunc(func(FunctionCall) Value {
 		return _undefined
 	}, nil, " ", nil, 0)
 	r.global.FunctionPrototype = funcProto
 	funcProtoObj := funcProto.self.(*nativeFuncObject)
 
-	r.global.IteratorPrototype = r.newLazyObject(r.createIterProto)
+	r.initIterator()
 
 	r.initObject()
 	r.initFunction()
@@ -440,6 +532,7 @@ func (r *Runtime) init() {
 	r.initMap()
 	r.initSet()
 	r.initPromise()
+	r.initShadowRealm()
 
 	r.global.thrower = r.newNativeFunc(r.builtin_thrower, nil, "", nil, 0)
 	r.global.throwerProperty = &valueProperty{
@@ -461,6 +554,9 @@ func (r *Runtime) init() {
 		accessor:     true,
 		configurable: true,
 	})
+
+	r.realm.globalObject = r.globalObject
+	r.realm.stringSingleton = r.stringSingleton
 }
 
 func (r *Runtime) typeErrorResult(throw bool, args ...interface{}) {
@@ -929,11 +1025,19 @@ func (r *Runtime) eval(srcVal valueString, direct, strict bool) Value {
 	return retval
 }
 
+// checkDynamicCode throws an EvalError if compiling the source is not allowed by the policy.
+func (r *Runtime) checkDynamicCode(kind DynamicCodeKind, src valueString) {
+	if policy := r.dynamicCodePolicy; policy != nil && !policy(kind, src.String()) {
+		panic(r.newError(r.global.EvalError, "Code generation from strings disallowed for this context"))
+	}
+}
+
 func (r *Runtime) builtin_eval(call FunctionCall) Value {
 	if len(call.Arguments) == 0 {
 		return _undefined
 	}
 	if str, ok := call.Arguments[0].(valueString); ok {
+		r.checkDynamicCode(DynamicCodeEval, str)
 		return r.eval(str, false, false)
 	}
 	return call.Arguments[0]
@@ -1276,14 +1380,14 @@ func New() *Runtime {
 // method. This representation is not linked to a runtime in any way and can be run in multiple runtimes (possibly
 // at the same time).
 func Compile(name, src string, strict bool) (*Program, error) {
-	return compile(name, src, strict, true, nil)
+	return compile(name, src, strict, true, nil, compilerMode{})
 }
 
 // CompileAST creates an internal representation of the JavaScript code that can be later run using the Runtime.RunProgram()
 // method. This representation is not linked to a runtime in any way and can be run in multiple runtimes (possibly
 // at the same time).
 func CompileAST(prg *js_ast.Program, strict bool) (*Program, error) {
-	return compileAST(prg, strict, true, nil)
+	return compileAST(prg, strict, true, nil, compilerMode{})
 }
 
 // MustCompile is like Compile but panics if the code cannot be compiled.
@@ -1319,17 +1423,27 @@ func Parse(name, src string, options ...parser.Option) (prg *js_ast.Program, err
 	return
 }
 
-func compile(name, src string, strict, inGlobal bool, evalVm *vm, parserOptions ...parser.Option) (p *Program, err error) {
+// compilerMode holds the options that change the generated code.
+type compilerMode struct {
+	debug    bool // see compiler.debug
+	coverage *Coverage
+}
+
+func compile(name, src string, strict, inGlobal bool, evalVm *vm, mode compilerMode, parserOptions ...parser.Option) (p *Program, err error) {
 	prg, err := Parse(name, src, parserOptions...)
 	if err != nil {
 		return
 	}
 
-	return compileAST(prg, strict, inGlobal, evalVm)
+	return compileAST(prg, strict, inGlobal, evalVm, mode)
 }
 
-func compileAST(prg *js_ast.Program, strict, inGlobal bool, evalVm *vm) (p *Program, err error) {
+func compileAST(prg *js_ast.Program, strict, inGlobal bool, evalVm *vm, mode compilerMode) (p *Program, err error) {
 	c := newCompiler()
+	c.debug = mode.debug
+	if mode.coverage != nil && evalVm == nil && prg.File != nil && prg.File.Name() != "" {
+		c.coverage = mode.coverage.file(prg.File.Name(), prg.File.Source())
+	}
 
 	defer func() {
 		if x := recover(); x != nil {
@@ -1349,7 +1463,10 @@ func compileAST(prg *js_ast.Program, strict, inGlobal bool, evalVm *vm) (p *Prog
 }
 
 func (r *Runtime) compile(name, src string, strict, inGlobal bool, evalVm *vm) (p *Program, err error) {
-	p, err = compile(name, src, strict, inGlobal, evalVm, r.parserOptions...)
+	p, err = compile(name, src, strict, inGlobal, evalVm, compilerMode{
+		debug:    r.debugger != nil,
+		coverage: r.coverage,
+	}, r.parserOptions...)
 	if err != nil {
 		switch x1 := err.(type) {
 		case *CompilerSyntaxError:
@@ -1383,10 +1500,20 @@ func (r *Runtime) RunScript(name, src string) (Value, error) {
 
 // RunProgram executes a pre-compiled (see Compile()) code in the global context.
 func (r *Runtime) RunProgram(p *Program) (result Value, err error) {
+	if atomic.LoadUint32(&r.forked) != 0 {
+		return nil, errForkTemplateRun
+	}
+	vm := r.vm
+	recursive := false
 	defer func() {
 		if x := recover(); x != nil {
 			if ex, ok := x.(*uncatchableException); ok {
 				err = ex.err
+				if recursive {
+					vm.popCtx()
+					vm.halt = false
+					vm.clearStack()
+				}
 				if len(r.vm.callStack) == 0 {
 					r.leaveAbrupt()
 				}
@@ -1395,14 +1522,12 @@ func (r *Runtime) RunProgram(p *Program) (result Value, err error) {
 			}
 		}
 	}()
-	vm := r.vm
-	recursive := false
 	if len(vm.callStack) > 0 {
 		recursive = true
 		vm.pushCtx()
-		vm.stash = &r.global.stash
 		vm.sb = vm.sp - 1
 	}
+	vm.stash = &r.global.stash
 	vm.prg = p
 	vm.pc = 0
 	vm.result = _undefined
@@ -1425,6 +1550,84 @@ func (r *Runtime) RunProgram(p *Program) (result Value, err error) {
 	return
 }
 
+// RunStringContext is like RunString but the execution is interrupted when ctx is done (see RunProgramContext()).
+func (r *Runtime) RunStringContext(ctx gocontext.Context, str string) (Value, error) {
+	return r.RunScriptContext(ctx, "", str)
+}
+
+// RunScriptContext is like RunScript but the execution is interrupted when ctx is done (see RunProgramContext()).
+func (r *Runtime) RunScriptContext(ctx gocontext.Context, name, src string) (Value, error) {
+	p, err := r.compile(name, src, false, true, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.RunProgramContext(ctx, p)
+}
+
+// RunProgramContext executes a pre-compiled (see Compile()) code in the global context. If ctx is cancelled
+// or its deadline is exceeded before the execution is complete, the vm is interrupted (see Interrupt()) and
+// an *InterruptedError is returned which wraps ctx.Err(), so errors.Is(err, context.Canceled) can be used
+// to test for it. While the program is running ctx is available to Go functions through Context().
+func (r *Runtime) RunProgramContext(ctx gocontext.Context, p *Program) (result Value, err error) {
+	err = r.runContext(ctx, func() error {
+		result, err = r.RunProgram(p)
+		return err
+	})
+	return
+}
+
+// runContext makes ctx the current context and runs f, interrupting the vm if ctx is done before f returns.
+func (r *Runtime) runContext(ctx gocontext.Context, f func() error) error {
+	prevCtx := r.ctx
+	r.ctx = ctx
+	defer func() {
+		r.ctx = prevCtx
+	}()
+
+	done := ctx.Done()
+	if done == nil {
+		return f()
+	}
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	var interruptSeq uint64
+	if err := ctx.Err(); err != nil {
+		// make sure the execution does not start
+		interruptSeq = r.vm.interrupt(err)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-done:
+			interruptSeq = r.vm.interrupt(ctx.Err())
+		case <-stop:
+		}
+	}()
+	err := f()
+	close(stop)
+	wg.Wait()
+	if interruptSeq != 0 {
+		// Either the interrupt has been delivered and it only needs to affect this call, or f has
+		// returned before it could be delivered. In both cases the flag must not remain set, unless
+		// it has been set by another Interrupt() since.
+		r.vm.clearInterrupt(interruptSeq)
+	}
+	return err
+}
+
+// Context returns the context.Context of the current execution as supplied to RunProgramContext()
+// (or any other *Context() method). If there is none, context.Background() is returned.
+// This method should only be called by a Go function that is called from a running script.
+func (r *Runtime) Context() gocontext.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return gocontext.Background()
+}
+
 // CaptureCallStack appends the current call stack frames to the stack slice (which may be nil) up to the specified depth.
 // The most recent frame will be the first one.
 // If depth <= 0 or more than the number of available frames, returns the entire stack.
@@ -1448,7 +1651,9 @@ func (r *Runtime) CaptureCallStack(depth int, stack []StackFrame) []StackFrame {
 // Interrupt a running JavaScript. The corresponding Go call will return an *InterruptedError containing v.
 // If the interrupt propagates until the stack is empty the currently queued promise resolve/reject jobs will be cleared
 // without being executed. This is the same time they would be executed otherwise.
-// Note, it only works while in JavaScript code, it does not interrupt native Go functions (which includes all built-ins).
+// Built-in functions that may run for a long time (such as Array.prototype.sort(), Array.prototype.join(),
+// String.prototype.repeat(), JSON.stringify() or regular expression matching) check the interrupt flag periodically.
+// Note, it does not interrupt other native Go functions.
 // If the runtime is currently not running, it will be immediately interrupted on the next Run*() call.
 // To avoid that use ClearInterrupt()
 func (r *Runtime) Interrupt(v interface{}) {
@@ -1737,10 +1942,14 @@ func (r *Runtime) ToValue(i interface{}) Value {
 		}
 	case func(FunctionCall) Value:
 		name := unistring.NewFromString(runtime.FuncForPC(reflect.ValueOf(i).Pointer()).Name())
-		return r.newNativeFunc(i, nil, name, nil, 0)
+		return r.newNativeFunc(func(call FunctionCall) Value {
+			call.ctx = r.ctx
+			return i(call)
+		}, nil, name, nil, 0)
 	case func(FunctionCall, *Runtime) Value:
 		name := unistring.NewFromString(runtime.FuncForPC(reflect.ValueOf(i).Pointer()).Name())
 		return r.newNativeFunc(func(call FunctionCall) Value {
+			call.ctx = r.ctx
 			return i(call, r)
 		}, nil, name, nil, 0)
 	case func(ConstructorCall) *Object:
@@ -2035,7 +2244,7 @@ func (r *Runtime) toReflectValue(v Value, dst reflect.Value, ctx *objectExportCt
 			}
 		}
 		if et.Kind() == reflect.String {
-			tme, ok := dateParse(v.String())
+			tme, ok := dateParse(v.String(), r.timeZone())
 			if !ok {
 				return fmt.Errorf("could not convert string %v to %v", v, typ)
 			}
@@ -2157,6 +2366,12 @@ func (r *Runtime) toReflectValue(v Value, dst reflect.Value, ctx *objectExportCt
 	return fmt.Errorf("could not convert %v to %v", v, typ)
 }
 
+// awaitsPromise returns true if a Promise returned by a function exported to a func type with the given first
+// return value type should be awaited, i.e. the type cannot hold the Promise itself.
+func awaitsPromise(typ reflect.Type) bool {
+	return typ != typeValue && typ != typeObject && !typePromise.AssignableTo(typ)
+}
+
 func (r *Runtime) wrapJSFunc(fn Callable, typ reflect.Type) func(args []reflect.Value) (results []reflect.Value) {
 	return func(args []reflect.Value) (results []reflect.Value) {
 		jsArgs := make([]Value, len(args))
@@ -2166,6 +2381,13 @@ func (r *Runtime) wrapJSFunc(fn Callable, typ reflect.Type) func(args []reflect.
 
 		results = make([]reflect.Value, typ.NumOut())
 		res, err := fn(_undefined, jsArgs...)
+		if err == nil && typ.NumOut() > 0 && awaitsPromise(typ.Out(0)) {
+			if obj, ok := res.(*Object); ok {
+				if p, ok := obj.self.(*Promise); ok {
+					res, err = r.AwaitPromise(r.Context(), p)
+				}
+			}
+		}
 		if err == nil {
 			if typ.NumOut() > 0 {
 				v := reflect.New(typ.Out(0)).Elem()
@@ -2217,6 +2439,10 @@ func (r *Runtime) wrapJSFunc(fn Callable, typ reflect.Type) func(args []reflect.
 // are caught and returned as *Exception. In all other cases exceptions result in a panic. Any extra return values
 // are zeroed.
 //
+// If the function returns a Promise and the first return value type cannot hold it (i.e. it's not Value, *Object,
+// *Promise or interface{}), the Promise is awaited using Runtime.AwaitPromise() and its result is converted instead.
+// A rejection is treated as an exception, and if the Promise is still pending the error is ErrPromisePending.
+//
 // Note, if you want to catch and return exceptions as an `error` and you don't need the return value,
 // 'func(...) error' will not work as expected. The 'error' in this case is mapped to the function return value, not
 // the exception which will still result in a panic. Use 'func(...) (Value, error)' instead, and ignore the Value.
@@ -2320,11 +2546,36 @@ func (r *Runtime) SetTimeSource(now Now) {
 	r.now = now
 }
 
+// SetTimeZone sets the location which is used for the local time by the Date built-ins (e.g. in getHours(),
+// toString() and when parsing a date string without a time zone). If not called or set to nil, time.Local is used.
+func (r *Runtime) SetTimeZone(loc *time.Location) {
+	r.tz = loc
+}
+
+func (r *Runtime) timeZone() *time.Location {
+	if loc := r.tz; loc != nil {
+		return loc
+	}
+	return time.Local
+}
+
 // SetParserOptions sets parser options to be used by RunString, RunScript and eval() within the code.
 func (r *Runtime) SetParserOptions(opts ...parser.Option) {
 	r.parserOptions = opts
 }
 
+// SetDynamicCodePolicy restricts compiling code from strings at runtime, similar to the 'unsafe-eval' source
+// expression of the Content Security Policy. When set, the policy is called before a direct or an indirect eval(),
+// the Function constructor or ShadowRealm.prototype.evaluate() compiles a string, and if it returns false an
+// EvalError is thrown instead. To disallow the dynamic code entirely use a policy that always returns false.
+// The code compiled by the host (such as with Compile() or RunString()) is not affected. Setting the policy to nil
+// (the default) allows everything.
+// This method (as the rest of the Set* methods) is not safe for concurrent use and may only be called
+// from the vm goroutine or when the vm is not running.
+func (r *Runtime) SetDynamicCodePolicy(policy DynamicCodePolicy) {
+	r.dynamicCodePolicy = policy
+}
+
 // SetMaxCallStackSize sets the maximum function call depth. When exceeded, a *StackOverflowError is thrown and
 // returned by RunProgram or by a Callable call. This is useful to prevent memory exhaustion caused by an
 // infinite recursion. The default value is math.MaxInt32.
@@ -2334,6 +2585,46 @@ func (r *Runtime) SetMaxCallStackSize(size int) {
 	r.vm.maxCallStackSize = size
 }
 
+// chargeSort charges the instruction budget for sorting n elements.
+func (r *Runtime) chargeSort(n int) {
+	if n > 1 {
+		r.vm.charge(int64(n) * int64(bits.Len(uint(n))))
+	}
+}
+
+// SetInstructionLimit sets the maximum number of instructions the vm may execute. Built-in functions which
+// perform work proportional to the size of their input (such as Array.prototype.sort(), Array.prototype.join()
+// or String.prototype.repeat()) are charged accordingly. When the count (see InstructionCount()) exceeds
+// the limit, the execution is stopped and an *InstructionLimitExceededError is returned by RunProgram or by
+// a Callable call. Because the count is not reset automatically, any further attempt to run code will fail in the
+// same way until either the limit is raised or ResetInstructionCount() is called.
+// Setting the limit to 0 removes it. The instructions are only counted while a limit is set, so that a Runtime
+// without a limit does not pay for the accounting. To count the instructions without limiting them, set the limit
+// to math.MaxUint64.
+// This method (as the rest of the Set* methods) is not safe for concurrent use and may only be called
+// from the vm goroutine or when the vm is not running.
+func (r *Runtime) SetInstructionLimit(limit uint64) {
+	if limit == 0 {
+		r.vm.instructionLimit = math.MaxUint64
+		atomicClearFlag(&r.vm.interrupted, vmFlagInstructionLimit)
+		return
+	}
+	r.vm.instructionLimit = limit
+	atomicSetFlag(&r.vm.interrupted, vmFlagInstructionLimit)
+}
+
+// InstructionCount returns the number of instructions consumed since the Runtime was created or since the
+// last call to ResetInstructionCount(), while an instruction limit was set (see SetInstructionLimit()). The count
+// is deterministic, i.e. running the same code with the same inputs results in the same count.
+func (r *Runtime) InstructionCount() uint64 {
+	return r.vm.instructions
+}
+
+// ResetInstructionCount resets the consumed instruction count to zero.
+func (r *Runtime) ResetInstructionCount() {
+	r.vm.instructions = 0
+}
+
 // New is an equivalent of the 'new' operator allowing to call it directly from Go.
 func (r *Runtime) New(construct Value, args ...Value) (o *Object, err error) {
 	err = r.try(func() {
@@ -2345,11 +2636,34 @@ func (r *Runtime) New(construct Value, args ...Value) (o *Object, err error) {
 // Callable represents a JavaScript function that can be called from Go.
 type Callable func(this Value, args ...Value) (Value, error)
 
+// CallableContext represents a JavaScript function that can be called from Go. The call is interrupted
+// if ctx is done before it returns, see Runtime.RunProgramContext().
+type CallableContext func(ctx gocontext.Context, this Value, args ...Value) (Value, error)
+
+// AssertFunctionContext checks if the Value is a function and returns a CallableContext.
+func AssertFunctionContext(v Value) (CallableContext, bool) {
+	fn, ok := AssertFunction(v)
+	if !ok {
+		return nil, false
+	}
+	r := v.(*Object).runtime
+	return func(ctx gocontext.Context, this Value, args ...Value) (ret Value, err error) {
+		err = r.runContext(ctx, func() error {
+			ret, err = fn(this, args...)
+			return err
+		})
+		return
+	}, true
+}
+
 // AssertFunction checks if the Value is a function and returns a Callable.
 func AssertFunction(v Value) (Callable, bool) {
 	if obj, ok := v.(*Object); ok {
 		if f, ok := obj.self.assertCallable(); ok {
 			return func(this Value, args ...Value) (ret Value, err error) {
+				if atomic.LoadUint32(&obj.runtime.forked) != 0 {
+					return nil, errForkTemplateRun
+				}
 				defer func() {
 					if x := recover(); x != nil {
 						if ex, ok := x.(*uncatchableException); ok {
@@ -2626,9 +2940,16 @@ func (r *Runtime) createIterResultObject(value Value, done bool) Value {
 
 func (r *Runtime) newLazyObject(create func(*Object) objectImpl) *Object {
 	val := &Object{runtime: r}
+	realm := r.realm
 	o := &lazyObject{
-		val:    val,
-		create: create,
+		val: val,
+		create: func(val *Object) (o objectImpl) {
+			// make sure the intrinsics are taken from the realm the object belongs to
+			r.runInRealm(realm, func() {
+				o = create(val)
+			})
+			return
+		},
 	}
 	val.self = o
 	return val
@@ -2653,12 +2974,16 @@ func (r *Runtime) leave() {
 			job()
 		}
 	}
+	r.leaveDebug()
+	r.profileLeave()
 }
 
 // called when the top level function returns (i.e. control is passed outside the Runtime) but it was due to an interrupt
 func (r *Runtime) leaveAbrupt() {
 	r.jobQueue = nil
 	r.ClearInterrupt()
+	r.leaveDebug()
+	r.profileLeave()
 }
 
 func nilSafe(v Value) Value {
@@ -2774,7 +3099,45 @@ func (r *Runtime) trackPromiseRejection(p *Promise, operation PromiseRejectionOp
 	}
 }
 
+// makeJobCallback creates a job callback that runs in the realm of fn (or in the current realm if fn is nil).
+func (r *Runtime) makeJobCallback(fn Value, callback func(FunctionCall) Value) *jobCallback {
+	realm := r.realm
+	if fn != nil {
+		realm = r.functionRealm(fn)
+	}
+	return &jobCallback{callback: callback, realm: realm}
+}
+
+// functionRealm implements GetFunctionRealm. The current realm is returned if the realm cannot be determined
+// (including for revoked proxies, which is what the callers that use it need).
+func (r *Runtime) functionRealm(v Value) *Realm {
+	if obj, ok := v.(*Object); ok {
+		switch f := obj.self.(type) {
+		case *boundFuncObject:
+			return r.functionRealm(f.wrapped)
+		case *proxyObject:
+			if f.handler != nil {
+				return r.functionRealm(f.target)
+			}
+			return r.realm
+		case *lazyObject:
+			obj.self = f.create(obj)
+			return r.functionRealm(obj)
+		}
+		if f, ok := obj.self.(interface{ funcRealm() *Realm }); ok {
+			if realm := f.funcRealm(); realm != nil {
+				return realm
+			}
+		}
+	}
+	return r.realm
+}
+
 func (r *Runtime) callJobCallback(job *jobCallback, this Value, args ...Value) Value {
+	if job.realm != r.realm {
+		defer r.setRealm(r.realm)
+		r.setRealm(job.realm)
+	}
 	return job.callback(FunctionCall{This: this, Arguments: args})
 }
 
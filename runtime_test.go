@@ -1,6 +1,7 @@
 package goja
 
 import (
+	gocontext "context"
 	"errors"
 	"fmt"
 	"math"
@@ -389,6 +390,336 @@ func TestInterrupt(t *testing.T) {
 	}
 }
 
+func TestInterruptBuiltins(t *testing.T) {
+	for _, script := range []string{
+		`Array.prototype.indexOf.call({length: 2**53 - 1}, 1)`,
+		`Array.prototype.includes.call({length: 2**53 - 1}, 1)`,
+		`Array.prototype.some.call({length: 2**53 - 1}, Boolean)`,
+		`Array.prototype.join.call({length: 2**53 - 1}, "")`,
+		`/(a*)*b\1/.test("a".repeat(40))`,
+		`"a".repeat(40).replace(/(a*)*b\1/g, "")`,
+	} {
+		vm := New()
+		timer := time.AfterFunc(50*time.Millisecond, func() {
+			vm.Interrupt("halt")
+		})
+		_, err := vm.RunString(script)
+		timer.Stop()
+		var ie *InterruptedError
+		if !errors.As(err, &ie) || ie.Value() != "halt" {
+			t.Fatalf("%s: unexpected error: %v", script, err)
+		}
+	}
+}
+
+func TestInstructionLimit(t *testing.T) {
+	vm := New()
+	vm.SetInstructionLimit(10000)
+	_, err := vm.RunString(`
+	try {
+		for (;;) {}
+	} catch (e) {
+		// must not be caught
+	}
+	`)
+	var le *InstructionLimitExceededError
+	if !errors.As(err, &le) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if le.Limit() != 10000 || vm.InstructionCount() <= 10000 {
+		t.Fatal(le.Limit(), vm.InstructionCount())
+	}
+	if _, err := vm.RunString(`1`); !errors.As(err, &le) {
+		t.Fatal("the limit is not sticky")
+	}
+
+	vm.ResetInstructionCount()
+	if _, err := vm.RunString(`var x = 0; for (var i = 0; i < 10; i++) { x += i }`); err != nil {
+		t.Fatal(err)
+	}
+	c1 := vm.InstructionCount()
+	vm.ResetInstructionCount()
+	if _, err := vm.RunString(`var x = 0; for (var i = 0; i < 10; i++) { x += i }`); err != nil {
+		t.Fatal(err)
+	}
+	if c2 := vm.InstructionCount(); c1 != c2 || c1 == 0 {
+		t.Fatalf("count is not deterministic: %d, %d", c1, c2)
+	}
+
+	vm.SetInstructionLimit(0)
+	vm.ResetInstructionCount()
+	if _, err := vm.RunString(`for (var i = 0; i < 100000; i++) {}`); err != nil {
+		t.Fatal(err)
+	}
+	if c := vm.InstructionCount(); c != 0 {
+		t.Fatalf("instructions are counted without a limit: %d", c)
+	}
+}
+
+func TestInstructionLimitBuiltins(t *testing.T) {
+	for _, script := range []string{
+		`"x".repeat(1e9)`,
+		`new Array(1e6).join()`,
+		`"".padStart(1e9)`,
+		`Array.prototype.sort.call({length: 2e5})`,
+		`new Uint8Array(1e5).sort()`,
+		`new Array(1e6).fill(0)`,
+		`Array.prototype.indexOf.call({length: 1e6}, 1)`,
+		`Array.prototype.includes.call({length: 1e6}, 1)`,
+		`Array.prototype.lastIndexOf.call({length: 1e6}, 1)`,
+		`var a = new Array(1000).fill(0); for (var i = 0; i < 100; i++) a.indexOf(1)`,
+		`var a = new Array(1000).fill(0); for (var i = 0; i < 100; i++) a.includes(1)`,
+		`var a = new Array(1000).fill(0); for (var i = 0; i < 100; i++) a.lastIndexOf(1)`,
+		`var a = new Array(1000).fill(0); for (var i = 0; i < 200; i++) a.reverse()`,
+		`var a = new Array(1000).fill(0); for (var i = 0; i < 100; i++) a.copyWithin(0, 1)`,
+		`var a = new Array(1000).fill(0); for (var i = 0; i < 100; i++) { a.splice(0, 1); a.push(0) }`,
+		`var a = new Array(1000).fill(0); for (var i = 0; i < 100; i++) a.fill(1)`,
+		`var a = new Array(1000).fill(0); for (var i = 0; i < 100; i++) JSON.stringify(a)`,
+	} {
+		vm := New()
+		vm.SetInstructionLimit(50000)
+		_, err := vm.RunString(script)
+		var le *InstructionLimitExceededError
+		if !errors.As(err, &le) {
+			t.Fatalf("%s: unexpected error: %v", script, err)
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	for _, script := range []string{
+		`"x".repeat(1e9)`,
+		`"".padEnd(1e9, "\u00e9")`,
+		`var a = []; for (;;) a.push(1)`,
+		`var a = []; for (var i = 0;; i += 100) a[i] = i`,
+		`var s = ""; for (;;) s += "x".repeat(100)`,
+		`var a = []; for (;;) a.push({})`,
+		`var o = {}; for (var i = 0;; i++) o["p" + i] = i`,
+		`new ArrayBuffer(1e9)`,
+		`new Float64Array(1e8)`,
+		`var m = new Map(); for (var i = 0;; i++) m.set(i, i)`,
+		`var s = new Set(); for (var i = 0;; i++) s.add(i)`,
+		`new Array(1e5).fill("xxxxxxxxxxxxxxxxxxxx").join()`,
+		`JSON.stringify(new Array(1e5).fill("xxxxxxxxxxxxxxxxxxxx"))`,
+		`var a = [], s = "x".repeat(1000); for (;;) a.push(s.toUpperCase())`,
+		`var a = [], s = "x".repeat(1000); for (;;) a.push(encodeURIComponent(s + " "))`,
+	} {
+		vm := New()
+		vm.SetMemoryLimit(vm.MemoryUsage().Total() + 1e6)
+		_, err := vm.RunString(`try { ` + script + ` } catch (e) {}`)
+		var me *MemoryLimitExceededError
+		if !errors.As(err, &me) {
+			t.Fatalf("%s: unexpected error: %v", script, err)
+		}
+		if me.Limit() != vm.memLimit {
+			t.Fatal(me.Limit())
+		}
+	}
+}
+
+func TestMemoryLimitGarbage(t *testing.T) {
+	vm := New()
+	vm.SetMemoryLimit(vm.MemoryUsage().Total() + 1e6)
+	// the memory that is no longer reachable does not count towards the limit, no matter how much has been allocated
+	for i := 0; i < 50; i++ {
+		_, err := vm.RunString(`
+		var a = [];
+		for (var i = 0; i < 2000; i++) a.push({i: i, s: "x" + i});
+		a = null;
+		`)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+	// but the memory that is retained does
+	_, err := vm.RunString(`
+	var keep = [];
+	for (var i = 0; i < 20; i++) {
+		var a = [];
+		for (var j = 0; j < 2000; j++) a.push({j: j});
+		keep.push(a);
+	}
+	`)
+	var me *MemoryLimitExceededError
+	if !errors.As(err, &me) {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	vm := New()
+	before := vm.MemoryUsage()
+	_, err := vm.RunString(`
+	var s = "abc".repeat(1000);
+	var a = []; for (var i = 0; i < 1000; i++) a.push(i);
+	var m = new Map([[1, 1], [2, 2]]);
+	var b = new ArrayBuffer(4096);
+	var shared = []; for (var i = 0; i < 1000; i++) shared.push(s);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := vm.MemoryUsage()
+	if u.Strings-before.Strings < 3000 || u.Strings-before.Strings > 6000 || u.Arrays-before.Arrays < 2000*memValueSize ||
+		u.Objects <= before.Objects || u.ArrayBuffers-before.ArrayBuffers != 4096 || u.Maps-before.Maps != 2*memMapEntrySize {
+		t.Fatalf("%+v, %+v", before, u)
+	}
+	if u.Total() != u.Strings+u.Arrays+u.Objects+u.ArrayBuffers+u.Maps {
+		t.Fatal(u.Total())
+	}
+
+	if _, err := vm.RunString(`s = a = m = b = shared = undefined`); err != nil {
+		t.Fatal(err)
+	}
+	if after := vm.MemoryUsage(); after.Total() >= u.Total() {
+		t.Fatalf("%+v, %+v", u, after)
+	}
+
+	// the host allocations never fail, but they are found by the next measurement
+	vm.SetMemoryLimit(vm.MemoryUsage().Total() + 1000)
+	if err = vm.Set("big", strings.Repeat("x", 2000)); err != nil {
+		t.Fatal(err)
+	}
+	var le *MemoryLimitExceededError
+	if _, err = vm.RunString(`({})`); !errors.As(err, &le) {
+		t.Fatal(err)
+	}
+
+	// no accounting is done without a limit
+	vm.SetMemoryLimit(0)
+	if _, err = vm.RunString(`"x".repeat(1e6)`); err != nil {
+		t.Fatal(err)
+	}
+	if vm.memAllocated != 0 {
+		t.Fatal(vm.memAllocated)
+	}
+}
+
+func TestRunProgramContext(t *testing.T) {
+	vm := New()
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := vm.RunStringContext(ctx, `for (;;) {}`)
+	var ie *InterruptedError
+	if !errors.As(err, &ie) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !errors.Is(err, gocontext.DeadlineExceeded) {
+		t.Fatalf("error does not wrap ctx.Err(): %v", err)
+	}
+
+	res, err := vm.RunStringContext(gocontext.Background(), `1 + 1`)
+	if err != nil || res.ToInteger() != 2 {
+		t.Fatal(res, err)
+	}
+
+	cancelled, cancel1 := gocontext.WithCancel(gocontext.Background())
+	cancel1()
+	_, err = vm.RunStringContext(cancelled, `1`)
+	if !errors.Is(err, gocontext.Canceled) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = vm.RunString(`1`); err != nil {
+		t.Fatalf("interrupt flag was not cleared: %v", err)
+	}
+}
+
+func TestRuntimeContext(t *testing.T) {
+	type key struct{}
+	vm := New()
+	var got, gotCall interface{}
+	vm.Set("f", func(call FunctionCall) Value {
+		got = vm.Context().Value(key{})
+		gotCall = call.Context().Value(key{})
+		return _undefined
+	})
+	ctx := gocontext.WithValue(gocontext.Background(), key{}, "v")
+	if _, err := vm.RunStringContext(ctx, `f()`); err != nil {
+		t.Fatal(err)
+	}
+	if got != "v" {
+		t.Fatal(got)
+	}
+	if gotCall != "v" {
+		t.Fatal(gotCall)
+	}
+	if vm.Context() != gocontext.Background() {
+		t.Fatal("context was not restored")
+	}
+	if _, err := vm.RunStringContext(ctx, `[1].forEach(f)`); err != nil {
+		t.Fatal(err)
+	}
+	if gotCall != "v" {
+		t.Fatal("callback", gotCall)
+	}
+}
+
+func TestRunContextKeepsOtherInterrupt(t *testing.T) {
+	vm := New()
+	seq := vm.vm.interrupt(gocontext.Canceled)
+	vm.Interrupt("other")
+	vm.vm.clearInterrupt(seq)
+	_, err := vm.RunString(`1`)
+	var ie *InterruptedError
+	if !errors.As(err, &ie) || ie.Value() != "other" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = vm.RunString(`1`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	vm := New()
+	v, err := vm.RunString(`
+	(function(n) {
+		if (n > 0) {
+			for (;;) {}
+		}
+		return "ok";
+	})
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn, ok := AssertFunctionContext(v)
+	if !ok {
+		t.Fatal("not a function")
+	}
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := fn(ctx, nil, vm.ToValue(1)); !errors.Is(err, gocontext.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := fn(gocontext.Background(), nil, vm.ToValue(0))
+	if err != nil || res.String() != "ok" {
+		t.Fatal(res, err)
+	}
+	if _, ok := AssertFunctionContext(vm.ToValue(1)); ok {
+		t.Fatal("1 is not a function")
+	}
+}
+
+func TestNestedRunContextCancel(t *testing.T) {
+	vm := New()
+	vm.Set("nested", func() string {
+		ctx, cancel := gocontext.WithTimeout(gocontext.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := vm.RunStringContext(ctx, `for (;;) {}`)
+		if err == nil {
+			return "no error"
+		}
+		return "interrupted"
+	})
+	res, err := vm.RunString(`nested() + " and continued"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := res.String(); s != "interrupted and continued" {
+		t.Fatal(s)
+	}
+}
+
 func TestRuntime_ExportToNumbers(t *testing.T) {
 	vm := New()
 	t.Run("int8/no overflow", func(t *testing.T) {
@@ -2470,6 +2801,271 @@ func TestPromiseExport(t *testing.T) {
 	}
 }
 
+// testAsyncScriptWithTestLib runs the script which must evaluate to a Promise and checks that the Promise
+// is fulfilled once all pending jobs have been run.
+func testAsyncScriptWithTestLib(script string, t *testing.T) {
+	r := New()
+	if _, err := r.RunProgram(testLib()); err != nil {
+		t.Fatal(err)
+	}
+	v, err := r.RunString(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := v.Export().(*Promise)
+	if !ok {
+		t.Fatalf("script did not return a Promise: %v", v)
+	}
+	switch p.State() {
+	case PromiseStatePending:
+		t.Fatal("promise is still pending")
+	case PromiseStateRejected:
+		t.Fatal(p.Result())
+	}
+}
+
+func TestPromiseWithResolvers(t *testing.T) {
+	const SCRIPT = `
+	const r = Promise.withResolvers();
+	assert(r.promise instanceof Promise, "promise");
+	assert.sameValue(Object.keys(r).join(), "promise,resolve,reject", "keys");
+	Promise.resolve().then(() => r.resolve(42));
+
+	class MyPromise extends Promise {}
+	assert(MyPromise.withResolvers().promise instanceof MyPromise, "subclass");
+	assert.throws(TypeError, () => Promise.withResolvers.call({}));
+
+	r.promise.then(v => assert.sameValue(v, 42));
+	`
+	testAsyncScriptWithTestLib(SCRIPT, t)
+}
+
+func TestPromiseTry(t *testing.T) {
+	const SCRIPT = `
+	let sync = false;
+	const p1 = Promise.try((a, b) => { sync = true; return a + b }, 1, 2);
+	assert(sync, "callback is called synchronously");
+	const p2 = Promise.try(() => { throw new RangeError() });
+	const p3 = Promise.try(() => Promise.resolve("inner"));
+	const p4 = Promise.try(1);
+	assert.throws(TypeError, () => Promise.try.call(1, () => {}));
+
+	Promise.all([
+		p1.then(v => assert.sameValue(v, 3)),
+		p2.then(() => { throw new Error("should reject") }, e => assert(e instanceof RangeError, "rejected")),
+		p3.then(v => assert.sameValue(v, "inner")),
+		p4.then(() => { throw new Error("should reject") }, e => assert(e instanceof TypeError, "not callable")),
+	]);
+	`
+	testAsyncScriptWithTestLib(SCRIPT, t)
+}
+
+func TestHostEnqueuePromiseJob(t *testing.T) {
+	vm := New()
+	var jobs []func() error
+	vm.SetHostEnqueuePromiseJob(func(job func() error) {
+		jobs = append(jobs, job)
+	})
+	_, err := vm.RunString(`
+	var log = [];
+	Promise.resolve(1).then(v => log.push(v)).then(() => log.push(2));
+	log.push("sync");
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.HasPendingJobs() {
+		t.Fatal("jobs were added to the internal queue")
+	}
+	log := func() string {
+		return vm.Get("log").String()
+	}
+	if l := log(); l != "sync" {
+		t.Fatal(l)
+	}
+	for len(jobs) > 0 {
+		job := jobs[0]
+		jobs = jobs[1:]
+		if err := job(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l := log(); l != "sync,1,2" {
+		t.Fatal(l)
+	}
+
+	_, err = vm.RunString(`Promise.resolve().then(() => { for (;;) {} })`)
+	if err != nil || len(jobs) != 1 {
+		t.Fatal(err, len(jobs))
+	}
+	time.AfterFunc(10*time.Millisecond, func() {
+		vm.Interrupt("halt")
+	})
+	var ie *InterruptedError
+	if err := jobs[0](); !errors.As(err, &ie) {
+		t.Fatal(err)
+	}
+	if _, err := vm.RunString(`1`); err != nil {
+		t.Fatalf("interrupt flag was not cleared: %v", err)
+	}
+
+	vm.SetHostEnqueuePromiseJob(nil)
+	_, err = vm.RunString(`Promise.resolve(3).then(v => log.push(v))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := log(); l != "sync,1,2,3" {
+		t.Fatal(l)
+	}
+}
+
+func TestRunPendingJobs(t *testing.T) {
+	vm := New()
+	var pending bool
+	vm.Set("checkPending", func() {
+		pending = vm.HasPendingJobs()
+	})
+	_, err := vm.RunString(`
+	var done = false;
+	Promise.resolve().then(() => { done = true });
+	checkPending();
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pending {
+		t.Fatal("HasPendingJobs() returned false while a job was queued")
+	}
+	if vm.HasPendingJobs() || !vm.Get("done").ToBoolean() {
+		t.Fatal("jobs were not run on return")
+	}
+	if err := vm.RunPendingJobs(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPromiseThen(t *testing.T) {
+	vm := New()
+	var tracked []PromiseRejectionOperation
+	vm.SetPromiseRejectionTracker(func(p *Promise, op PromiseRejectionOperation) {
+		tracked = append(tracked, op)
+	})
+	p, resolve, reject := vm.NewPromise()
+	var res []string
+	p.Then(func(v Value) {
+		res = append(res, "fulfilled "+v.String())
+	}, func(v Value) {
+		res = append(res, "rejected "+v.String())
+	})
+	resolve(42)
+	reject("ignored")
+	if err := vm.RunPendingJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0] != "fulfilled 42" {
+		t.Fatal(res)
+	}
+
+	v, err := vm.RunString(`Promise.reject(new Error("boom"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Export().(*Promise).Then(nil, func(v Value) {
+		res = append(res, "rejected "+v.String())
+	})
+	if err := vm.RunPendingJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[1] != "rejected Error: boom" {
+		t.Fatal(res)
+	}
+	if len(tracked) != 2 || tracked[0] != PromiseRejectionReject || tracked[1] != PromiseRejectionHandle {
+		t.Fatal(tracked)
+	}
+}
+
+func TestAwaitPromise(t *testing.T) {
+	vm := New()
+	v, err := vm.RunString(`
+	function f(x) {
+		return Promise.resolve().then(() => x * 2);
+	}
+	f(21)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := vm.AwaitPromise(gocontext.Background(), v.Export().(*Promise))
+	if err != nil || res.ToInteger() != 42 {
+		t.Fatal(res, err)
+	}
+
+	v, err = vm.RunString(`Promise.resolve().then(() => { throw new TypeError("async boom") })`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = vm.AwaitPromise(gocontext.Background(), v.Export().(*Promise))
+	var ex *Exception
+	if !errors.As(err, &ex) || ex.Value().String() != "TypeError: async boom" {
+		t.Fatal(err)
+	}
+
+	p, resolve, _ := vm.NewPromise()
+	if _, err = vm.AwaitPromise(gocontext.Background(), p); err != ErrPromisePending {
+		t.Fatal(err)
+	}
+	resolve("done")
+	res, err = vm.AwaitPromise(gocontext.Background(), p)
+	if err != nil || res.String() != "done" {
+		t.Fatal(res, err)
+	}
+}
+
+func TestExportToAsyncFunc(t *testing.T) {
+	vm := New()
+	_, err := vm.RunString(`
+	function handler(x) {
+		return Promise.resolve().then(() => {
+			if (x < 0) {
+				throw new RangeError("negative");
+			}
+			return {Result: x * 2};
+		});
+	}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type response struct {
+		Result int
+	}
+	var fn func(int) (response, error)
+	if err := vm.ExportTo(vm.Get("handler"), &fn); err != nil {
+		t.Fatal(err)
+	}
+	res, err := fn(21)
+	if err != nil || res.Result != 42 {
+		t.Fatal(res, err)
+	}
+	_, err = fn(-1)
+	var ex *Exception
+	if !errors.As(err, &ex) || ex.Value().String() != "RangeError: negative" {
+		t.Fatal(err)
+	}
+
+	var fnValue func(int) (Value, error)
+	if err := vm.ExportTo(vm.Get("handler"), &fnValue); err != nil {
+		t.Fatal(err)
+	}
+	v, err := fnValue(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v.Export().(*Promise); !ok {
+		t.Fatalf("promise was awaited: %v", v)
+	}
+}
+
 func TestErrorStack(t *testing.T) {
 	const SCRIPT = `
 	const err = new Error("test");
@@ -2522,6 +3118,49 @@ function foo(a,b,c)
 }
 */
 
+func TestDynamicCodePolicy(t *testing.T) {
+	r := New()
+	p, err := Compile("precompiled.js", `var f = function(a, b) { return a + b; }; f(1, 2)`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []DynamicCodeKind
+	r.SetDynamicCodePolicy(func(kind DynamicCodeKind, src string) bool {
+		kinds = append(kinds, kind)
+		return src == "1 + 1" || src == "(function anonymous(a\n) {\nreturn a * 2\n})"
+	})
+	if res, err := r.RunProgram(p); err != nil || res.ToInteger() != 3 {
+		t.Fatalf("Unexpected result: %v, %v", res, err)
+	}
+	const SCRIPT = `
+	assert.throws(EvalError, () => eval("2 + 2"));
+	assert.throws(EvalError, () => (0, eval)("2 + 2"));
+	assert.throws(EvalError, () => new Function("return 1"));
+	assert.throws(EvalError, () => Function.prototype.constructor("a", "return a"));
+	assert.throws(EvalError, () => new ShadowRealm().evaluate("1"));
+	assert.sameValue(eval("1 + 1"), 2, "direct eval");
+	assert.sameValue((0, eval)("1 + 1"), 2, "indirect eval");
+	assert.sameValue(new Function("a", "return a * 2")(21), 42, "Function");
+	assert.sameValue(eval(42), 42, "non-string argument");
+	`
+	if _, err := r.RunProgram(testLib()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RunString(SCRIPT); err != nil {
+		t.Fatal(err)
+	}
+	expected := []DynamicCodeKind{DynamicCodeEval, DynamicCodeEval, DynamicCodeFunction, DynamicCodeFunction,
+		DynamicCodeShadowRealm, DynamicCodeEval, DynamicCodeEval, DynamicCodeFunction}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Fatal(kinds)
+	}
+
+	r.SetDynamicCodePolicy(nil)
+	if res, err := r.RunString(`eval("2 + 2")`); err != nil || res.ToInteger() != 4 {
+		t.Fatalf("Unexpected result: %v, %v", res, err)
+	}
+}
+
 func BenchmarkCallReflect(b *testing.B) {
 	vm := New()
 	vm.Set("f", func(v Value) {
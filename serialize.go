@@ -0,0 +1,686 @@
+package goja
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/dop251/goja/unistring"
+)
+
+// The format produced by Runtime.Serialize(). It starts with serializeMagic followed by the format version
+// and a single serialized value. Objects are assigned ids in the order they are first encountered and any
+// subsequent occurrence of the same object is encoded as a reference to its id, which preserves cycles
+// and shared references.
+const (
+	serializeMagic   = 0xFF
+	serializeVersion = 1
+)
+
+// serializeMaxDepth limits the nesting of the objects, so that a deeply nested value (or crafted input to
+// Deserialize()) results in an error rather than a stack overflow.
+const serializeMaxDepth = 10000
+
+const (
+	serTagEnd byte = iota
+	serTagUndefined
+	serTagNull
+	serTagTrue
+	serTagFalse
+	serTagInt
+	serTagFloat
+	serTagASCIIString
+	serTagUnicodeString
+	serTagRef
+	serTagObject
+	serTagArray
+	serTagBooleanObject
+	serTagNumberObject
+	serTagStringObject
+	serTagDate
+	serTagRegExp
+	serTagMap
+	serTagSet
+	serTagError
+	serTagArrayBuffer
+	serTagTransferredArrayBuffer
+	serTagTypedArray
+	serTagDataView
+)
+
+var serializableErrorNames = []string{
+	"Error", "EvalError", "RangeError", "ReferenceError", "SyntaxError", "TypeError", "URIError",
+}
+
+type serializer struct {
+	r        *Runtime
+	buf      []byte
+	ids      map[*Object]uint64
+	transfer map[*arrayBufferObject]int
+	depth    int
+}
+
+type deserializer struct {
+	r           *Runtime
+	data        []byte
+	pos         int
+	objs        []*Object
+	transferred []*Object
+	depth       int
+}
+
+func (r *Runtime) typedArrayCtors() []*Object {
+	return []*Object{
+		r.global.Uint8Array,
+		r.global.Uint8ClampedArray,
+		r.global.Int8Array,
+		r.global.Uint16Array,
+		r.global.Int16Array,
+		r.global.Uint32Array,
+		r.global.Int32Array,
+		r.global.Float32Array,
+		r.global.Float64Array,
+	}
+}
+
+func (r *Runtime) throwDataCloneError(v Value) {
+	panic(r.NewTypeError("%s could not be cloned", v.String()))
+}
+
+func (s *serializer) writeTag(tag byte) {
+	s.buf = append(s.buf, tag)
+}
+
+func (s *serializer) writeUint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	s.buf = append(s.buf, b[:binary.PutUvarint(b[:], n)]...)
+}
+
+func (s *serializer) writeInt(n int64) {
+	var b [binary.MaxVarintLen64]byte
+	s.buf = append(s.buf, b[:binary.PutVarint(b[:], n)]...)
+}
+
+func (s *serializer) writeFloat(f float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	s.buf = append(s.buf, b[:]...)
+}
+
+func (s *serializer) writeBytes(b []byte) {
+	s.writeUint(uint64(len(b)))
+	s.buf = append(s.buf, b...)
+}
+
+func (s *serializer) writeString(str valueString) {
+	switch str := str.(type) {
+	case asciiString:
+		s.writeTag(serTagASCIIString)
+		s.writeUint(uint64(len(str)))
+		s.buf = append(s.buf, str...)
+	case unicodeString:
+		s.writeTag(serTagUnicodeString)
+		// skip the BOM
+		s.writeUint(uint64(len(str) - 1))
+		for _, c := range str[1:] {
+			s.buf = append(s.buf, byte(c), byte(c>>8))
+		}
+	default:
+		panic(s.r.NewTypeError("Unsupported string type: %T", str))
+	}
+}
+
+// newId registers obj and returns true if it was not seen before. Otherwise, a reference is written.
+func (s *serializer) newId(obj *Object) bool {
+	if id, exists := s.ids[obj]; exists {
+		s.writeTag(serTagRef)
+		s.writeUint(id)
+		return false
+	}
+	s.ids[obj] = uint64(len(s.ids))
+	return true
+}
+
+func (s *serializer) writeValue(v Value) {
+	switch v := v.(type) {
+	case valueUndefined:
+		s.writeTag(serTagUndefined)
+	case valueNull:
+		s.writeTag(serTagNull)
+	case valueBool:
+		if v {
+			s.writeTag(serTagTrue)
+		} else {
+			s.writeTag(serTagFalse)
+		}
+	case valueInt:
+		s.writeTag(serTagInt)
+		s.writeInt(int64(v))
+	case valueFloat:
+		s.writeTag(serTagFloat)
+		s.writeFloat(float64(v))
+	case valueString:
+		s.writeString(v)
+	case *Object:
+		s.writeObject(v)
+	default:
+		s.r.throwDataCloneError(v)
+	}
+}
+
+func (s *serializer) writeProps(obj *Object) {
+	for _, key := range obj.self.stringKeys(false, nil) {
+		name := key.string()
+		if !obj.self.hasOwnPropertyStr(name) {
+			continue
+		}
+		s.writeString(key.toString())
+		s.writeValue(nilSafe(obj.self.getStr(name, nil)))
+	}
+	s.writeTag(serTagEnd)
+}
+
+func (s *serializer) writeArrayBuffer(b *arrayBufferObject) {
+	if !s.newId(b.val) {
+		return
+	}
+	if idx, exists := s.transfer[b]; exists {
+		s.writeTag(serTagTransferredArrayBuffer)
+		s.writeUint(uint64(idx))
+		return
+	}
+	if b.detached {
+		s.r.throwDataCloneError(b.val)
+	}
+	s.writeTag(serTagArrayBuffer)
+	s.writeBytes(b.data)
+}
+
+func (s *serializer) writeObject(obj *Object) {
+	r := s.r
+	if s.depth++; s.depth > serializeMaxDepth {
+		panic(r.newError(r.global.RangeError, "Maximum serialization depth exceeded"))
+	}
+	defer func() {
+		s.depth--
+	}()
+	forceLazy(obj)
+	switch o := obj.self.(type) {
+	case *arrayBufferObject:
+		s.writeArrayBuffer(o)
+		return
+	case *typedArrayObject:
+		kind := -1
+		for i, ctor := range r.typedArrayCtors() {
+			if o.defaultCtor == ctor {
+				kind = i
+				break
+			}
+		}
+		if kind == -1 {
+			r.throwDataCloneError(obj)
+		}
+		if !s.newId(obj) {
+			return
+		}
+		s.writeTag(serTagTypedArray)
+		s.buf = append(s.buf, byte(kind))
+		s.writeArrayBuffer(o.viewedArrayBuf)
+		s.writeUint(uint64(o.offset * o.elemSize))
+		s.writeUint(uint64(o.length))
+		return
+	case *dataViewObject:
+		if !s.newId(obj) {
+			return
+		}
+		s.writeTag(serTagDataView)
+		s.writeArrayBuffer(o.viewedArrayBuf)
+		s.writeUint(uint64(o.byteOffset))
+		s.writeUint(uint64(o.byteLen))
+		return
+	}
+
+	if !s.newId(obj) {
+		return
+	}
+	switch o := obj.self.(type) {
+	case *primitiveValueObject:
+		switch pv := o.pValue.(type) {
+		case valueBool:
+			s.writeTag(serTagBooleanObject)
+			s.writeValue(pv)
+		case valueInt, valueFloat:
+			s.writeTag(serTagNumberObject)
+			s.writeValue(pv)
+		default:
+			r.throwDataCloneError(obj)
+		}
+	case *stringObject:
+		s.writeTag(serTagStringObject)
+		s.writeString(o.value)
+	case *dateObject:
+		s.writeTag(serTagDate)
+		if o.isSet() {
+			s.writeFloat(float64(o.msec))
+		} else {
+			s.writeFloat(math.NaN())
+		}
+	case *regexpObject:
+		s.writeTag(serTagRegExp)
+		s.writeString(o.source)
+		s.writeString(r.regexpproto_getFlags(FunctionCall{This: obj}).toString())
+	case *mapObject:
+		s.writeTag(serTagMap)
+		iter := o.m.newIter()
+		for entry := iter.next(); entry != nil; entry = iter.next() {
+			s.writeValue(entry.key)
+			s.writeValue(entry.value)
+		}
+		s.writeTag(serTagEnd)
+	case *setObject:
+		s.writeTag(serTagSet)
+		iter := o.m.newIter()
+		for entry := iter.next(); entry != nil; entry = iter.next() {
+			s.writeValue(entry.key)
+		}
+		s.writeTag(serTagEnd)
+	case *errorObject:
+		name := "Error"
+		if n, ok := nilSafe(o.getStr("name", nil)).(valueString); ok {
+			for _, errName := range serializableErrorNames {
+				if n.String() == errName {
+					name = errName
+					break
+				}
+			}
+		}
+		s.writeTag(serTagError)
+		s.writeString(asciiString(name))
+		if o.hasOwnPropertyStr("message") {
+			s.buf = append(s.buf, 1)
+			s.writeString(nilSafe(o.getStr("message", nil)).toString())
+		} else {
+			s.buf = append(s.buf, 0)
+		}
+	case *arrayObject, *sparseArrayObject:
+		s.writeTag(serTagArray)
+		s.writeUint(uint64(toLength(obj.self.getStr("length", nil))))
+		s.writeProps(obj)
+	case *baseObject:
+		s.writeTag(serTagObject)
+		s.writeProps(obj)
+	default:
+		r.throwDataCloneError(obj)
+	}
+}
+
+func (d *deserializer) fail() {
+	panic(d.r.NewTypeError("Invalid serialized data"))
+}
+
+func (d *deserializer) readByte() byte {
+	if d.pos >= len(d.data) {
+		d.fail()
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *deserializer) readUint() uint64 {
+	n, size := binary.Uvarint(d.data[d.pos:])
+	if size <= 0 {
+		d.fail()
+	}
+	d.pos += size
+	return n
+}
+
+func (d *deserializer) readLen() int {
+	n := d.readUint()
+	if n > uint64(len(d.data)) {
+		d.fail()
+	}
+	return int(n)
+}
+
+func (d *deserializer) readInt() int64 {
+	n, size := binary.Varint(d.data[d.pos:])
+	if size <= 0 {
+		d.fail()
+	}
+	d.pos += size
+	return n
+}
+
+func (d *deserializer) readFloat() float64 {
+	if d.pos+8 > len(d.data) {
+		d.fail()
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(d.data[d.pos:]))
+	d.pos += 8
+	return f
+}
+
+func (d *deserializer) readBytes() []byte {
+	l := d.readLen()
+	if d.pos+l > len(d.data) {
+		d.fail()
+	}
+	b := make([]byte, l)
+	copy(b, d.data[d.pos:])
+	d.pos += l
+	return b
+}
+
+func (d *deserializer) readStringTagged(tag byte) valueString {
+	switch tag {
+	case serTagASCIIString:
+		l := d.readLen()
+		if d.pos+l > len(d.data) {
+			d.fail()
+		}
+		s := asciiString(d.data[d.pos : d.pos+l])
+		d.pos += l
+		return s
+	case serTagUnicodeString:
+		l := d.readLen()
+		if d.pos+l*2 > len(d.data) {
+			d.fail()
+		}
+		buf := make([]uint16, l+1)
+		buf[0] = unistring.BOM
+		ascii := true
+		for i := 1; i <= l; i++ {
+			buf[i] = uint16(d.data[d.pos]) | uint16(d.data[d.pos+1])<<8
+			if buf[i] >= 0x80 {
+				ascii = false
+			}
+			d.pos += 2
+		}
+		if ascii {
+			b := make([]byte, l)
+			for i := range b {
+				b[i] = byte(buf[i+1])
+			}
+			return asciiString(b)
+		}
+		return unicodeString(buf)
+	}
+	d.fail()
+	return nil
+}
+
+func (d *deserializer) readString() valueString {
+	return d.readStringTagged(d.readByte())
+}
+
+// newId reserves an id for an object that is about to be read. The object must be stored using setId().
+func (d *deserializer) newId() int {
+	d.objs = append(d.objs, nil)
+	return len(d.objs) - 1
+}
+
+func (d *deserializer) setId(id int, obj *Object) *Object {
+	d.objs[id] = obj
+	return obj
+}
+
+func (d *deserializer) readProps(obj *Object) {
+	for {
+		tag := d.readByte()
+		if tag == serTagEnd {
+			return
+		}
+		key := d.readStringTagged(tag)
+		createDataProperty(obj, key, d.readValue())
+	}
+}
+
+func (d *deserializer) readArrayBuffer() *arrayBufferObject {
+	if b, ok := d.readValue().(*Object); ok {
+		if buf, ok := b.self.(*arrayBufferObject); ok {
+			return buf
+		}
+	}
+	d.fail()
+	return nil
+}
+
+func (d *deserializer) readValue() Value {
+	r := d.r
+	if d.depth++; d.depth > serializeMaxDepth {
+		d.fail()
+	}
+	defer func() {
+		d.depth--
+	}()
+	tag := d.readByte()
+	switch tag {
+	case serTagUndefined:
+		return _undefined
+	case serTagNull:
+		return _null
+	case serTagTrue:
+		return valueTrue
+	case serTagFalse:
+		return valueFalse
+	case serTagInt:
+		return intToValue(d.readInt())
+	case serTagFloat:
+		return floatToValue(d.readFloat())
+	case serTagASCIIString, serTagUnicodeString:
+		return d.readStringTagged(tag)
+	case serTagRef:
+		id := d.readUint()
+		if id >= uint64(len(d.objs)) || d.objs[id] == nil {
+			d.fail()
+		}
+		return d.objs[id]
+	}
+
+	id := d.newId()
+	switch tag {
+	case serTagObject:
+		obj := d.setId(id, r.NewObject())
+		d.readProps(obj)
+		return obj
+	case serTagArray:
+		obj := d.setId(id, r.newArrayLength(int64(d.readUint())))
+		d.readProps(obj)
+		return obj
+	case serTagBooleanObject, serTagNumberObject:
+		v := d.readValue()
+		switch v.(type) {
+		case valueBool:
+			if tag == serTagBooleanObject {
+				return d.setId(id, r.newPrimitiveObject(v, r.global.BooleanPrototype, classBoolean))
+			}
+		case valueInt, valueFloat:
+			if tag == serTagNumberObject {
+				return d.setId(id, r.newPrimitiveObject(v, r.global.NumberPrototype, classNumber))
+			}
+		}
+	case serTagStringObject:
+		return d.setId(id, r._newString(d.readString(), r.global.StringPrototype))
+	case serTagDate:
+		msec := d.readFloat()
+		obj := r.newDateObject(timeFromMsec(0), false, r.global.DatePrototype)
+		if !math.IsNaN(msec) && msec >= -maxTime && msec <= maxTime {
+			obj.self.(*dateObject).msec = int64(msec)
+		}
+		return d.setId(id, obj)
+	case serTagRegExp:
+		source := d.readString()
+		flags := d.readString()
+		return d.setId(id, r._newRegExp(source, flags.String(), r.global.RegExpPrototype).val)
+	case serTagMap:
+		mo := r.newMapObject(r.global.MapPrototype)
+		d.setId(id, mo.val)
+		for {
+			if d.pos < len(d.data) && d.data[d.pos] == serTagEnd {
+				d.pos++
+				return mo.val
+			}
+			key := d.readValue()
+			mo.m.set(key, d.readValue())
+		}
+	case serTagSet:
+		so := r.newSetObject(r.global.SetPrototype)
+		d.setId(id, so.val)
+		for {
+			if d.pos < len(d.data) && d.data[d.pos] == serTagEnd {
+				d.pos++
+				return so.val
+			}
+			so.m.set(d.readValue(), nil)
+		}
+	case serTagError:
+		name := d.readString().String()
+		proto := r.global.ErrorPrototype
+		switch name {
+		case "EvalError":
+			proto = r.global.EvalErrorPrototype
+		case "RangeError":
+			proto = r.global.RangeErrorPrototype
+		case "ReferenceError":
+			proto = r.global.ReferenceErrorPrototype
+		case "SyntaxError":
+			proto = r.global.SyntaxErrorPrototype
+		case "TypeError":
+			proto = r.global.TypeErrorPrototype
+		case "URIError":
+			proto = r.global.URIErrorPrototype
+		}
+		o := r.newErrorObject(proto, classError)
+		if d.readByte() != 0 {
+			o._putProp("message", d.readString(), true, false, true)
+		}
+		return d.setId(id, o.val)
+	case serTagArrayBuffer:
+		buf := r._newArrayBuffer(r.global.ArrayBufferPrototype, nil)
+		buf.data = d.readBytes()
+		return d.setId(id, buf.val)
+	case serTagTransferredArrayBuffer:
+		idx := d.readUint()
+		if idx >= uint64(len(d.transferred)) {
+			d.fail()
+		}
+		return d.setId(id, d.transferred[idx])
+	case serTagTypedArray:
+		kind := int(d.readByte())
+		ctors := r.typedArrayCtors()
+		if kind >= len(ctors) {
+			d.fail()
+		}
+		buf := d.readArrayBuffer()
+		offset := d.readUint()
+		length := d.readUint()
+		return d.setId(id, r.builtin_new(ctors[kind], []Value{buf.val, intToValue(int64(offset)), intToValue(int64(length))}))
+	case serTagDataView:
+		buf := d.readArrayBuffer()
+		offset := d.readUint()
+		length := d.readUint()
+		return d.setId(id, r.newDataView([]Value{buf.val, intToValue(int64(offset)), intToValue(int64(length))}, r.global.DataView))
+	}
+	d.fail()
+	return nil
+}
+
+func (r *Runtime) serialize(value Value, transfer []*arrayBufferObject) []byte {
+	s := &serializer{
+		r:   r,
+		buf: []byte{serializeMagic, serializeVersion},
+		ids: make(map[*Object]uint64),
+	}
+	if len(transfer) > 0 {
+		s.transfer = make(map[*arrayBufferObject]int, len(transfer))
+		for i, buf := range transfer {
+			s.transfer[buf] = i
+		}
+	}
+	s.writeValue(value)
+	return s.buf
+}
+
+func (r *Runtime) deserialize(data []byte, transferred [][]byte) Value {
+	d := &deserializer{
+		r:    r,
+		data: data,
+	}
+	if len(data) < 2 || data[0] != serializeMagic {
+		d.fail()
+	}
+	if data[1] != serializeVersion {
+		panic(r.NewTypeError("Unsupported serialization format version: %d", data[1]))
+	}
+	d.pos = 2
+	d.transferred = make([]*Object, len(transferred))
+	for i, b := range transferred {
+		buf := r._newArrayBuffer(r.global.ArrayBufferPrototype, nil)
+		buf.data = b
+		d.transferred[i] = buf.val
+	}
+	v := d.readValue()
+	if d.pos != len(data) {
+		d.fail()
+	}
+	return v
+}
+
+func (r *Runtime) builtin_structuredClone(call FunctionCall) Value {
+	var transfer []*arrayBufferObject
+	if options, ok := call.Argument(1).(*Object); ok {
+		if t := options.self.getStr("transfer", nil); t != nil && t != _undefined {
+			for _, item := range r.iterableToList(t, nil) {
+				var buf *arrayBufferObject
+				if o, ok := item.(*Object); ok {
+					buf, _ = o.self.(*arrayBufferObject)
+				}
+				if buf == nil {
+					panic(r.NewTypeError("Value at index %d of the transfer list is not an ArrayBuffer", len(transfer)))
+				}
+				if buf.detached {
+					panic(r.NewTypeError("ArrayBuffer at index %d of the transfer list is already detached", len(transfer)))
+				}
+				for _, b := range transfer {
+					if b == buf {
+						panic(r.NewTypeError("ArrayBuffer at index %d is a duplicate of an earlier ArrayBuffer", len(transfer)))
+					}
+				}
+				transfer = append(transfer, buf)
+			}
+		}
+	}
+	data := r.serialize(call.Argument(0), transfer)
+	transferred := make([][]byte, len(transfer))
+	for i, buf := range transfer {
+		transferred[i] = buf.data
+		buf.detach()
+	}
+	return r.deserialize(data, transferred)
+}
+
+// Serialize encodes the value using the structured serialization algorithm (the same one that is used
+// by structuredClone()). Supported are primitive values, plain objects, arrays, Boolean, Number and String objects,
+// Date, RegExp, Map, Set, errors, ArrayBuffer, typed arrays and DataView, including any cycles and shared references
+// between them. Own enumerable string-keyed properties of objects and arrays are preserved, prototypes are not.
+// Functions, Symbols, Proxies and Go-backed objects cannot be serialized and result in an error, as do objects
+// nested more than 10000 levels deep.
+//
+// The returned bytes can be passed to Deserialize() of any Runtime, including a Runtime running in a different
+// process, as long as it uses the same version of this package.
+func (r *Runtime) Serialize(value Value) (data []byte, err error) {
+	err = r.try(func() {
+		data = r.serialize(value, nil)
+	})
+	return
+}
+
+// Deserialize decodes data produced by Serialize() into a new value that belongs to this Runtime.
+// An error is returned if the data is malformed.
+func (r *Runtime) Deserialize(data []byte) (value Value, err error) {
+	err = r.try(func() {
+		value = r.deserialize(data, nil)
+	})
+	return
+}
@@ -0,0 +1,161 @@
+package goja
+
+import (
+	"testing"
+)
+
+func TestStructuredClone(t *testing.T) {
+	const SCRIPT = `
+	const o = {a: 1, s: "str", u: "é\ud800", f: -0, n: null, und: undefined};
+	o.self = o;
+	o.arr = [1, , 3];
+	o.arr.extra = "x";
+	o.shared = {x: 1};
+	o.shared2 = o.shared;
+	o.map = new Map([[o.shared, "v"], ["k", o]]);
+	o.set = new Set([1, "2", o.shared]);
+	o.date = new Date(1234567890);
+	o.badDate = new Date(NaN);
+	o.re = /ab+c/gi;
+	o.err = new RangeError("boom");
+	o.wrappers = [new Boolean(false), new Number(42), new String("s")];
+
+	const c = structuredClone(o);
+	assert(c !== o, "copy");
+	assert.sameValue(c.self, c, "cycle");
+	assert.sameValue(c.a, 1);
+	assert.sameValue(c.u, "é\ud800");
+	assert.sameValue(1/c.f, -Infinity, "-0");
+	assert.sameValue(c.n, null);
+	assert("und" in c, "undefined property");
+	assert.sameValue(c.arr.length, 3);
+	assert(!(1 in c.arr), "hole");
+	assert.sameValue(c.arr.extra, "x");
+	assert(c.shared !== o.shared, "shared copied");
+	assert.sameValue(c.shared2, c.shared, "shared references preserved");
+	assert.sameValue(c.map.get(c.shared), "v");
+	assert.sameValue(c.map.get("k"), c);
+	assert(c.set.has(c.shared) && c.set.has("2") && c.set.size === 3, "set");
+	assert.sameValue(c.date.getTime(), 1234567890);
+	assert(isNaN(c.badDate.getTime()), "invalid date");
+	assert.sameValue(c.re.source, "ab+c");
+	assert.sameValue(c.re.flags, "gi");
+	assert(c.err instanceof RangeError, "error type");
+	assert.sameValue(c.err.message, "boom");
+	assert.sameValue(typeof c.wrappers[0], "object");
+	assert.sameValue(c.wrappers[0].valueOf(), false);
+	assert.sameValue(c.wrappers[1].valueOf(), 42);
+	assert.sameValue(c.wrappers[2].valueOf(), "s");
+
+	assert.throws(TypeError, () => structuredClone(function() {}));
+	assert.throws(TypeError, () => structuredClone(Symbol()));
+	assert.throws(TypeError, () => structuredClone({p: new Proxy({}, {})}));
+	`
+	testScriptWithTestLib(SCRIPT, _undefined, t)
+}
+
+func TestStructuredCloneArrayBuffers(t *testing.T) {
+	const SCRIPT = `
+	const buf = new ArrayBuffer(8);
+	const u8 = new Uint8Array(buf, 2, 4);
+	u8[0] = 1;
+	const dv = new DataView(buf, 1, 3);
+	const c = structuredClone({buf, u8, dv});
+	assert.sameValue(c.u8.buffer, c.buf, "shared buffer");
+	assert.sameValue(c.dv.buffer, c.buf, "shared buffer (DataView)");
+	assert.sameValue(c.u8.byteOffset, 2);
+	assert.sameValue(c.u8.length, 4);
+	assert.sameValue(c.u8[0], 1);
+	assert.sameValue(c.dv.byteOffset, 1);
+	assert.sameValue(c.dv.byteLength, 3);
+	u8[0] = 2;
+	assert.sameValue(c.u8[0], 1, "copied");
+
+	const f64 = new Float64Array([1.5, 2.5]);
+	const t = structuredClone(f64, {transfer: [f64.buffer]});
+	assert.sameValue(f64.buffer.byteLength, 0, "detached");
+	assert.sameValue(t[1], 2.5, "transferred");
+	assert.throws(TypeError, () => structuredClone(f64), "detached buffer");
+	assert.throws(TypeError, () => structuredClone(1, {transfer: [{}]}));
+	const b2 = new ArrayBuffer(1);
+	assert.throws(TypeError, () => structuredClone(1, {transfer: [b2, b2]}));
+	`
+	testScriptWithTestLib(SCRIPT, _undefined, t)
+}
+
+func TestSerializeAcrossRuntimes(t *testing.T) {
+	r1 := New()
+	v, err := r1.RunString(`
+	const o = {m: new Map([[1, [1, 2]]]), b: new Uint16Array([1, 65535])};
+	o.o = o;
+	o;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := r1.Serialize(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r2 := New()
+	v2, err := r2.Deserialize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := v2.(*Object); o.runtime != r2 {
+		t.Fatal("wrong runtime")
+	}
+	r2.Set("v", v2)
+	res, err := r2.RunString(`v.o === v && v.m.get(1)[1] === 2 && v.b instanceof Uint16Array && v.b[1] === 65535`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.ToBoolean() {
+		t.Fatal("unexpected result")
+	}
+
+	if _, err := r1.Serialize(r1.ToValue(func() {})); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := r2.Deserialize(data[:len(data)-1]); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := r2.Deserialize([]byte{serializeMagic, serializeVersion + 1, serTagNull}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestSerializeDepth(t *testing.T) {
+	r := New()
+	v, err := r.RunString(`
+	let deep = [];
+	for (let i = 0; i < 100000; i++) {
+		deep = [deep];
+	}
+	deep;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Serialize(v); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := r.RunString(`structuredClone(deep)`); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// [[[...]]] nested 100000 times
+	const depth = 100000
+	data := []byte{serializeMagic, serializeVersion}
+	for i := 0; i < depth; i++ {
+		data = append(data, serTagArray, 1, serTagASCIIString, 1, '0')
+	}
+	data = append(data, serTagArray, 0, serTagEnd)
+	for i := 0; i < depth; i++ {
+		data = append(data, serTagEnd)
+	}
+	if _, err := r.Deserialize(data); err == nil {
+		t.Fatal("expected error")
+	}
+}
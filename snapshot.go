@@ -0,0 +1,1744 @@
+package goja
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/dop251/goja/file"
+	"github.com/dop251/goja/unistring"
+)
+
+// The format produced by Runtime.Snapshot(). It starts with snapshotMagic followed by the snapshot and the compiled
+// program format versions, the number of the built-in objects (intrinsics) and the list of the ones that could not
+// be located in the source Runtime. Then follow the global lexical scope, the names of the global var declarations
+// and the states of the objects in the order of their ids. The intrinsics occupy the first ids, the rest are assigned
+// in the order the objects are first referenced. The stashes, the private environments and the symbols are written
+// the first time they are referenced and subsequently referred to by their index, the programs are encoded as
+// described in program_binary.go.
+//
+// snapshotVersion must be incremented whenever the encoding of any object changes.
+const (
+	snapshotMagic   = "goja\x00snp"
+	snapshotVersion = 1
+)
+
+var (
+	errSnapshotVersion     = errors.New("goja: unsupported snapshot version")
+	errInvalidSnapshotData = errors.New("goja: invalid snapshot data")
+	errSnapshotMismatch    = errors.New("goja: the built-in objects of the Runtime do not match the snapshot")
+)
+
+const (
+	snapValUndefined byte = iota
+	snapValNull
+	snapValTrue
+	snapValFalse
+	snapValInt
+	snapValFloat
+	snapValString
+	snapValNil // array holes and uninitialised bindings
+	snapValObject
+	snapValSymbol
+	snapValProperty
+	snapValOwnProperty // the property stored in a field of the object, such as the function 'length'
+)
+
+const (
+	snapKindObject byte = iota
+	snapKindGuardedObject
+	snapKindNativeFunc
+	snapKindArray
+	snapKindSparseArray
+	snapKindFunc
+	snapKindMethod
+	snapKindArrow
+	snapKindClass
+	snapKindBoundFunc
+	snapKindPrimitive
+	snapKindString
+	snapKindDate
+	snapKindRegExp
+	snapKindError
+	snapKindMap
+	snapKindSet
+	snapKindWeakMap
+	snapKindWeakSet
+	snapKindProxy
+	snapKindPromise
+	snapKindArrayBuffer
+	snapKindTypedArray
+	snapKindDataView
+)
+
+const (
+	snapStepValue byte = iota
+	snapStepGetter
+	snapStepSetter
+	snapStepProto
+)
+
+var snapshotWellKnownSymbols = []*Symbol{
+	SymHasInstance,
+	SymIsConcatSpreadable,
+	SymIterator,
+	SymMatch,
+	SymMatchAll,
+	SymReplace,
+	SymSearch,
+	SymSpecies,
+	SymSplit,
+	SymToPrimitive,
+	SymToStringTag,
+	SymUnscopables,
+}
+
+// SnapshotRegistry names the host objects (i.e. the objects created from Go, such as the native functions) so that
+// the references to them could be stored in a snapshot and re-bound when it's restored. The registry passed to
+// Runtime.Snapshot() must contain the objects of the source Runtime, the one passed to Runtime.RestoreSnapshot()
+// must contain the objects under the same names created in the target Runtime.
+//
+// Only the references are stored, the state of the registered objects (including their properties) is not.
+type SnapshotRegistry struct {
+	byName map[string]*Object
+	names  map[*Object]string
+}
+
+func NewSnapshotRegistry() *SnapshotRegistry {
+	return &SnapshotRegistry{
+		byName: make(map[string]*Object),
+		names:  make(map[*Object]string),
+	}
+}
+
+// Register adds the object to the registry under the specified name, replacing the one previously registered
+// under the same name.
+func (reg *SnapshotRegistry) Register(name string, obj *Object) {
+	if old := reg.byName[name]; old != nil {
+		delete(reg.names, old)
+	}
+	reg.byName[name] = obj
+	reg.names[obj] = name
+}
+
+// snapshotIntrinsic describes the location of a built-in object in a pristine Runtime: either a root (the global
+// object or a field of the global struct) or a step from a previously located object.
+type snapshotIntrinsic struct {
+	parent int // -1 for the roots
+	field  int // the index of the global struct field, -1 for the global object
+	name   unistring.String
+	sym    *Symbol
+	step   byte
+
+	// the signature which is compared to verify the located object
+	typ             reflect.Type
+	call, construct uintptr
+}
+
+var (
+	snapshotIntrinsicsOnce sync.Once
+	snapshotIntrinsics     []snapshotIntrinsic
+)
+
+func getSnapshotIntrinsics() []snapshotIntrinsic {
+	snapshotIntrinsicsOnce.Do(func() {
+		snapshotIntrinsics = buildSnapshotIntrinsics(New())
+	})
+	return snapshotIntrinsics
+}
+
+func forceLazy(o *Object) {
+	if l, ok := o.self.(*lazyObject); ok {
+		o.self = l.create(o)
+	}
+}
+
+// snapshotSignature returns the properties used to verify that an object located in a Runtime is the same built-in
+// as in the pristine Runtime.
+func snapshotSignature(o *Object) (typ reflect.Type, call, construct uintptr, ok bool) {
+	if snapshotBaseObject(o.self) == nil {
+		return
+	}
+	switch impl := o.self.(type) {
+	case *guardedObject:
+		typ = reflect.TypeOf(&impl.baseObject)
+	case *nativeFuncObject:
+		typ = reflect.TypeOf(impl)
+		if impl.f != nil {
+			call = reflect.ValueOf(impl.f).Pointer()
+		}
+		if impl.construct != nil {
+			construct = reflect.ValueOf(impl.construct).Pointer()
+		}
+	default:
+		typ = reflect.TypeOf(impl)
+	}
+	return typ, call, construct, true
+}
+
+func buildSnapshotIntrinsics(r *Runtime) []snapshotIntrinsic {
+	var table []snapshotIntrinsic
+	var objs []*Object
+	seen := make(map[*Object]struct{})
+	add := func(o *Object, e snapshotIntrinsic) {
+		if o == nil {
+			return
+		}
+		if _, exists := seen[o]; exists {
+			return
+		}
+		forceLazy(o)
+		typ, call, construct, ok := snapshotSignature(o)
+		if !ok {
+			return
+		}
+		seen[o] = struct{}{}
+		e.typ, e.call, e.construct = typ, call, construct
+		table = append(table, e)
+		objs = append(objs, o)
+	}
+	add(r.globalObject, snapshotIntrinsic{parent: -1, field: -1})
+	g := reflect.ValueOf(r.global).Elem()
+	for i := 0; i < g.NumField(); i++ {
+		if f := g.Field(i); f.Type() == typeObject {
+			add(exposeField(f).Interface().(*Object), snapshotIntrinsic{parent: -1, field: i})
+		}
+	}
+	addProp := func(parent int, name unistring.String, sym *Symbol, v Value) {
+		e := snapshotIntrinsic{parent: parent, name: name, sym: sym}
+		if prop, ok := v.(*valueProperty); ok {
+			if prop.accessor {
+				e.step = snapStepGetter
+				add(prop.getterFunc, e)
+				e.step = snapStepSetter
+				add(prop.setterFunc, e)
+				return
+			}
+			v = prop.value
+		}
+		if o, ok := v.(*Object); ok {
+			e.step = snapStepValue
+			add(o, e)
+		}
+	}
+	for i := 0; i < len(objs); i++ {
+		b := snapshotBaseObject(objs[i].self)
+		for _, name := range b.propNames {
+			addProp(i, name, nil, b.values[name])
+		}
+		if b.symValues != nil {
+			iter := b.symValues.newIter()
+			for entry := iter.next(); entry != nil; entry = iter.next() {
+				addProp(i, "", entry.key.(*Symbol), entry.value)
+			}
+		}
+		add(b.prototype, snapshotIntrinsic{parent: i, step: snapStepProto})
+	}
+	return table
+}
+
+func (e *snapshotIntrinsic) lookup(b *baseObject) *Object {
+	if e.step == snapStepProto {
+		return b.prototype
+	}
+	var v Value
+	if e.sym != nil {
+		if b.symValues != nil {
+			v = b.symValues.get(e.sym)
+		}
+	} else {
+		v = b.values[e.name]
+	}
+	if prop, ok := v.(*valueProperty); ok {
+		switch e.step {
+		case snapStepGetter:
+			return prop.getterFunc
+		case snapStepSetter:
+			return prop.setterFunc
+		}
+		if prop.accessor {
+			return nil
+		}
+		v = prop.value
+	} else if e.step != snapStepValue {
+		return nil
+	}
+	o, _ := v.(*Object)
+	return o
+}
+
+// resolveSnapshotIntrinsics locates the built-in objects in the Runtime. The objects that cannot be found (because
+// they have been replaced) are nil.
+func (r *Runtime) resolveSnapshotIntrinsics(table []snapshotIntrinsic) []*Object {
+	objs := make([]*Object, len(table))
+	seen := make(map[*Object]struct{}, len(table))
+	g := reflect.ValueOf(r.global).Elem()
+	for i := range table {
+		e := &table[i]
+		var o *Object
+		if e.parent == -1 {
+			if e.field == -1 {
+				o = r.globalObject
+			} else {
+				o = exposeField(g.Field(e.field)).Interface().(*Object)
+			}
+		} else if p := objs[e.parent]; p != nil {
+			o = e.lookup(snapshotBaseObject(p.self))
+		}
+		if o == nil || o.runtime != r {
+			continue
+		}
+		if _, exists := seen[o]; exists {
+			continue
+		}
+		forceLazy(o)
+		typ, call, construct, ok := snapshotSignature(o)
+		if !ok || typ != e.typ || call != e.call || construct != e.construct {
+			continue
+		}
+		seen[o] = struct{}{}
+		objs[i] = o
+	}
+	return objs
+}
+
+func (r *Runtime) typedArrayObjectCtors() []typedArrayObjectCtor {
+	return []typedArrayObjectCtor{
+		r.newUint8ArrayObject,
+		r.newUint8ClampedArrayObject,
+		r.newInt8ArrayObject,
+		r.newUint16ArrayObject,
+		r.newInt16ArrayObject,
+		r.newUint32ArrayObject,
+		r.newInt32ArrayObject,
+		r.newFloat32ArrayObject,
+		r.newFloat64ArrayObject,
+	}
+}
+
+func snapshotBaseObject(impl objectImpl) *baseObject {
+	switch o := impl.(type) {
+	case *baseObject:
+		return o
+	case *guardedObject:
+		return &o.baseObject
+	case *nativeFuncObject:
+		return &o.baseObject
+	case *arrayObject:
+		return &o.baseObject
+	case *sparseArrayObject:
+		return &o.baseObject
+	case *funcObject:
+		return &o.baseObject
+	case *methodFuncObject:
+		return &o.baseObject
+	case *arrowFuncObject:
+		return &o.baseObject
+	case *classFuncObject:
+		return &o.baseObject
+	case *boundFuncObject:
+		return &o.baseObject
+	case *primitiveValueObject:
+		return &o.baseObject
+	case *stringObject:
+		return &o.baseObject
+	case *dateObject:
+		return &o.baseObject
+	case *regexpObject:
+		return &o.baseObject
+	case *errorObject:
+		return &o.baseObject
+	case *mapObject:
+		return &o.baseObject
+	case *setObject:
+		return &o.baseObject
+	case *weakMapObject:
+		return &o.baseObject
+	case *weakSetObject:
+		return &o.baseObject
+	case *proxyObject:
+		return &o.baseObject
+	case *Promise:
+		return &o.baseObject
+	case *arrayBufferObject:
+		return &o.baseObject
+	case *typedArrayObject:
+		return &o.baseObject
+	case *dataViewObject:
+		return &o.baseObject
+	}
+	return nil
+}
+
+func snapshotKind(impl objectImpl) (byte, bool) {
+	switch impl.(type) {
+	case *baseObject:
+		return snapKindObject, true
+	case *guardedObject:
+		return snapKindGuardedObject, true
+	case *nativeFuncObject:
+		return snapKindNativeFunc, true
+	case *arrayObject:
+		return snapKindArray, true
+	case *sparseArrayObject:
+		return snapKindSparseArray, true
+	case *funcObject:
+		return snapKindFunc, true
+	case *methodFuncObject:
+		return snapKindMethod, true
+	case *arrowFuncObject:
+		return snapKindArrow, true
+	case *classFuncObject:
+		return snapKindClass, true
+	case *boundFuncObject:
+		return snapKindBoundFunc, true
+	case *primitiveValueObject:
+		return snapKindPrimitive, true
+	case *stringObject:
+		return snapKindString, true
+	case *dateObject:
+		return snapKindDate, true
+	case *regexpObject:
+		return snapKindRegExp, true
+	case *errorObject:
+		return snapKindError, true
+	case *mapObject:
+		return snapKindMap, true
+	case *setObject:
+		return snapKindSet, true
+	case *weakMapObject:
+		return snapKindWeakMap, true
+	case *weakSetObject:
+		return snapKindWeakSet, true
+	case *proxyObject:
+		return snapKindProxy, true
+	case *Promise:
+		return snapKindPromise, true
+	case *arrayBufferObject:
+		return snapKindArrayBuffer, true
+	case *typedArrayObject:
+		return snapKindTypedArray, true
+	case *dataViewObject:
+		return snapKindDataView, true
+	}
+	return 0, false
+}
+
+// snapshotOwnProperty returns the property that is stored in a field of the object rather than allocated separately.
+func snapshotOwnProperty(impl objectImpl) *valueProperty {
+	switch o := impl.(type) {
+	case *nativeFuncObject:
+		return &o.lenProp
+	case *funcObject:
+		return &o.lenProp
+	case *methodFuncObject:
+		return &o.lenProp
+	case *arrowFuncObject:
+		return &o.lenProp
+	case *classFuncObject:
+		return &o.lenProp
+	case *boundFuncObject:
+		return &o.lenProp
+	case *arrayObject:
+		return &o.lengthProp
+	case *sparseArrayObject:
+		return &o.lengthProp
+	case *stringObject:
+		return &o.lengthProp
+	}
+	return nil
+}
+
+func objectValue(o *Object) Value {
+	if o == nil {
+		return nil
+	}
+	return o
+}
+
+type snapshotEncoder struct {
+	programEncoder
+	r   *Runtime
+	reg *SnapshotRegistry
+	op  string // the operation named in the errors
+
+	numIntrinsics int
+	ids           map[*Object]uint64
+	objs          []*Object
+	fill          []bool
+
+	stashes   map[*stash]uint64
+	privEnvs  map[*privateEnv]uint64
+	privTypes map[*privateEnvType]uint64
+	syms      map[*Symbol]uint64
+
+	weakColls map[weakMap]uint64
+	weakDone  map[snapshotWeakEntry]struct{}
+}
+
+type snapshotWeakEntry struct {
+	key uint64
+	wm  weakMap
+}
+
+type snapshotDecoder struct {
+	programDecoder
+	r   *Runtime
+	reg *SnapshotRegistry
+
+	numIntrinsics int
+	objs          []*Object
+	fill          []bool
+
+	stashes   []*stash
+	privEnvs  []*privateEnv
+	privTypes []*privateEnvType
+	syms      []*Symbol
+
+	fixups     map[*Object]func()
+	fixupOrder []*Object
+}
+
+// Snapshot serialises the heap of the Runtime: the global object and the global lexical declarations, and
+// everything that is reachable from them, including the closures with their scopes, the classes with their private
+// elements, the symbols and the modifications of the built-in objects. The result can be passed to RestoreSnapshot()
+// of a new Runtime, which allows to skip running the initialisation code (such as a library bundle) for every
+// Runtime.
+//
+// The built-in objects are not stored, instead they are referred to by their location in a pristine Runtime. A
+// built-in object that has been replaced at its original location, but is still referenced elsewhere, is stored as
+// if it was a user object (which fails for the built-in functions).
+//
+// The host objects (such as the Go functions set with Runtime.Set()) must be registered in reg, otherwise an error is
+// returned. Other objects that cannot be snapshotted are iterators, arguments objects, Go-backed objects, Proxies with
+// native handlers and Promises with pending reactions. A snapshot cannot be taken while the Runtime is running or when
+// its job queue is not empty.
+//
+// Settings that are not a part of the heap (such as SetRandSource(), SetFieldNameMapper() or SetMemoryLimit()) are
+// not stored. The format is versioned and is only guaranteed to be readable by the same version of this package.
+func (r *Runtime) Snapshot(reg *SnapshotRegistry) (data []byte, err error) {
+	if len(r.vm.callStack) > 0 {
+		return nil, errors.New("goja: cannot snapshot a running Runtime")
+	}
+	if len(r.jobQueue) > 0 {
+		return nil, errors.New("goja: cannot snapshot a Runtime with pending jobs")
+	}
+	e := r.newSnapshotEncoder(reg, "snapshot Runtime")
+	if err := e.encode(); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// RestoreSnapshot replaces the state of the Runtime with the one stored by Snapshot(). It is meant to be called on a
+// new Runtime before it runs any code, with the host objects already created and registered in reg under the same
+// names as in the source Runtime.
+//
+// If an error is returned, the Runtime is left in an inconsistent state and should be discarded.
+func (r *Runtime) RestoreSnapshot(data []byte, reg *SnapshotRegistry) (err error) {
+	if len(r.vm.callStack) > 0 {
+		return errors.New("goja: cannot restore a snapshot into a running Runtime")
+	}
+	if len(data) < len(snapshotMagic) || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return errInvalidSnapshotData
+	}
+	d := &snapshotDecoder{
+		programDecoder: programDecoder{
+			data: data,
+			pos:  len(snapshotMagic),
+		},
+		r:      r,
+		reg:    reg,
+		fixups: make(map[*Object]func()),
+	}
+	defer func() {
+		if x := recover(); x != nil {
+			err = snapshotError(x)
+			if err == errInvalidProgramData {
+				err = errInvalidSnapshotData
+			}
+		}
+	}()
+	if d.readUint() != snapshotVersion || d.readUint() != programVersion {
+		return errSnapshotVersion
+	}
+	d.readSnapshot()
+	if d.pos != len(d.data) {
+		return errInvalidSnapshotData
+	}
+	return nil
+}
+
+func (r *Runtime) newSnapshotEncoder(reg *SnapshotRegistry, op string) *snapshotEncoder {
+	return &snapshotEncoder{
+		programEncoder: programEncoder{
+			files: make(map[*file.File]uint64),
+			progs: make(map[*Program]uint64),
+		},
+		r:         r,
+		reg:       reg,
+		op:        op,
+		ids:       make(map[*Object]uint64),
+		stashes:   make(map[*stash]uint64),
+		privEnvs:  make(map[*privateEnv]uint64),
+		privTypes: make(map[*privateEnvType]uint64),
+		syms:      make(map[*Symbol]uint64),
+		weakColls: make(map[weakMap]uint64),
+		weakDone:  make(map[snapshotWeakEntry]struct{}),
+	}
+}
+
+func (e *snapshotEncoder) encode() (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = snapshotError(x)
+		}
+	}()
+	e.writeSnapshot()
+	return nil
+}
+
+func snapshotError(x interface{}) error {
+	switch x := x.(type) {
+	case programEncodeError:
+		return x.err
+	case *uncatchableException:
+		return x.err
+	case *Exception:
+		return x
+	}
+	panic(x)
+}
+
+func (e *snapshotEncoder) fail(format string, args ...interface{}) {
+	panic(programEncodeError{fmt.Errorf("goja: cannot "+e.op+": "+format, args...)})
+}
+
+func (e *snapshotEncoder) addObject(o *Object, fill bool) uint64 {
+	id := uint64(len(e.objs))
+	e.ids[o] = id
+	e.objs = append(e.objs, o)
+	e.fill = append(e.fill, fill)
+	return id
+}
+
+func (e *snapshotEncoder) writeSnapshot() {
+	r := e.r
+	e.buf = append(e.buf, snapshotMagic...)
+	e.writeUint(snapshotVersion)
+	e.writeUint(programVersion)
+
+	table := getSnapshotIntrinsics()
+	intrinsics := r.resolveSnapshotIntrinsics(table)
+	e.numIntrinsics = len(intrinsics)
+	e.writeUint(uint64(len(intrinsics)))
+	var skipped []int
+	for i, o := range intrinsics {
+		if o == nil {
+			skipped = append(skipped, i)
+			e.objs = append(e.objs, nil)
+			e.fill = append(e.fill, false)
+		} else {
+			e.addObject(o, true)
+		}
+	}
+	e.writeUint(uint64(len(skipped)))
+	last := 0
+	for _, i := range skipped {
+		e.writeUint(uint64(i - last))
+		last = i
+	}
+
+	e.writeStash(&r.global.stash)
+	varNames := make([]string, 0, len(r.global.varNames))
+	for name := range r.global.varNames {
+		varNames = append(varNames, string(name))
+	}
+	sort.Strings(varNames)
+	e.writeUint(uint64(len(varNames)))
+	for _, name := range varNames {
+		e.writeString(name)
+	}
+
+	filled := 0
+	for {
+		for ; filled < len(e.objs); filled++ {
+			if e.fill[filled] {
+				o := e.objs[filled]
+				if filled < e.numIntrinsics {
+					kind, _ := snapshotKind(o.self)
+					e.buf = append(e.buf, kind)
+				}
+				e.writeState(o)
+			}
+		}
+		if !e.writeWeakEntries() {
+			break
+		}
+	}
+}
+
+// writeWeakEntries writes the entries of the WeakMaps and WeakSets which are stored in the key objects. Only the
+// entries where both the key and the collection have been written are included. Because writing the values may
+// add more objects, it's called repeatedly until there are no more entries.
+func (e *snapshotEncoder) writeWeakEntries() bool {
+	type entry struct {
+		key, coll uint64
+		value     Value
+	}
+	var entries []entry
+	for id, o := range e.objs {
+		if o == nil {
+			continue
+		}
+		for wm, value := range o.weakRefs {
+			coll, exists := e.weakColls[wm]
+			if !exists {
+				continue
+			}
+			k := snapshotWeakEntry{key: uint64(id), wm: wm}
+			if _, done := e.weakDone[k]; done {
+				continue
+			}
+			e.weakDone[k] = struct{}{}
+			entries = append(entries, entry{key: uint64(id), coll: coll, value: value})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+		return entries[i].coll < entries[j].coll
+	})
+	e.writeUint(uint64(len(entries)))
+	for _, entry := range entries {
+		e.writeUint(entry.key)
+		e.writeUint(entry.coll)
+		e.writeValue(entry.value)
+	}
+	return len(entries) > 0
+}
+
+func (e *snapshotEncoder) writeObjectRef(o *Object) {
+	if id, exists := e.ids[o]; exists {
+		e.writeUint(id + 2)
+		return
+	}
+	if e.reg != nil {
+		if name, exists := e.reg.names[o]; exists {
+			e.addObject(o, false)
+			e.writeUint(1)
+			e.writeString(name)
+			return
+		}
+	}
+	if o.runtime != e.r {
+		e.fail("the object belongs to another Runtime")
+	}
+	forceLazy(o)
+	kind, ok := snapshotKind(o.self)
+	switch {
+	case !ok:
+		e.fail("unsupported object type %T (%s)", o.self, o.self.className())
+	case kind == snapKindNativeFunc:
+		name, _ := nilSafe(o.self.getStr("name", nil)).(valueString)
+		e.fail("native function %q is not registered", name)
+	case kind == snapKindGuardedObject:
+		e.fail("unsupported object type %T", o.self)
+	}
+	e.addObject(o, true)
+	e.writeUint(0)
+	e.buf = append(e.buf, kind)
+}
+
+func (e *snapshotEncoder) writeValue(v Value) {
+	switch v := v.(type) {
+	case nil:
+		e.buf = append(e.buf, snapValNil)
+	case valueUndefined:
+		e.buf = append(e.buf, snapValUndefined)
+	case valueNull:
+		e.buf = append(e.buf, snapValNull)
+	case valueBool:
+		if v {
+			e.buf = append(e.buf, snapValTrue)
+		} else {
+			e.buf = append(e.buf, snapValFalse)
+		}
+	case valueInt:
+		e.buf = append(e.buf, snapValInt)
+		e.writeInt(int64(v))
+	case valueFloat:
+		e.buf = append(e.buf, snapValFloat)
+		e.writeFloat(float64(v))
+	case valueString:
+		e.buf = append(e.buf, snapValString)
+		e.writeString(string(v.string()))
+	case *Object:
+		e.buf = append(e.buf, snapValObject)
+		e.writeObjectRef(v)
+	case *Symbol:
+		e.buf = append(e.buf, snapValSymbol)
+		e.writeSymbol(v)
+	case *valueProperty:
+		e.buf = append(e.buf, snapValProperty)
+		e.writeProperty(v)
+	default:
+		e.fail("unsupported value type %T", v)
+	}
+}
+
+func (e *snapshotEncoder) writeFloat(f float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *snapshotEncoder) writeValues(values []Value) {
+	if values == nil {
+		e.writeUint(0)
+		return
+	}
+	e.writeUint(uint64(len(values)) + 1)
+	for _, v := range values {
+		e.writeValue(v)
+	}
+}
+
+func (e *snapshotEncoder) writeProperty(p *valueProperty) {
+	e.writeBool(p.writable)
+	e.writeBool(p.configurable)
+	e.writeBool(p.enumerable)
+	e.writeBool(p.accessor)
+	if p.accessor {
+		e.writeValue(objectValue(p.getterFunc))
+		e.writeValue(objectValue(p.setterFunc))
+	} else {
+		e.writeValue(p.value)
+	}
+}
+
+func (e *snapshotEncoder) writeSymbol(s *Symbol) {
+	for i, sym := range snapshotWellKnownSymbols {
+		if sym == s {
+			e.writeUint(uint64(i) + 2)
+			return
+		}
+	}
+	if id, exists := e.syms[s]; exists {
+		e.writeUint(uint64(len(snapshotWellKnownSymbols)) + 2 + id)
+		return
+	}
+	e.syms[s] = uint64(len(e.syms))
+	if s.desc != nil && e.r.symbolRegistry[s.desc.string()] == s {
+		e.writeUint(1)
+		e.writeString(string(s.desc.string()))
+		return
+	}
+	e.writeUint(0)
+	e.writeBool(s.desc != nil)
+	if s.desc != nil {
+		e.writeString(string(s.desc.string()))
+	}
+}
+
+func (e *snapshotEncoder) writeStashRef(s *stash) {
+	switch {
+	case s == nil:
+		e.writeUint(0)
+		return
+	case s == &e.r.global.stash:
+		e.writeUint(1)
+		return
+	}
+	if id, exists := e.stashes[s]; exists {
+		e.writeUint(id + 3)
+		return
+	}
+	e.stashes[s] = uint64(len(e.stashes))
+	e.writeUint(2)
+	e.writeStash(s)
+}
+
+func (e *snapshotEncoder) writeStash(s *stash) {
+	e.writeValues(s.values)
+	e.writeValues(s.extraArgs)
+	if s.names == nil {
+		e.writeUint(0)
+	} else {
+		names := make([]string, 0, len(s.names))
+		for name := range s.names {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		e.writeUint(uint64(len(names)) + 1)
+		for _, name := range names {
+			e.writeString(name)
+			e.writeUint(uint64(s.names[unistring.String(name)]))
+		}
+	}
+	e.writeValue(objectValue(s.obj))
+	e.writeStashRef(s.outer)
+	e.writeUint(uint64(s.funcType))
+}
+
+func (e *snapshotEncoder) writePrivateTypeRef(t *privateEnvType) {
+	if t == nil {
+		e.writeUint(0)
+		return
+	}
+	if id, exists := e.privTypes[t]; exists {
+		e.writeUint(id + 2)
+		return
+	}
+	e.privTypes[t] = uint64(len(e.privTypes))
+	e.writeUint(1)
+	e.writeUint(uint64(t.numFields))
+	e.writeUint(uint64(t.numMethods))
+}
+
+func (e *snapshotEncoder) writePrivateEnvRef(p *privateEnv) {
+	if p == nil {
+		e.writeUint(0)
+		return
+	}
+	if id, exists := e.privEnvs[p]; exists {
+		e.writeUint(id + 2)
+		return
+	}
+	e.privEnvs[p] = uint64(len(e.privEnvs))
+	e.writeUint(1)
+	e.writePrivateTypeRef(p.instanceType)
+	e.writePrivateTypeRef(p.staticType)
+	if p.names == nil {
+		e.writeUint(0)
+	} else {
+		names := make([]string, 0, len(p.names))
+		for name := range p.names {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		e.writeUint(uint64(len(names)) + 1)
+		for _, name := range names {
+			id := p.names[unistring.String(name)]
+			e.writeString(name)
+			e.writePrivateTypeRef(id.typ)
+			e.writeUint(uint64(id.idx))
+			e.writeBool(id.isMethod)
+		}
+	}
+	e.writePrivateEnvRef(p.outer)
+}
+
+func (e *snapshotEncoder) writeJsFunc(f *baseJsFuncObject) {
+	e.writeStashRef(f.stash)
+	e.writePrivateEnvRef(f.privEnv)
+	e.writeProgramRef(f.prg)
+	prevSrc := e.src
+	e.src = nil
+	if f.prg != nil {
+		e.src = f.prg.src
+	}
+	e.writeSrcString(f.src)
+	e.src = prevSrc
+	e.writeBool(f.strict)
+}
+
+func (e *snapshotEncoder) writeBase(b *baseObject, own *valueProperty) {
+	e.writeString(b.class)
+	e.writeValue(objectValue(b.prototype))
+	e.writeBool(b.extensible)
+	e.writeUint(uint64(len(b.propNames)))
+	for _, name := range b.propNames {
+		e.writeString(string(name))
+		v := b.values[name]
+		if own != nil && v == Value(own) {
+			e.buf = append(e.buf, snapValOwnProperty)
+			e.writeProperty(own)
+		} else {
+			e.writeValue(v)
+		}
+	}
+	e.writeUint(uint64(b.lastSortedPropLen))
+	e.writeUint(uint64(b.idxPropCount))
+	if b.symValues == nil {
+		e.writeUint(0)
+	} else {
+		e.writeUint(uint64(b.symValues.size) + 1)
+		iter := b.symValues.newIter()
+		for entry := iter.next(); entry != nil; entry = iter.next() {
+			e.writeSymbol(entry.key.(*Symbol))
+			e.writeValue(entry.value)
+		}
+	}
+	if b.privateElements == nil {
+		e.writeUint(0)
+	} else {
+		e.writeUint(uint64(len(b.privateElements)) + 1)
+		for typ, elements := range b.privateElements {
+			e.writePrivateTypeRef(typ)
+			e.writeValues(elements.methods)
+			e.writeValues(elements.fields)
+		}
+	}
+}
+
+// writeState writes the type-specific state of the object followed by the state common to all objects.
+func (e *snapshotEncoder) writeState(obj *Object) {
+	r := e.r
+	switch o := obj.self.(type) {
+	case *arrayObject:
+		e.writeUint(uint64(o.length))
+		e.writeUint(uint64(o.objCount))
+		e.writeUint(uint64(o.propValueCount))
+		e.writeValues(o.values)
+	case *sparseArrayObject:
+		e.writeUint(uint64(o.length))
+		e.writeUint(uint64(o.propValueCount))
+		e.writeUint(uint64(len(o.items)))
+		for _, item := range o.items {
+			e.writeUint(uint64(item.idx))
+			e.writeValue(item.value)
+		}
+	case *funcObject:
+		e.writeJsFunc(&o.baseJsFuncObject)
+	case *methodFuncObject:
+		e.writeJsFunc(&o.baseJsFuncObject)
+		e.writeValue(objectValue(o.homeObject))
+	case *arrowFuncObject:
+		e.writeJsFunc(&o.baseJsFuncObject)
+		e.writeValue(objectValue(o.funcObj))
+		e.writeValue(o.newTarget)
+	case *classFuncObject:
+		e.writeJsFunc(&o.baseJsFuncObject)
+		e.writeProgramRef(o.initFields)
+		e.writeValues(o.computedKeys)
+		e.writePrivateTypeRef(o.privateEnvType)
+		e.writeValues(o.privateMethods)
+		e.writeBool(o.derived)
+	case *boundFuncObject:
+		e.writeObjectRef(o.wrapped)
+		e.writeValues(o.boundArgs)
+	case *primitiveValueObject:
+		e.writeValue(o.pValue)
+	case *stringObject:
+		e.writeValue(o.value)
+	case *dateObject:
+		e.writeInt(o.msec)
+	case *regexpObject:
+		e.writeValue(o.source)
+		e.writeString(o.pattern.flags())
+		e.writeBool(o.standard)
+	case *errorObject:
+		e.writeUint(uint64(len(o.stack)))
+		for _, frame := range o.stack {
+			e.writeProgramRef(frame.prg)
+			e.writeString(string(frame.funcName))
+			e.writeInt(int64(frame.pc))
+		}
+		e.writeBool(o.stackPropAdded)
+	case *mapObject:
+		e.writeOrderedMap(o.m)
+	case *setObject:
+		e.writeOrderedMap(o.m)
+	case *weakMapObject:
+		e.weakColls[o.m] = e.ids[obj]
+	case *weakSetObject:
+		e.weakColls[o.s] = e.ids[obj]
+	case *proxyObject:
+		if o.handler == nil {
+			e.writeBool(false)
+			break
+		}
+		h, ok := o.handler.(*jsProxyHandler)
+		if !ok {
+			e.fail("Proxies with native handlers are not supported")
+		}
+		e.writeBool(true)
+		e.writeObjectRef(h.handler)
+		e.writeObjectRef(o.target)
+	case *Promise:
+		if len(o.fulfillReactions) > 0 || len(o.rejectReactions) > 0 {
+			e.fail("Promises with pending reactions are not supported")
+		}
+		e.writeUint(uint64(o.state))
+		e.writeValue(o.result)
+		e.writeBool(o.handled)
+	case *arrayBufferObject:
+		e.writeBool(o.detached)
+		e.writeUint(uint64(len(o.data)))
+		e.buf = append(e.buf, o.data...)
+	case *typedArrayObject:
+		kind := -1
+		for i, ctor := range r.typedArrayCtors() {
+			if o.defaultCtor == ctor {
+				kind = i
+				break
+			}
+		}
+		if kind == -1 {
+			e.fail("unsupported typed array type")
+		}
+		e.writeUint(uint64(kind))
+		e.writeObjectRef(o.viewedArrayBuf.val)
+		e.writeUint(uint64(o.offset))
+		e.writeUint(uint64(o.length))
+	case *dataViewObject:
+		e.writeObjectRef(o.viewedArrayBuf.val)
+		e.writeUint(uint64(o.byteOffset))
+		e.writeUint(uint64(o.byteLen))
+	}
+	e.writeBase(snapshotBaseObject(obj.self), snapshotOwnProperty(obj.self))
+}
+
+func (e *snapshotEncoder) writeOrderedMap(m *orderedMap) {
+	e.writeUint(uint64(m.size))
+	iter := m.newIter()
+	for entry := iter.next(); entry != nil; entry = iter.next() {
+		e.writeValue(entry.key)
+		e.writeValue(entry.value)
+	}
+}
+
+func (d *snapshotDecoder) readSnapshot() {
+	r := d.r
+	table := getSnapshotIntrinsics()
+	if d.readLen() != len(table) {
+		panic(programEncodeError{errSnapshotMismatch})
+	}
+	intrinsics := r.resolveSnapshotIntrinsics(table)
+	skipped := make([]bool, len(intrinsics))
+	n := d.readLen()
+	idx := 0
+	for i := 0; i < n; i++ {
+		delta := d.readLen()
+		idx += delta
+		if idx >= len(intrinsics) || i > 0 && delta == 0 {
+			d.fail()
+		}
+		skipped[idx] = true
+	}
+	d.numIntrinsics = len(intrinsics)
+	d.fill = make([]bool, len(intrinsics))
+	for i, o := range intrinsics {
+		if skipped[i] {
+			intrinsics[i] = nil
+		} else if o == nil {
+			panic(programEncodeError{errSnapshotMismatch})
+		} else {
+			d.fill[i] = true
+		}
+	}
+	d.objs = intrinsics
+
+	d.readStashInto(&r.global.stash)
+	n = d.readLen()
+	r.global.varNames = make(map[unistring.String]struct{}, n)
+	for i := 0; i < n; i++ {
+		r.global.varNames[unistring.String(d.readString())] = struct{}{}
+	}
+
+	filled := 0
+	for {
+		for ; filled < len(d.objs); filled++ {
+			if d.fill[filled] {
+				o := d.objs[filled]
+				intrinsic := filled < d.numIntrinsics
+				if intrinsic {
+					d.readIntrinsicKind(o)
+				}
+				d.readState(o, intrinsic)
+			}
+		}
+		if !d.readWeakEntries() {
+			break
+		}
+	}
+	for _, o := range d.fixupOrder {
+		d.runFixup(o)
+	}
+}
+
+func (d *snapshotDecoder) readIntrinsicKind(o *Object) {
+	kind := d.readByte()
+	expected, _ := snapshotKind(o.self)
+	if kind != expected {
+		if g, ok := o.self.(*guardedObject); ok && kind == snapKindObject {
+			// the guarded properties have been modified
+			o.self = &g.baseObject
+			return
+		}
+		panic(programEncodeError{errSnapshotMismatch})
+	}
+}
+
+func (d *snapshotDecoder) readWeakEntries() bool {
+	n := d.readLen()
+	for i := 0; i < n; i++ {
+		key := d.objectById(d.readUint())
+		coll := d.objectById(d.readUint())
+		var wm weakMap
+		switch c := coll.self.(type) {
+		case *weakMapObject:
+			wm = c.m
+		case *weakSetObject:
+			wm = c.s
+		default:
+			d.fail()
+		}
+		key.getWeakRefs()[wm] = d.readValue()
+	}
+	return n > 0
+}
+
+func (d *snapshotDecoder) objectById(id uint64) *Object {
+	if id >= uint64(len(d.objs)) || d.objs[id] == nil {
+		d.fail()
+	}
+	return d.objs[id]
+}
+
+func (d *snapshotDecoder) addFixup(o *Object, f func()) {
+	d.fixups[o] = f
+	d.fixupOrder = append(d.fixupOrder, o)
+}
+
+// runFixup completes the object which depends on the state of other objects (such as a bound function which needs
+// the target to be callable).
+func (d *snapshotDecoder) runFixup(o *Object) {
+	if f := d.fixups[o]; f != nil {
+		delete(d.fixups, o)
+		f()
+	}
+}
+
+func (d *snapshotDecoder) newObject(kind byte) *Object {
+	r := d.r
+	o := &Object{runtime: r}
+	o.self = newSnapshotObjectImpl(r, o, kind)
+	if o.self == nil {
+		d.fail()
+	}
+	r.allocMem(memObjectSize)
+	return o
+}
+
+// newSnapshotObjectImpl allocates an empty object of the specified kind which is then filled with the state.
+func newSnapshotObjectImpl(r *Runtime, o *Object, kind byte) objectImpl {
+	b := baseObject{val: o}
+	switch kind {
+	case snapKindObject:
+		return &b
+	case snapKindArray:
+		return &arrayObject{baseObject: b}
+	case snapKindSparseArray:
+		return &sparseArrayObject{baseObject: b}
+	case snapKindFunc:
+		return &funcObject{baseJsFuncObject: baseJsFuncObject{baseFuncObject: baseFuncObject{baseObject: b, realm: r.realm}}}
+	case snapKindMethod:
+		return &methodFuncObject{baseJsFuncObject: baseJsFuncObject{baseFuncObject: baseFuncObject{baseObject: b, realm: r.realm}}}
+	case snapKindArrow:
+		return &arrowFuncObject{baseJsFuncObject: baseJsFuncObject{baseFuncObject: baseFuncObject{baseObject: b, realm: r.realm}}}
+	case snapKindClass:
+		return &classFuncObject{baseJsFuncObject: baseJsFuncObject{baseFuncObject: baseFuncObject{baseObject: b, realm: r.realm}}}
+	case snapKindBoundFunc:
+		return &boundFuncObject{nativeFuncObject: nativeFuncObject{baseFuncObject: baseFuncObject{baseObject: b, realm: r.realm}}}
+	case snapKindPrimitive:
+		return &primitiveValueObject{baseObject: b}
+	case snapKindString:
+		return &stringObject{baseObject: b}
+	case snapKindDate:
+		return &dateObject{baseObject: b}
+	case snapKindRegExp:
+		return &regexpObject{baseObject: b}
+	case snapKindError:
+		return &errorObject{baseObject: b}
+	case snapKindMap:
+		return &mapObject{baseObject: b}
+	case snapKindSet:
+		return &setObject{baseObject: b}
+	case snapKindWeakMap:
+		return &weakMapObject{baseObject: b, m: weakMap(r.genId())}
+	case snapKindWeakSet:
+		return &weakSetObject{baseObject: b, s: weakMap(r.genId())}
+	case snapKindProxy:
+		return &proxyObject{baseObject: b}
+	case snapKindPromise:
+		return &Promise{baseObject: b}
+	case snapKindArrayBuffer:
+		return &arrayBufferObject{baseObject: b}
+	case snapKindTypedArray:
+		return &typedArrayObject{baseObject: b}
+	case snapKindDataView:
+		return &dataViewObject{baseObject: b}
+	}
+	return nil
+}
+
+func (d *snapshotDecoder) readObjectRef() *Object {
+	switch id := d.readUint(); id {
+	case 0:
+		o := d.newObject(d.readByte())
+		d.objs = append(d.objs, o)
+		d.fill = append(d.fill, true)
+		return o
+	case 1:
+		name := d.readString()
+		var o *Object
+		if d.reg != nil {
+			o = d.reg.byName[name]
+		}
+		if o == nil {
+			panic(programEncodeError{fmt.Errorf("goja: host object %q is not registered", name)})
+		}
+		if o.runtime != d.r {
+			panic(programEncodeError{fmt.Errorf("goja: host object %q belongs to another Runtime", name)})
+		}
+		d.objs = append(d.objs, o)
+		d.fill = append(d.fill, false)
+		return o
+	default:
+		return d.objectById(id - 2)
+	}
+}
+
+func (d *snapshotDecoder) readValue() Value {
+	return d.readValueTagged(d.readByte())
+}
+
+func (d *snapshotDecoder) readValueTagged(tag byte) Value {
+	switch tag {
+	case snapValNil:
+		return nil
+	case snapValUndefined:
+		return _undefined
+	case snapValNull:
+		return _null
+	case snapValTrue:
+		return valueTrue
+	case snapValFalse:
+		return valueFalse
+	case snapValInt:
+		return valueInt(d.readInt())
+	case snapValFloat:
+		if d.pos+8 > len(d.data) {
+			d.fail()
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(d.data[d.pos:]))
+		d.pos += 8
+		return valueFloat(f)
+	case snapValString:
+		return stringValueFromRaw(unistring.String(d.readString()))
+	case snapValObject:
+		return d.readObjectRef()
+	case snapValSymbol:
+		return d.readSymbol()
+	case snapValProperty:
+		p := &valueProperty{}
+		d.readPropertyInto(p)
+		return p
+	}
+	d.fail()
+	return nil
+}
+
+func (d *snapshotDecoder) readObjectOrNil() *Object {
+	switch v := d.readValue().(type) {
+	case nil:
+		return nil
+	case *Object:
+		return v
+	}
+	d.fail()
+	return nil
+}
+
+func (d *snapshotDecoder) readValues() []Value {
+	n := d.readLen()
+	if n == 0 {
+		return nil
+	}
+	values := make([]Value, n-1)
+	for i := range values {
+		values[i] = d.readValue()
+	}
+	return values
+}
+
+func (d *snapshotDecoder) readPropertyInto(p *valueProperty) {
+	p.writable = d.readBool()
+	p.configurable = d.readBool()
+	p.enumerable = d.readBool()
+	p.accessor = d.readBool()
+	if p.accessor {
+		p.getterFunc = d.readObjectOrNil()
+		p.setterFunc = d.readObjectOrNil()
+		p.value = nil
+	} else {
+		p.value = d.readValue()
+		p.getterFunc, p.setterFunc = nil, nil
+	}
+}
+
+func (d *snapshotDecoder) readSymbol() *Symbol {
+	id := d.readUint()
+	switch id {
+	case 0:
+		var desc valueString
+		if d.readBool() {
+			desc = stringValueFromRaw(unistring.String(d.readString()))
+		}
+		s := newSymbol(desc)
+		d.syms = append(d.syms, s)
+		return s
+	case 1:
+		key := stringValueFromRaw(unistring.String(d.readString()))
+		s := d.r.symbol_for(FunctionCall{Arguments: []Value{key}}).(*Symbol)
+		d.syms = append(d.syms, s)
+		return s
+	}
+	id -= 2
+	if id < uint64(len(snapshotWellKnownSymbols)) {
+		return snapshotWellKnownSymbols[id]
+	}
+	id -= uint64(len(snapshotWellKnownSymbols))
+	if id >= uint64(len(d.syms)) {
+		d.fail()
+	}
+	return d.syms[id]
+}
+
+func (d *snapshotDecoder) readStashRef() *stash {
+	switch id := d.readUint(); id {
+	case 0:
+		return nil
+	case 1:
+		return &d.r.global.stash
+	case 2:
+		s := &stash{}
+		d.stashes = append(d.stashes, s)
+		d.readStashInto(s)
+		return s
+	default:
+		id -= 3
+		if id >= uint64(len(d.stashes)) {
+			d.fail()
+		}
+		return d.stashes[id]
+	}
+}
+
+func (d *snapshotDecoder) readStashInto(s *stash) {
+	s.values = d.readValues()
+	s.extraArgs = d.readValues()
+	s.names = nil
+	if n := d.readLen(); n > 0 {
+		n--
+		s.names = make(map[unistring.String]uint32, n)
+		for i := 0; i < n; i++ {
+			name := unistring.String(d.readString())
+			idx := d.readUint()
+			if idx > math.MaxUint32 || uint64(idx&^maskTyp) >= uint64(len(s.values)) {
+				d.fail()
+			}
+			s.names[name] = uint32(idx)
+		}
+	}
+	s.obj = d.readObjectOrNil()
+	s.outer = d.readStashRef()
+	s.funcType = funcType(d.readUint())
+}
+
+func (d *snapshotDecoder) readPrivateTypeRef() *privateEnvType {
+	switch id := d.readUint(); id {
+	case 0:
+		return nil
+	case 1:
+		t := &privateEnvType{}
+		t.numFields = uint32(d.readLen())
+		t.numMethods = uint32(d.readLen())
+		d.privTypes = append(d.privTypes, t)
+		return t
+	default:
+		id -= 2
+		if id >= uint64(len(d.privTypes)) {
+			d.fail()
+		}
+		return d.privTypes[id]
+	}
+}
+
+func (d *snapshotDecoder) readPrivateEnvRef() *privateEnv {
+	switch id := d.readUint(); id {
+	case 0:
+		return nil
+	case 1:
+		p := &privateEnv{}
+		d.privEnvs = append(d.privEnvs, p)
+		p.instanceType = d.readPrivateTypeRef()
+		p.staticType = d.readPrivateTypeRef()
+		if n := d.readLen(); n > 0 {
+			n--
+			p.names = make(privateNames, n)
+			for i := 0; i < n; i++ {
+				id := &privateId{}
+				id.name = unistring.String(d.readString())
+				id.typ = d.readPrivateTypeRef()
+				id.idx = uint32(d.readLen())
+				id.isMethod = d.readBool()
+				p.names[id.name] = id
+			}
+		}
+		p.outer = d.readPrivateEnvRef()
+		return p
+	default:
+		id -= 2
+		if id >= uint64(len(d.privEnvs)) {
+			d.fail()
+		}
+		return d.privEnvs[id]
+	}
+}
+
+func (d *snapshotDecoder) readJsFunc(f *baseJsFuncObject) {
+	f.stash = d.readStashRef()
+	f.privEnv = d.readPrivateEnvRef()
+	f.prg = d.readProgramRef()
+	prevSrc := d.src
+	d.src = nil
+	if f.prg != nil {
+		d.src = f.prg.src
+	}
+	f.src = d.readSrcString()
+	d.src = prevSrc
+	f.strict = d.readBool()
+}
+
+func (d *snapshotDecoder) readBase(b *baseObject, own *valueProperty, account bool) {
+	r := d.r
+	b.class = d.readString()
+	b.prototype = d.readObjectOrNil()
+	b.extensible = d.readBool()
+	n := d.readLen()
+	b.values = make(map[unistring.String]Value, n)
+	b.propNames = nil
+	if n > 0 {
+		b.propNames = make([]unistring.String, 0, n)
+	}
+	for i := 0; i < n; i++ {
+		name := unistring.String(d.readString())
+		var v Value
+		if tag := d.readByte(); tag == snapValOwnProperty {
+			if own == nil {
+				d.fail()
+			}
+			d.readPropertyInto(own)
+			v = own
+		} else {
+			v = d.readValueTagged(tag)
+		}
+		if _, exists := b.values[name]; exists || v == nil {
+			d.fail()
+		}
+		if account {
+			r.allocMem(memPropertySize)
+		}
+		b.values[name] = v
+		b.propNames = append(b.propNames, name)
+	}
+	b.lastSortedPropLen = d.readLen()
+	b.idxPropCount = d.readLen()
+	if b.lastSortedPropLen > n || b.idxPropCount > n {
+		d.fail()
+	}
+	b.symValues = nil
+	if n := d.readLen(); n > 0 {
+		b.symValues = newOrderedMap(nil)
+		for i := 1; i < n; i++ {
+			sym := d.readSymbol()
+			b.symValues.set(sym, d.readValue())
+		}
+	}
+	b.privateElements = nil
+	if n := d.readLen(); n > 0 {
+		b.privateElements = make(map[*privateEnvType]*privateElements, n-1)
+		for i := 1; i < n; i++ {
+			typ := d.readPrivateTypeRef()
+			if typ == nil {
+				d.fail()
+			}
+			b.privateElements[typ] = &privateElements{
+				methods: d.readValues(),
+				fields:  d.readValues(),
+			}
+		}
+	}
+}
+
+func (d *snapshotDecoder) readOrderedMap() *orderedMap {
+	r := d.r
+	m := newOrderedMap(r.getHash())
+	m.runtime = r
+	n := d.readLen()
+	for i := 0; i < n; i++ {
+		key := d.readValue()
+		m.set(key, d.readValue())
+	}
+	return m
+}
+
+func (d *snapshotDecoder) readArrayBufferRef() *arrayBufferObject {
+	if buf, ok := d.readObjectRef().self.(*arrayBufferObject); ok {
+		return buf
+	}
+	d.fail()
+	return nil
+}
+
+// readState is the counterpart of snapshotEncoder.writeState().
+func (d *snapshotDecoder) readState(obj *Object, intrinsic bool) {
+	r := d.r
+	switch o := obj.self.(type) {
+	case *arrayObject:
+		o.length = uint32(d.readLen())
+		o.objCount = d.readLen()
+		o.propValueCount = d.readLen()
+		o.values = d.readValues()
+		if uint64(len(o.values)) > uint64(o.length) {
+			d.fail()
+		}
+		r.allocMem(int64(len(o.values)) * memValueSize)
+	case *sparseArrayObject:
+		o.length = uint32(d.readUint())
+		o.propValueCount = d.readLen()
+		n := d.readLen()
+		o.items = make([]sparseArrayItem, n)
+		for i := range o.items {
+			idx := d.readUint()
+			if idx >= uint64(o.length) || i > 0 && uint32(idx) <= o.items[i-1].idx {
+				d.fail()
+			}
+			o.items[i] = sparseArrayItem{idx: uint32(idx), value: d.readValue()}
+		}
+		r.allocMem(int64(n) * memSparseItemSize)
+	case *funcObject:
+		d.readJsFunc(&o.baseJsFuncObject)
+	case *methodFuncObject:
+		d.readJsFunc(&o.baseJsFuncObject)
+		o.homeObject = d.readObjectOrNil()
+	case *arrowFuncObject:
+		d.readJsFunc(&o.baseJsFuncObject)
+		o.funcObj = d.readObjectOrNil()
+		o.newTarget = d.readValue()
+	case *classFuncObject:
+		d.readJsFunc(&o.baseJsFuncObject)
+		o.initFields = d.readProgramRef()
+		o.computedKeys = d.readValues()
+		o.privateEnvType = d.readPrivateTypeRef()
+		o.privateMethods = d.readValues()
+		o.derived = d.readBool()
+	case *boundFuncObject:
+		o.wrapped = d.readObjectRef()
+		o.boundArgs = d.readValues()
+		d.addFixup(obj, func() {
+			d.runFixup(o.wrapped)
+			o.f = r.boundCallable(r.toCallable(o.wrapped), o.boundArgs)
+			o.construct = r.boundConstruct(obj, o.wrapped.self.assertConstructor(), o.boundArgs)
+		})
+	case *primitiveValueObject:
+		o.pValue = d.readValue()
+	case *stringObject:
+		s, ok := d.readValue().(valueString)
+		if !ok {
+			d.fail()
+		}
+		o.value = s
+		o.length = s.length()
+	case *dateObject:
+		o.msec = d.readInt()
+	case *regexpObject:
+		source, ok := d.readValue().(valueString)
+		if !ok {
+			d.fail()
+		}
+		pattern, err := compileRegexpFromValueString(source, d.readString())
+		if err != nil {
+			d.fail()
+		}
+		o.source = source
+		o.pattern = pattern
+		o.standard = d.readBool()
+	case *errorObject:
+		n := d.readLen()
+		o.stack = make([]StackFrame, n)
+		for i := range o.stack {
+			o.stack[i].prg = d.readProgramRef()
+			o.stack[i].funcName = unistring.String(d.readString())
+			o.stack[i].pc = int(d.readInt())
+		}
+		o.stackPropAdded = d.readBool()
+	case *mapObject:
+		o.m = d.readOrderedMap()
+	case *setObject:
+		o.m = d.readOrderedMap()
+	case *proxyObject:
+		if d.readBool() {
+			o.handler = &jsProxyHandler{handler: d.readObjectRef()}
+			o.target = d.readObjectRef()
+			d.addFixup(obj, func() {
+				d.runFixup(o.target)
+				if call, ok := o.target.self.assertCallable(); ok {
+					o.call = call
+				}
+				if ctor := o.target.self.assertConstructor(); ctor != nil {
+					o.ctor = ctor
+				}
+			})
+		}
+	case *Promise:
+		o.state = PromiseState(d.readUint())
+		o.result = d.readValue()
+		o.handled = d.readBool()
+	case *arrayBufferObject:
+		o.detached = d.readBool()
+		n := d.readLen()
+		if d.pos+n > len(d.data) {
+			d.fail()
+		}
+		r.allocMem(int64(n))
+		o.data = make([]byte, n)
+		copy(o.data, d.data[d.pos:])
+		d.pos += n
+	case *typedArrayObject:
+		ctors := r.typedArrayObjectCtors()
+		kind := d.readUint()
+		if kind >= uint64(len(ctors)) {
+			d.fail()
+		}
+		buf := d.readArrayBufferRef()
+		offset, length := d.readLen(), d.readLen()
+		// only the type-specific fields are taken from the temporary object
+		a := ctors[kind](buf, offset, length, nil)
+		o.viewedArrayBuf, o.defaultCtor, o.typedArray = a.viewedArrayBuf, a.defaultCtor, a.typedArray
+		o.offset, o.length, o.elemSize = a.offset, a.length, a.elemSize
+		d.addFixup(obj, func() {
+			if !buf.detached && offset+length*o.elemSize > len(buf.data) {
+				d.fail()
+			}
+		})
+	case *dataViewObject:
+		buf := d.readArrayBufferRef()
+		o.viewedArrayBuf = buf
+		o.byteOffset, o.byteLen = d.readLen(), d.readLen()
+		d.addFixup(obj, func() {
+			if !buf.detached && o.byteOffset+o.byteLen > len(buf.data) {
+				d.fail()
+			}
+		})
+	}
+	d.readBase(snapshotBaseObject(obj.self), snapshotOwnProperty(obj.self), !intrinsic)
+}
@@ -0,0 +1,286 @@
+package goja
+
+import (
+	"strings"
+	"testing"
+)
+
+func snapshotRoundTrip(t *testing.T, src *Runtime, setup func(r *Runtime, reg *SnapshotRegistry)) *Runtime {
+	reg := NewSnapshotRegistry()
+	if setup != nil {
+		setup(src, reg)
+	}
+	data, err := src.Snapshot(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := New()
+	reg1 := NewSnapshotRegistry()
+	if setup != nil {
+		setup(dst, reg1)
+	}
+	if err := dst.RestoreSnapshot(data, reg1); err != nil {
+		t.Fatal(err)
+	}
+	data1, err := dst.Snapshot(reg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data1) != len(data) {
+		t.Fatalf("Snapshot of the restored Runtime differs in size: %d, expected %d", len(data1), len(data))
+	}
+	return dst
+}
+
+const snapshotTestSetup = `
+	var counter = (function() {
+		let n = 0;
+		return {
+			inc() { return ++n; },
+			get value() { return n; }
+		};
+	})();
+	counter.inc();
+
+	class Point {
+		#x; #y;
+		static count = 0;
+		constructor(x, y) { this.#x = x; this.#y = y; Point.count++; }
+		get x() { return this.#x; }
+		#norm() { return Math.hypot(this.#x, this.#y); }
+		norm() { return this.#norm(); }
+		static isPoint(o) { return #x in o; }
+	}
+	class Point3 extends Point {
+		constructor(x, y, z) { super(x, y); this.z = z; }
+		norm() { return Math.round(super.norm() * 100) / 100; }
+	}
+	const p = new Point3(3, 4, 5);
+
+	const tag = Symbol("tag");
+	const shared = Symbol.for("shared");
+	const obj = { [tag]: 1, [shared]: 2, arr: [1, , 3], sparse: [] };
+	obj.sparse[1000] = "x";
+	obj.self = obj;
+
+	const m = new Map([["a", obj], [obj, "b"]]);
+	const s = new Set([1, "2", tag]);
+	const wm = new WeakMap([[obj, "weak"]]);
+	const ws = new WeakSet([p]);
+	const d = new Date(86400000);
+	const re = /a(b)+/gi;
+	re.lastIndex = 2;
+	const err = new RangeError("boom");
+	const bound = function(a, b) { return this.k + a + b; }.bind({ k: 1 }, 2);
+	const arrow = x => x * counter.value;
+	const buf = new ArrayBuffer(8);
+	const u16 = new Uint16Array(buf, 2, 2);
+	u16[0] = 0xABCD;
+	const dv = new DataView(buf);
+	const proxy = new Proxy({}, { get(t, k) { return "proxied " + String(k); } });
+	const resolved = Promise.resolve(42);
+	const boxed = [new String("str"), new Number(1.5), Object(tag)];
+
+	Array.prototype.last = function() { return this[this.length - 1]; };
+	Object.defineProperty(String.prototype, "shout", { get() { return this.toUpperCase() + "!"; } });
+	delete Math.hypot2;
+	let lexical = "let";
+	var declared = "var";
+	`
+
+const snapshotTestCheck = `
+	const results = [];
+	function check(name, actual, expected) {
+		if (actual !== expected) {
+			throw new Error(name + ": " + actual + " !== " + expected);
+		}
+	}
+	check("counter", counter.inc(), 2);
+	check("counter.value", counter.value, 2);
+	check("point", p.norm(), 5);
+	check("point.x", p.x, 3);
+	check("point.z", p.z, 5);
+	check("isPoint", Point.isPoint(p), true);
+	check("isPoint2", Point.isPoint({}), false);
+	check("count", Point.count, 1);
+	check("instanceof", p instanceof Point && p instanceof Point3, true);
+	check("new point", new Point3(6, 8, 0).norm(), 10);
+	check("count2", Point.count, 2);
+	check("tag", obj[tag], 1);
+	check("shared", obj[Symbol.for("shared")], 2);
+	check("keyFor", Symbol.keyFor(shared), "shared");
+	check("tag desc", tag.description, "tag");
+	check("hole", 1 in obj.arr, false);
+	check("arr", obj.arr.length, 3);
+	check("sparse", obj.sparse[1000] + obj.sparse.length, "x1001");
+	check("cycle", obj.self, obj);
+	check("map", m.get("a"), obj);
+	check("map2", m.get(obj), "b");
+	check("set", s.has(tag) && s.size === 3, true);
+	check("weakmap", wm.get(obj), "weak");
+	check("weakset", ws.has(p), true);
+	check("date", d.toISOString(), "1970-01-02T00:00:00.000Z");
+	check("re", re.lastIndex, 2);
+	check("re.exec", re.exec("xxabbb")[1], "b");
+	check("re.flags", re.flags, "gi");
+	check("err", err instanceof RangeError && err.message, "boom");
+	check("err.stack", err.stack.indexOf("setup.js") !== -1, true);
+	check("bound", bound(3), 6);
+	check("bound.name", bound.name, "bound ");
+	check("bound.length", bound.length, 1);
+	check("arrow", arrow(10), 20);
+	check("u16", u16[0], 0xABCD);
+	check("dv", dv.getUint16(2, true), 0xABCD);
+	check("u16 buffer", u16.buffer, buf);
+	check("proxy", proxy.foo, "proxied foo");
+	check("boxed", boxed[0].length + boxed[1].valueOf(), 4.5);
+	check("boxed symbol", typeof boxed[2] === "object" && boxed[2].valueOf(), tag);
+	check("last", [1, 2, 3].last(), 3);
+	check("shout", "hi".shout, "HI!");
+	check("lexical", lexical, "let");
+	check("declared", declared + globalThis.declared, "varvar");
+	check("lexical not global", globalThis.lexical, undefined);
+	check("array length", Object.getOwnPropertyDescriptor(Array.prototype, "length").writable, true);
+	check("func length", (function(a, b) {}).length, 2);
+	resolved.then(v => { results.push(v); });
+	results;
+	`
+
+func TestSnapshot(t *testing.T) {
+	src := New()
+	if _, err := src.RunScript("setup.js", snapshotTestSetup); err != nil {
+		t.Fatal(err)
+	}
+	dst := snapshotRoundTrip(t, src, nil)
+	res, err := dst.RunString(snapshotTestCheck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := res.(*Object).Get("0"); v == nil || v.ToInteger() != 42 {
+		t.Fatalf("Unexpected promise result: %v", v)
+	}
+
+	// the source Runtime must not be affected
+	if _, err := src.RunString(snapshotTestCheck); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotRegistry(t *testing.T) {
+	setup := func(r *Runtime, reg *SnapshotRegistry) {
+		prefix := "dst"
+		if r.Get("marker") != nil {
+			prefix = "src"
+		}
+		log := r.ToValue(func(s string) string {
+			return prefix + ":" + s
+		}).(*Object)
+		reg.Register("log", log)
+		r.Set("log", log)
+	}
+	src := New()
+	src.Set("marker", true)
+	reg := NewSnapshotRegistry()
+	setup(src, reg)
+	if _, err := src.RunString(`
+	const logger = { write: log };
+	function hello(name) { return logger.write("hello " + name); }
+	`); err != nil {
+		t.Fatal(err)
+	}
+	data, err := src.Snapshot(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := New()
+	if err := dst.RestoreSnapshot(data, NewSnapshotRegistry()); err == nil || !strings.Contains(err.Error(), `"log"`) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dst = New()
+	reg1 := NewSnapshotRegistry()
+	setup(dst, reg1)
+	if err := dst.RestoreSnapshot(data, reg1); err != nil {
+		t.Fatal(err)
+	}
+	res, err := dst.RunString(`hello("world")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "dst:hello world" {
+		t.Fatal(res)
+	}
+
+	if _, err := src.Snapshot(nil); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestSnapshotReplacedBuiltin(t *testing.T) {
+	src := New()
+	if _, err := src.RunString(`
+	var origMap = Array.prototype.map;
+	Array.prototype.map = function(f) { return "replaced"; };
+	var origFloor = Math.floor;
+	Math.floor = undefined;
+	`); err != nil {
+		t.Fatal(err)
+	}
+	_, err := src.Snapshot(nil)
+	if err == nil || !strings.Contains(err.Error(), `"map"`) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := src.RunString(`origMap = origFloor = undefined`); err != nil {
+		t.Fatal(err)
+	}
+	dst := snapshotRoundTrip(t, src, nil)
+	res, err := dst.RunString(`[1].map(x => x) + ":" + typeof Math.floor + ":" + [1.5].filter(x => x > 1).length`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.String() != "replaced:undefined:1" {
+		t.Fatal(res)
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	r := New()
+	if _, err := r.RunString(`var it = [1, 2][Symbol.iterator]();`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Snapshot(nil); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	r = New()
+	if _, err := r.RunString(`var pending = new Promise(() => {}); pending.then(() => {});`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Snapshot(nil); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	r = New()
+	r.Set("snapshot", func() error {
+		_, err := r.Snapshot(nil)
+		return err
+	})
+	if _, err := r.RunString(`snapshot()`); err == nil || !strings.Contains(err.Error(), "running") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	r = New()
+	data, err := r.Snapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := New().RestoreSnapshot([]byte("garbage"), nil); err != errInvalidSnapshotData {
+		t.Fatal(err)
+	}
+	for i := len(snapshotMagic) + 2; i < len(data); i += 7 {
+		if err := New().RestoreSnapshot(data[:i], nil); err == nil {
+			t.Fatalf("Truncated data (%d bytes) was accepted", i)
+		}
+	}
+}
@@ -39,6 +39,10 @@ type lenientUtf16Decoder struct {
 type valueStringBuilder struct {
 	asciiBuilder   strings.Builder
 	unicodeBuilder unicodeStringBuilder
+
+	// if set, the growth of the buffer is added to the Runtime's memory usage
+	runtime   *Runtime
+	accounted int64
 }
 
 type unicodeStringBuilder struct {
@@ -193,8 +197,31 @@ func (b *valueStringBuilder) ascii() bool {
 	return len(b.unicodeBuilder.buf) == 0
 }
 
+// accountMem is called before extraLen characters are written. It adds the resulting growth of the buffer to the
+// memory usage of the Runtime (if set). unicode should be true if the written characters may not be ASCII.
+func (b *valueStringBuilder) accountMem(extraLen int, unicode bool) {
+	if b.runtime == nil {
+		return
+	}
+	var size int64
+	if b.ascii() {
+		size = int64(b.asciiBuilder.Len()) + int64(extraLen)
+		if unicode {
+			size *= memUnicodeCharSize
+		}
+	} else {
+		size = (int64(len(b.unicodeBuilder.buf)) + int64(extraLen)) * memUnicodeCharSize
+	}
+	if size > b.accounted {
+		b.runtime.allocMem(size - b.accounted)
+		b.accounted = size
+	}
+}
+
 func (b *valueStringBuilder) WriteString(s valueString) {
-	if ascii, ok := s.(asciiString); ok {
+	ascii, ok := s.(asciiString)
+	b.accountMem(s.length(), !ok)
+	if ok {
 		if b.ascii() {
 			b.asciiBuilder.WriteString(string(ascii))
 		} else {
@@ -207,6 +234,7 @@ func (b *valueStringBuilder) WriteString(s valueString) {
 }
 
 func (b *valueStringBuilder) WriteASCII(s string) {
+	b.accountMem(len(s), false)
 	if b.ascii() {
 		b.asciiBuilder.WriteString(s)
 	} else {
@@ -216,6 +244,7 @@ func (b *valueStringBuilder) WriteASCII(s string) {
 
 func (b *valueStringBuilder) WriteRune(r rune) {
 	if r < utf8.RuneSelf {
+		b.accountMem(1, false)
 		if b.ascii() {
 			b.asciiBuilder.WriteByte(byte(r))
 		} else {
@@ -228,6 +257,7 @@ func (b *valueStringBuilder) WriteRune(r rune) {
 		} else {
 			extraLen = 2
 		}
+		b.accountMem(extraLen, true)
 		b.switchToUnicode(extraLen)
 		b.unicodeBuilder.WriteRune(r)
 	}
@@ -241,6 +271,7 @@ func (b *valueStringBuilder) String() valueString {
 }
 
 func (b *valueStringBuilder) Grow(n int) {
+	b.accountMem(n, false)
 	if b.ascii() {
 		b.asciiBuilder.Grow(n)
 	} else {
@@ -257,7 +288,9 @@ func (b *valueStringBuilder) switchToUnicode(extraLen int) {
 }
 
 func (b *valueStringBuilder) WriteSubstring(source valueString, start int, end int) {
-	if ascii, ok := source.(asciiString); ok {
+	ascii, ok := source.(asciiString)
+	b.accountMem(end-start, !ok)
+	if ok {
 		if b.ascii() {
 			b.asciiBuilder.WriteString(string(ascii[start:end]))
 		} else {
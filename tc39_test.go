@@ -236,7 +236,6 @@ var (
 		"Object.hasOwn",
 		"__getter__",
 		"__setter__",
-		"ShadowRealm",
 		"SharedArrayBuffer",
 		"error-cause",
 		"decorators",
@@ -0,0 +1,150 @@
+package goja
+
+import (
+	"github.com/dop251/goja/file"
+	"github.com/dop251/goja/unistring"
+)
+
+// Tracer receives the function call events of a Runtime (see Runtime.SetTracer()).
+//
+// The methods are called synchronously on the goroutine running the script, before the function starts and after it
+// finishes. The calls are properly nested, i.e. every FunctionEnter is followed by exactly one FunctionExit with the
+// same *TraceCall, and all the calls made by the function are reported in between.
+//
+// JavaScript functions are reported whenever they are called, either from JavaScript or from Go (including the
+// callbacks invoked by the built-in functions). Native functions are reported when they are called from JavaScript
+// code.
+type Tracer interface {
+	FunctionEnter(call *TraceCall)
+
+	// FunctionExit is called when the function returns (in which case err is nil) or when it's terminated by an
+	// exception or an uncatchable error (such as *InterruptedError). In the first case err is an *Exception.
+	FunctionExit(call *TraceCall, result Value, err error)
+}
+
+// GlobalTracer is an optional interface that a Tracer may implement to receive the reads and the writes of global
+// variables (i.e. the properties of the global object and the top-level lexical declarations) performed by
+// JavaScript code. The accesses made through the global object itself (e.g. globalThis.x) or from Go
+// (e.g. Runtime.Get()) are not reported.
+type GlobalTracer interface {
+	GlobalGet(name string, value Value)
+	GlobalSet(name string, value Value)
+}
+
+// TraceCall describes a function call reported to a Tracer. The values are only valid until the corresponding
+// FunctionExit returns, with the exception of Arguments, which are a copy and may be retained.
+type TraceCall struct {
+	// Function is the callee.
+	Function *Object
+	FuncName string
+
+	// Native is true for the functions implemented in Go.
+	Native bool
+
+	// This is nil for arrow functions.
+	This      Value
+	Arguments []Value
+
+	// Position is the position of the beginning of the function code (zero for native functions), CallSite is the
+	// position of the call expression (zero if the function was called from Go).
+	Position file.Position
+	CallSite file.Position
+}
+
+type traceFrame struct {
+	call   *TraceCall
+	tracer Tracer
+	// depth is the length of the call stack while the function is running
+	depth int
+}
+
+// SetTracer installs a Tracer that receives the function call events (and, if it implements GlobalTracer, the global
+// variable accesses). Passing nil removes the tracer. The functions that are running at the time of the call are
+// not reported, except that the functions entered by the previous tracer are still reported to it when they exit.
+//
+// There is no overhead when the tracer is not set. This method is not safe for concurrent use and should not be
+// called while the Runtime is running, except from within the tracer methods or native functions.
+func (r *Runtime) SetTracer(t Tracer) {
+	r.vm.tracer = t
+	r.vm.globalTracer, _ = t.(GlobalTracer)
+}
+
+func (vm *vm) traceEnter(callee *Object, prg *Program, funcName unistring.String, this Value, args []Value, depth int) {
+	call := &TraceCall{
+		Function:  callee,
+		FuncName:  funcName.String(),
+		Native:    prg == nil,
+		This:      this,
+		Arguments: append([]Value(nil), args...),
+	}
+	if prg != nil && prg.src != nil && len(prg.srcMap) > 0 {
+		call.Position = prg.src.Position(prg.srcMap[0].srcPos)
+	}
+	if vm.prg != nil && vm.pc >= 0 {
+		f := StackFrame{prg: vm.prg, pc: vm.pc}
+		call.CallSite = f.Position()
+	}
+	t := vm.tracer
+	vm.traceFrames = append(vm.traceFrames, traceFrame{call: call, tracer: t, depth: depth})
+	t.FunctionEnter(call)
+}
+
+// traceReturn reports the exit of the traced functions whose frames have been popped off the call stack.
+func (vm *vm) traceReturn(result Value) {
+	for l := len(vm.traceFrames) - 1; l >= 0; l-- {
+		frame := vm.traceFrames[l]
+		if frame.depth <= len(vm.callStack) {
+			break
+		}
+		vm.traceFrames[l] = traceFrame{}
+		vm.traceFrames = vm.traceFrames[:l]
+		frame.tracer.FunctionExit(frame.call, result, nil)
+	}
+}
+
+// traceUnwind is called by try() after the call stack has been unwound by an exception.
+func (vm *vm) traceUnwind(x interface{}, ex *Exception) {
+	var err error
+	if ex != nil {
+		err = ex
+	} else if u, ok := x.(*uncatchableException); ok {
+		err = u.err
+	}
+	for l := len(vm.traceFrames) - 1; l >= 0; l-- {
+		frame := vm.traceFrames[l]
+		if frame.depth <= len(vm.callStack) {
+			break
+		}
+		vm.traceFrames[l] = traceFrame{}
+		vm.traceFrames = vm.traceFrames[:l]
+		frame.tracer.FunctionExit(frame.call, nil, err)
+	}
+}
+
+func (vm *vm) isGlobalStash(s *stash) bool {
+	return s == &vm.r.global.stash
+}
+
+// tracedRef reports the accesses to a global variable made through a reference (e.g. in compound assignments).
+type tracedRef struct {
+	ref
+	t GlobalTracer
+}
+
+func (r *tracedRef) get() Value {
+	v := r.ref.get()
+	if v != nil {
+		r.t.GlobalGet(r.refname().String(), v)
+	}
+	return v
+}
+
+func (r *tracedRef) set(v Value) {
+	r.ref.set(v)
+	r.t.GlobalSet(r.refname().String(), v)
+}
+
+func (r *tracedRef) init(v Value) {
+	r.ref.init(v)
+	r.t.GlobalSet(r.refname().String(), v)
+}
@@ -0,0 +1,169 @@
+package goja
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testTracer struct {
+	events []string
+	stack  []*TraceCall
+}
+
+func (t *testTracer) FunctionEnter(call *TraceCall) {
+	args := make([]string, len(call.Arguments))
+	for i, arg := range call.Arguments {
+		args[i] = arg.String()
+	}
+	ev := fmt.Sprintf("enter %s(%s)", call.FuncName, strings.Join(args, ","))
+	if call.Native {
+		ev += " native"
+	} else {
+		ev += fmt.Sprintf(" %d:%d", call.Position.Line, call.Position.Column)
+	}
+	if call.CallSite.Line != 0 {
+		ev += fmt.Sprintf(" from %d", call.CallSite.Line)
+	}
+	t.events = append(t.events, ev)
+	t.stack = append(t.stack, call)
+}
+
+func (t *testTracer) FunctionExit(call *TraceCall, result Value, err error) {
+	if top := t.stack[len(t.stack)-1]; top != call {
+		panic(fmt.Errorf("unbalanced exit: %s, expected %s", call.FuncName, top.FuncName))
+	}
+	t.stack = t.stack[:len(t.stack)-1]
+	if err != nil {
+		t.events = append(t.events, fmt.Sprintf("throw %s: %v", call.FuncName, err.(*Exception).Value()))
+	} else {
+		t.events = append(t.events, fmt.Sprintf("exit %s = %v", call.FuncName, result))
+	}
+}
+
+type testGlobalTracer struct {
+	testTracer
+}
+
+func (t *testGlobalTracer) GlobalGet(name string, value Value) {
+	t.events = append(t.events, fmt.Sprintf("get %s = %v", name, value))
+}
+
+func (t *testGlobalTracer) GlobalSet(name string, value Value) {
+	t.events = append(t.events, fmt.Sprintf("set %s = %v", name, value))
+}
+
+func TestTracer(t *testing.T) {
+	const SCRIPT = `function add(a, b) {
+	return a + b;
+}
+function fail() {
+	throw "oops";
+}
+var f = x => add(x, 1);
+try {
+	fail();
+} catch (e) {
+}
+[1].map(f);
+`
+	vm := New()
+	tr := &testTracer{}
+	vm.SetTracer(tr)
+	if _, err := vm.RunScript("test.js", SCRIPT); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"enter fail() 5:2 from 9",
+		"throw fail: oops",
+		"enter map(x => add(x, 1)) native from 12",
+		"enter f(1,0,1) 7:14",
+		"enter add(1,1) 2:9 from 7",
+		"exit add = 2",
+		"exit f = 2",
+		"exit map = 2",
+	}
+	if strings.Join(tr.events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected events:\n%s", strings.Join(tr.events, "\n"))
+	}
+	if len(tr.stack) != 0 || len(vm.vm.traceFrames) != 0 {
+		t.Fatal("unbalanced frames")
+	}
+
+	fn, _ := AssertFunction(vm.Get("add"))
+	tr.events = nil
+	if res, err := fn(nil, vm.ToValue(2), vm.ToValue(3)); err != nil || res.ToInteger() != 5 {
+		t.Fatal(res, err)
+	}
+	if strings.Join(tr.events, "\n") != "enter add(2,3) 2:9\nexit add = 5" {
+		t.Fatalf("Unexpected events:\n%s", strings.Join(tr.events, "\n"))
+	}
+
+	vm.SetTracer(nil)
+	tr.events = nil
+	if _, err := vm.RunString("add(1, 2)"); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.events) != 0 {
+		t.Fatal(tr.events)
+	}
+}
+
+func TestTracerUncaught(t *testing.T) {
+	vm := New()
+	tr := &testTracer{}
+	vm.SetTracer(tr)
+	_, err := vm.RunString(`
+	function f() {
+		throw new Error("boom");
+	}
+	function g() {
+		f();
+	}
+	g();
+	`)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if len(tr.events) != 4 || !strings.HasPrefix(tr.events[2], "throw f: Error: boom") || !strings.HasPrefix(tr.events[3], "throw g: Error: boom") {
+		t.Fatalf("Unexpected events:\n%s", strings.Join(tr.events, "\n"))
+	}
+	if len(tr.stack) != 0 || len(vm.vm.traceFrames) != 0 {
+		t.Fatal("unbalanced frames")
+	}
+}
+
+func TestGlobalTracer(t *testing.T) {
+	vm := New()
+	vm.Set("host", 1)
+	tr := &testGlobalTracer{}
+	vm.SetTracer(tr)
+	_, err := vm.RunString(`
+	var x = host + 1;
+	let y = 10;
+	x += y;
+	(function() {
+		y = x;
+		undeclared = 5;
+	})();
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"get host = 1",
+		"set x = 2",
+		"set y = 10",
+		"get x = 2",
+		"get y = 10",
+		"set x = 12",
+		"enter () 6:3 from 8",
+		"get x = 12",
+		"set y = 12",
+		"set undeclared = 5",
+		"exit  = undefined",
+	}
+	if strings.Join(tr.events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected events:\n%s", strings.Join(tr.events, "\n"))
+	}
+}
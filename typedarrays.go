@@ -109,6 +109,7 @@ func (a ArrayBuffer) Detached() bool {
 }
 
 func (r *Runtime) NewArrayBuffer(data []byte) ArrayBuffer {
+	r.allocMem(int64(len(data)))
 	buf := r._newArrayBuffer(r.global.ArrayBufferPrototype, nil)
 	buf.data = data
 	return ArrayBuffer{
@@ -40,6 +40,7 @@ type context struct {
 	result    Value
 	pc, sb    int
 	args      int
+	realm     *Realm
 }
 
 type iterStackItem struct {
@@ -259,12 +260,57 @@ type vm struct {
 
 	maxCallStackSize int
 
+	// instructions is the number of executed instructions (plus the work charged by built-ins), it's only
+	// maintained while vmFlagInstructionLimit is set. instructionLimit is math.MaxUint64 if there is no limit.
+	instructions     uint64
+	instructionLimit uint64
+
 	stashAllocs int
 	halt        bool
 
+	// running is the number of nested run() calls, i.e. it's non-zero while JavaScript code is running. If a run()
+	// is aborted by a panic, try() restores it.
+	running int
+
+	// interrupted is a combination of the vmFlag* bits, see Interrupt(), StartProfile(), SetInstructionLimit()
+	// and AttachDebugger()
 	interrupted   uint32
 	interruptVal  interface{}
+	interruptSeq  uint64 // incremented by every Interrupt(), protected by interruptLock
 	interruptLock sync.Mutex
+
+	// see SetTracer(), traceFrames is only non-empty while there are traced functions running
+	tracer       Tracer
+	globalTracer GlobalTracer
+	traceFrames  []traceFrame
+}
+
+const (
+	vmFlagInterrupt uint32 = 1 << iota
+	vmFlagProfileSample
+	// vmFlagInstructionLimit is set permanently while there is an instruction limit, so that the run loop
+	// only counts the instructions when it's needed.
+	vmFlagInstructionLimit
+	// vmFlagDebugger is set while a Debugger is attached.
+	vmFlagDebugger
+)
+
+func atomicSetFlag(addr *uint32, flag uint32) {
+	for {
+		old := atomic.LoadUint32(addr)
+		if atomic.CompareAndSwapUint32(addr, old, old|flag) {
+			return
+		}
+	}
+}
+
+func atomicClearFlag(addr *uint32, flag uint32) {
+	for {
+		old := atomic.LoadUint32(addr)
+		if atomic.CompareAndSwapUint32(addr, old, old&^flag) {
+			return
+		}
+	}
 }
 
 type instruction interface {
@@ -482,15 +528,28 @@ func (vm *vm) init() {
 	vm.sb = -1
 	vm.stash = &vm.r.global.stash
 	vm.maxCallStackSize = math.MaxInt32
+	vm.instructionLimit = math.MaxUint64
 }
 
 func (vm *vm) run() {
+	vm.running++
 	vm.halt = false
 	interrupted := false
 	ticks := 0
 	for !vm.halt {
-		if interrupted = atomic.LoadUint32(&vm.interrupted) != 0; interrupted {
-			break
+		if flags := atomic.LoadUint32(&vm.interrupted); flags != 0 {
+			if interrupted = flags&vmFlagInterrupt != 0; interrupted {
+				break
+			}
+			if flags&vmFlagProfileSample != 0 {
+				vm.profileSample()
+			}
+			if flags&vmFlagInstructionLimit != 0 {
+				vm.countInstruction()
+			}
+			if flags&vmFlagDebugger != 0 {
+				vm.r.debugger.beforeExec(vm)
+			}
 		}
 		vm.prg.code[vm.pc].exec(vm)
 		ticks++
@@ -499,29 +558,93 @@ func (vm *vm) run() {
 			ticks = 0
 		}
 	}
+	vm.running--
 
 	if interrupted {
-		vm.interruptLock.Lock()
-		v := &InterruptedError{
-			iface: vm.interruptVal,
-		}
-		v.stack = vm.captureStack(nil, 0)
-		vm.interruptLock.Unlock()
-		panic(&uncatchableException{
-			err: v,
-		})
+		vm.throwInterrupted()
 	}
 }
 
+func (vm *vm) throwInterrupted() {
+	vm.interruptLock.Lock()
+	v := &InterruptedError{
+		iface: vm.interruptVal,
+	}
+	v.stack = vm.captureStack(nil, 0)
+	vm.interruptLock.Unlock()
+	panic(&uncatchableException{
+		err: v,
+	})
+}
+
+// checkInterrupt should be called periodically by built-in functions that may run for a long time (such as
+// sorting a large array) so that they can be stopped with Interrupt().
+func (vm *vm) checkInterrupt() {
+	if atomic.LoadUint32(&vm.interrupted)&vmFlagInterrupt != 0 {
+		vm.throwInterrupted()
+	}
+}
+
+// charge accounts for the work done by a built-in function (such as sorting or joining an array) towards
+// the instruction limit. n should be roughly proportional to the number of elementary operations.
+func (vm *vm) charge(n int64) {
+	if n <= 0 || atomic.LoadUint32(&vm.interrupted)&vmFlagInstructionLimit == 0 {
+		return
+	}
+	if c := vm.instructions + uint64(n); c >= vm.instructions {
+		vm.instructions = c
+	} else {
+		vm.instructions = math.MaxUint64
+	}
+	if vm.instructions > vm.instructionLimit {
+		vm.instructionLimitExceeded()
+	}
+}
+
+func (vm *vm) countInstruction() {
+	vm.instructions++
+	if vm.instructions > vm.instructionLimit {
+		vm.instructionLimitExceeded()
+	}
+}
+
+func (vm *vm) instructionLimitExceeded() {
+	ex := &InstructionLimitExceededError{
+		limit: vm.instructionLimit,
+	}
+	ex.stack = vm.captureStack(nil, 0)
+	panic(&uncatchableException{
+		err: ex,
+	})
+}
+
 func (vm *vm) Interrupt(v interface{}) {
+	vm.interrupt(v)
+}
+
+// interrupt is like Interrupt but returns a sequence number that can be passed to clearInterrupt().
+func (vm *vm) interrupt(v interface{}) uint64 {
 	vm.interruptLock.Lock()
+	vm.interruptSeq++
+	seq := vm.interruptSeq
 	vm.interruptVal = v
-	atomic.StoreUint32(&vm.interrupted, 1)
+	atomicSetFlag(&vm.interrupted, vmFlagInterrupt)
+	vm.interruptLock.Unlock()
+	return seq
+}
+
+// clearInterrupt resets the interrupt flag if it has not been set again since the interrupt() call that
+// returned seq.
+func (vm *vm) clearInterrupt(seq uint64) {
+	vm.interruptLock.Lock()
+	if vm.interruptSeq == seq {
+		atomicClearFlag(&vm.interrupted, vmFlagInterrupt)
+	}
 	vm.interruptLock.Unlock()
 }
 
 func (vm *vm) ClearInterrupt() {
-	atomic.StoreUint32(&vm.interrupted, 0)
+	atomicClearFlag(&vm.interrupted, vmFlagInterrupt)
 }
 
 func (vm *vm) captureStack(stack []StackFrame, ctxOffset int) []StackFrame {
@@ -558,11 +681,16 @@ func (vm *vm) try(f func()) (ex *Exception) {
 	sp := vm.sp
 	iterLen := len(vm.iterStack)
 	refLen := len(vm.refStack)
+	running := vm.running
 
 	defer func() {
 		if x := recover(); x != nil {
 			defer func() {
+				vm.running = running
 				vm.callStack = vm.callStack[:ctxOffset]
+				if len(vm.traceFrames) > 0 {
+					vm.traceUnwind(x, ex)
+				}
 				vm.restoreCtx(&ctx)
 				vm.sp = sp
 
@@ -655,8 +783,8 @@ func (vm *vm) peek() Value {
 }
 
 func (vm *vm) saveCtx(ctx *context) {
-	ctx.prg, ctx.stash, ctx.privEnv, ctx.newTarget, ctx.result, ctx.pc, ctx.sb, ctx.args, ctx.funcName =
-		vm.prg, vm.stash, vm.privEnv, vm.newTarget, vm.result, vm.pc, vm.sb, vm.args, vm.funcName
+	ctx.prg, ctx.stash, ctx.privEnv, ctx.newTarget, ctx.result, ctx.pc, ctx.sb, ctx.args, ctx.funcName, ctx.realm =
+		vm.prg, vm.stash, vm.privEnv, vm.newTarget, vm.result, vm.pc, vm.sb, vm.args, vm.funcName, vm.r.realm
 }
 
 func (vm *vm) pushCtx() {
@@ -675,6 +803,16 @@ func (vm *vm) pushCtx() {
 func (vm *vm) restoreCtx(ctx *context) {
 	vm.prg, vm.funcName, vm.stash, vm.privEnv, vm.newTarget, vm.result, vm.pc, vm.sb, vm.args =
 		ctx.prg, ctx.funcName, ctx.stash, ctx.privEnv, ctx.newTarget, ctx.result, ctx.pc, ctx.sb, ctx.args
+	vm.enterRealm(ctx.realm)
+}
+
+// enterRealm makes realm the current realm. It must be called after pushCtx() so that the realm of the caller
+// is restored when the frame is popped. A nil realm (such as the one of the extra frames pushed by the calls
+// from Go) leaves the current realm unchanged.
+func (vm *vm) enterRealm(realm *Realm) {
+	if realm != nil && realm != vm.r.realm {
+		vm.r.setRealm(realm)
+	}
 }
 
 func (vm *vm) popCtx() {
@@ -687,6 +825,7 @@ func (vm *vm) popCtx() {
 	ctx.privEnv = nil
 	ctx.result = nil
 	ctx.newTarget = nil
+	ctx.realm = nil
 
 	vm.callStack = vm.callStack[:l]
 }
@@ -1021,6 +1160,9 @@ func (_add) exec(vm *vm) {
 		if !isRightString {
 			rightString = right.toString()
 		}
+		_, leftASCII := leftString.(asciiString)
+		_, rightASCII := rightString.(asciiString)
+		vm.r.allocStringMem(int64(leftString.length())+int64(rightString.length()), leftASCII && rightASCII)
 		ret = leftString.concat(rightString)
 	} else {
 		if leftInt, ok := left.(valueInt); ok {
@@ -2425,6 +2567,9 @@ type initGlobalP unistring.String
 func (s initGlobalP) exec(vm *vm) {
 	vm.sp--
 	vm.r.global.stash.initByName(unistring.String(s), vm.stack[vm.sp])
+	if t := vm.globalTracer; t != nil {
+		t.GlobalSet(unistring.String(s).String(), vm.stack[vm.sp])
+	}
 	vm.pc++
 }
 
@@ -2432,6 +2577,9 @@ type initGlobal unistring.String
 
 func (s initGlobal) exec(vm *vm) {
 	vm.r.global.stash.initByName(unistring.String(s), vm.stack[vm.sp])
+	if t := vm.globalTracer; t != nil {
+		t.GlobalSet(unistring.String(s).String(), vm.stack[vm.sp])
+	}
 	vm.pc++
 }
 
@@ -2443,6 +2591,9 @@ func (s resolveVar1) exec(vm *vm) {
 	for stash := vm.stash; stash != nil; stash = stash.outer {
 		ref = stash.getRefByName(name, false)
 		if ref != nil {
+			if t := vm.globalTracer; t != nil && vm.isGlobalStash(stash) {
+				ref = &tracedRef{ref: ref, t: t}
+			}
 			goto end
 		}
 	}
@@ -2452,6 +2603,9 @@ func (s resolveVar1) exec(vm *vm) {
 		name:    name,
 		binding: true,
 	}
+	if t := vm.globalTracer; t != nil {
+		ref = &tracedRef{ref: ref, t: t}
+	}
 
 end:
 	vm.refStack = append(vm.refStack, ref)
@@ -2523,6 +2677,9 @@ func (s resolveVar1Strict) exec(vm *vm) {
 	for stash := vm.stash; stash != nil; stash = stash.outer {
 		ref = stash.getRefByName(name, true)
 		if ref != nil {
+			if t := vm.globalTracer; t != nil && vm.isGlobalStash(stash) {
+				ref = &tracedRef{ref: ref, t: t}
+			}
 			goto end
 		}
 	}
@@ -2534,6 +2691,9 @@ func (s resolveVar1Strict) exec(vm *vm) {
 			binding: true,
 			strict:  true,
 		}
+		if t := vm.globalTracer; t != nil {
+			ref = &tracedRef{ref: ref, t: t}
+		}
 		goto end
 	}
 
@@ -2551,6 +2711,9 @@ type setGlobal unistring.String
 
 func (s setGlobal) exec(vm *vm) {
 	vm.r.setGlobal(unistring.String(s), vm.peek(), false)
+	if t := vm.globalTracer; t != nil {
+		t.GlobalSet(unistring.String(s).String(), vm.peek())
+	}
 	vm.pc++
 }
 
@@ -2558,6 +2721,9 @@ type setGlobalStrict unistring.String
 
 func (s setGlobalStrict) exec(vm *vm) {
 	vm.r.setGlobal(unistring.String(s), vm.peek(), true)
+	if t := vm.globalTracer; t != nil {
+		t.GlobalSet(unistring.String(s).String(), vm.peek())
+	}
 	vm.pc++
 }
 
@@ -2979,6 +3145,9 @@ func (n loadDynamic) exec(vm *vm) {
 	for stash := vm.stash; stash != nil; stash = stash.outer {
 		if v, exists := stash.getByName(name); exists {
 			val = v
+			if t := vm.globalTracer; t != nil && vm.isGlobalStash(stash) {
+				t.GlobalGet(name.String(), v)
+			}
 			break
 		}
 	}
@@ -2987,6 +3156,9 @@ func (n loadDynamic) exec(vm *vm) {
 		if val == nil {
 			vm.r.throwReferenceError(name)
 		}
+		if t := vm.globalTracer; t != nil {
+			t.GlobalGet(name.String(), val)
+		}
 	}
 	vm.push(val)
 	vm.pc++
@@ -3000,6 +3172,9 @@ func (n loadDynamicRef) exec(vm *vm) {
 	for stash := vm.stash; stash != nil; stash = stash.outer {
 		if v, exists := stash.getByName(name); exists {
 			val = v
+			if t := vm.globalTracer; t != nil && vm.isGlobalStash(stash) {
+				t.GlobalGet(name.String(), v)
+			}
 			break
 		}
 	}
@@ -3007,6 +3182,8 @@ func (n loadDynamicRef) exec(vm *vm) {
 		val = vm.r.globalObject.self.getStr(name, nil)
 		if val == nil {
 			val = valueUnresolved{r: vm.r, ref: name}
+		} else if t := vm.globalTracer; t != nil {
+			t.GlobalGet(name.String(), val)
 		}
 	}
 	vm.push(val)
@@ -3023,6 +3200,9 @@ func (n loadDynamicCallee) exec(vm *vm) {
 		if v, exists := stash.getByName(name); exists {
 			callee = stash.obj
 			val = v
+			if t := vm.globalTracer; t != nil && vm.isGlobalStash(stash) {
+				t.GlobalGet(name.String(), v)
+			}
 			break
 		}
 	}
@@ -3030,6 +3210,8 @@ func (n loadDynamicCallee) exec(vm *vm) {
 		val = vm.r.globalObject.self.getStr(name, nil)
 		if val == nil {
 			val = valueUnresolved{r: vm.r, ref: name}
+		} else if t := vm.globalTracer; t != nil {
+			t.GlobalGet(name.String(), val)
 		}
 	}
 	if callee != nil {
@@ -3055,6 +3237,7 @@ func (vm *vm) callEval(n int, strict bool) {
 		if n > 0 {
 			srcVal := vm.stack[vm.sp-n]
 			if src, ok := srcVal.(valueString); ok {
+				vm.r.checkDynamicCode(DynamicCodeEval, src)
 				ret := vm.r.eval(src, true, strict)
 				vm.stack[vm.sp-n-2] = ret
 			} else {
@@ -3169,8 +3352,12 @@ repeat:
 	case *classFuncObject:
 		f.Call(FunctionCall{}) // throws
 	case *methodFuncObject:
+		if vm.tracer != nil {
+			vm.traceEnter(obj, f.prg, f.prg.funcName, vm.stack[vm.sp-n-2], vm.stack[vm.sp-n:vm.sp], len(vm.callStack)+1)
+		}
 		vm.pc++
 		vm.pushCtx()
+		vm.enterRealm(f.realm)
 		vm.args = n
 		vm.prg = f.prg
 		vm.stash = f.stash
@@ -3179,8 +3366,12 @@ repeat:
 		vm.stack[vm.sp-n-1], vm.stack[vm.sp-n-2] = vm.stack[vm.sp-n-2], vm.stack[vm.sp-n-1]
 		return
 	case *funcObject:
+		if vm.tracer != nil {
+			vm.traceEnter(obj, f.prg, f.prg.funcName, vm.stack[vm.sp-n-2], vm.stack[vm.sp-n:vm.sp], len(vm.callStack)+1)
+		}
 		vm.pc++
 		vm.pushCtx()
+		vm.enterRealm(f.realm)
 		vm.args = n
 		vm.prg = f.prg
 		vm.stash = f.stash
@@ -3189,8 +3380,12 @@ repeat:
 		vm.stack[vm.sp-n-1], vm.stack[vm.sp-n-2] = vm.stack[vm.sp-n-2], vm.stack[vm.sp-n-1]
 		return
 	case *arrowFuncObject:
+		if vm.tracer != nil {
+			vm.traceEnter(obj, f.prg, f.prg.funcName, nil, vm.stack[vm.sp-n:vm.sp], len(vm.callStack)+1)
+		}
 		vm.pc++
 		vm.pushCtx()
+		vm.enterRealm(f.realm)
 		vm.args = n
 		vm.prg = f.prg
 		vm.stash = f.stash
@@ -3225,9 +3420,14 @@ repeat:
 
 func (vm *vm) _nativeCall(f *nativeFuncObject, n int) {
 	if f.f != nil {
+		funcName := nilSafe(f.getStr("name", nil)).string()
+		if vm.tracer != nil {
+			vm.traceEnter(f.val, nil, funcName, vm.stack[vm.sp-n-2], vm.stack[vm.sp-n:vm.sp], len(vm.callStack)+1)
+		}
 		vm.pushCtx()
+		vm.enterRealm(f.realm)
 		vm.prg = nil
-		vm.funcName = nilSafe(f.getStr("name", nil)).string()
+		vm.funcName = funcName
 		ret := f.f(FunctionCall{
 			Arguments: vm.stack[vm.sp-n : vm.sp],
 			This:      vm.stack[vm.sp-n-2],
@@ -3237,6 +3437,9 @@ func (vm *vm) _nativeCall(f *nativeFuncObject, n int) {
 		}
 		vm.stack[vm.sp-n-2] = ret
 		vm.popCtx()
+		if len(vm.traceFrames) > 0 {
+			vm.traceReturn(ret)
+		}
 	} else {
 		vm.stack[vm.sp-n-2] = _undefined
 	}
@@ -3503,6 +3706,9 @@ func (_ret) exec(vm *vm) {
 	vm.stack[vm.sb-1] = vm.stack[vm.sp-1]
 	vm.sp = vm.sb
 	vm.popCtx()
+	if len(vm.traceFrames) > 0 {
+		vm.traceReturn(vm.stack[vm.sp-1])
+	}
 	if vm.pc < 0 {
 		vm.halt = true
 	}